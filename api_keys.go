@@ -0,0 +1,155 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"magpanel/database"
+	"magpanel/models"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Las API keys tienen la forma "mpk_<48 caracteres hex>". Los primeros caracteres se guardan
+// en claro como prefijo para poder identificarlas en los listados.
+const (
+	apiKeyPrefix     = "mpk_"
+	apiKeyPrefixLen  = 12
+	apiKeyDefaultTTL = 90 * 24 * time.Hour
+)
+
+// apiKeyScopesKey guarda en el contexto los scopes de la API key con la que se autenticó el request
+const apiKeyScopesKey contextKey = "apiKeyScopes"
+
+// generateAPIKey devuelve una clave nueva, su prefijo visible y el hash que se guarda en la base
+func generateAPIKey() (key, prefix, hash string, err error) {
+	random, err := generateToken(24)
+	if err != nil {
+		return "", "", "", err
+	}
+	key = apiKeyPrefix + random
+	return key, key[:apiKeyPrefixLen], hashToken(key), nil
+}
+
+// apiKeyFromRequest busca la clave en "Authorization: ApiKey <clave>" o en "X-API-Key"
+func apiKeyFromRequest(r *http.Request) string {
+	if key := strings.TrimSpace(r.Header.Get("X-API-Key")); key != "" {
+		return key
+	}
+	authHeader := r.Header.Get("Authorization")
+	if strings.HasPrefix(authHeader, "ApiKey ") {
+		return strings.TrimSpace(strings.TrimPrefix(authHeader, "ApiKey "))
+	}
+	return ""
+}
+
+// authenticateAPIKey valida la clave (existente, no revocada, no vencida), registra su uso
+// y devuelve el usuario al que está ligada junto con los scopes de la clave
+func authenticateAPIKey(key string) (*models.User, []string, error) {
+	var user models.User
+	var keyID int
+	var scopes, expiresAt string
+	var revokedAt sql.NullString
+	var status string
+
+	row, err := dataBase.SelectRow("SELECT k.id, k.scopes, k.expires_at, k.revoked_at, u.id, u.username, u.email, u.`rank`, u.service_account, u.status "+
+		"FROM api_keys k JOIN users u ON k.user_id = u.id WHERE k.key_hash = ?", hashToken(key))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := row.Scan(&keyID, &scopes, &expiresAt, &revokedAt, &user.ID, &user.Username, &user.Email, &user.Rank, &user.ServiceAccount, &status); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, fmt.Errorf("API key inválida")
+		}
+		return nil, nil, err
+	}
+
+	if revokedAt.Valid {
+		return nil, nil, fmt.Errorf("API key revocada")
+	}
+	if status != userStatusActive {
+		return nil, nil, fmt.Errorf("El usuario de la API key está desactivado")
+	}
+	expires, err := database.ParseTime(expiresAt)
+	if err != nil || time.Now().After(expires) {
+		return nil, nil, fmt.Errorf("API key vencida")
+	}
+
+	var keyScopes []string
+	if err := json.Unmarshal([]byte(scopes), &keyScopes); err != nil {
+		return nil, nil, fmt.Errorf("API key con scopes inválidos")
+	}
+
+	if _, err := dataBase.Update(false, "UPDATE api_keys SET last_used_at = ? WHERE id = ?", database.FormatTime(time.Now()), keyID); err != nil {
+		return nil, nil, err
+	}
+
+	return &user, keyScopes, nil
+}
+
+// rejectAPIKey responde 403 si el request se autenticó con una API key o con una sesión de
+// suplantación. Se usa en las operaciones que solo puede hacer una persona con su propia sesión
+// (crear claves, configurar 2FA).
+func rejectAPIKey(w http.ResponseWriter, r *http.Request) bool {
+	if _, ok := requestAPIKeyScopes(r); ok {
+		http.Error(w, "Esta operación no está disponible con una API key", http.StatusForbidden)
+		return true
+	}
+	if user, ok := r.Context().Value(currentUserKey).(*models.User); ok && user.Impersonator != nil {
+		http.Error(w, "Esta operación no está disponible durante una suplantación", http.StatusForbidden)
+		return true
+	}
+	return false
+}
+
+// requestAPIKeyScopes devuelve los scopes si el request se autenticó con una API key
+func requestAPIKeyScopes(r *http.Request) ([]string, bool) {
+	scopes, ok := r.Context().Value(apiKeyScopesKey).([]string)
+	return scopes, ok
+}
+
+// trimAPIKeyScopes quita de las API keys vigentes del usuario los scopes que ya no están en
+// permissions, por ejemplo cuando baja su rol
+func trimAPIKeyScopes(tx *database.Tx, userID int, permissions []string) error {
+	rows, err := tx.Select("SELECT id, scopes FROM api_keys WHERE user_id = ? AND revoked_at IS NULL", userID)
+	if err != nil {
+		return err
+	}
+	keys := map[int][]string{}
+	for rows.Next() {
+		var id int
+		var scopes string
+		if err := rows.Scan(&id, &scopes); err != nil {
+			rows.Close()
+			return err
+		}
+		var keyScopes []string
+		if err := json.Unmarshal([]byte(scopes), &keyScopes); err != nil {
+			rows.Close()
+			return fmt.Errorf("API key %d con scopes inválidos", id)
+		}
+		keys[id] = keyScopes
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, keyScopes := range keys {
+		trimmed := []string{}
+		for _, scope := range keyScopes {
+			if containsPermission(permissions, scope) {
+				trimmed = append(trimmed, scope)
+			}
+		}
+		if len(trimmed) == len(keyScopes) {
+			continue
+		}
+		scopes, _ := json.Marshal(trimmed)
+		if _, err := tx.Update(false, "UPDATE api_keys SET scopes = ? WHERE id = ?", string(scopes), id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
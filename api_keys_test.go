@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"magpanel/database"
+	"magpanel/models"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// createTestAPIKey guarda una API key del usuario como lo hace createAPIKey y devuelve la clave
+func createTestAPIKey(t *testing.T, userID int, scopes []string, expiresAt time.Time) string {
+	t.Helper()
+	key, prefix, hash, err := generateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, _ := json.Marshal(scopes)
+	if _, err := dataBase.Insert(false, "INSERT INTO api_keys (name, prefix, key_hash, user_id, scopes, expires_at, created_by) VALUES ('ci', ?, ?, ?, ?, ?, ?)",
+		prefix, hash, userID, string(encoded), database.FormatTime(expiresAt), userID); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// doAPIKeyRequest es como doRequest pero se autentica con la API key en X-API-Key
+func doAPIKeyRequest(t *testing.T, handler http.Handler, method, path, key, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", key)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+// apiKeyPermissions devuelve los permisos que informa GET /permissions para la API key
+func apiKeyPermissions(t *testing.T, handler http.Handler, key string) []string {
+	t.Helper()
+	w := doAPIKeyRequest(t, handler, "GET", "/permissions", key, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /permissions con la API key = %d %s", w.Code, w.Body)
+	}
+	var response struct {
+		Permissions []string `json:"permissions"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	return response.Permissions
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	newTestDatabase(t)
+	userID := createTestUser(t, "integracion", 1)
+	key := createTestAPIKey(t, userID, []string{PermReportsRead}, time.Now().Add(time.Hour))
+
+	// En la base solo queda el hash: ni la clave ni su hash sirven como otra clave
+	var prefix, stored string
+	row, _ := dataBase.SelectRow("SELECT prefix, key_hash FROM api_keys WHERE user_id = ?", userID)
+	if err := row.Scan(&prefix, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored == key || stored != hashToken(key) || !strings.HasPrefix(key, prefix) || len(prefix) != apiKeyPrefixLen {
+		t.Fatalf("se guardó prefix %q y key_hash %q para la clave %q", prefix, stored, key)
+	}
+	if _, _, err := authenticateAPIKey(stored); err == nil {
+		t.Error("authenticateAPIKey aceptó el hash guardado como clave")
+	}
+	if _, _, err := authenticateAPIKey(apiKeyPrefix + "desconocida"); err == nil {
+		t.Error("authenticateAPIKey aceptó una clave que no existe")
+	}
+
+	user, scopes, err := authenticateAPIKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != userID || !reflect.DeepEqual(scopes, []string{PermReportsRead}) {
+		t.Errorf("authenticateAPIKey = usuario %d con %v", user.ID, scopes)
+	}
+	var lastUsed *string
+	row, _ = dataBase.SelectRow("SELECT last_used_at FROM api_keys WHERE key_hash = ?", stored)
+	if err := row.Scan(&lastUsed); err != nil || lastUsed == nil {
+		t.Errorf("no se registró el uso de la API key (%v)", err)
+	}
+
+	// Por header Authorization también se acepta
+	req := httptest.NewRequest("GET", "/permissions", nil)
+	req.Header.Set("Authorization", "ApiKey "+key)
+	if got := apiKeyFromRequest(req); got != key {
+		t.Errorf("apiKeyFromRequest = %q", got)
+	}
+}
+
+func TestExpiredAndRevokedAPIKeys(t *testing.T) {
+	newTestDatabase(t)
+	userID := createTestUser(t, "integracion", 1)
+	router := initRoutes()
+
+	valid := createTestAPIKey(t, userID, []string{PermReportsRead}, time.Now().Add(time.Hour))
+	expired := createTestAPIKey(t, userID, []string{PermReportsRead}, time.Now().Add(-time.Minute))
+	revoked := createTestAPIKey(t, userID, []string{PermReportsRead}, time.Now().Add(time.Hour))
+	if _, err := dataBase.Update(false, "UPDATE api_keys SET revoked_at = ? WHERE key_hash = ?", database.FormatTime(time.Now()), hashToken(revoked)); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		key  string
+		want int
+	}{
+		{"vigente", valid, http.StatusOK},
+		{"vencida", expired, http.StatusUnauthorized},
+		{"revocada", revoked, http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		if w := doAPIKeyRequest(t, router, "GET", "/permissions", c.key, ""); w.Code != c.want {
+			t.Errorf("API key %s: GET /permissions = %d, se esperaba %d", c.name, w.Code, c.want)
+		}
+	}
+
+	// Tampoco sirve la clave de un usuario desactivado
+	if _, err := dataBase.Update(false, "UPDATE users SET status = ? WHERE id = ?", userStatusInactive, userID); err != nil {
+		t.Fatal(err)
+	}
+	if w := doAPIKeyRequest(t, router, "GET", "/permissions", valid, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("API key de un usuario desactivado: GET /permissions = %d, se esperaba 401", w.Code)
+	}
+}
+
+func TestAPIKeyScopesIntersectRole(t *testing.T) {
+	newTestDatabase(t)
+	managerID := createTestUser(t, "manager", 2)
+	router := initRoutes()
+
+	// catalog:write no es de manager: aunque la clave lo tenga, no lo obtiene
+	key := createTestAPIKey(t, managerID, []string{PermProjectsRead, PermCatalogWrite}, time.Now().Add(time.Hour))
+	if got := apiKeyPermissions(t, router, key); !reflect.DeepEqual(got, []string{PermProjectsRead}) {
+		t.Errorf("permisos de la API key = %v, se esperaba solo %s", got, PermProjectsRead)
+	}
+
+	req := httptest.NewRequest("GET", "/projects", nil)
+	req = req.WithContext(context.WithValue(req.Context(), apiKeyScopesKey, []string{PermProjectsRead, PermCatalogWrite}))
+	manager := &models.User{ID: managerID, Rank: 2}
+	for permission, want := range map[string]bool{
+		PermProjectsRead:  true,  // del rol y de la clave
+		PermCatalogWrite:  false, // de la clave pero no del rol
+		PermProjectsWrite: false, // del rol pero no de la clave
+	} {
+		if got := requestHasPermission(req, manager, permission); got != want {
+			t.Errorf("requestHasPermission(%s) = %v, se esperaba %v", permission, got, want)
+		}
+	}
+
+	cases := []struct {
+		method, path, body string
+		want               int
+	}{
+		{"GET", "/projects", "", http.StatusOK},
+		{"POST", "/projects", `{"name": "Obra"}`, http.StatusForbidden},
+		{"POST", "/categories", `{"type": "project", "name": "Nueva"}`, http.StatusForbidden},
+		{"GET", "/clients", "", http.StatusForbidden},
+	}
+	for _, c := range cases {
+		if w := doAPIKeyRequest(t, router, c.method, c.path, key, c.body); w.Code != c.want {
+			t.Errorf("%s %s con la API key = %d, se esperaba %d", c.method, c.path, w.Code, c.want)
+		}
+	}
+}
+
+func TestAPIKeyCannotReachSensitiveEndpoints(t *testing.T) {
+	newTestDatabase(t)
+	adminID := createTestUser(t, "admin", 3)
+	otherID := createTestUser(t, "otro", 1)
+	router := initRoutes()
+	key := createTestAPIKey(t, adminID, adminPermissions, time.Now().Add(time.Hour))
+
+	cases := []struct {
+		method, path, body string
+	}{
+		{"POST", "/api-keys", fmt.Sprintf(`{"name": "otra", "scopes": [%q]}`, PermReportsRead)},
+		{"POST", "/2fa/enroll", ""},
+		{"POST", "/2fa/disable", `{"code": "000000"}`},
+		{"POST", "/profile/password", fmt.Sprintf(`{"current_password": %q, "new_password": "Otra-Clave-Segura-10"}`, testPassword)},
+		{"POST", fmt.Sprintf("/users/%d/impersonate", otherID), ""},
+		{"DELETE", fmt.Sprintf("/users/%d", otherID), ""},
+		{"POST", "/users/invite", `{"email": "nuevo@example.com", "rank": 1}`},
+	}
+	for _, c := range cases {
+		// La clave tiene todos los permisos de admin: el 403 tiene que venir de rejectAPIKey
+		w := doAPIKeyRequest(t, router, c.method, c.path, key, c.body)
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "con una API key") {
+			t.Errorf("%s %s con la API key = %d %s, se esperaba 403 de rejectAPIKey", c.method, c.path, w.Code, strings.TrimSpace(w.Body.String()))
+		}
+	}
+
+	var keys, status int
+	row, _ := dataBase.SelectRow("SELECT COUNT(*) FROM api_keys")
+	row.Scan(&keys)
+	row, _ = dataBase.SelectRow("SELECT COUNT(*) FROM users WHERE id = ? AND status = ?", otherID, userStatusActive)
+	row.Scan(&status)
+	if keys != 1 || status != 1 {
+		t.Errorf("la API key creó %d claves o desactivó al usuario (activo: %d)", keys-1, status)
+	}
+}
@@ -0,0 +1,281 @@
+// mockoidc es un proveedor OpenID Connect mínimo para probar el login OIDC de MagPanel en local.
+// Aprueba cualquier pedido de autorización sin pedir credenciales y firma los id_token con una
+// clave RSA generada al arrancar. No usar fuera de desarrollo.
+//
+//	go run ./cmd/mockoidc -addr :9998 -email ana@example.com -groups magpanel-admins
+//
+// La identidad se puede cambiar por pedido agregando a la URL de autorización los parámetros
+// mock_sub, mock_email, mock_name, mock_username, mock_groups (separados por coma) y
+// mock_email_verified=false.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const keyID = "mock-1"
+
+// authorization es un código emitido por /authorize y todavía no canjeado
+type authorization struct {
+	clientID      string
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	claims        jwt.MapClaims
+	expiresAt     time.Time
+}
+
+type provider struct {
+	issuer   string
+	clientID string
+	secret   string
+	key      *rsa.PrivateKey
+
+	// Identidad por defecto
+	sub, email, name, username string
+	groups                     []string
+
+	mu    sync.Mutex
+	codes map[string]*authorization
+}
+
+func main() {
+	addr := flag.String("addr", ":9998", "Dirección en la que escucha el proveedor")
+	issuer := flag.String("issuer", "", "Issuer publicado (por defecto http://localhost<addr>)")
+	clientID := flag.String("client-id", "magpanel", "client_id aceptado")
+	secret := flag.String("client-secret", "", "client_secret exigido en /token (vacío: cliente público)")
+	sub := flag.String("sub", "mock-user-1", "sub del usuario por defecto")
+	email := flag.String("email", "ana@example.com", "Email del usuario por defecto")
+	name := flag.String("name", "Ana Pérez", "Nombre del usuario por defecto")
+	username := flag.String("username", "ana", "preferred_username del usuario por defecto")
+	groups := flag.String("groups", "", "Grupos del usuario por defecto, separados por coma")
+	flag.Parse()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	p := &provider{
+		issuer:   *issuer,
+		clientID: *clientID,
+		secret:   *secret,
+		key:      key,
+		sub:      *sub,
+		email:    *email,
+		name:     *name,
+		username: *username,
+		groups:   splitList(*groups),
+		codes:    map[string]*authorization{},
+	}
+	if p.issuer == "" {
+		p.issuer = "http://localhost" + *addr
+	}
+
+	http.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	http.HandleFunc("/authorize", p.authorize)
+	http.HandleFunc("/token", p.token)
+	http.HandleFunc("/jwks", p.jwks)
+
+	log.Printf("Proveedor OIDC de prueba en %s (issuer %s)", *addr, p.issuer)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func tokenError(w http.ResponseWriter, code, description string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code, "error_description": description})
+}
+
+func (p *provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *provider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": keyID,
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+// authorize aprueba el pedido de inmediato y redirige al cliente con un código
+func (p *provider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectURI := q.Get("redirect_uri")
+	if q.Get("client_id") != p.clientID || redirectURI == "" {
+		http.Error(w, "client_id o redirect_uri inválidos", http.StatusBadRequest)
+		return
+	}
+	if q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "solo se admite response_type=code con PKCE S256", http.StatusBadRequest)
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"sub":                override(q.Get("mock_sub"), p.sub),
+		"email":              override(q.Get("mock_email"), p.email),
+		"email_verified":     q.Get("mock_email_verified") != "false",
+		"name":               override(q.Get("mock_name"), p.name),
+		"preferred_username": override(q.Get("mock_username"), p.username),
+	}
+	groups := p.groups
+	if q.Has("mock_groups") {
+		groups = splitList(q.Get("mock_groups"))
+	}
+	if len(groups) > 0 {
+		claims["groups"] = groups
+	}
+
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	code := hex.EncodeToString(buf)
+
+	p.mu.Lock()
+	p.codes[code] = &authorization{
+		clientID:      p.clientID,
+		redirectURI:   redirectURI,
+		nonce:         q.Get("nonce"),
+		codeChallenge: q.Get("code_challenge"),
+		claims:        claims,
+		expiresAt:     time.Now().Add(time.Minute),
+	}
+	p.mu.Unlock()
+
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "redirect_uri inválido", http.StatusBadRequest)
+		return
+	}
+	values := target.Query()
+	values.Set("code", code)
+	values.Set("state", q.Get("state"))
+	target.RawQuery = values.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+func override(value, fallback string) string {
+	if value != "" {
+		return value
+	}
+	return fallback
+}
+
+// token canjea el código por un id_token, verificando PKCE y la autenticación del cliente
+func (p *provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request", err.Error())
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type", "solo authorization_code")
+		return
+	}
+
+	clientID, secret, hasBasic := r.BasicAuth()
+	if hasBasic {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+	}
+	if clientID != p.clientID || (p.secret != "" && secret != p.secret) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	auth := p.codes[code]
+	delete(p.codes, code) // Un código se canjea una sola vez
+	p.mu.Unlock()
+	if auth == nil || time.Now().After(auth.expiresAt) {
+		tokenError(w, "invalid_grant", "código inválido o expirado")
+		return
+	}
+	if r.PostForm.Get("redirect_uri") != auth.redirectURI {
+		tokenError(w, "invalid_grant", "redirect_uri no coincide")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != auth.codeChallenge {
+		tokenError(w, "invalid_grant", "code_verifier inválido")
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss": p.issuer,
+		"aud": auth.clientID,
+		"iat": now.Unix(),
+		"exp": now.Add(5 * time.Minute).Unix(),
+	}
+	if auth.nonce != "" {
+		claims["nonce"] = auth.nonce
+	}
+	for k, v := range auth.claims {
+		claims[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": fmt.Sprintf("mock-%s", code),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
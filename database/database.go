@@ -1,21 +1,63 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
+	"net/url"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "modernc.org/sqlite"
 )
 
 type DatabaseStruct struct {
 	connection *sql.DB
+	dialect    dialect
+}
+
+// Tx envuelve una transacción en curso y expone los mismos helpers que DatabaseStruct
+type Tx struct {
+	tx      *sql.Tx
+	dialect dialect
+}
+
+// Config describe la conexión leída de la sección [database] de data.conf
+type Config struct {
+	Driver string // "mysql" (por defecto) o "sqlite"
+	User   string
+	Pass   string
+	Name   string
+	Host   string
+	Path   string // archivo de SQLite, ":memory:" para una base en memoria
+}
+
+// executor es la interfaz común entre *sql.DB y *sql.Tx
+type executor interface {
+	Prepare(query string) (*sql.Stmt, error)
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Open abre la base de datos con el motor indicado en la configuración
+func Open(cfg Config) (*DatabaseStruct, error) {
+	switch cfg.Driver {
+	case "", DriverMySQL:
+		return NewDatabase(cfg.User, cfg.Pass, cfg.Name, cfg.Host)
+	case DriverSQLite:
+		return NewSQLiteDatabase(cfg.Path)
+	}
+	return nil, fmt.Errorf("motor de base de datos desconocido: %s", cfg.Driver)
 }
 
 func NewDatabase(dbUser, dbPass, dbName, dbHost string) (*DatabaseStruct, error) {
 
-	// Construir la cadena de conexión
-	connectionString := fmt.Sprintf("%s:%s@tcp(%s)/%s", dbUser, dbPass, dbHost, dbName)
+	// Construir la cadena de conexión. La sesión usa UTC, igual que FormatTime, para que las
+	// fechas que completa MySQL (CURRENT_TIMESTAMP, NOW()) coincidan con las que escribe la API
+	connectionString := fmt.Sprintf("%s:%s@tcp(%s)/%s?time_zone=%s", dbUser, dbPass, dbHost, dbName, url.QueryEscape("'+00:00'"))
 
-	db, err := sql.Open("mysql", connectionString)
+	db, err := sql.Open(DriverMySQL, connectionString)
 
 	// Cambia los detalles de conexión según tu configuración de MySQL
 	if err != nil {
@@ -24,60 +66,145 @@ func NewDatabase(dbUser, dbPass, dbName, dbHost string) (*DatabaseStruct, error)
 
 	err = db.Ping()
 
-	return &DatabaseStruct{connection: db}, err
+	return &DatabaseStruct{connection: db, dialect: mysqlDialect{}}, err
+}
+
+// NewSQLiteDatabase abre (o crea) una base SQLite embebida, sin necesidad de un servidor
+func NewSQLiteDatabase(path string) (*DatabaseStruct, error) {
+	if path == "" {
+		path = "magpanel.db"
+	}
+
+	// busy_timeout evita errores "database is locked" con escrituras concurrentes y
+	// _txlock=immediate toma el lock de escritura al iniciar cada transacción
+	connectionString := "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=foreign_keys(0)&_txlock=immediate"
+	if path != ":memory:" {
+		connectionString += "&_pragma=journal_mode(WAL)"
+	}
+
+	db, err := sql.Open(DriverSQLite, connectionString)
+	if err != nil {
+		return nil, err
+	}
+
+	if path == ":memory:" {
+		// Cada conexión a ":memory:" es una base distinta, así que usamos una sola.
+		// Ojo: dentro de WithTx no se puede usar la conexión principal o se bloquea.
+		db.SetMaxOpenConns(1)
+	}
+
+	err = db.Ping()
+
+	return &DatabaseStruct{connection: db, dialect: sqliteDialect{}}, err
+}
+
+// Driver devuelve el motor en uso ("mysql" o "sqlite")
+func (db *DatabaseStruct) Driver() string {
+	return db.dialect.driver()
 }
 
 func (db *DatabaseStruct) Close() {
 	db.connection.Close()
 }
 
-func (db *DatabaseStruct) Insert(prepare bool, query string, args ...interface{}) (int64, error) {
-	if prepare {
-		stmt, err := db.connection.Prepare(query)
-		if err != nil {
-			return 0, err
-		}
-		defer stmt.Close()
-	}
-	result, err := db.connection.Exec(query, args...)
+// WithTx ejecuta fn dentro de una transacción. Si fn devuelve un error (o entra en pánico)
+// se hace rollback de todo lo escrito; en caso contrario se hace commit.
+func (db *DatabaseStruct) WithTx(ctx context.Context, fn func(tx *Tx) error) (err error) {
+	sqlTx, err := db.connection.BeginTx(ctx, nil)
 	if err != nil {
-		return 0, err
+		return err
 	}
-	id, err := result.LastInsertId()
-	if err != nil {
-		return 0, err
+
+	defer func() {
+		if p := recover(); p != nil {
+			sqlTx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err = fn(&Tx{tx: sqlTx, dialect: db.dialect}); err != nil {
+		if rbErr := sqlTx.Rollback(); rbErr != nil {
+			log.Printf("Error al hacer rollback de la transacción: %v", rbErr)
+		}
+		return err
 	}
-	return id, nil
+
+	return sqlTx.Commit()
+}
+
+func (db *DatabaseStruct) Insert(prepare bool, query string, args ...interface{}) (int64, error) {
+	return insert(db.connection, prepare, db.dialect.rewrite(query), args...)
 }
 
 func (db *DatabaseStruct) Update(prepare bool, query string, args ...interface{}) (int64, error) {
+	return exec(db.connection, prepare, db.dialect.rewrite(query), args...)
+}
+
+func (db *DatabaseStruct) Delete(prepare bool, query string, args ...interface{}) (int64, error) {
+	return exec(db.connection, prepare, db.dialect.rewrite(query), args...)
+}
+
+// el retorno rows requiere un defer rows.Close()
+func (db *DatabaseStruct) Select(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.connection.Query(db.dialect.rewrite(query), args...)
+}
+
+func (db *DatabaseStruct) SelectRow(query string, args ...interface{}) (*sql.Row, error) {
+	row := db.connection.QueryRow(db.dialect.rewrite(query), args...)
+	return row, nil
+}
+
+func (t *Tx) Insert(prepare bool, query string, args ...interface{}) (int64, error) {
+	return insert(t.tx, prepare, t.dialect.rewrite(query), args...)
+}
+
+func (t *Tx) Update(prepare bool, query string, args ...interface{}) (int64, error) {
+	return exec(t.tx, prepare, t.dialect.rewrite(query), args...)
+}
+
+func (t *Tx) Delete(prepare bool, query string, args ...interface{}) (int64, error) {
+	return exec(t.tx, prepare, t.dialect.rewrite(query), args...)
+}
+
+// el retorno rows requiere un defer rows.Close() antes de la siguiente sentencia de la transacción
+func (t *Tx) Select(query string, args ...interface{}) (*sql.Rows, error) {
+	return t.tx.Query(t.dialect.rewrite(query), args...)
+}
+
+func (t *Tx) SelectRow(query string, args ...interface{}) (*sql.Row, error) {
+	row := t.tx.QueryRow(t.dialect.rewrite(query), args...)
+	return row, nil
+}
+
+func insert(e executor, prepare bool, query string, args ...interface{}) (int64, error) {
 	if prepare {
-		stmt, err := db.connection.Prepare(query)
+		stmt, err := e.Prepare(query)
 		if err != nil {
 			return 0, err
 		}
 		defer stmt.Close()
 	}
-	result, err := db.connection.Exec(query, args...)
+	result, err := e.Exec(query, args...)
 	if err != nil {
 		return 0, err
 	}
-	rows, err := result.RowsAffected()
+	id, err := result.LastInsertId()
 	if err != nil {
 		return 0, err
 	}
-	return rows, nil
+	return id, nil
 }
 
-func (db *DatabaseStruct) Delete(prepare bool, query string, args ...interface{}) (int64, error) {
+// exec se usa tanto para UPDATE como para DELETE, devuelve las filas afectadas
+func exec(e executor, prepare bool, query string, args ...interface{}) (int64, error) {
 	if prepare {
-		stmt, err := db.connection.Prepare(query)
+		stmt, err := e.Prepare(query)
 		if err != nil {
 			return 0, err
 		}
 		defer stmt.Close()
 	}
-	result, err := db.connection.Exec(query, args...)
+	result, err := e.Exec(query, args...)
 	if err != nil {
 		return 0, err
 	}
@@ -87,17 +214,3 @@ func (db *DatabaseStruct) Delete(prepare bool, query string, args ...interface{}
 	}
 	return rows, nil
 }
-
-// el retorno rows requiere un defer rows.Close()
-func (db *DatabaseStruct) Select(query string, args ...interface{}) (*sql.Rows, error) {
-	rows, err := db.connection.Query(query, args...)
-	if err != nil {
-		return nil, err
-	}
-	return rows, nil
-}
-
-func (db *DatabaseStruct) SelectRow(query string, args ...interface{}) (*sql.Row, error) {
-	row := db.connection.QueryRow(query, args...)
-	return row, nil
-}
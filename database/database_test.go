@@ -0,0 +1,187 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// openMemory abre una base SQLite en memoria que se cierra al terminar el test
+func openMemory(t *testing.T) *DatabaseStruct {
+	t.Helper()
+	db, err := NewSQLiteDatabase(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	return db
+}
+
+func TestSQLiteRewrite(t *testing.T) {
+	cases := []struct{ query, want string }{
+		{"UPDATE projects SET updated_at = NOW() WHERE id = ?", "UPDATE projects SET updated_at = CURRENT_TIMESTAMP WHERE id = ?"},
+		{"SELECT now(), NOW()", "SELECT CURRENT_TIMESTAMP, CURRENT_TIMESTAMP"},
+		{"SELECT failed_login_count FROM users WHERE id = ? FOR UPDATE", "SELECT failed_login_count FROM users WHERE id = ?"},
+		{"SELECT id FROM t\n  for   update", "SELECT id FROM t"},
+		{"INSERT IGNORE INTO project_members (project_id) VALUES (?)", "INSERT OR IGNORE INTO project_members (project_id) VALUES (?)"},
+		{"SELECT snow() FROM t", "SELECT snow() FROM t"},
+		{"SELECT `rank` FROM users", "SELECT `rank` FROM users"},
+	}
+	for _, c := range cases {
+		if got := (sqliteDialect{}).rewrite(c.query); got != c.want {
+			t.Errorf("rewrite(%q) = %q, se esperaba %q", c.query, got, c.want)
+		}
+	}
+}
+
+func TestMySQLRewriteKeepsQuery(t *testing.T) {
+	query := "INSERT IGNORE INTO t (a) VALUES (NOW()) FOR UPDATE"
+	if got := (mysqlDialect{}).rewrite(query); got != query {
+		t.Errorf("rewrite(%q) = %q, MySQL no debería cambiar la consulta", query, got)
+	}
+}
+
+func TestSQLiteRewrittenQueriesRun(t *testing.T) {
+	db := openMemory(t)
+	if _, err := db.Update(false, "CREATE TABLE t (id INTEGER PRIMARY KEY AUTOINCREMENT, `name` TEXT NOT NULL UNIQUE, created_at TEXT NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := db.Insert(true, "INSERT INTO t (`name`, created_at) VALUES (?, NOW())", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 1 {
+		t.Errorf("LastInsertId = %d, se esperaba 1", id)
+	}
+
+	affected, err := db.Update(true, "INSERT IGNORE INTO t (`name`, created_at) VALUES (?, NOW())", "a")
+	if err != nil {
+		t.Fatalf("INSERT IGNORE con un duplicado: %v", err)
+	}
+	if affected != 0 {
+		t.Errorf("INSERT IGNORE con un duplicado afectó %d filas", affected)
+	}
+
+	var createdAt string
+	err = db.WithTx(context.Background(), func(tx *Tx) error {
+		row, err := tx.SelectRow("SELECT created_at FROM t WHERE id = ? FOR UPDATE", id)
+		if err != nil {
+			return err
+		}
+		return row.Scan(&createdAt)
+	})
+	if err != nil {
+		t.Fatalf("SELECT ... FOR UPDATE: %v", err)
+	}
+	// CURRENT_TIMESTAMP de SQLite está en UTC, igual que FormatTime
+	at, err := ParseTime(createdAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(at); d < -time.Minute || d > time.Minute {
+		t.Errorf("NOW() guardó %s, que no es la hora actual en UTC", createdAt)
+	}
+}
+
+func TestWithTx(t *testing.T) {
+	db := openMemory(t)
+	if _, err := db.Update(false, "CREATE TABLE t (id INTEGER PRIMARY KEY AUTOINCREMENT, n INTEGER NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+	count := func() int {
+		var n int
+		row, _ := db.SelectRow("SELECT COUNT(*) FROM t")
+		if err := row.Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	insertTwo := func(tx *Tx) error {
+		for i := 0; i < 2; i++ {
+			if _, err := tx.Insert(false, "INSERT INTO t (n) VALUES (?)", i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	errFailed := errors.New("falla")
+	err := db.WithTx(context.Background(), func(tx *Tx) error {
+		if err := insertTwo(tx); err != nil {
+			return err
+		}
+		return errFailed
+	})
+	if err != errFailed {
+		t.Fatalf("WithTx devolvió %v, se esperaba el error de fn", err)
+	}
+	if n := count(); n != 0 {
+		t.Errorf("después del rollback quedaron %d filas", n)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("WithTx no propagó el pánico")
+			}
+		}()
+		db.WithTx(context.Background(), func(tx *Tx) error {
+			insertTwo(tx)
+			panic("pánico")
+		})
+	}()
+	if n := count(); n != 0 {
+		t.Errorf("después de un pánico quedaron %d filas", n)
+	}
+
+	if err := db.WithTx(context.Background(), insertTwo); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 2 {
+		t.Errorf("después del commit hay %d filas, se esperaban 2", n)
+	}
+}
+
+func TestFormatTimeIsUTC(t *testing.T) {
+	local := time.Date(2024, 3, 1, 21, 30, 0, 0, time.FixedZone("UTC-3", -3*60*60))
+	if got := FormatTime(local); got != "2024-03-02 00:30:00" {
+		t.Errorf("FormatTime = %s, se esperaba la hora en UTC", got)
+	}
+	parsed, err := ParseTime("2024-03-02T00:30:00Z")
+	if err != nil || !parsed.Equal(local) {
+		t.Errorf("ParseTime(RFC3339) = %v, %v", parsed, err)
+	}
+}
+
+func TestIsDuplicateKey(t *testing.T) {
+	db := openMemory(t)
+	if _, err := db.Update(false, "CREATE TABLE t (id INTEGER PRIMARY KEY, code TEXT NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Update(false, "CREATE UNIQUE INDEX t_code ON t (code) WHERE code <> ''"); err != nil {
+		t.Fatal(err)
+	}
+	for _, code := range []string{"A-1", "", ""} {
+		if _, err := db.Insert(false, "INSERT INTO t (code) VALUES (?)", code); err != nil {
+			t.Fatalf("insert %q: %v", code, err)
+		}
+	}
+
+	_, err := db.Insert(false, "INSERT INTO t (code) VALUES (?)", "A-1")
+	if !IsDuplicateKey(err) {
+		t.Errorf("IsDuplicateKey(%v) = false con un código repetido", err)
+	}
+	_, err = db.Insert(false, "INSERT INTO t (id, code) VALUES (1, 'B-1')")
+	if !IsDuplicateKey(err) {
+		t.Errorf("IsDuplicateKey(%v) = false con una clave primaria repetida", err)
+	}
+	_, err = db.Insert(false, "INSERT INTO t (code) VALUES (NULL)")
+	if err == nil || IsDuplicateKey(err) {
+		t.Errorf("IsDuplicateKey(%v) = true con un NOT NULL", err)
+	}
+	if IsDuplicateKey(errors.New("Duplicate entry")) {
+		t.Error("IsDuplicateKey aceptó un error que no es del motor")
+	}
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+const (
+	DriverMySQL  = "mysql"
+	DriverSQLite = "sqlite"
+)
+
+// dialect adapta las sentencias escritas para MySQL al motor configurado
+type dialect interface {
+	driver() string
+	rewrite(query string) string
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) driver() string { return DriverMySQL }
+
+// Las consultas del proyecto están escritas en MySQL, no hay nada que adaptar
+func (mysqlDialect) rewrite(query string) string { return query }
+
+var (
+	nowCall      = regexp.MustCompile(`(?i)\bNOW\(\)`)
+	forUpdate    = regexp.MustCompile(`(?i)\s+FOR\s+UPDATE\b`)
+	insertIgnore = regexp.MustCompile(`(?i)\bINSERT\s+IGNORE\b`)
+)
+
+// sqliteDialect traduce las construcciones de MySQL usadas en los handlers.
+// SQLite ya acepta los identificadores entre backticks y LastInsertId funciona igual
+// con las columnas INTEGER PRIMARY KEY AUTOINCREMENT, así que no requieren cambios.
+type sqliteDialect struct{}
+
+func (sqliteDialect) driver() string { return DriverSQLite }
+
+func (sqliteDialect) rewrite(query string) string {
+	query = nowCall.ReplaceAllString(query, "CURRENT_TIMESTAMP")
+	// SQLite bloquea la base completa al escribir dentro de la transacción, FOR UPDATE no aplica
+	query = forUpdate.ReplaceAllString(query, "")
+	query = insertIgnore.ReplaceAllString(query, "INSERT OR IGNORE")
+	return query
+}
+
+// IsDuplicateKey indica si el error es una violación de un índice único, en cualquiera de los
+// dos motores
+func IsDuplicateKey(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1062 // ER_DUP_ENTRY
+	}
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+	}
+	return false
+}
+
+// TimeLayout es el formato en el que se guardan y leen las fechas en ambos motores
+const TimeLayout = "2006-01-02 15:04:05"
+
+// FormatTime convierte una fecha a UTC en el formato de las columnas DATETIME
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(TimeLayout)
+}
+
+// ParseTime interpreta una fecha leída de la base (siempre en UTC)
+func ParseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(TimeLayout, value); err == nil {
+		return t, nil
+	}
+	// SQLite puede devolver las columnas DATETIME/TIMESTAMP ya formateadas en RFC3339
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,257 @@
+package database
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/mysql/*.sql migrations/sqlite/*.sql
+var migrationFiles embed.FS
+
+// Migration representa un archivo NNNN_nombre.up.sql con su correspondiente .down.sql; Down queda
+// vacío en las migraciones que no se pueden revertir
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus indica si una migración conocida está aplicada en la base
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt string
+}
+
+const createMigrationsTable = "CREATE TABLE IF NOT EXISTS schema_migrations (" +
+	"version BIGINT NOT NULL PRIMARY KEY, " +
+	"name VARCHAR(255) NOT NULL, " +
+	"applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)"
+
+// LoadMigrations lee las migraciones embebidas del motor indicado y las devuelve ordenadas por versión
+func LoadMigrations(driver string) ([]Migration, error) {
+	dir := path.Join("migrations", driver)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		fileName := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(fileName, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(fileName, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		base := strings.TrimSuffix(fileName, "."+direction+".sql")
+		versionPart, name, found := strings.Cut(base, "_")
+		if !found {
+			return nil, fmt.Errorf("nombre de migración inválido: %s", fileName)
+		}
+		version, err := strconv.ParseInt(versionPart, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("versión de migración inválida en %s: %v", fileName, err)
+		}
+
+		content, err := migrationFiles.ReadFile(path.Join(dir, fileName))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("la versión %d está duplicada (%s y %s)", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("la migración %04d_%s no tiene archivo .up.sql", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// appliedMigrations devuelve las versiones aplicadas y su fecha de aplicación
+func (db *DatabaseStruct) appliedMigrations() (map[int64]string, error) {
+	if _, err := db.connection.Exec(createMigrationsTable); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.connection.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int64]string{}
+	for rows.Next() {
+		var version int64
+		var appliedAt string
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// MigrationStatus devuelve todas las migraciones conocidas indicando cuáles están aplicadas
+func (db *DatabaseStruct) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := LoadMigrations(db.Driver())
+	if err != nil {
+		return nil, err
+	}
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		appliedAt, ok := applied[m.Version]
+		status = append(status, MigrationStatus{Migration: m, Applied: ok, AppliedAt: appliedAt})
+	}
+	return status, nil
+}
+
+// PendingMigrations devuelve las migraciones que todavía no fueron aplicadas
+func (db *DatabaseStruct) PendingMigrations() ([]Migration, error) {
+	status, err := db.MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, s := range status {
+		if !s.Applied {
+			pending = append(pending, s.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// MigrateUp aplica en orden todas las migraciones pendientes y devuelve las que aplicó
+func (db *DatabaseStruct) MigrateUp() ([]Migration, error) {
+	pending, err := db.PendingMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range pending {
+		if err := db.execScript(m.Up); err != nil {
+			return done, fmt.Errorf("migración %04d_%s: %v", m.Version, m.Name, err)
+		}
+		if _, err := db.connection.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)", m.Version, m.Name, FormatTime(time.Now())); err != nil {
+			return done, err
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// MigrateDown revierte las últimas `steps` migraciones aplicadas, de la más nueva a la más vieja.
+// Las migraciones sin .down.sql (como el esquema inicial) no se pueden revertir: si alguna entra
+// en las `steps` no se revierte ninguna.
+func (db *DatabaseStruct) MigrateDown(steps int) ([]Migration, error) {
+	status, err := db.MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []Migration
+	for i := len(status) - 1; i >= 0 && len(targets) < steps; i-- {
+		m := status[i]
+		if !m.Applied {
+			continue
+		}
+		if m.Down == "" {
+			return nil, fmt.Errorf("la migración %04d_%s no se puede revertir", m.Version, m.Name)
+		}
+		targets = append(targets, m.Migration)
+	}
+
+	var done []Migration
+	for _, m := range targets {
+		if err := db.execScript(m.Down); err != nil {
+			return done, fmt.Errorf("migración %04d_%s: %v", m.Version, m.Name, err)
+		}
+		if _, err := db.connection.Exec("DELETE FROM schema_migrations WHERE version = ?", m.Version); err != nil {
+			return done, err
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// execScript ejecuta un archivo .sql sentencia por sentencia
+func (db *DatabaseStruct) execScript(script string) error {
+	for _, statement := range splitStatements(script) {
+		if _, err := db.connection.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitStatements separa un script en sentencias terminadas en ";" al final de la línea.
+// Los bloques BEGIN ... END; (triggers) se mantienen como una sola sentencia.
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+	inBlock := false
+
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if current.Len() == 0 && (trimmed == "" || strings.HasPrefix(trimmed, "--")) {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+
+		upper := strings.ToUpper(trimmed)
+		if strings.HasSuffix(upper, "BEGIN") {
+			inBlock = true
+		}
+		if inBlock {
+			if upper == "END;" {
+				inBlock = false
+			} else {
+				continue
+			}
+		}
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSuffix(strings.TrimSpace(current.String()), ";"))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
@@ -0,0 +1,175 @@
+-- Esquema inicial de MagPanel, reconstruido a partir de la base de producción.
+-- Se usa IF NOT EXISTS para que las bases existentes puedan adoptar las migraciones sin perder datos.
+
+CREATE TABLE IF NOT EXISTS users (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    username VARCHAR(64) NOT NULL,
+    `rank` INT NOT NULL DEFAULT 0,
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    password_hash VARCHAR(255) NOT NULL DEFAULT '',
+    salt VARCHAR(64) NOT NULL DEFAULT '',
+    recovery_hash VARCHAR(255) NULL,
+    recovery_hash_time DATETIME NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY users_username_unique (username),
+    KEY users_email_index (email)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS categories (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `type` VARCHAR(32) NOT NULL,
+    code VARCHAR(32) NULL,
+    name VARCHAR(255) NOT NULL,
+    fields TEXT NOT NULL,
+    filters TEXT NOT NULL,
+    PRIMARY KEY (id),
+    KEY categories_type_index (`type`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS clients (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    code VARCHAR(32) NOT NULL DEFAULT '',
+    name VARCHAR(255) NOT NULL,
+    address VARCHAR(255) NOT NULL DEFAULT '',
+    phone VARCHAR(64) NOT NULL DEFAULT '',
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    web VARCHAR(255) NOT NULL DEFAULT '',
+    city VARCHAR(128) NOT NULL DEFAULT '',
+    category_id INT UNSIGNED NOT NULL DEFAULT 0,
+    company VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY clients_code_index (code)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS providers (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    code VARCHAR(32) NOT NULL DEFAULT '',
+    name VARCHAR(255) NOT NULL,
+    address VARCHAR(255) NOT NULL DEFAULT '',
+    phone VARCHAR(64) NOT NULL DEFAULT '',
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    web VARCHAR(255) NOT NULL DEFAULT '',
+    city VARCHAR(128) NOT NULL DEFAULT '',
+    category_id INT UNSIGNED NOT NULL DEFAULT 0,
+    company VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS contacts (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    name VARCHAR(255) NOT NULL,
+    position VARCHAR(255) NOT NULL DEFAULT '',
+    phone VARCHAR(64) NOT NULL DEFAULT '',
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS client_contact (
+    client_id INT UNSIGNED NOT NULL,
+    contact_id INT UNSIGNED NOT NULL,
+    PRIMARY KEY (client_id, contact_id),
+    KEY client_contact_contact_index (contact_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS provider_contact (
+    provider_id INT UNSIGNED NOT NULL,
+    contact_id INT UNSIGNED NOT NULL,
+    PRIMARY KEY (provider_id, contact_id),
+    KEY provider_contact_contact_index (contact_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS locations (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    name VARCHAR(255) NOT NULL,
+    lat DECIMAL(10, 7) NOT NULL DEFAULT 0,
+    lng DECIMAL(10, 7) NOT NULL DEFAULT 0,
+    state VARCHAR(128) NOT NULL DEFAULT '',
+    city VARCHAR(128) NOT NULL DEFAULT '',
+    country VARCHAR(128) NOT NULL DEFAULT '',
+    PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS project_statuses (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    status_name VARCHAR(255) NOT NULL,
+    `order` INT NOT NULL DEFAULT 0,
+    category_id INT UNSIGNED NOT NULL,
+    PRIMARY KEY (id),
+    KEY project_statuses_category_index (category_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS projects (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    code VARCHAR(64) NOT NULL DEFAULT '',
+    name VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL,
+    category_id INT UNSIGNED NOT NULL,
+    client_id INT UNSIGNED NOT NULL,
+    status_id INT UNSIGNED NOT NULL,
+    location_id INT UNSIGNED NOT NULL,
+    author_id INT UNSIGNED NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY projects_category_index (category_id),
+    KEY projects_client_index (client_id),
+    KEY projects_status_index (status_id),
+    KEY projects_author_index (author_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS reports (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    project_id INT UNSIGNED NOT NULL,
+    category_id INT UNSIGNED NOT NULL,
+    fields JSON NOT NULL,
+    author_id INT UNSIGNED NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY reports_project_index (project_id),
+    KEY reports_author_index (author_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS settings (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `key` VARCHAR(128) NOT NULL,
+    `value` TEXT NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    PRIMARY KEY (id),
+    UNIQUE KEY settings_key_unique (`key`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS logs (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `type` VARCHAR(64) NOT NULL,
+    old_value LONGTEXT NOT NULL,
+    new_value LONGTEXT NOT NULL,
+    user_id INT UNSIGNED NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY logs_type_index (`type`),
+    KEY logs_user_index (user_id),
+    KEY logs_created_at_index (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS feedbacks (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    user_id INT UNSIGNED NOT NULL,
+    message TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+INSERT IGNORE INTO settings (`key`, `value`, description) VALUES
+    ('mailgun_domain', '', 'Dominio de Mailgun para el envío de correos'),
+    ('mailgun_api_key', '', 'API key de Mailgun'),
+    ('notification_email', '', 'Correo que recibe los feedbacks de los usuarios');
@@ -0,0 +1,18 @@
+ALTER TABLE users ADD COLUMN service_account TINYINT(1) NOT NULL DEFAULT 0;
+
+CREATE TABLE api_keys (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    name VARCHAR(255) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    key_hash CHAR(64) NOT NULL,
+    user_id INT UNSIGNED NOT NULL,
+    scopes TEXT NOT NULL,
+    expires_at DATETIME NOT NULL,
+    last_used_at DATETIME NULL,
+    revoked_at DATETIME NULL,
+    created_by INT UNSIGNED NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY api_keys_key_hash_unique (key_hash),
+    KEY api_keys_user_index (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,16 @@
+CREATE TABLE sessions (
+    id CHAR(32) NOT NULL,
+    user_id INT UNSIGNED NOT NULL,
+    refresh_hash CHAR(64) NOT NULL,
+    previous_refresh_hash CHAR(64) NULL,
+    user_agent VARCHAR(255) NOT NULL DEFAULT '',
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    expires_at DATETIME NOT NULL,
+    last_used_at DATETIME NULL,
+    revoked_at DATETIME NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY sessions_refresh_hash_unique (refresh_hash),
+    KEY sessions_previous_refresh_hash_index (previous_refresh_hash),
+    KEY sessions_user_index (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
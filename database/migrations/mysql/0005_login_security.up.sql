@@ -0,0 +1,17 @@
+ALTER TABLE users ADD COLUMN failed_login_count INT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN lockout_count INT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN locked_until DATETIME NULL;
+
+CREATE TABLE failed_logins (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    username VARCHAR(255) NOT NULL,
+    user_id INT UNSIGNED NULL,
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    user_agent VARCHAR(255) NOT NULL DEFAULT '',
+    reason VARCHAR(32) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY failed_logins_user_index (user_id),
+    KEY failed_logins_ip_index (ip),
+    KEY failed_logins_created_at_index (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,2 @@
+-- Los tokens de recuperación pasan a guardarse hasheados: los pendientes en texto plano se invalidan
+UPDATE users SET recovery_hash = NULL, recovery_hash_time = NULL WHERE recovery_hash IS NOT NULL;
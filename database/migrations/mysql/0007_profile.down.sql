@@ -0,0 +1,4 @@
+ALTER TABLE users DROP COLUMN email_verification_time;
+ALTER TABLE users DROP COLUMN email_verification_hash;
+ALTER TABLE users DROP COLUMN pending_email;
+ALTER TABLE users DROP COLUMN preferences;
@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN preferences TEXT NULL;
+ALTER TABLE users ADD COLUMN pending_email VARCHAR(255) NULL;
+ALTER TABLE users ADD COLUMN email_verification_hash CHAR(64) NULL;
+ALTER TABLE users ADD COLUMN email_verification_time DATETIME NULL;
@@ -0,0 +1,12 @@
+CREATE TABLE jwt_keys (
+    kid VARCHAR(32) NOT NULL,
+    algorithm VARCHAR(16) NOT NULL,
+    secret TEXT NULL,
+    public_key TEXT NULL,
+    active TINYINT(1) NOT NULL DEFAULT 0,
+    retired_at DATETIME NULL,
+    verify_until DATETIME NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (kid),
+    KEY jwt_keys_active_index (active)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
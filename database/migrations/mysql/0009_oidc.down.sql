@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS oidc_states;
+ALTER TABLE users DROP INDEX users_oidc_subject_unique;
+ALTER TABLE users DROP COLUMN oidc_subject;
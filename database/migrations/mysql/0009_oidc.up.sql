@@ -0,0 +1,11 @@
+ALTER TABLE users ADD COLUMN oidc_subject VARCHAR(255) NULL;
+ALTER TABLE users ADD UNIQUE KEY users_oidc_subject_unique (oidc_subject);
+
+CREATE TABLE oidc_states (
+    state_hash CHAR(64) NOT NULL,
+    nonce CHAR(64) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    expires_at DATETIME NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (state_hash)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS user_invitations;
+ALTER TABLE users DROP COLUMN status;
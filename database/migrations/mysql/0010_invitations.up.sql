@@ -0,0 +1,19 @@
+ALTER TABLE users ADD COLUMN status VARCHAR(16) NOT NULL DEFAULT 'active';
+
+CREATE TABLE user_invitations (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    user_id INT UNSIGNED NOT NULL,
+    username VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    invited_by INT UNSIGNED NOT NULL,
+    send_count INT NOT NULL DEFAULT 1,
+    last_sent_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL,
+    accepted_at DATETIME NULL,
+    revoked_at DATETIME NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY user_invitations_token_hash_unique (token_hash),
+    KEY user_invitations_user_index (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,2 @@
+ALTER TABLE logs DROP INDEX logs_impersonated_user_index, DROP COLUMN impersonated_user_id;
+ALTER TABLE sessions DROP COLUMN impersonator_id;
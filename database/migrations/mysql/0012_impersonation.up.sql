@@ -0,0 +1,2 @@
+ALTER TABLE sessions ADD COLUMN impersonator_id INT UNSIGNED NULL;
+ALTER TABLE logs ADD COLUMN impersonated_user_id INT UNSIGNED NULL, ADD KEY logs_impersonated_user_index (impersonated_user_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS project_code_sequences;
+ALTER TABLE categories DROP COLUMN code_template;
@@ -0,0 +1,7 @@
+ALTER TABLE categories ADD COLUMN code_template VARCHAR(255) NULL;
+
+CREATE TABLE project_code_sequences (
+    scope VARCHAR(191) NOT NULL,
+    last_value INT UNSIGNED NOT NULL DEFAULT 0,
+    PRIMARY KEY (scope)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
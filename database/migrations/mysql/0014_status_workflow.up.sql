@@ -0,0 +1,24 @@
+CREATE TABLE project_status_transitions (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    category_id INT UNSIGNED NOT NULL,
+    from_status_id INT UNSIGNED NOT NULL,
+    to_status_id INT UNSIGNED NOT NULL,
+    roles VARCHAR(255) NOT NULL DEFAULT '',
+    require_comment TINYINT(1) NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY project_status_transitions_unique (from_status_id, to_status_id),
+    KEY project_status_transitions_category_index (category_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE project_status_history (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    project_id INT UNSIGNED NOT NULL,
+    from_status_id INT UNSIGNED NULL,
+    to_status_id INT UNSIGNED NOT NULL,
+    user_id INT UNSIGNED NOT NULL,
+    comment TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY project_status_history_project_index (project_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
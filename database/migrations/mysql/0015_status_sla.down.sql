@@ -0,0 +1,2 @@
+DROP INDEX project_status_history_status_index ON project_status_history;
+ALTER TABLE project_statuses DROP COLUMN sla_hours;
@@ -0,0 +1 @@
+ALTER TABLE logs DROP INDEX logs_project_index, DROP COLUMN project_id;
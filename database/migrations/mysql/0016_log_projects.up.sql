@@ -0,0 +1,9 @@
+ALTER TABLE logs ADD COLUMN project_id INT UNSIGNED NULL, ADD KEY logs_project_index (project_id);
+
+-- Los registros anteriores guardan el proyecto dentro del JSON
+UPDATE logs SET project_id = CAST(JSON_UNQUOTE(JSON_EXTRACT(new_value, '$.id')) AS UNSIGNED)
+WHERE `type` = 'create_project' AND JSON_VALID(new_value);
+UPDATE logs SET project_id = CAST(JSON_UNQUOTE(JSON_EXTRACT(old_value, '$.id')) AS UNSIGNED)
+WHERE `type` IN ('update_project', 'delete_project') AND JSON_VALID(old_value);
+UPDATE logs SET project_id = CAST(JSON_UNQUOTE(JSON_EXTRACT(old_value, '$.project_id')) AS UNSIGNED)
+WHERE `type` IN ('update_report', 'delete_report', 'transition_project') AND JSON_VALID(old_value);
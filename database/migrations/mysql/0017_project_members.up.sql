@@ -0,0 +1,11 @@
+CREATE TABLE project_members (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    project_id INT UNSIGNED NOT NULL,
+    user_id INT UNSIGNED NOT NULL,
+    role VARCHAR(20) NOT NULL,
+    added_by INT UNSIGNED NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY project_members_unique (project_id, user_id),
+    KEY project_members_user_index (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
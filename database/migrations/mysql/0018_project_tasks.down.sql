@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS project_task_items;
+DROP TABLE IF EXISTS project_tasks;
@@ -0,0 +1,30 @@
+CREATE TABLE project_tasks (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    project_id INT UNSIGNED NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL,
+    assignee_id INT UNSIGNED NULL,
+    due_date DATE NULL,
+    priority VARCHAR(10) NOT NULL DEFAULT 'normal',
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    created_by INT UNSIGNED NOT NULL,
+    completed_at TIMESTAMP NULL DEFAULT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY project_tasks_project_index (project_id),
+    KEY project_tasks_assignee_index (assignee_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE project_task_items (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    task_id INT UNSIGNED NOT NULL,
+    text VARCHAR(255) NOT NULL,
+    position INT NOT NULL DEFAULT 0,
+    done TINYINT(1) NOT NULL DEFAULT 0,
+    done_by INT UNSIGNED NULL,
+    done_at TIMESTAMP NULL DEFAULT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY project_task_items_task_index (task_id, position)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS project_milestones;
+DROP INDEX projects_target_end_date_index ON projects;
+ALTER TABLE projects DROP COLUMN start_date, DROP COLUMN target_end_date;
+ALTER TABLE project_statuses DROP COLUMN closed;
@@ -0,0 +1,17 @@
+ALTER TABLE project_statuses ADD COLUMN closed TINYINT(1) NOT NULL DEFAULT 0;
+
+ALTER TABLE projects ADD COLUMN start_date DATE NULL, ADD COLUMN target_end_date DATE NULL;
+CREATE INDEX projects_target_end_date_index ON projects (target_end_date);
+
+CREATE TABLE project_milestones (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    project_id INT UNSIGNED NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    due_date DATE NOT NULL,
+    completed_at TIMESTAMP NULL DEFAULT NULL,
+    created_by INT UNSIGNED NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY project_milestones_project_index (project_id, due_date),
+    KEY project_milestones_due_date_index (due_date)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
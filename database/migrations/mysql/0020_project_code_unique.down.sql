@@ -0,0 +1,2 @@
+DROP INDEX projects_code_unique ON projects;
+ALTER TABLE projects DROP COLUMN code_key;
@@ -0,0 +1,9 @@
+-- Los códigos repetidos se desambiguan con el id; el primer proyecto que lo tomó lo conserva
+UPDATE projects p
+JOIN (SELECT code, MIN(id) AS first_id FROM projects WHERE code <> '' GROUP BY code HAVING COUNT(*) > 1) d
+    ON d.code = p.code AND p.id <> d.first_id
+SET p.code = CONCAT(LEFT(p.code, 53), '-', p.id);
+
+-- Los proyectos sin código ('') quedan fuera del índice: code_key es NULL para ellos
+ALTER TABLE projects ADD COLUMN code_key VARCHAR(64) AS (NULLIF(code, '')) VIRTUAL;
+CREATE UNIQUE INDEX projects_code_unique ON projects (code_key);
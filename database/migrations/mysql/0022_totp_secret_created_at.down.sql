@@ -0,0 +1 @@
+ALTER TABLE users DROP COLUMN totp_secret_created_at;
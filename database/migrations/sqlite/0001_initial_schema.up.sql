@@ -0,0 +1,192 @@
+-- Esquema inicial de MagPanel para SQLite, equivalente a migrations/mysql/0001_initial_schema.up.sql.
+-- Las fechas se guardan como TEXT "YYYY-MM-DD HH:MM:SS" para que se lean igual que en MySQL
+-- y los triggers reemplazan el ON UPDATE CURRENT_TIMESTAMP.
+
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TEXT NOT NULL UNIQUE,
+    `rank` INTEGER NOT NULL DEFAULT 0,
+    email TEXT NOT NULL DEFAULT '',
+    name TEXT NOT NULL DEFAULT '',
+    password_hash TEXT NOT NULL DEFAULT '',
+    salt TEXT NOT NULL DEFAULT '',
+    recovery_hash TEXT NULL,
+    recovery_hash_time TEXT NULL,
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS users_email_index ON users (email);
+
+CREATE TABLE IF NOT EXISTS categories (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    `type` TEXT NOT NULL,
+    code TEXT NULL,
+    name TEXT NOT NULL,
+    fields TEXT NOT NULL,
+    filters TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS categories_type_index ON categories (`type`);
+
+CREATE TABLE IF NOT EXISTS clients (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    code TEXT NOT NULL DEFAULT '',
+    name TEXT NOT NULL,
+    address TEXT NOT NULL DEFAULT '',
+    phone TEXT NOT NULL DEFAULT '',
+    email TEXT NOT NULL DEFAULT '',
+    web TEXT NOT NULL DEFAULT '',
+    city TEXT NOT NULL DEFAULT '',
+    category_id INTEGER NOT NULL DEFAULT 0,
+    company TEXT NOT NULL DEFAULT '',
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS clients_code_index ON clients (code);
+
+CREATE TABLE IF NOT EXISTS providers (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    code TEXT NOT NULL DEFAULT '',
+    name TEXT NOT NULL,
+    address TEXT NOT NULL DEFAULT '',
+    phone TEXT NOT NULL DEFAULT '',
+    email TEXT NOT NULL DEFAULT '',
+    web TEXT NOT NULL DEFAULT '',
+    city TEXT NOT NULL DEFAULT '',
+    category_id INTEGER NOT NULL DEFAULT 0,
+    company TEXT NOT NULL DEFAULT '',
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS contacts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    position TEXT NOT NULL DEFAULT '',
+    phone TEXT NOT NULL DEFAULT '',
+    email TEXT NOT NULL DEFAULT '',
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS client_contact (
+    client_id INTEGER NOT NULL,
+    contact_id INTEGER NOT NULL,
+    PRIMARY KEY (client_id, contact_id)
+);
+CREATE INDEX IF NOT EXISTS client_contact_contact_index ON client_contact (contact_id);
+
+CREATE TABLE IF NOT EXISTS provider_contact (
+    provider_id INTEGER NOT NULL,
+    contact_id INTEGER NOT NULL,
+    PRIMARY KEY (provider_id, contact_id)
+);
+CREATE INDEX IF NOT EXISTS provider_contact_contact_index ON provider_contact (contact_id);
+
+CREATE TABLE IF NOT EXISTS locations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    lat REAL NOT NULL DEFAULT 0,
+    lng REAL NOT NULL DEFAULT 0,
+    state TEXT NOT NULL DEFAULT '',
+    city TEXT NOT NULL DEFAULT '',
+    country TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS project_statuses (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    status_name TEXT NOT NULL,
+    `order` INTEGER NOT NULL DEFAULT 0,
+    category_id INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS project_statuses_category_index ON project_statuses (category_id);
+
+CREATE TABLE IF NOT EXISTS projects (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    code TEXT NOT NULL DEFAULT '',
+    name TEXT NOT NULL,
+    description TEXT NOT NULL,
+    category_id INTEGER NOT NULL,
+    client_id INTEGER NOT NULL,
+    status_id INTEGER NOT NULL,
+    location_id INTEGER NOT NULL,
+    author_id INTEGER NOT NULL,
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS projects_category_index ON projects (category_id);
+CREATE INDEX IF NOT EXISTS projects_client_index ON projects (client_id);
+CREATE INDEX IF NOT EXISTS projects_status_index ON projects (status_id);
+CREATE INDEX IF NOT EXISTS projects_author_index ON projects (author_id);
+
+CREATE TABLE IF NOT EXISTS reports (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id INTEGER NOT NULL,
+    category_id INTEGER NOT NULL,
+    fields TEXT NOT NULL,
+    author_id INTEGER NOT NULL,
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS reports_project_index ON reports (project_id);
+CREATE INDEX IF NOT EXISTS reports_author_index ON reports (author_id);
+
+CREATE TABLE IF NOT EXISTS settings (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    `key` TEXT NOT NULL UNIQUE,
+    `value` TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS logs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    `type` TEXT NOT NULL,
+    old_value TEXT NOT NULL,
+    new_value TEXT NOT NULL,
+    user_id INTEGER NOT NULL,
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS logs_type_index ON logs (`type`);
+CREATE INDEX IF NOT EXISTS logs_user_index ON logs (user_id);
+CREATE INDEX IF NOT EXISTS logs_created_at_index ON logs (created_at);
+
+CREATE TABLE IF NOT EXISTS feedbacks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    message TEXT NOT NULL,
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER IF NOT EXISTS users_updated_at AFTER UPDATE ON users
+FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at BEGIN
+    UPDATE users SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS clients_updated_at AFTER UPDATE ON clients
+FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at BEGIN
+    UPDATE clients SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS providers_updated_at AFTER UPDATE ON providers
+FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at BEGIN
+    UPDATE providers SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS contacts_updated_at AFTER UPDATE ON contacts
+FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at BEGIN
+    UPDATE contacts SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS projects_updated_at AFTER UPDATE ON projects
+FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at BEGIN
+    UPDATE projects SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS reports_updated_at AFTER UPDATE ON reports
+FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at BEGIN
+    UPDATE reports SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
+
+INSERT OR IGNORE INTO settings (`key`, `value`, description) VALUES
+    ('mailgun_domain', '', 'Dominio de Mailgun para el envío de correos'),
+    ('mailgun_api_key', '', 'API key de Mailgun'),
+    ('notification_email', '', 'Correo que recibe los feedbacks de los usuarios');
@@ -0,0 +1,16 @@
+ALTER TABLE users ADD COLUMN service_account INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE api_keys (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    key_hash TEXT NOT NULL UNIQUE,
+    user_id INTEGER NOT NULL,
+    scopes TEXT NOT NULL,
+    expires_at TEXT NOT NULL,
+    last_used_at TEXT NULL,
+    revoked_at TEXT NULL,
+    created_by INTEGER NOT NULL,
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX api_keys_user_index ON api_keys (user_id);
@@ -0,0 +1,14 @@
+CREATE TABLE sessions (
+    id TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    refresh_hash TEXT NOT NULL UNIQUE,
+    previous_refresh_hash TEXT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    expires_at TEXT NOT NULL,
+    last_used_at TEXT NULL,
+    revoked_at TEXT NULL,
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX sessions_previous_refresh_hash_index ON sessions (previous_refresh_hash);
+CREATE INDEX sessions_user_index ON sessions (user_id);
@@ -0,0 +1,16 @@
+ALTER TABLE users ADD COLUMN failed_login_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN lockout_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN locked_until TEXT NULL;
+
+CREATE TABLE failed_logins (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TEXT NOT NULL,
+    user_id INTEGER NULL,
+    ip TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    reason TEXT NOT NULL,
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX failed_logins_user_index ON failed_logins (user_id);
+CREATE INDEX failed_logins_ip_index ON failed_logins (ip);
+CREATE INDEX failed_logins_created_at_index ON failed_logins (created_at);
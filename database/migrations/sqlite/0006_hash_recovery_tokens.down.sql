@@ -0,0 +1,2 @@
+-- Los tokens invalidados no se pueden recuperar
+UPDATE users SET recovery_hash = NULL, recovery_hash_time = NULL WHERE recovery_hash IS NOT NULL;
@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN preferences TEXT NULL;
+ALTER TABLE users ADD COLUMN pending_email TEXT NULL;
+ALTER TABLE users ADD COLUMN email_verification_hash TEXT NULL;
+ALTER TABLE users ADD COLUMN email_verification_time TEXT NULL;
@@ -0,0 +1,11 @@
+CREATE TABLE jwt_keys (
+    kid TEXT PRIMARY KEY,
+    algorithm TEXT NOT NULL,
+    secret TEXT NULL,
+    public_key TEXT NULL,
+    active INTEGER NOT NULL DEFAULT 0,
+    retired_at TEXT NULL,
+    verify_until TEXT NULL,
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX jwt_keys_active_index ON jwt_keys (active);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS oidc_states;
+DROP INDEX IF EXISTS users_oidc_subject_unique;
+ALTER TABLE users DROP COLUMN oidc_subject;
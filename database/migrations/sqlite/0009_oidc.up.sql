@@ -0,0 +1,10 @@
+ALTER TABLE users ADD COLUMN oidc_subject TEXT NULL;
+CREATE UNIQUE INDEX users_oidc_subject_unique ON users (oidc_subject);
+
+CREATE TABLE oidc_states (
+    state_hash TEXT PRIMARY KEY,
+    nonce TEXT NOT NULL,
+    code_verifier TEXT NOT NULL,
+    expires_at TEXT NOT NULL,
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
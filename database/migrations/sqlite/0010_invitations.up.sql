@@ -0,0 +1,17 @@
+ALTER TABLE users ADD COLUMN status TEXT NOT NULL DEFAULT 'active';
+
+CREATE TABLE user_invitations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    username TEXT NOT NULL,
+    email TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    invited_by INTEGER NOT NULL,
+    send_count INTEGER NOT NULL DEFAULT 1,
+    last_sent_at TEXT NOT NULL,
+    expires_at TEXT NOT NULL,
+    accepted_at TEXT NULL,
+    revoked_at TEXT NULL,
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX user_invitations_user_index ON user_invitations (user_id);
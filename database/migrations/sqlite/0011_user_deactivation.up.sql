@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN deactivated_at TEXT NULL;
+ALTER TABLE users ADD COLUMN deactivated_by INTEGER NULL;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS logs_impersonated_user_index;
+ALTER TABLE logs DROP COLUMN impersonated_user_id;
+ALTER TABLE sessions DROP COLUMN impersonator_id;
@@ -0,0 +1,3 @@
+ALTER TABLE sessions ADD COLUMN impersonator_id INTEGER NULL;
+ALTER TABLE logs ADD COLUMN impersonated_user_id INTEGER NULL;
+CREATE INDEX logs_impersonated_user_index ON logs (impersonated_user_id);
@@ -0,0 +1,6 @@
+ALTER TABLE categories ADD COLUMN code_template TEXT NULL;
+
+CREATE TABLE project_code_sequences (
+    scope TEXT PRIMARY KEY,
+    last_value INTEGER NOT NULL DEFAULT 0
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS project_status_history;
+DROP TABLE IF EXISTS project_status_transitions;
@@ -0,0 +1,22 @@
+CREATE TABLE project_status_transitions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    category_id INTEGER NOT NULL,
+    from_status_id INTEGER NOT NULL,
+    to_status_id INTEGER NOT NULL,
+    roles TEXT NOT NULL DEFAULT '',
+    require_comment INTEGER NOT NULL DEFAULT 0,
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE UNIQUE INDEX project_status_transitions_unique ON project_status_transitions (from_status_id, to_status_id);
+CREATE INDEX project_status_transitions_category_index ON project_status_transitions (category_id);
+
+CREATE TABLE project_status_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id INTEGER NOT NULL,
+    from_status_id INTEGER NULL,
+    to_status_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    comment TEXT NOT NULL DEFAULT '',
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX project_status_history_project_index ON project_status_history (project_id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS project_status_history_status_index;
+ALTER TABLE project_statuses DROP COLUMN sla_hours;
@@ -0,0 +1,8 @@
+ALTER TABLE project_statuses ADD COLUMN sla_hours INTEGER NULL;
+
+-- Los proyectos creados antes del historial de estados entran en su estado actual al darse de alta
+INSERT INTO project_status_history (project_id, from_status_id, to_status_id, user_id, comment, created_at)
+SELECT p.id, NULL, p.status_id, p.author_id, '', p.created_at FROM projects p
+WHERE NOT EXISTS (SELECT 1 FROM project_status_history h WHERE h.project_id = p.id);
+
+CREATE INDEX project_status_history_status_index ON project_status_history (to_status_id, created_at);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS logs_project_index;
+ALTER TABLE logs DROP COLUMN project_id;
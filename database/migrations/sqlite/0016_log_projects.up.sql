@@ -0,0 +1,10 @@
+ALTER TABLE logs ADD COLUMN project_id INTEGER NULL;
+CREATE INDEX logs_project_index ON logs (project_id);
+
+-- Los registros anteriores guardan el proyecto dentro del JSON
+UPDATE logs SET project_id = json_extract(new_value, '$.id')
+WHERE `type` = 'create_project' AND json_valid(new_value);
+UPDATE logs SET project_id = json_extract(old_value, '$.id')
+WHERE `type` IN ('update_project', 'delete_project') AND json_valid(old_value);
+UPDATE logs SET project_id = json_extract(old_value, '$.project_id')
+WHERE `type` IN ('update_report', 'delete_report', 'transition_project') AND json_valid(old_value);
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS project_tasks_updated_at;
+DROP TABLE IF EXISTS project_task_items;
+DROP TABLE IF EXISTS project_tasks;
@@ -0,0 +1,33 @@
+CREATE TABLE project_tasks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id INTEGER NOT NULL,
+    title TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    assignee_id INTEGER NULL,
+    due_date TEXT NULL,
+    priority TEXT NOT NULL DEFAULT 'normal',
+    status TEXT NOT NULL DEFAULT 'pending',
+    created_by INTEGER NOT NULL,
+    completed_at TEXT NULL,
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX project_tasks_project_index ON project_tasks (project_id);
+CREATE INDEX project_tasks_assignee_index ON project_tasks (assignee_id);
+
+CREATE TRIGGER IF NOT EXISTS project_tasks_updated_at AFTER UPDATE ON project_tasks
+FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at BEGIN
+    UPDATE project_tasks SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
+
+CREATE TABLE project_task_items (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    task_id INTEGER NOT NULL,
+    text TEXT NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0,
+    done INTEGER NOT NULL DEFAULT 0,
+    done_by INTEGER NULL,
+    done_at TEXT NULL,
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX project_task_items_task_index ON project_task_items (task_id, position);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS project_milestones;
+DROP INDEX IF EXISTS projects_target_end_date_index;
+ALTER TABLE projects DROP COLUMN target_end_date;
+ALTER TABLE projects DROP COLUMN start_date;
+ALTER TABLE project_statuses DROP COLUMN closed;
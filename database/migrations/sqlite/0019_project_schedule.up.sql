@@ -0,0 +1,17 @@
+ALTER TABLE project_statuses ADD COLUMN closed INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE projects ADD COLUMN start_date TEXT NULL;
+ALTER TABLE projects ADD COLUMN target_end_date TEXT NULL;
+CREATE INDEX projects_target_end_date_index ON projects (target_end_date);
+
+CREATE TABLE project_milestones (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    due_date TEXT NOT NULL,
+    completed_at TEXT NULL,
+    created_by INTEGER NOT NULL,
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX project_milestones_project_index ON project_milestones (project_id, due_date);
+CREATE INDEX project_milestones_due_date_index ON project_milestones (due_date);
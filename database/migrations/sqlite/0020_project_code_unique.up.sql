@@ -0,0 +1,6 @@
+-- Los códigos repetidos se desambiguan con el id; el primer proyecto que lo tomó lo conserva
+UPDATE projects SET code = substr(code, 1, 53) || '-' || id
+WHERE code <> '' AND id > (SELECT MIN(p.id) FROM projects p WHERE p.code = projects.code);
+
+-- Los proyectos sin código ('') quedan fuera del índice
+CREATE UNIQUE INDEX projects_code_unique ON projects (code) WHERE code <> '';
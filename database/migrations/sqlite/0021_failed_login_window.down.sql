@@ -0,0 +1 @@
+ALTER TABLE users DROP COLUMN last_failed_login_at;
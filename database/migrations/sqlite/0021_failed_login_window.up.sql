@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN last_failed_login_at TEXT NULL;
@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN totp_secret_created_at TEXT NULL;
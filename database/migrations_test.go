@@ -0,0 +1,201 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMigrationsMatchAcrossDrivers(t *testing.T) {
+	mysql, err := LoadMigrations(DriverMySQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlite, err := LoadMigrations(DriverSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mysql) != len(sqlite) {
+		t.Fatalf("MySQL tiene %d migraciones y SQLite %d", len(mysql), len(sqlite))
+	}
+	for i := range mysql {
+		if mysql[i].Version != sqlite[i].Version || mysql[i].Name != sqlite[i].Name {
+			t.Errorf("migración %d: %04d_%s en MySQL y %04d_%s en SQLite", i, mysql[i].Version, mysql[i].Name, sqlite[i].Version, sqlite[i].Name)
+		}
+		// Solo el esquema inicial es irreversible
+		if irreversible := i == 0; (mysql[i].Down == "") != irreversible || (sqlite[i].Down == "") != irreversible {
+			t.Errorf("la migración %04d_%s debería tener .down.sql en los dos motores: %v", mysql[i].Version, mysql[i].Name, !irreversible)
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := `-- comentario
+CREATE TABLE a (id INTEGER);
+
+CREATE TRIGGER a_touch AFTER UPDATE ON a
+BEGIN
+    UPDATE a SET id = id;
+END;
+INSERT INTO a VALUES (1);
+`
+	statements := splitStatements(script)
+	if len(statements) != 3 {
+		t.Fatalf("se obtuvieron %d sentencias: %q", len(statements), statements)
+	}
+	if !strings.Contains(statements[1], "UPDATE a SET id = id;") || !strings.HasSuffix(strings.TrimSpace(statements[1]), "END") {
+		t.Errorf("el trigger no quedó como una sola sentencia: %q", statements[1])
+	}
+}
+
+// sqliteSchema devuelve las definiciones de tablas, índices y triggers de la base, sin
+// schema_migrations
+func sqliteSchema(t *testing.T, db *DatabaseStruct) map[string]string {
+	t.Helper()
+	rows, err := db.Select("SELECT name, sql FROM sqlite_master WHERE sql IS NOT NULL AND name NOT IN ('schema_migrations', 'sqlite_sequence')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	schema := map[string]string{}
+	for rows.Next() {
+		var name, sql string
+		if err := rows.Scan(&name, &sql); err != nil {
+			t.Fatal(err)
+		}
+		schema[name] = sql
+	}
+	return schema
+}
+
+func TestMigrationsRoundTrip(t *testing.T) {
+	db := openMemory(t)
+	all, err := LoadMigrations(DriverSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := db.MigrateUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(all) {
+		t.Fatalf("se aplicaron %d de %d migraciones", len(applied), len(all))
+	}
+	if pending, err := db.PendingMigrations(); err != nil || len(pending) != 0 {
+		t.Fatalf("después de migrate up quedan %d pendientes (%v)", len(pending), err)
+	}
+	if again, err := db.MigrateUp(); err != nil || len(again) != 0 {
+		t.Fatalf("un segundo migrate up aplicó %d migraciones (%v)", len(again), err)
+	}
+	schema := sqliteSchema(t, db)
+
+	// Bajar y volver a subir cada migración posterior a la inicial deja el mismo esquema
+	for i := len(all) - 1; i >= 1; i-- {
+		reverted, err := db.MigrateDown(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reverted) != 1 || reverted[0].Version != all[i].Version {
+			t.Fatalf("migrate down revirtió %v, se esperaba %04d", reverted, all[i].Version)
+		}
+	}
+
+	// El esquema inicial no se revierte
+	baseline := sqliteSchema(t, db)
+	if len(baseline) == 0 {
+		t.Fatal("revertir las migraciones posteriores borró el esquema inicial")
+	}
+	if reverted, err := db.MigrateDown(1); err == nil || len(reverted) != 0 {
+		t.Fatalf("migrate down del esquema inicial revirtió %v (%v)", reverted, err)
+	}
+	if pending, err := db.PendingMigrations(); err != nil || len(pending) != len(all)-1 {
+		t.Fatalf("quedan %d migraciones pendientes, se esperaban %d (%v)", len(pending), len(all)-1, err)
+	}
+	if left := sqliteSchema(t, db); len(left) != len(baseline) {
+		t.Errorf("el esquema inicial tenía %d objetos y quedaron %d", len(baseline), len(left))
+	}
+
+	if _, err := db.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	again := sqliteSchema(t, db)
+	for name, sql := range schema {
+		if again[name] != sql {
+			t.Errorf("%s cambió después de bajar y subir las migraciones", name)
+		}
+	}
+	if len(again) != len(schema) {
+		t.Errorf("el esquema tenía %d objetos y ahora %d", len(schema), len(again))
+	}
+}
+
+func TestMigrateDownNeverRevertsBaseline(t *testing.T) {
+	db := openMemory(t)
+	all, err := LoadMigrations(DriverSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Si las migraciones a revertir llegan al esquema inicial no se revierte ninguna
+	if reverted, err := db.MigrateDown(len(all)); err == nil || len(reverted) != 0 {
+		t.Fatalf("migrate down %d revirtió %d migraciones (%v)", len(all), len(reverted), err)
+	}
+	if pending, err := db.PendingMigrations(); err != nil || len(pending) != 0 {
+		t.Errorf("quedaron %d migraciones pendientes (%v)", len(pending), err)
+	}
+	if reverted, err := db.MigrateDown(len(all) - 1); err != nil || len(reverted) != len(all)-1 {
+		t.Errorf("migrate down %d revirtió %d migraciones (%v)", len(all)-1, len(reverted), err)
+	}
+}
+
+func TestProjectCodeUniqueMigration(t *testing.T) {
+	db := openMemory(t)
+	all, err := LoadMigrations(DriverSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	// Se vuelve a la versión anterior al índice único para cargar códigos repetidos
+	if _, err := db.MigrateDown(int(all[len(all)-1].Version) - 19); err != nil {
+		t.Fatal(err)
+	}
+	for _, code := range []string{"OBR-0001", "OBR-0001", "OBR-0002", "", ""} {
+		if _, err := db.Insert(false, "INSERT INTO projects (code, name, description, category_id, client_id, status_id, location_id, author_id) VALUES (?, 'Obra', '', 1, 0, 0, 0, 1)", code); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.Select("SELECT code FROM projects ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var codes []string
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			t.Fatal(err)
+		}
+		codes = append(codes, code)
+	}
+	want := []string{"OBR-0001", "OBR-0001-2", "OBR-0002", "", ""}
+	if strings.Join(codes, ",") != strings.Join(want, ",") {
+		t.Errorf("códigos después de la migración = %q, se esperaba %q", codes, want)
+	}
+
+	// Los proyectos sin código pueden ser varios, los códigos no se repiten
+	if _, err := db.Insert(false, "INSERT INTO projects (code, name, description, category_id, client_id, status_id, location_id, author_id) VALUES ('', 'Obra', '', 1, 0, 0, 0, 1)"); err != nil {
+		t.Errorf("otro proyecto sin código: %v", err)
+	}
+	if _, err := db.Insert(false, "INSERT INTO projects (code, name, description, category_id, client_id, status_id, location_id, author_id) VALUES ('OBR-0002', 'Obra', '', 1, 0, 0, 0, 1)"); !IsDuplicateKey(err) {
+		t.Errorf("un código repetido se guardó (%v)", err)
+	}
+}
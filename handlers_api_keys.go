@@ -0,0 +1,285 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"magpanel/database"
+	"magpanel/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var apiKeyListSpec = listSpec{
+	from: "FROM api_keys k JOIN users u ON k.user_id = u.id",
+	sortable: map[string]string{
+		"id":           "k.id",
+		"name":         "k.name",
+		"user_id":      "k.user_id",
+		"expires_at":   "k.expires_at",
+		"last_used_at": "k.last_used_at",
+		"created_at":   "k.created_at",
+	},
+	filters: map[string]listFilter{
+		"user_id":        {"k.user_id", filterInt},
+		"expires_after":  {"k.expires_at", filterAfter},
+		"expires_before": {"k.expires_at", filterBefore},
+	},
+	defaultOrder: "k.id DESC",
+}
+
+// getAPIKeys lista las API keys propias; con users:admin lista las de todos los usuarios
+func getAPIKeys(w http.ResponseWriter, r *http.Request) {
+	apiKeys := []models.APIKey{}
+
+	user, err := getCurrentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	q, err := parseListQuery(r, apiKeyListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !requestHasPermission(r, user, PermUsersAdmin) {
+		q.Where("k.user_id = ?", user.ID)
+	}
+	if revoked := r.URL.Query().Get("revoked"); revoked != "" {
+		b, err := strconv.ParseBool(revoked)
+		if err != nil {
+			http.Error(w, "el filtro revoked debe ser true o false", http.StatusBadRequest)
+			return
+		}
+		if b {
+			q.Where("k.revoked_at IS NOT NULL")
+		} else {
+			q.Where("k.revoked_at IS NULL")
+		}
+	}
+	if err := writeTotalCount(w, q); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query, args := q.selectQuery("SELECT k.id, k.name, k.prefix, k.user_id, u.username, k.scopes, k.expires_at, k.last_used_at, k.revoked_at, k.created_by, k.created_at")
+	rows, err := dataBase.Select(query, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var k models.APIKey
+		var scopes string
+		var lastUsedAt, revokedAt sql.NullString
+		if err := rows.Scan(&k.ID, &k.Name, &k.Prefix, &k.UserID, &k.Username, &scopes, &k.ExpiresAt, &lastUsedAt, &revokedAt, &k.CreatedBy, &k.CreatedAt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := json.Unmarshal([]byte(scopes), &k.Scopes); err != nil {
+			log.Printf("Error al leer los scopes de la API key %d: %v", k.ID, err)
+		}
+		k.LastUsedAt = lastUsedAt.String
+		k.RevokedAt = revokedAt.String
+		apiKeys = append(apiKeys, k)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(apiKeys)
+}
+
+// createAPIKey genera una API key para el usuario autenticado o, con users:admin, para
+// otro usuario o cuenta de servicio. La clave completa solo se devuelve en esta respuesta.
+func createAPIKey(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name      string   `json:"name"`
+		UserID    int      `json:"user_id"`
+		Scopes    []string `json:"scopes"`
+		ExpiresAt string   `json:"expires_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := getCurrentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// Una API key no puede generar otras API keys
+	if rejectAPIKey(w, r) {
+		return
+	}
+
+	if input.Name == "" {
+		http.Error(w, "El nombre de la API key es obligatorio", http.StatusBadRequest)
+		return
+	}
+	if len(input.Scopes) == 0 {
+		http.Error(w, "La API key debe tener al menos un scope", http.StatusBadRequest)
+		return
+	}
+
+	// Usuario al que queda ligada la clave
+	owner := *user
+	if input.UserID != 0 && input.UserID != user.ID {
+		if !requestHasPermission(r, user, PermUsersAdmin) {
+			http.Error(w, "Acceso denegado. Se requiere el permiso "+PermUsersAdmin+".", http.StatusForbidden)
+			return
+		}
+		row, err := dataBase.SelectRow("SELECT id, username, `rank` FROM users WHERE id = ?", input.UserID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := row.Scan(&owner.ID, &owner.Username, &owner.Rank); err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+			} else {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+
+	// Los scopes no pueden superar los permisos del rol del usuario dueño de la clave
+	for _, scope := range input.Scopes {
+		if !isKnownPermission(scope) {
+			http.Error(w, fmt.Sprintf("Scope desconocido: %s", scope), http.StatusBadRequest)
+			return
+		}
+		if !hasPermission(owner.Rank, scope) {
+			http.Error(w, fmt.Sprintf("El usuario %s no tiene el permiso %s", owner.Username, scope), http.StatusBadRequest)
+			return
+		}
+	}
+
+	expiresAt := time.Now().Add(apiKeyDefaultTTL)
+	if input.ExpiresAt != "" {
+		expiresAt, err = parseFilterDate(input.ExpiresAt)
+		if err != nil {
+			http.Error(w, "expires_at debe ser una fecha (YYYY-MM-DD o RFC3339)", http.StatusBadRequest)
+			return
+		}
+		if !expiresAt.After(time.Now()) {
+			http.Error(w, "expires_at debe ser una fecha futura", http.StatusBadRequest)
+			return
+		}
+	}
+
+	key, prefix, hash, err := generateAPIKey()
+	if err != nil {
+		http.Error(w, "Error al generar la API key", http.StatusInternalServerError)
+		return
+	}
+	scopes, err := json.Marshal(input.Scopes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	k := models.APIKey{
+		Name:      input.Name,
+		Prefix:    prefix,
+		UserID:    owner.ID,
+		Username:  owner.Username,
+		Scopes:    input.Scopes,
+		ExpiresAt: database.FormatTime(expiresAt),
+		CreatedBy: user.ID,
+	}
+
+	lastInsertID, err := dataBase.Insert(true, "INSERT INTO api_keys (name, prefix, key_hash, user_id, scopes, expires_at, created_by) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		k.Name, k.Prefix, hash, k.UserID, string(scopes), k.ExpiresAt, k.CreatedBy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	k.ID = int(lastInsertID)
+
+	// El log se guarda antes de agregar la clave, para que nunca quede registrada
+	newValueBytes, err := json.Marshal(k)
+	if err != nil {
+		// Manejar error de serialización
+		log.Printf("Error al serializar nueva API key: %v", err)
+	}
+	newValue := string(newValueBytes)
+
+	// Registro del evento de creación
+	if err := insertLog("create_api_key", "", newValue, r); err != nil {
+		// Manejar el error de inserción del log aquí
+		log.Printf("Error al insertar el registro de creación de API key: %v", err)
+	}
+
+	k.Key = key
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(k)
+}
+
+// revokeAPIKey revoca una API key propia; con users:admin se puede revocar cualquiera
+func revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	apiKeyID := chi.URLParam(r, "id")
+
+	user, err := getCurrentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var old models.APIKey
+	var revokedAt sql.NullString
+	row, err := dataBase.SelectRow("SELECT id, name, prefix, user_id, expires_at, revoked_at FROM api_keys WHERE id = ?", apiKeyID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := row.Scan(&old.ID, &old.Name, &old.Prefix, &old.UserID, &old.ExpiresAt, &revokedAt); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "API key no encontrada", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Las claves de otros usuarios se informan como inexistentes
+	if old.UserID != user.ID && !requestHasPermission(r, user, PermUsersAdmin) {
+		http.Error(w, "API key no encontrada", http.StatusNotFound)
+		return
+	}
+	if revokedAt.Valid {
+		http.Error(w, "La API key ya está revocada", http.StatusConflict)
+		return
+	}
+
+	_, err = dataBase.Update(true, "UPDATE api_keys SET revoked_at = ? WHERE id = ?", database.FormatTime(time.Now()), apiKeyID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	oldValueBytes, err := json.Marshal(old)
+	if err != nil {
+		// Manejar error de serialización
+		log.Printf("Error al serializar API key revocada: %v", err)
+	}
+	oldValue := string(oldValueBytes)
+
+	// Registro del evento de revocación
+	if err := insertLog("revoke_api_key", oldValue, "", r); err != nil {
+		// Manejar el error de inserción del log aquí
+		log.Printf("Error al insertar el registro de revocación de API key: %v", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/minio/minio-go/v7"
 )
@@ -24,14 +27,26 @@ func HandleRemove(minioClient *minio.Client, bucketName string) http.HandlerFunc
 
 		// filePath := r.FormValue("file
 		filePath := r.FormValue("fileId")
+		projectID, status, err := attachmentProjectID(r)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
 		// remove the https://...../ to the first slash with split
 		// Eliminar el archivo del bucket de DigitalOcean Spaces
-		err := minioClient.RemoveObject(r.Context(), bucketName, filePath, minio.RemoveObjectOptions{})
+		err = minioClient.RemoveObject(r.Context(), bucketName, filePath, minio.RemoveObjectOptions{})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		if projectID != 0 {
+			oldValue, _ := json.Marshal(map[string]string{"file_id": filePath})
+			if err := insertProjectLog(projectID, "remove_attachment", string(oldValue), "", r); err != nil {
+				log.Printf("Error al insertar el registro de eliminación de adjunto: %v", err)
+			}
+		}
+
 		w.Write([]byte("Archivo eliminado: " + filePath + " del bucket: " + bucketName))
 		// Respuesta exitosa
 		w.WriteHeader(http.StatusOK)
@@ -54,6 +69,12 @@ func HandleUpload(minioClient *minio.Client, bucketName string) http.HandlerFunc
 		}
 		defer file.Close()
 
+		projectID, status, err := attachmentProjectID(r)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+
 		// Verificar si el cliente MinIO es nulo
 		if minioClient == nil {
 			http.Error(w, "Cliente MinIO nulo", http.StatusInternalServerError)
@@ -76,8 +97,41 @@ func HandleUpload(minioClient *minio.Client, bucketName string) http.HandlerFunc
 			return
 		}
 
+		url := fmt.Sprintf("https://magservicios.sfo3.cdn.digitaloceanspaces.com/%s/%s", destinationFolder, header.Filename)
+		if projectID != 0 {
+			newValue, _ := json.Marshal(map[string]interface{}{
+				"url":  url,
+				"name": header.Filename,
+				"size": header.Size,
+			})
+			if err := insertProjectLog(projectID, "upload_attachment", "", string(newValue), r); err != nil {
+				log.Printf("Error al insertar el registro de subida de adjunto: %v", err)
+			}
+		}
+
 		// Respuesta exitosa
 		w.WriteHeader(http.StatusOK)
-		fmt.Fprintf(w, "https://magservicios.sfo3.cdn.digitaloceanspaces.com/%s/%s", destinationFolder, header.Filename)
+		fmt.Fprint(w, url)
+	}
+}
+
+// attachmentProjectID lee el project_id opcional del formulario, con el que el adjunto queda en la
+// línea de tiempo del proyecto. Devuelve 0 si no se envió.
+func attachmentProjectID(r *http.Request) (int, int, error) {
+	value := r.FormValue("project_id")
+	if value == "" {
+		return 0, 0, nil
+	}
+	projectID, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, http.StatusBadRequest, fmt.Errorf("project_id debe ser un número")
+	}
+	visible, err := projectVisible(r, projectID)
+	if err != nil {
+		return 0, http.StatusInternalServerError, err
+	}
+	if !visible {
+		return 0, http.StatusNotFound, fmt.Errorf("Proyecto no encontrado")
 	}
+	return projectID, 0, nil
 }
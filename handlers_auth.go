@@ -1,16 +1,20 @@
 package main
 
 import (
-	"crypto/md5"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
+	"magpanel/database"
 	"magpanel/models"
 	"net/http"
+	"strings"
 	"time"
 )
 
+// Validez del token de recuperación de contraseña, configurable en [security] de data.conf
+var recoveryTokenTTL = time.Hour
+
 func loginUser(w http.ResponseWriter, r *http.Request) {
 	var loginData struct {
 		Username string `json:"username"`
@@ -20,86 +24,215 @@ func loginUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	log.Printf("Intento de login con usuario: %s, contraseña: %s", loginData.Username, loginData.Password)
 
 	var u models.User
 	var salt string
 
-	rows, err := dataBase.SelectRow("SELECT id, name, username, password_hash, salt FROM users WHERE username = ?", loginData.Username)
+	var totpEnabled bool
+	var lockedUntil sql.NullString
+	var status string
+
+	// Además del límite por IP, se limita por usuario para frenar ataques distribuidos sobre una cuenta
+	if !usernameLimiter.Allow(strings.ToLower(loginData.Username)) {
+		http.Error(w, "Demasiados intentos para este usuario, intenta de nuevo más tarde.", http.StatusTooManyRequests)
+		return
+	}
+
+	rows, err := dataBase.SelectRow("SELECT id, name, username, email, `rank`, password_hash, salt, service_account, totp_enabled, locked_until, status FROM users WHERE username = ?", loginData.Username)
 	if err != nil {
 		http.Error(w, "No se encontró el usuario", http.StatusInternalServerError)
 		return
 	}
-	rows.Scan(&u.ID, &u.Name, &u.Username, &u.PasswordHash, &salt)
+	rows.Scan(&u.ID, &u.Name, &u.Username, &u.Email, &u.Rank, &u.PasswordHash, &salt, &u.ServiceAccount, &totpEnabled, &lockedUntil, &status)
 
-	log.Printf("Intento de login al usuario: %s, contraseña: %s y salt: %s", u.Username, u.PasswordHash, salt)
+	// Las cuentas de servicio solo se autentican con API keys. Se verifica igual contra un hash de
+	// referencia para que responda en el mismo tiempo que una contraseña incorrecta.
+	if u.ServiceAccount {
+		checkPassword("", "", loginData.Password)
+		http.Error(w, "Credenciales inválidas", http.StatusUnauthorized)
+		return
+	}
+
+	// Una cuenta bloqueada no se evalúa hasta que venza el bloqueo. Los usuarios que no existen
+	// se bloquean igual, para que el 423 no revele cuáles existen.
+	until := accountLockedUntil(lockedUntil)
+	if u.ID == 0 {
+		until = unknownUserLockedUntil(loginData.Username)
+	}
+	if !until.IsZero() {
+		recordFailedLogin(r, loginData.Username, u.ID, failedLoginLocked)
+		http.Error(w, "Cuenta bloqueada por intentos fallidos hasta "+database.FormatTime(until)+" (UTC)", http.StatusLocked)
+		return
+	}
 
-	// Verificar la contraseña utilizando la función comparePasswords
-	if !comparePasswords(u.PasswordHash, loginData.Password, salt) {
-		// log.Println("Contraseña incorrecta para el usuario:", u.Username)
-		// log.Println("Contraseña proporcionada:", loginData.Password)
-		// log.Println("Hash almacenado:", u.PasswordHash)
-		// log.Println("Sal utilizada:", salt)
+	// Verificar la contraseña; salt solo se usa con hashes del formato anterior
+	ok, needsRehash := checkPassword(u.PasswordHash, salt, loginData.Password)
+	if !ok {
+		if u.ID == 0 {
+			recordFailedLogin(r, loginData.Username, 0, failedLoginUnknownUser)
+		} else {
+			recordFailedLogin(r, loginData.Username, u.ID, failedLoginBadPassword)
+		}
 		http.Error(w, "Credenciales inválidas", http.StatusUnauthorized)
 		return
 	}
 
-	accessToken, err := generateAccessToken(u.ID, u.Name)
+	// Un usuario desactivado conserva su contraseña pero no puede volver a entrar
+	if status == userStatusInactive {
+		http.Error(w, "La cuenta está desactivada", http.StatusForbidden)
+		return
+	}
+
+	// Si el hash es del formato anterior o cambió el costo configurado se aprovecha que
+	// tenemos la contraseña para actualizarlo
+	if needsRehash {
+		rehashPassword(u.ID, u.PasswordHash, loginData.Password)
+	}
+
+	// Con 2FA activo (o exigido para su rol) la contraseña sola no alcanza: se emite un
+	// challenge que se completa en POST /login/2fa
+	if totpEnabled || twoFactorRequiredForRank(u.Rank) {
+		startTwoFactorLogin(w, r, &u, totpEnabled)
+		return
+	}
+
+	if err := resetFailedLogins(u.ID); err != nil {
+		log.Printf("Error al reiniciar los intentos fallidos del usuario %d: %v", u.ID, err)
+	}
+
+	tokens, err := createSession(r, u.ID, u.Name)
 	if err != nil {
 		http.Error(w, "Error al generar el Access Token", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"access_token": accessToken})
+	json.NewEncoder(w).Encode(tokens)
 }
 
-func requestPasswordRecovery(w http.ResponseWriter, r *http.Request) {
+// refreshToken canjea un refresh token por un access token nuevo y rota el refresh token
+func refreshToken(w http.ResponseWriter, r *http.Request) {
 	var requestData struct {
-		Email string `json:"email"`
+		RefreshToken string `json:"refresh_token"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if requestData.RefreshToken == "" {
+		http.Error(w, "refresh_token es obligatorio", http.StatusBadRequest)
+		return
+	}
 
-	// Buscar usuario por email
-	var u models.User
-	rows, err := dataBase.SelectRow("SELECT id, email FROM users WHERE email = ?", requestData.Email)
+	tokens, _, err := rotateRefreshToken(r.Context(), requestData.RefreshToken)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "Email no encontrado", http.StatusNotFound)
+		if err == errInvalidRefreshToken || err == errRefreshTokenReused {
+			http.Error(w, "No autorizado. "+err.Error(), http.StatusUnauthorized)
 		} else {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 		return
 	}
-	rows.Scan(&u.ID, &u.Email)
 
-	// Generar recovery token y almacenar en la base de datos con la fecha y hora actual
-	recoveryToken := fmt.Sprintf("%x", md5.Sum([]byte(time.Now().String()+u.Email)))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
 
-	// Asumiendo que ahora tienes una columna recovery_hash_time
-	_, err = dataBase.Update(true, "UPDATE users SET recovery_hash = ?, recovery_hash_time = NOW() WHERE id = ?", recoveryToken, u.ID)
+// logout cierra la sesión del access token actual
+func logout(w http.ResponseWriter, r *http.Request) {
+	sessionID, ok := requestSessionID(r)
+	if !ok {
+		http.Error(w, "El request no pertenece a una sesión", http.StatusBadRequest)
+		return
+	}
+	if err := revokeSession(sessionID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// logoutAll cierra todas las sesiones del usuario autenticado, en todos sus dispositivos
+func logoutAll(w http.ResponseWriter, r *http.Request) {
+	if rejectAPIKey(w, r) {
+		return
+	}
+	user, err := getCurrentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	revoked, err := revokeUserSessions(user.ID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Enviar recovery token por correo electrónico al usuario (implementa esta parte según tu lógica de envío de correos)
+	// Registro del evento de cierre de sesiones
+	if err := insertLog("logout_all", "", fmt.Sprintf(`{"user_id":%d,"sessions":%d}`, user.ID, revoked), r); err != nil {
+		// Manejar el error de inserción del log aquí
+		log.Printf("Error al insertar el registro de cierre de sesiones: %v", err)
+	}
 
-	// send recovery with mailgun
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	err = sendRecoveryEmail(u.Email, recoveryToken)
+func requestPasswordRecovery(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// La respuesta es siempre la misma, exista o no el email, para no revelar qué cuentas existen
+	defer func() {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Si el email está registrado, se enviaron las instrucciones de recuperación."})
+	}()
+
+	// Buscar usuario por email
+	var u models.User
+	var status string
+	rows, err := dataBase.SelectRow("SELECT id, email, service_account, status FROM users WHERE email = ?", requestData.Email)
 	if err != nil {
-		fmt.Println("Error al enviar el correo electrónico de recuperación:", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Printf("Error al buscar el email de recuperación: %v", err)
+		return
+	}
+	if err := rows.Scan(&u.ID, &u.Email, &u.ServiceAccount, &status); err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error al buscar el email de recuperación: %v", err)
+		}
+		return
+	}
+	// Las cuentas de servicio no tienen contraseña y los invitados la eligen con su invitación
+	if u.ServiceAccount || status != userStatusActive {
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Instrucciones de recuperación enviadas."})
+	// Generar un recovery token aleatorio; en la base solo se guarda su hash
+	recoveryToken, err := generateToken(32)
+	if err != nil {
+		log.Printf("Error al generar el token de recuperación: %v", err)
+		return
+	}
+
+	_, err = dataBase.Update(true, "UPDATE users SET recovery_hash = ?, recovery_hash_time = ? WHERE id = ?", hashToken(recoveryToken), database.FormatTime(time.Now()), u.ID)
+	if err != nil {
+		log.Printf("Error al guardar el token de recuperación: %v", err)
+		return
+	}
+
+	// El correo se envía en segundo plano para que el tiempo de respuesta tampoco delate si el email existe
+	go func(email, token string) {
+		if err := sendRecoveryEmail(email, token); err != nil {
+			log.Printf("Error al enviar el correo electrónico de recuperación: %v", err)
+		}
+	}(u.Email, recoveryToken)
 }
 
 func changePassword(w http.ResponseWriter, r *http.Request) {
@@ -112,41 +245,69 @@ func changePassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if requestData.Token == "" {
+		http.Error(w, "Token de recuperación inválido o expirado", http.StatusBadRequest)
+		return
+	}
+
 	var userID int
-	var recoveryHashTimeBytes []byte
+	var username, email string
+	var recoveryHashTime sql.NullString
 
-	// Recuperar la fecha y hora del token además del ID del usuario
-	rows, err := dataBase.SelectRow("SELECT id, recovery_hash_time FROM users WHERE recovery_hash = ?", requestData.Token)
+	// Recuperar la fecha y hora del token además del ID del usuario. El token se busca por su hash.
+	rows, err := dataBase.SelectRow("SELECT id, username, email, recovery_hash_time FROM users WHERE recovery_hash = ?", hashToken(requestData.Token))
 	if err != nil {
-		fmt.Println("Error al obtener el token de recuperación:", err)
-		http.Error(w, "Error al obtener el token de recuperación", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := rows.Scan(&userID, &username, &email, &recoveryHashTime); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Token de recuperación inválido o expirado", http.StatusBadRequest)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
 		return
 	}
-	rows.Scan(&userID, &recoveryHashTimeBytes)
 
-	// Convertir recoveryHashTimeBytes a una cadena y luego parsearla como una fecha y hora
-	recoveryHashTimeString := string(recoveryHashTimeBytes)
-	recoveryHashTime, err := time.Parse("2006-01-02 15:04:05", recoveryHashTimeString) // Ajusta el formato según sea necesario
-	if err != nil {
-		fmt.Printf("Error al parsear 'recoveryHashTime': %v\n", err)
-		http.Error(w, "Error al parsear 'recoveryHashTime'", http.StatusInternalServerError)
+	// Verificar que el token no haya vencido
+	issuedAt, err := database.ParseTime(recoveryHashTime.String)
+	if err != nil || time.Since(issuedAt) > recoveryTokenTTL {
+		http.Error(w, "Token de recuperación inválido o expirado", http.StatusBadRequest)
 		return
 	}
-	// Verificar si han pasado 24 horas desde que se generó el token
-	if time.Since(recoveryHashTime).Hours() > 24 {
-		http.Error(w, "El token de recuperación ha expirado", http.StatusBadRequest)
+
+	if err := validatePassword(requestData.NewPassword, username, email); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Generar nueva sal y hashear la nueva contraseña
-	newSalt := generateSalt()
-	newHashedPassword := hashPassword(requestData.NewPassword, newSalt)
+	newHashedPassword, err := hashPassword(requestData.NewPassword)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	_, err = dataBase.Update(true, "UPDATE users SET password_hash = ?, salt = ?, recovery_hash = NULL WHERE id = ?", newHashedPassword, newSalt, userID)
+	// El token es de un solo uso: se borra junto con el cambio de contraseña
+	updated, err := dataBase.Update(true, "UPDATE users SET password_hash = ?, salt = '', recovery_hash = NULL, recovery_hash_time = NULL WHERE id = ? AND recovery_hash = ?",
+		newHashedPassword, userID, hashToken(requestData.Token))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if updated == 0 {
+		http.Error(w, "Token de recuperación inválido o expirado", http.StatusBadRequest)
+		return
+	}
+
+	// Quien recupera la contraseña también recupera el acceso si la cuenta estaba bloqueada
+	if err := resetFailedLogins(userID); err != nil {
+		log.Printf("Error al reiniciar los intentos fallidos del usuario %d: %v", userID, err)
+	}
+
+	// Con la contraseña nueva se cierran todas las sesiones abiertas
+	if _, err := revokeUserSessions(userID); err != nil {
+		log.Printf("Error al revocar las sesiones del usuario %d: %v", userID, err)
+	}
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Contraseña actualizada con éxito."})
@@ -5,14 +5,41 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"magpanel/database"
 	"magpanel/models"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
 )
 
+var categoryListSpec = listSpec{
+	from: "FROM categories",
+	sortable: map[string]string{
+		"id":   "id",
+		"type": "type",
+		"code": "code",
+		"name": "name",
+	},
+	filters: map[string]listFilter{
+		"type": {"type", filterString},
+		"code": {"code", filterString},
+	},
+	defaultOrder: "id ASC",
+}
+
 func getCategories(w http.ResponseWriter, r *http.Request) {
-	rows, err := dataBase.Select("SELECT id, type, name, code, fields, filters FROM categories")
+	q, err := parseListQuery(r, categoryListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := writeTotalCount(w, q); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query, args := q.selectQuery("SELECT id, type, name, code, code_template, fields, filters")
+	rows, err := dataBase.Select(query, args...)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -23,9 +50,9 @@ func getCategories(w http.ResponseWriter, r *http.Request) {
 
 	for rows.Next() {
 		var c models.Category
-		var codeNullString sql.NullString
+		var codeNullString, templateNullString sql.NullString
 
-		if err := rows.Scan(&c.ID, &c.Type, &c.Name, &codeNullString, &c.FieldsJSON, &c.FiltersJSON); err != nil {
+		if err := rows.Scan(&c.ID, &c.Type, &c.Name, &codeNullString, &templateNullString, &c.FieldsJSON, &c.FiltersJSON); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -35,6 +62,9 @@ func getCategories(w http.ResponseWriter, r *http.Request) {
 		} else {
 			c.Code = ""
 		}
+		if templateNullString.Valid {
+			c.CodeTemplate = &templateNullString.String
+		}
 
 		if c.FieldsJSON != "" {
 			if err := json.Unmarshal([]byte(c.FieldsJSON), &c.Fields); err != nil {
@@ -78,6 +108,12 @@ func createCategory(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	codeTemplate, err := categoryCodeTemplate(c.CodeTemplate)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	var fieldsDataString string
 	if c.Fields == nil {
 		fieldsDataString = "[]" // empty array
@@ -105,26 +141,25 @@ func createCategory(w http.ResponseWriter, r *http.Request) {
 		filtersDataString = string(filtersData)
 
 	}
-	lastInsertID, err := dataBase.Insert(true, "INSERT INTO categories (type, name, fields, filters) VALUES (?, ?, ?, ?)", c.Type, c.Name, fieldsDataString, filtersDataString)
+	// El insert y la asignación del código se hacen en la misma transacción
+	err = dataBase.WithTx(r.Context(), func(tx *database.Tx) error {
+		lastInsertID, err := tx.Insert(true, "INSERT INTO categories (type, name, fields, filters, code_template) VALUES (?, ?, ?, ?, ?)", c.Type, c.Name, fieldsDataString, filtersDataString, codeTemplate)
+		if err != nil {
+			return err
+		}
+		c.ID = int(lastInsertID)
 
+		if c.Type == "projects" && c.Code != "" {
+			// update the code
+			_, err = tx.Update(true, "UPDATE categories SET code = ? WHERE id = ?", c.Code, c.ID)
+		}
+		return err
+	})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if c.Type == "projects" && c.Code != "" {
-		// update the code
-		_, err = dataBase.Update(true, "UPDATE categories SET code = ? WHERE id = ?", c.Code, lastInsertID)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-	}
-
-	// convert lastInsertID to int
-
-	c.ID = int(lastInsertID)
-
 	newValueBytes, err := json.Marshal(c)
 	if err != nil {
 		// Manejar error de serialización
@@ -166,7 +201,8 @@ func updateCategory(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var old models.Category
-	rows, err := dataBase.SelectRow("SELECT id, type, name, fields, filters FROM categories WHERE id = ?", categoryID)
+	var oldTemplate sql.NullString
+	rows, err := dataBase.SelectRow("SELECT id, type, name, fields, filters, code_template FROM categories WHERE id = ?", categoryID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Categoría no encontrada", http.StatusNotFound)
@@ -176,7 +212,7 @@ func updateCategory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := rows.Scan(&old.ID, &old.Type, &old.Name, &old.FieldsJSON, &old.FiltersJSON); err != nil {
+	if err := rows.Scan(&old.ID, &old.Type, &old.Name, &old.FieldsJSON, &old.FiltersJSON, &oldTemplate); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -192,13 +228,26 @@ func updateCategory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if oldTemplate.Valid {
+		old.CodeTemplate = &oldTemplate.String
+	}
+
+	// Sin code_template en el body se conserva la plantilla actual
+	codeTemplate := interface{}(oldTemplate)
+	if c.CodeTemplate != nil {
+		if codeTemplate, err = categoryCodeTemplate(c.CodeTemplate); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	oldValueBytes, err := json.Marshal(old)
 	if err != nil {
 		log.Printf("Error al serializar antigua categoría: %v", err)
 	}
 	oldValue := string(oldValueBytes)
 
-	_, err = dataBase.Update(true, "UPDATE categories SET type = ?, name = ?, fields = ?, filters = ? WHERE id = ?", c.Type, c.Name, string(fieldsData), string(filtersData), categoryID)
+	_, err = dataBase.Update(true, "UPDATE categories SET type = ?, name = ?, fields = ?, filters = ?, code_template = ? WHERE id = ?", c.Type, c.Name, string(fieldsData), string(filtersData), codeTemplate, categoryID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -259,9 +308,9 @@ func getCategoryByID(w http.ResponseWriter, r *http.Request) {
 	categoryID := chi.URLParam(r, "id") // Obtiene el ID de la categoría de la URL
 
 	var c models.Category
-	var codeNullString sql.NullString
+	var codeNullString, templateNullString sql.NullString
 
-	rows, err := dataBase.SelectRow("SELECT id, code, type, name, fields, filters FROM categories WHERE id = ?", categoryID)
+	rows, err := dataBase.SelectRow("SELECT id, code, code_template, type, name, fields, filters FROM categories WHERE id = ?", categoryID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Categoría no encontrada", http.StatusNotFound)
@@ -272,7 +321,7 @@ func getCategoryByID(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Asumiendo que FieldsJSON es un campo en models.Category que se utiliza para escanear el JSON crudo
-	if err := rows.Scan(&c.ID, &codeNullString, &c.Type, &c.Name, &c.FieldsJSON, &c.FiltersJSON); err != nil {
+	if err := rows.Scan(&c.ID, &codeNullString, &templateNullString, &c.Type, &c.Name, &c.FieldsJSON, &c.FiltersJSON); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -282,6 +331,9 @@ func getCategoryByID(w http.ResponseWriter, r *http.Request) {
 	} else {
 		c.Code = ""
 	}
+	if templateNullString.Valid {
+		c.CodeTemplate = &templateNullString.String
+	}
 
 	if c.FieldsJSON != "" {
 		if err := json.Unmarshal([]byte(c.FieldsJSON), &c.Fields); err != nil {
@@ -299,3 +351,15 @@ func getCategoryByID(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(c)
 }
+
+// categoryCodeTemplate valida la plantilla de código recibida y devuelve el valor a guardar:
+// NULL si no hay plantilla propia
+func categoryCodeTemplate(template *string) (interface{}, error) {
+	if template == nil || *template == "" {
+		return nil, nil
+	}
+	if _, err := parseCodeTemplate(*template); err != nil {
+		return nil, err
+	}
+	return *template, nil
+}
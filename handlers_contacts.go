@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"magpanel/database"
 	"magpanel/models"
 	"net/http"
 	"time"
@@ -20,31 +21,20 @@ func createContact(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	lastInsertID, err := dataBase.Insert(true, "INSERT INTO contacts(name, position, phone, email) VALUES(?, ?, ?, ?)", contactData.Name, contactData.Position, contactData.Phone, contactData.Email)
+	// El contacto y sus relaciones se insertan en una sola transacción
+	err := dataBase.WithTx(r.Context(), func(tx *database.Tx) error {
+		lastInsertID, err := tx.Insert(true, "INSERT INTO contacts(name, position, phone, email) VALUES(?, ?, ?, ?)", contactData.Name, contactData.Position, contactData.Phone, contactData.Email)
+		if err != nil {
+			return err
+		}
+		contactData.ID = int(lastInsertID)
+
+		return insertContactRelations(tx, contactData.ID, contactData.ClientIDs, contactData.ProviderIDs)
+	})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	contactData.ID = int(lastInsertID)
-
-	// Insertar relaciones en la tabla intermedia client_contact
-	for _, clientID := range contactData.ClientIDs {
-		_, err := dataBase.Insert(false, "INSERT INTO client_contact(client_id, contact_id) VALUES(?, ?)", clientID, contactData.ID)
-		if err != nil {
-			// Considerar rollback o manejo de errores adecuado aquí
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-	}
-	// Insertar relaciones en la tabla intermedia client_contact
-	for _, providerID := range contactData.ProviderIDs {
-		_, err := dataBase.Insert(false, "INSERT INTO provider_contact(provider_id, contact_id) VALUES(?, ?)", providerID, contactData.ID)
-		if err != nil {
-			// Considerar rollback o manejo de errores adecuado aquí
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -60,55 +50,80 @@ func updateContact(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := dataBase.Update(true, "UPDATE contacts SET name = ?, position = ?, phone = ?, email = ? WHERE id = ?", contactData.Name, contactData.Position, contactData.Phone, contactData.Email, contactID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+	// La actualización y el reemplazo de relaciones se hacen de forma atómica
+	err := dataBase.WithTx(r.Context(), func(tx *database.Tx) error {
+		_, err := tx.Update(true, "UPDATE contacts SET name = ?, position = ?, phone = ?, email = ? WHERE id = ?", contactData.Name, contactData.Position, contactData.Phone, contactData.Email, contactID)
+		if err != nil {
+			return err
+		}
 
-	// Primero, eliminar todas las asociaciones existentes para este contacto
-	_, err = dataBase.Delete(false, "DELETE FROM client_contact WHERE contact_id = ?", contactID)
-	if err != nil {
-		// Considerar rollback o manejo de errores adecuado aquí
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+		// Primero, eliminar todas las asociaciones existentes para este contacto
+		if _, err := tx.Delete(false, "DELETE FROM client_contact WHERE contact_id = ?", contactID); err != nil {
+			return err
+		}
+		if _, err := tx.Delete(false, "DELETE FROM provider_contact WHERE contact_id = ?", contactID); err != nil {
+			return err
+		}
 
-	_, err = dataBase.Delete(false, "DELETE FROM provider_contact WHERE contact_id = ?", contactID)
+		// Luego, insertar las nuevas relaciones
+		return insertContactRelations(tx, contactID, contactData.ClientIDs, contactData.ProviderIDs)
+	})
 	if err != nil {
-		// Considerar rollback o manejo de errores adecuado aquí
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Luego, insertar nuevas relaciones en la tabla intermedia client_contact
-	for _, clientID := range contactData.ClientIDs {
-		_, err := dataBase.Insert(false, "INSERT INTO client_contact(client_id, contact_id) VALUES(?, ?)", clientID, contactID)
-		if err != nil {
-			// Considerar rollback o manejo de errores adecuado aquí
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(fmt.Sprintf("Contacto con ID %s actualizado correctamente", contactID))
+}
+
+// insertContactRelations inserta las filas de client_contact y provider_contact de un contacto
+func insertContactRelations(tx *database.Tx, contactID interface{}, clientIDs, providerIDs []int) error {
+	for _, clientID := range clientIDs {
+		if _, err := tx.Insert(false, "INSERT INTO client_contact(client_id, contact_id) VALUES(?, ?)", clientID, contactID); err != nil {
+			return err
 		}
 	}
-
-	// Luego, insertar nuevas relaciones en la tabla intermedia provider_contact
-	for _, providerID := range contactData.ProviderIDs {
-		_, err := dataBase.Insert(false, "INSERT INTO provider_contact(provider_id, contact_id) VALUES(?, ?)", providerID, contactID)
-		if err != nil {
-			// Considerar rollback o manejo de errores adecuado aquí
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+	for _, providerID := range providerIDs {
+		if _, err := tx.Insert(false, "INSERT INTO provider_contact(provider_id, contact_id) VALUES(?, ?)", providerID, contactID); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(fmt.Sprintf("Contacto con ID %s actualizado correctamente", contactID))
+var contactListSpec = listSpec{
+	from: "FROM contacts",
+	sortable: map[string]string{
+		"id":         "id",
+		"name":       "name",
+		"position":   "position",
+		"email":      "email",
+		"created_at": "created_at",
+	},
+	filters: map[string]listFilter{
+		"email":          {"email", filterString},
+		"created_after":  {"created_at", filterAfter},
+		"created_before": {"created_at", filterBefore},
+	},
+	defaultOrder: "id ASC",
 }
 
 func getContacts(w http.ResponseWriter, r *http.Request) {
 	var contacts []models.Contact
-	rowsC, err := dataBase.Select("SELECT id, name, position, phone, email FROM contacts")
+	q, err := parseListQuery(r, contactListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := writeTotalCount(w, q); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query, args := q.selectQuery("SELECT id, name, position, phone, email")
+	rowsC, err := dataBase.Select(query, args...)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"magpanel/database"
+	"magpanel/models"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt"
+)
+
+// Duración máxima de una sesión de suplantación, configurable en [security] de data.conf
+var impersonationTTL = 30 * time.Minute
+
+// impersonateUser abre una sesión de corta duración en la que un administrador ve la API
+// exactamente como el usuario indicado. El token no se puede renovar y todo lo que se escribe
+// con él queda registrado a nombre del administrador.
+func impersonateUser(w http.ResponseWriter, r *http.Request) {
+	if rejectAPIKey(w, r) {
+		return
+	}
+	admin, err := getCurrentUser(r)
+	if err != nil {
+		http.Error(w, "Error al obtener el usuario actual", http.StatusInternalServerError)
+		return
+	}
+	if roleForRank(admin.Rank) != RoleAdmin {
+		http.Error(w, "Solo un administrador puede suplantar usuarios", http.StatusForbidden)
+		return
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	input.Reason = strings.TrimSpace(input.Reason)
+	if len(input.Reason) > 255 {
+		http.Error(w, "reason no puede superar los 255 caracteres", http.StatusBadRequest)
+		return
+	}
+
+	target, err := getUserSummary(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+	switch {
+	case target.ID == admin.ID:
+		http.Error(w, "No puedes suplantarte a ti mismo", http.StatusBadRequest)
+		return
+	case target.Status != userStatusActive:
+		http.Error(w, "Solo se pueden suplantar usuarios activos", http.StatusConflict)
+		return
+	case target.ServiceAccount:
+		http.Error(w, "Las cuentas de servicio no se pueden suplantar", http.StatusConflict)
+		return
+	case roleForRank(target.Rank) == RoleAdmin:
+		http.Error(w, "No se puede suplantar a otro administrador", http.StatusForbidden)
+		return
+	}
+
+	tokens, expiresAt, err := createImpersonationSession(r, admin, target)
+	if err != nil {
+		http.Error(w, "Error al generar el Access Token", http.StatusInternalServerError)
+		return
+	}
+
+	// Registro del inicio de la suplantación, a nombre del administrador
+	newValue, _ := json.Marshal(map[string]interface{}{
+		"user_id":    target.ID,
+		"username":   target.Username,
+		"reason":     input.Reason,
+		"expires_at": database.FormatTime(expiresAt),
+	})
+	if err := insertLog("impersonate_user", "", string(newValue), r); err != nil {
+		// Manejar el error de inserción del log aquí
+		log.Printf("Error al insertar el registro de suplantación: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// createImpersonationSession abre la sesión del usuario suplantado marcada con el administrador.
+// No tiene refresh token utilizable: vence a las impersonationTTL y se cierra con /logout.
+func createImpersonationSession(r *http.Request, admin, target *models.User) (*tokenResponse, time.Time, error) {
+	sessionID, err := generateToken(16)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	// refresh_hash es obligatorio y único; se guarda el hash de un valor que nunca se entrega
+	unusedRefresh, err := generateToken(32)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	userAgent := r.UserAgent()
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+
+	expiresAt := time.Now().Add(impersonationTTL)
+	_, err = dataBase.Insert(true, "INSERT INTO sessions (id, user_id, refresh_hash, user_agent, ip, expires_at, impersonator_id) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		sessionID, target.ID, hashToken(unusedRefresh), userAgent, clientIP(r), database.FormatTime(expiresAt), admin.ID)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	accessToken, err := signAccessToken(jwt.MapClaims{
+		"user_id":   target.ID,
+		"user_name": target.Name,
+		"sid":       sessionID,
+		"imp":       admin.ID,
+		"exp":       expiresAt.Unix(),
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return &tokenResponse{AccessToken: accessToken, TokenType: "Bearer", ExpiresIn: int(impersonationTTL.Seconds())}, expiresAt, nil
+}
+
+// loadImpersonator carga al administrador de un token de suplantación. Si dejó de ser un
+// administrador activo, la suplantación deja de valer.
+func loadImpersonator(userID int) (*models.User, error) {
+	var admin models.User
+	row, err := dataBase.SelectRow("SELECT id, username, email, `rank`, status FROM users WHERE id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := row.Scan(&admin.ID, &admin.Username, &admin.Email, &admin.Rank, &admin.Status); err != nil {
+		return nil, fmt.Errorf("el administrador de la suplantación ya no existe")
+	}
+	if admin.Status != userStatusActive || roleForRank(admin.Rank) != RoleAdmin {
+		return nil, fmt.Errorf("la suplantación ya no es válida")
+	}
+	return &admin, nil
+}
+
+// impersonationFor describe la suplantación de la sesión actual para /profile, o nil si no hay
+func impersonationFor(r *http.Request, user *models.User) *models.Impersonation {
+	if user.Impersonator == nil {
+		return nil
+	}
+	info := &models.Impersonation{
+		ImpersonatorID:       user.Impersonator.ID,
+		ImpersonatorUsername: user.Impersonator.Username,
+	}
+	if sessionID, ok := requestSessionID(r); ok {
+		row, err := dataBase.SelectRow("SELECT expires_at FROM sessions WHERE id = ?", sessionID)
+		if err == nil {
+			row.Scan(&info.ExpiresAt)
+		}
+	}
+	return info
+}
+
+// statusRecorder guarda el código de respuesta que escribió el handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// auditImpersonatedRequest atiende un request de una sesión de suplantación y, si modifica
+// datos, deja constancia en logs del método, la ruta y el resultado, aunque el handler no
+// registre nada por su cuenta
+func auditImpersonatedRequest(next http.Handler, w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	next.ServeHTTP(recorder, r)
+
+	newValue, _ := json.Marshal(map[string]interface{}{
+		"method": r.Method,
+		"path":   r.URL.Path,
+		"status": recorder.status,
+	})
+	if err := insertLog("impersonated_request", "", string(newValue), r); err != nil {
+		log.Printf("Error al insertar el registro de request suplantado: %v", err)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// impersonate pide la suplantación de targetID con el token indicado y devuelve el código y el
+// access token de la sesión suplantada
+func impersonate(t *testing.T, handler http.Handler, token string, targetID int) (int, *tokenResponse) {
+	t.Helper()
+	w := doRequest(t, handler, "POST", fmt.Sprintf("/users/%d/impersonate", targetID), token, `{"reason": "soporte"}`)
+	var tokens tokenResponse
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &tokens); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code, &tokens
+}
+
+func TestImpersonationIsAdminOnly(t *testing.T) {
+	newTestDatabase(t)
+	adminID := createTestUser(t, "admin", 3)
+	otherAdminID := createTestUser(t, "admin2", 3)
+	managerID := createTestUser(t, "manager", 2)
+	technicianID := createTestUser(t, "tecnico", 1)
+	inactiveID := createTestUser(t, "baja", 1)
+	if _, err := dataBase.Update(false, "UPDATE users SET status = ? WHERE id = ?", userStatusInactive, inactiveID); err != nil {
+		t.Fatal(err)
+	}
+	admin, manager := testToken(t, adminID), testToken(t, managerID)
+	router := initRoutes()
+
+	cases := []struct {
+		name   string
+		token  string
+		target int
+		want   int
+	}{
+		{"manager", manager, technicianID, http.StatusForbidden},
+		{"a otro administrador", admin, otherAdminID, http.StatusForbidden},
+		{"a sí mismo", admin, adminID, http.StatusBadRequest},
+		{"a un usuario desactivado", admin, inactiveID, http.StatusConflict},
+		{"a un usuario inexistente", admin, 999, http.StatusNotFound},
+	}
+	for _, c := range cases {
+		if code, _ := impersonate(t, router, c.token, c.target); code != c.want {
+			t.Errorf("suplantación %s = %d, se esperaba %d", c.name, code, c.want)
+		}
+	}
+
+	code, tokens := impersonate(t, router, admin, technicianID)
+	if code != http.StatusOK || tokens.AccessToken == "" {
+		t.Fatalf("suplantación de un técnico = %d", code)
+	}
+	if tokens.RefreshToken != "" {
+		t.Error("la sesión de suplantación entregó un refresh token")
+	}
+	// Con el token se ve la API como el técnico
+	w := doRequest(t, router, "GET", "/permissions", tokens.AccessToken, "")
+	var permissions struct {
+		UserID int    `json:"user_id"`
+		Role   string `json:"role"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &permissions); err != nil || permissions.UserID != technicianID || permissions.Role != RoleTechnician {
+		t.Errorf("GET /permissions suplantando = %d %s", w.Code, w.Body)
+	}
+}
+
+func TestImpersonationExpires(t *testing.T) {
+	newTestDatabase(t)
+	adminID := createTestUser(t, "admin", 3)
+	technicianID := createTestUser(t, "tecnico", 1)
+	router := initRoutes()
+
+	_, tokens := impersonate(t, router, testToken(t, adminID), technicianID)
+	if !authorized(t, router, tokens.AccessToken) {
+		t.Fatal("el token de suplantación no sirve")
+	}
+	if tokens.ExpiresIn != int(impersonationTTL.Seconds()) {
+		t.Errorf("expires_in = %d, se esperaba %d", tokens.ExpiresIn, int(impersonationTTL.Seconds()))
+	}
+
+	// Vencida la sesión el token deja de servir aunque el JWT no haya vencido
+	if _, err := dataBase.Update(false, "UPDATE sessions SET expires_at = ? WHERE impersonator_id = ?", "2000-01-01 00:00:00", adminID); err != nil {
+		t.Fatal(err)
+	}
+	if authorized(t, router, tokens.AccessToken) {
+		t.Error("el token de suplantación sirve con la sesión vencida")
+	}
+}
+
+func TestImpersonationEndsWhenAdminIsDemoted(t *testing.T) {
+	newTestDatabase(t)
+	adminID := createTestUser(t, "admin", 3)
+	technicianID := createTestUser(t, "tecnico", 1)
+	router := initRoutes()
+
+	_, tokens := impersonate(t, router, testToken(t, adminID), technicianID)
+	if _, err := dataBase.Update(false, "UPDATE users SET `rank` = 2 WHERE id = ?", adminID); err != nil {
+		t.Fatal(err)
+	}
+	if authorized(t, router, tokens.AccessToken) {
+		t.Error("la suplantación sigue valiendo después de que el administrador perdió el rol")
+	}
+}
+
+func TestImpersonationBlocksSensitiveOperations(t *testing.T) {
+	newTestDatabase(t)
+	adminID := createTestUser(t, "admin", 3)
+	technicianID := createTestUser(t, "tecnico", 1)
+	router := initRoutes()
+	_, tokens := impersonate(t, router, testToken(t, adminID), technicianID)
+
+	cases := []struct {
+		method, path, body string
+	}{
+		{"POST", "/api-keys", fmt.Sprintf(`{"name": "ci", "scopes": [%q]}`, PermReportsRead)},
+		{"POST", "/2fa/enroll", ""},
+		{"POST", "/profile/password", fmt.Sprintf(`{"current_password": %q, "new_password": "Otra-Clave-Segura-10"}`, testPassword)},
+		{"POST", "/logout-all", ""},
+	}
+	for _, c := range cases {
+		w := doRequest(t, router, c.method, c.path, tokens.AccessToken, c.body)
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "durante una suplantación") {
+			t.Errorf("%s %s suplantando = %d %s, se esperaba 403 de rejectAPIKey", c.method, c.path, w.Code, strings.TrimSpace(w.Body.String()))
+		}
+	}
+	if !authorized(t, router, testToken(t, technicianID)) {
+		t.Error("el usuario suplantado perdió sus sesiones")
+	}
+}
+
+func TestImpersonatedWritesAreLoggedToTheAdmin(t *testing.T) {
+	newTestDatabase(t)
+	adminID := createTestUser(t, "admin", 3)
+	managerID := createTestUser(t, "manager", 2)
+	router := initRoutes()
+
+	category, err := dataBase.Insert(false, "INSERT INTO categories (`type`, name, code, fields, filters) VALUES ('project', 'Obras', 'OBR', '[]', '[]')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := dataBase.Insert(false, "INSERT INTO clients (code, name) VALUES ('ACME', 'Acme')")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, tokens := impersonate(t, router, testToken(t, adminID), managerID)
+	body := fmt.Sprintf(`{"name": "Obra", "description": "", "category_id": %d, "client_id": %d}`, category, client)
+	w := doRequest(t, router, "POST", "/projects", tokens.AccessToken, body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /projects suplantando = %d %s", w.Code, w.Body)
+	}
+	var project struct {
+		ID       int `json:"id"`
+		AuthorID int `json:"author_id"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &project); err != nil {
+		t.Fatal(err)
+	}
+	if project.AuthorID != managerID {
+		t.Errorf("author_id = %d, se esperaba el usuario suplantado %d", project.AuthorID, managerID)
+	}
+
+	for _, logType := range []string{"create_project", "impersonated_request"} {
+		var userID, impersonatedID int
+		row, _ := dataBase.SelectRow("SELECT user_id, impersonated_user_id FROM logs WHERE `type` = ?", logType)
+		if err := row.Scan(&userID, &impersonatedID); err != nil {
+			t.Fatalf("registro %s: %v", logType, err)
+		}
+		if userID != adminID || impersonatedID != managerID {
+			t.Errorf("registro %s con user_id %d e impersonated_user_id %d, se esperaba %d y %d", logType, userID, impersonatedID, adminID, managerID)
+		}
+	}
+	var projectID int
+	row, _ := dataBase.SelectRow("SELECT project_id FROM logs WHERE `type` = 'create_project'")
+	if err := row.Scan(&projectID); err != nil || projectID != project.ID {
+		t.Errorf("el registro create_project quedó asociado al proyecto %d (%v), se esperaba %d", projectID, err, project.ID)
+	}
+}
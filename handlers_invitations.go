@@ -0,0 +1,440 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"magpanel/database"
+	"magpanel/models"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Validez del link de invitación, configurable en [security] de data.conf
+var invitationTTL = 72 * time.Hour
+
+// Estados de users.status
+const (
+	userStatusActive   = "active"
+	userStatusPending  = "pending"
+	userStatusInactive = "inactive"
+)
+
+var errInvalidInvitation = fmt.Errorf("Invitación inválida o expirada")
+
+var invitationListSpec = listSpec{
+	from: "FROM user_invitations",
+	sortable: map[string]string{
+		"id":           "id",
+		"username":     "username",
+		"email":        "email",
+		"expires_at":   "expires_at",
+		"last_sent_at": "last_sent_at",
+		"created_at":   "created_at",
+	},
+	filters: map[string]listFilter{
+		"user_id":        {"user_id", filterInt},
+		"email":          {"email", filterString},
+		"invited_by":     {"invited_by", filterInt},
+		"created_after":  {"created_at", filterAfter},
+		"created_before": {"created_at", filterBefore},
+	},
+	defaultOrder: "id DESC",
+}
+
+// invitationStatus deriva el estado de una invitación de sus fechas
+func invitationStatus(inv *models.Invitation) string {
+	switch {
+	case inv.AcceptedAt != "":
+		return "accepted"
+	case inv.RevokedAt != "":
+		return "revoked"
+	}
+	if expires, err := database.ParseTime(inv.ExpiresAt); err != nil || time.Now().After(expires) {
+		return "expired"
+	}
+	return "pending"
+}
+
+// getInvitation busca una invitación por ID
+func getInvitation(id interface{}) (*models.Invitation, error) {
+	var inv models.Invitation
+	var acceptedAt, revokedAt sql.NullString
+	row, err := dataBase.SelectRow("SELECT id, user_id, username, email, invited_by, send_count, last_sent_at, expires_at, accepted_at, revoked_at, created_at FROM user_invitations WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if err := row.Scan(&inv.ID, &inv.UserID, &inv.Username, &inv.Email, &inv.InvitedBy, &inv.SendCount, &inv.LastSentAt, &inv.ExpiresAt, &acceptedAt, &revokedAt, &inv.CreatedAt); err != nil {
+		return nil, err
+	}
+	inv.AcceptedAt = acceptedAt.String
+	inv.RevokedAt = revokedAt.String
+	inv.Status = invitationStatus(&inv)
+	return &inv, nil
+}
+
+// sendInvitation envía el link de invitación y registra el resultado en la respuesta
+func sendInvitation(inv *models.Invitation, name, inviterName, token string) {
+	sent := true
+	if err := sendInvitationEmail(inv.Email, name, inviterName, token); err != nil {
+		log.Printf("Error al enviar la invitación %d a %s: %v", inv.ID, inv.Email, err)
+		sent = false
+	}
+	inv.EmailSent = &sent
+}
+
+// inviteUser crea un usuario pendiente, sin contraseña, y le envía un link para que la elija
+func inviteUser(w http.ResponseWriter, r *http.Request) {
+	if rejectAPIKey(w, r) {
+		return
+	}
+	inviter, err := getCurrentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var input struct {
+		Username string `json:"username"`
+		Email    string `json:"email"`
+		Name     string `json:"name"`
+		Rank     int    `json:"rank"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	input.Username = strings.TrimSpace(input.Username)
+	input.Email = strings.TrimSpace(input.Email)
+	input.Name = strings.TrimSpace(input.Name)
+
+	if input.Username == "" || input.Name == "" {
+		http.Error(w, "username y name son obligatorios", http.StatusBadRequest)
+		return
+	}
+	if addr, err := mail.ParseAddress(input.Email); err != nil || addr.Address != input.Email {
+		http.Error(w, "Email inválido", http.StatusBadRequest)
+		return
+	}
+	if input.Rank < 0 || input.Rank > inviter.Rank {
+		http.Error(w, "No se puede invitar a un usuario con un rank mayor al propio", http.StatusForbidden)
+		return
+	}
+	if usernameExists(input.Username) {
+		http.Error(w, "El nombre de usuario ya existe", http.StatusBadRequest)
+		return
+	}
+	if emailInUse(input.Email, 0) {
+		http.Error(w, "El email ya está en uso por otro usuario", http.StatusConflict)
+		return
+	}
+
+	token, err := generateToken(32)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	var userID, invitationID int64
+	err = dataBase.WithTx(r.Context(), func(tx *database.Tx) error {
+		var err error
+		userID, err = tx.Insert(false, "INSERT INTO users (`username`, `rank`, `email`, `name`, `password_hash`, `status`) VALUES (?, ?, ?, ?, '', ?)",
+			input.Username, input.Rank, input.Email, input.Name, userStatusPending)
+		if err != nil {
+			return err
+		}
+		invitationID, err = tx.Insert(false, "INSERT INTO user_invitations (user_id, username, email, token_hash, invited_by, last_sent_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			userID, input.Username, input.Email, hashToken(token), inviter.ID, database.FormatTime(now), database.FormatTime(now.Add(invitationTTL)))
+		return err
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	inv, err := getInvitation(invitationID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendInvitation(inv, input.Name, inviter.Username, token)
+
+	newValue := fmt.Sprintf(`{"invitation_id":%d,"user_id":%d,"username":%q,"email":%q,"rank":%d}`, inv.ID, userID, input.Username, input.Email, input.Rank)
+	if err := insertLog("invite_user", "", newValue, r); err != nil {
+		// Manejar el error de inserción del log aquí
+		log.Printf("Error al insertar el registro de invitación: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(inv)
+}
+
+// getInvitations lista las invitaciones. ?status=pending|accepted|revoked|expired filtra por estado.
+func getInvitations(w http.ResponseWriter, r *http.Request) {
+	invitations := []models.Invitation{}
+
+	q, err := parseListQuery(r, invitationListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := database.FormatTime(time.Now())
+	switch status := r.URL.Query().Get("status"); status {
+	case "":
+	case "pending":
+		q.Where("accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", now)
+	case "accepted":
+		q.Where("accepted_at IS NOT NULL")
+	case "revoked":
+		q.Where("accepted_at IS NULL AND revoked_at IS NOT NULL")
+	case "expired":
+		q.Where("accepted_at IS NULL AND revoked_at IS NULL AND expires_at <= ?", now)
+	default:
+		http.Error(w, "el filtro status debe ser pending, accepted, revoked o expired", http.StatusBadRequest)
+		return
+	}
+	if err := writeTotalCount(w, q); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query, args := q.selectQuery("SELECT id, user_id, username, email, invited_by, send_count, last_sent_at, expires_at, accepted_at, revoked_at, created_at")
+	rows, err := dataBase.Select(query, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var inv models.Invitation
+		var acceptedAt, revokedAt sql.NullString
+		if err := rows.Scan(&inv.ID, &inv.UserID, &inv.Username, &inv.Email, &inv.InvitedBy, &inv.SendCount, &inv.LastSentAt, &inv.ExpiresAt, &acceptedAt, &revokedAt, &inv.CreatedAt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		inv.AcceptedAt = acceptedAt.String
+		inv.RevokedAt = revokedAt.String
+		inv.Status = invitationStatus(&inv)
+		invitations = append(invitations, inv)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invitations)
+}
+
+// resendInvitation genera un link nuevo (el anterior deja de servir) y vuelve a enviarlo.
+// También sirve para renovar una invitación vencida.
+func resendInvitation(w http.ResponseWriter, r *http.Request) {
+	if rejectAPIKey(w, r) {
+		return
+	}
+	inviter, err := getCurrentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	inv, err := getInvitation(chi.URLParam(r, "id"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Invitación no encontrada", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if inv.Status == "accepted" || inv.Status == "revoked" {
+		http.Error(w, "La invitación ya no está pendiente", http.StatusConflict)
+		return
+	}
+
+	token, err := generateToken(32)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	updated, err := dataBase.Update(true, "UPDATE user_invitations SET token_hash = ?, send_count = send_count + 1, last_sent_at = ?, expires_at = ? WHERE id = ? AND accepted_at IS NULL AND revoked_at IS NULL",
+		hashToken(token), database.FormatTime(now), database.FormatTime(now.Add(invitationTTL)), inv.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if updated == 0 {
+		http.Error(w, "La invitación ya no está pendiente", http.StatusConflict)
+		return
+	}
+
+	var name string
+	row, err := dataBase.SelectRow("SELECT name FROM users WHERE id = ?", inv.UserID)
+	if err == nil {
+		row.Scan(&name)
+	}
+
+	inv, err = getInvitation(inv.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendInvitation(inv, name, inviter.Username, token)
+
+	if err := insertLog("resend_invitation", "", fmt.Sprintf(`{"invitation_id":%d,"user_id":%d,"send_count":%d}`, inv.ID, inv.UserID, inv.SendCount), r); err != nil {
+		// Manejar el error de inserción del log aquí
+		log.Printf("Error al insertar el registro de reenvío de invitación: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(inv)
+}
+
+// revokeInvitation anula una invitación pendiente y borra el usuario pendiente, que nunca llegó
+// a usarse, para liberar su nombre de usuario y email
+func revokeInvitation(w http.ResponseWriter, r *http.Request) {
+	if rejectAPIKey(w, r) {
+		return
+	}
+
+	inv, err := getInvitation(chi.URLParam(r, "id"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Invitación no encontrada", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if inv.Status == "accepted" || inv.Status == "revoked" {
+		http.Error(w, "La invitación ya no está pendiente", http.StatusConflict)
+		return
+	}
+
+	err = dataBase.WithTx(r.Context(), func(tx *database.Tx) error {
+		updated, err := tx.Update(false, "UPDATE user_invitations SET revoked_at = ? WHERE id = ? AND accepted_at IS NULL AND revoked_at IS NULL",
+			database.FormatTime(time.Now()), inv.ID)
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return errInvalidInvitation
+		}
+		_, err = tx.Delete(false, "DELETE FROM users WHERE id = ? AND status = ?", inv.UserID, userStatusPending)
+		return err
+	})
+	if err != nil {
+		if err == errInvalidInvitation {
+			http.Error(w, "La invitación ya no está pendiente", http.StatusConflict)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	oldValueBytes, err := json.Marshal(inv)
+	if err != nil {
+		// Manejar error de serialización
+		log.Printf("Error al serializar invitación: %v", err)
+	}
+	if err := insertLog("revoke_invitation", string(oldValueBytes), "", r); err != nil {
+		// Manejar el error de inserción del log aquí
+		log.Printf("Error al insertar el registro de revocación de invitación: %v", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// acceptInvitation es el endpoint público en el que el invitado elige su contraseña. El link es de
+// un solo uso; al aceptarlo la cuenta queda activa y se abre la primera sesión.
+func acceptInvitation(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var u models.User
+	var invitationID int
+	var expiresAt string
+	hash := hashToken(input.Token)
+	row, err := dataBase.SelectRow("SELECT i.id, i.expires_at, u.id, u.username, u.email, u.name, u.`rank` FROM user_invitations i JOIN users u ON i.user_id = u.id "+
+		"WHERE i.token_hash = ? AND i.accepted_at IS NULL AND i.revoked_at IS NULL AND u.status = ?", hash, userStatusPending)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := row.Scan(&invitationID, &expiresAt, &u.ID, &u.Username, &u.Email, &u.Name, &u.Rank); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, errInvalidInvitation.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if expires, err := database.ParseTime(expiresAt); err != nil || time.Now().After(expires) {
+		http.Error(w, errInvalidInvitation.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := validatePassword(input.Password, u.Username, u.Email); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	passwordHash, err := hashPassword(input.Password)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = dataBase.WithTx(r.Context(), func(tx *database.Tx) error {
+		// El token es de un solo uso: solo el primer pedido marca la invitación como aceptada
+		updated, err := tx.Update(false, "UPDATE user_invitations SET accepted_at = ? WHERE id = ? AND token_hash = ? AND accepted_at IS NULL AND revoked_at IS NULL",
+			database.FormatTime(time.Now()), invitationID, hash)
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return errInvalidInvitation
+		}
+		_, err = tx.Update(false, "UPDATE users SET password_hash = ?, salt = '', status = ? WHERE id = ?", passwordHash, userStatusActive, u.ID)
+		return err
+	})
+	if err != nil {
+		if err == errInvalidInvitation {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Registro del evento en nombre del usuario que acaba de activar su cuenta
+	if err := insertLog("accept_invitation", "", fmt.Sprintf(`{"invitation_id":%d,"user_id":%d}`, invitationID, u.ID), withCurrentUser(r, &u)); err != nil {
+		// Manejar el error de inserción del log aquí
+		log.Printf("Error al insertar el registro de aceptación de invitación: %v", err)
+	}
+
+	// Si el rol exige 2FA, la primera sesión se abre por el login normal para enrolarse
+	if twoFactorRequiredForRank(u.Rank) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Cuenta activada. Inicia sesión para configurar la verificación en dos pasos."})
+		return
+	}
+
+	tokens, err := createSession(r, u.ID, u.Name)
+	if err != nil {
+		http.Error(w, "Error al generar el Access Token", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
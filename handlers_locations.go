@@ -12,9 +12,36 @@ import (
 )
 
 // 31a1243d85cd16ed13476c944890a556-8c90f339-4737e546
+var locationListSpec = listSpec{
+	from: "FROM locations",
+	sortable: map[string]string{
+		"id":      "id",
+		"name":    "name",
+		"state":   "state",
+		"city":    "city",
+		"country": "country",
+	},
+	filters: map[string]listFilter{
+		"state":   {"state", filterString},
+		"city":    {"city", filterString},
+		"country": {"country", filterString},
+	},
+	defaultOrder: "id ASC",
+}
+
 func getLocations(w http.ResponseWriter, r *http.Request) {
+	q, err := parseListQuery(r, locationListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := writeTotalCount(w, q); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	rows, err := dataBase.Select("SELECT id, name, lat, lng, state, city, country FROM locations")
+	query, args := q.selectQuery("SELECT id, name, lat, lng, state, city, country")
+	rows, err := dataBase.Select(query, args...)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
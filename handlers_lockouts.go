@@ -0,0 +1,161 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"magpanel/database"
+	"magpanel/models"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var failedLoginListSpec = listSpec{
+	from: "FROM failed_logins",
+	sortable: map[string]string{
+		"id":         "id",
+		"username":   "username",
+		"ip":         "ip",
+		"reason":     "reason",
+		"created_at": "created_at",
+	},
+	filters: map[string]listFilter{
+		"username":       {"username", filterString},
+		"user_id":        {"user_id", filterInt},
+		"ip":             {"ip", filterString},
+		"reason":         {"reason", filterString},
+		"created_after":  {"created_at", filterAfter},
+		"created_before": {"created_at", filterBefore},
+	},
+	defaultOrder: "id DESC",
+}
+
+var userLockListSpec = listSpec{
+	from: "FROM users",
+	sortable: map[string]string{
+		"user_id":       "id",
+		"username":      "username",
+		"lockout_count": "lockout_count",
+		"locked_until":  "locked_until",
+	},
+	filters:      map[string]listFilter{},
+	defaultOrder: "locked_until DESC",
+}
+
+// getFailedLogins devuelve el registro de intentos de login fallidos
+func getFailedLogins(w http.ResponseWriter, r *http.Request) {
+	failedLogins := []models.FailedLogin{}
+
+	q, err := parseListQuery(r, failedLoginListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := writeTotalCount(w, q); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query, args := q.selectQuery("SELECT id, username, user_id, ip, user_agent, reason, created_at")
+	rows, err := dataBase.Select(query, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var f models.FailedLogin
+		var userID sql.NullInt64
+		if err := rows.Scan(&f.ID, &f.Username, &userID, &f.IP, &f.UserAgent, &f.Reason, &f.CreatedAt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		f.UserID = int(userID.Int64)
+		failedLogins = append(failedLogins, f)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(failedLogins)
+}
+
+// getUserLocks lista las cuentas bloqueadas en este momento
+func getUserLocks(w http.ResponseWriter, r *http.Request) {
+	locks := []models.UserLock{}
+
+	q, err := parseListQuery(r, userLockListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.Where("locked_until > ?", database.FormatTime(time.Now()))
+	if err := writeTotalCount(w, q); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query, args := q.selectQuery("SELECT id, username, failed_login_count, lockout_count, locked_until")
+	rows, err := dataBase.Select(query, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var l models.UserLock
+		if err := rows.Scan(&l.UserID, &l.Username, &l.FailedLoginCount, &l.LockoutCount, &l.LockedUntil); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		locks = append(locks, l)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(locks)
+}
+
+// clearUserLock desbloquea una cuenta y reinicia sus contadores de intentos fallidos
+func clearUserLock(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "id")
+
+	var old models.UserLock
+	var lockedUntil sql.NullString
+	row, err := dataBase.SelectRow("SELECT id, username, failed_login_count, lockout_count, locked_until FROM users WHERE id = ?", userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := row.Scan(&old.UserID, &old.Username, &old.FailedLoginCount, &old.LockoutCount, &lockedUntil); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	old.LockedUntil = lockedUntil.String
+
+	if err := resetFailedLogins(userID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	oldValueBytes, err := json.Marshal(old)
+	if err != nil {
+		// Manejar error de serialización
+		log.Printf("Error al serializar bloqueo de usuario: %v", err)
+	}
+	oldValue := string(oldValueBytes)
+
+	// Registro del evento de desbloqueo
+	if err := insertLog("unlock_user", oldValue, fmt.Sprintf(`{"user_id":%d}`, old.UserID), r); err != nil {
+		// Manejar el error de inserción del log aquí
+		log.Printf("Error al insertar el registro de desbloqueo de usuario: %v", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
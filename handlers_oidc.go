@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"magpanel/database"
+	"magpanel/models"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var (
+	errLastAdminRank       = errors.New("no se puede quitar el rol de administrador al último administrador activo")
+	errOIDCNoAccount       = errors.New("no hay un usuario de MagPanel para esta identidad")
+	errOIDCSubjectConflict = errors.New("el email ya está vinculado a otra identidad del proveedor")
+)
+
+// oidcLogin inicia el login con el proveedor OIDC (authorization code + PKCE). Redirige al
+// proveedor, o con ?format=json devuelve la URL para que el frontend haga la redirección.
+func oidcLogin(w http.ResponseWriter, r *http.Request) {
+	if !oidcSettings.Enabled {
+		http.Error(w, "El login con OIDC no está habilitado", http.StatusNotFound)
+		return
+	}
+
+	d, err := oidcIdP.config()
+	if err != nil {
+		log.Printf("Error al consultar el proveedor OIDC: %v", err)
+		http.Error(w, "No se pudo contactar al proveedor de identidad", http.StatusBadGateway)
+		return
+	}
+
+	state, nonce, verifier, err := createOIDCState()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {oidcSettings.ClientID},
+		"redirect_uri":          {oidcSettings.RedirectURL},
+		"scope":                 {strings.Join(oidcSettings.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {pkceChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	authorizationURL := d.AuthorizationEndpoint + separator + params.Encode()
+
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"authorization_url": authorizationURL})
+		return
+	}
+	http.Redirect(w, r, authorizationURL, http.StatusFound)
+}
+
+// oidcCallback recibe el código del proveedor, valida el id_token y abre una sesión de MagPanel
+// para el usuario vinculado a esa identidad
+func oidcCallback(w http.ResponseWriter, r *http.Request) {
+	if !oidcSettings.Enabled {
+		http.Error(w, "El login con OIDC no está habilitado", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	if providerError := query.Get("error"); providerError != "" {
+		http.Error(w, "El proveedor de identidad rechazó el login: "+providerError, http.StatusUnauthorized)
+		return
+	}
+
+	nonce, verifier, err := takeOIDCState(query.Get("state"))
+	if err != nil {
+		if err == errInvalidOIDCState {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	idToken, err := exchangeOIDCCode(query.Get("code"), verifier)
+	if err != nil {
+		log.Printf("Error al canjear el código OIDC: %v", err)
+		http.Error(w, "No se pudo completar el login con el proveedor de identidad", http.StatusUnauthorized)
+		return
+	}
+
+	identity, err := verifyIDToken(idToken, nonce)
+	if err != nil {
+		log.Printf("id_token OIDC rechazado: %v", err)
+		http.Error(w, "No se pudo completar el login con el proveedor de identidad", http.StatusUnauthorized)
+		return
+	}
+
+	u, lockedUntil, provisioned, err := oidcUser(r, identity)
+	if err != nil {
+		if err == errOIDCNoAccount || err == errOIDCSubjectConflict {
+			recordFailedLogin(r, identity.Email, 0, failedLoginUnknownUser)
+			http.Error(w, err.Error(), http.StatusForbidden)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Las cuentas de servicio solo se autentican con API keys
+	if u.ServiceAccount {
+		http.Error(w, "Credenciales inválidas", http.StatusForbidden)
+		return
+	}
+	// Un invitado activa su cuenta con el link de la invitación
+	if u.Status == userStatusPending {
+		http.Error(w, "La invitación de esta cuenta todavía no fue aceptada", http.StatusForbidden)
+		return
+	}
+	if u.Status == userStatusInactive {
+		http.Error(w, "La cuenta está desactivada", http.StatusForbidden)
+		return
+	}
+	if until := accountLockedUntil(lockedUntil); !until.IsZero() {
+		recordFailedLogin(r, u.Username, u.ID, failedLoginLocked)
+		http.Error(w, "Cuenta bloqueada por intentos fallidos hasta "+database.FormatTime(until)+" (UTC)", http.StatusLocked)
+		return
+	}
+
+	// Con grupos mapeados, el rol lo decide el proveedor en cada login
+	oldRank := u.Rank
+	if rank, ok := rankForGroups(identity.Groups); ok && rank != u.Rank {
+		if err := updateOIDCRank(r.Context(), u, rank); err == errLastAdminRank {
+			log.Printf("OIDC: se mantiene el rol de %s porque es el último administrador activo", u.Username)
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else {
+			u.Rank = rank
+		}
+	}
+
+	// El 2FA se exige igual que en el login con contraseña, después de aplicar el rol del proveedor
+	var totpEnabled bool
+	row, err := dataBase.SelectRow("SELECT totp_enabled FROM users WHERE id = ?", u.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := row.Scan(&totpEnabled); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	twoFactor := totpEnabled || twoFactorRequiredForRank(u.Rank)
+
+	// Registro del login en nombre del usuario que acaba de autenticarse
+	newValue, _ := json.Marshal(map[string]interface{}{
+		"user_id":     u.ID,
+		"subject":     identity.Subject,
+		"provisioned": provisioned,
+		"old_rank":    oldRank,
+		"rank":        u.Rank,
+		"two_factor":  twoFactor,
+	})
+	if err := insertLog("login_oidc", "", string(newValue), withCurrentUser(r, u)); err != nil {
+		// Manejar el error de inserción del log aquí
+		log.Printf("Error al insertar el registro de login OIDC: %v", err)
+	}
+
+	// Con 2FA la identidad del proveedor reemplaza a la contraseña: se emite el mismo challenge
+	// que en POST /login y la sesión se abre en POST /login/2fa
+	if twoFactor {
+		challenge, err := twoFactorLoginChallenge(r.Context(), u, totpEnabled)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if oidcSettings.FrontendURL != "" {
+			fragment := url.Values{}
+			for key, value := range challenge {
+				fragment.Set(key, fmt.Sprint(value))
+			}
+			http.Redirect(w, r, oidcSettings.FrontendURL+"#"+fragment.Encode(), http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(challenge)
+		return
+	}
+
+	tokens, err := createSession(r, u.ID, u.Name)
+	if err != nil {
+		http.Error(w, "Error al generar el Access Token", http.StatusInternalServerError)
+		return
+	}
+
+	if oidcSettings.FrontendURL != "" {
+		fragment := url.Values{
+			"access_token":  {tokens.AccessToken},
+			"refresh_token": {tokens.RefreshToken},
+			"token_type":    {tokens.TokenType},
+			"expires_in":    {strconv.Itoa(tokens.ExpiresIn)},
+		}
+		http.Redirect(w, r, oidcSettings.FrontendURL+"#"+fragment.Encode(), http.StatusFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// updateOIDCRank aplica el rank que corresponde a los grupos del proveedor. Nunca deja el sistema
+// sin administradores activos. Si el rank baja se cierran las sesiones del usuario y sus API keys
+// pierden los scopes que el nuevo rol ya no tiene.
+func updateOIDCRank(ctx context.Context, u *models.User, rank int) error {
+	err := dataBase.WithTx(ctx, func(tx *database.Tx) error {
+		if roleForRank(u.Rank) == RoleAdmin && roleForRank(rank) != RoleAdmin {
+			if last, err := isLastActiveAdmin(tx, u.ID); err != nil {
+				return err
+			} else if last {
+				return errLastAdminRank
+			}
+		}
+		if _, err := tx.Update(false, "UPDATE users SET `rank` = ? WHERE id = ?", rank, u.ID); err != nil {
+			return err
+		}
+		if rank < u.Rank {
+			return trimAPIKeyScopes(tx, u.ID, permissionsForRank(rank))
+		}
+		return nil
+	})
+	if err != nil || rank >= u.Rank {
+		return err
+	}
+
+	if _, err := revokeUserSessions(u.ID); err != nil {
+		log.Printf("Error al revocar las sesiones del usuario %d: %v", u.ID, err)
+	}
+	return nil
+}
+
+// oidcUser busca al usuario vinculado a la identidad: primero por `sub`, después por email (y
+// lo vincula), y si no existe lo da de alta cuando PROVISION está activo
+func oidcUser(r *http.Request, identity *oidcIdentity) (*models.User, sql.NullString, bool, error) {
+	var u models.User
+	var lockedUntil, subject sql.NullString
+	const columns = "SELECT id, username, name, email, `rank`, service_account, status, locked_until, oidc_subject FROM users "
+
+	row, err := dataBase.SelectRow(columns+"WHERE oidc_subject = ?", identity.Subject)
+	if err != nil {
+		return nil, lockedUntil, false, err
+	}
+	err = row.Scan(&u.ID, &u.Username, &u.Name, &u.Email, &u.Rank, &u.ServiceAccount, &u.Status, &lockedUntil, &subject)
+	if err == nil {
+		return &u, lockedUntil, false, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, lockedUntil, false, err
+	}
+
+	if identity.Email == "" {
+		return nil, lockedUntil, false, errOIDCNoAccount
+	}
+
+	row, err = dataBase.SelectRow(columns+"WHERE LOWER(email) = LOWER(?)", identity.Email)
+	if err != nil {
+		return nil, lockedUntil, false, err
+	}
+	err = row.Scan(&u.ID, &u.Username, &u.Name, &u.Email, &u.Rank, &u.ServiceAccount, &u.Status, &lockedUntil, &subject)
+	if err == nil {
+		if subject.Valid && subject.String != identity.Subject {
+			return nil, lockedUntil, false, errOIDCSubjectConflict
+		}
+		if _, err := dataBase.Update(true, "UPDATE users SET oidc_subject = ? WHERE id = ?", identity.Subject, u.ID); err != nil {
+			return nil, lockedUntil, false, err
+		}
+		return &u, lockedUntil, false, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, lockedUntil, false, err
+	}
+
+	if !oidcSettings.Provision {
+		return nil, lockedUntil, false, errOIDCNoAccount
+	}
+	provisioned, err := provisionOIDCUser(r, identity)
+	if err != nil {
+		return nil, lockedUntil, false, err
+	}
+	return provisioned, lockedUntil, true, nil
+}
+
+// provisionOIDCUser da de alta un usuario sin contraseña local a partir de la identidad del proveedor
+func provisionOIDCUser(r *http.Request, identity *oidcIdentity) (*models.User, error) {
+	u := models.User{
+		Username: oidcUsername(identity),
+		Email:    identity.Email,
+		Name:     identity.Name,
+		Rank:     oidcSettings.DefaultRank,
+		Status:   userStatusActive,
+	}
+	if u.Name == "" {
+		u.Name = u.Username
+	}
+	if rank, ok := rankForGroups(identity.Groups); ok {
+		u.Rank = rank
+	}
+
+	lastInsertID, err := dataBase.Insert(true, "INSERT INTO users (`username`, `rank`, `email`, `name`, `password_hash`, `oidc_subject`) VALUES (?, ?, ?, ?, '', ?)",
+		u.Username, u.Rank, u.Email, u.Name, identity.Subject)
+	if err != nil {
+		return nil, err
+	}
+	u.ID = int(lastInsertID)
+
+	newValueBytes, err := json.Marshal(u)
+	if err != nil {
+		// Manejar error de serialización
+		log.Printf("Error al serializar nuevo usuario: %v", err)
+	}
+
+	// Registro del evento de creación, a nombre del propio usuario
+	if err := insertLog("create_user", "", string(newValueBytes), withCurrentUser(r, &u)); err != nil {
+		// Manejar el error de inserción del log aquí
+		log.Printf("Error al insertar el registro de creación de usuario: %v", err)
+	}
+	return &u, nil
+}
+
+// oidcUsername arma un nombre de usuario libre a partir de preferred_username o del email
+func oidcUsername(identity *oidcIdentity) string {
+	base := identity.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(identity.Email, "@")
+	}
+	base = strings.Map(func(c rune) rune {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '.', c == '_', c == '-':
+			return c
+		case c >= 'A' && c <= 'Z':
+			return c + ('a' - 'A')
+		}
+		return -1
+	}, base)
+	if base == "" {
+		base = "usuario"
+	}
+
+	username := base
+	for i := 2; usernameExists(username); i++ {
+		username = fmt.Sprintf("%s%d", base, i)
+	}
+	return username
+}
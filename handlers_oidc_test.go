@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"magpanel/models"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func userRank(t *testing.T, id int) int {
+	t.Helper()
+	var rank int
+	row, _ := dataBase.SelectRow("SELECT `rank` FROM users WHERE id = ?", id)
+	if err := row.Scan(&rank); err != nil {
+		t.Fatal(err)
+	}
+	return rank
+}
+
+func TestUpdateOIDCRankKeepsLastAdmin(t *testing.T) {
+	newTestDatabase(t)
+	adminID := createTestUser(t, "admin", 3)
+	createTestUser(t, "tecnico", 1)
+
+	err := updateOIDCRank(context.Background(), &models.User{ID: adminID, Rank: 3}, 1)
+	if err != errLastAdminRank {
+		t.Fatalf("updateOIDCRank = %v, se esperaba errLastAdminRank", err)
+	}
+	if rank := userRank(t, adminID); rank != 3 {
+		t.Errorf("el único administrador quedó con rank %d", rank)
+	}
+}
+
+func TestUpdateOIDCRankDemotion(t *testing.T) {
+	newTestDatabase(t)
+	createTestUser(t, "admin", 3)
+	demotedID := createTestUser(t, "admin2", 3)
+	token := testToken(t, demotedID)
+	scopes, _ := json.Marshal([]string{PermReportsWrite, PermUsersAdmin})
+	keyID, err := dataBase.Insert(false, "INSERT INTO api_keys (name, prefix, key_hash, user_id, scopes, expires_at, created_by) VALUES ('ci', 'mp_', 'hash', ?, ?, '2099-01-01 00:00:00', ?)",
+		demotedID, string(scopes), demotedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := initRoutes()
+	if w := doRequest(t, router, "GET", "/permissions", token, ""); w.Code != http.StatusOK {
+		t.Fatalf("GET /permissions antes de bajar el rol = %d", w.Code)
+	}
+
+	if err := updateOIDCRank(context.Background(), &models.User{ID: demotedID, Rank: 3}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if rank := userRank(t, demotedID); rank != 1 {
+		t.Errorf("rank = %d, se esperaba 1", rank)
+	}
+
+	// Las sesiones abiertas con el rol anterior se cierran
+	if w := doRequest(t, router, "GET", "/permissions", token, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /permissions con la sesión anterior = %d, se esperaba 401", w.Code)
+	}
+
+	// Y las API keys pierden los scopes que el rol nuevo no tiene
+	var stored string
+	row, _ := dataBase.SelectRow("SELECT scopes FROM api_keys WHERE id = ?", keyID)
+	if err := row.Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	json.Unmarshal([]byte(stored), &got)
+	if !reflect.DeepEqual(got, []string{PermReportsWrite}) {
+		t.Errorf("scopes de la API key = %v, se esperaba solo %s", got, PermReportsWrite)
+	}
+}
+
+func TestUpdateOIDCRankPromotionKeepsSessions(t *testing.T) {
+	newTestDatabase(t)
+	userID := createTestUser(t, "tecnico", 1)
+	token := testToken(t, userID)
+
+	if err := updateOIDCRank(context.Background(), &models.User{ID: userID, Rank: 1}, 2); err != nil {
+		t.Fatal(err)
+	}
+	if rank := userRank(t, userID); rank != 2 {
+		t.Errorf("rank = %d, se esperaba 2", rank)
+	}
+	if w := doRequest(t, initRoutes(), "GET", "/permissions", token, ""); w.Code != http.StatusOK {
+		t.Errorf("GET /permissions después de subir el rol = %d, se esperaba 200", w.Code)
+	}
+}
+
+func TestVerifiedEmail(t *testing.T) {
+	defer func(require bool) { oidcSettings.RequireEmailVerified = require }(oidcSettings.RequireEmailVerified)
+
+	cases := []struct {
+		name    string
+		claims  jwt.MapClaims
+		require bool
+		want    string
+	}{
+		{"verificado", jwt.MapClaims{"email": "ana@example.com", "email_verified": true}, true, "ana@example.com"},
+		{"no verificado", jwt.MapClaims{"email": "ana@example.com", "email_verified": false}, true, ""},
+		{"sin email_verified", jwt.MapClaims{"email": "ana@example.com"}, true, ""},
+		{"email_verified no booleano", jwt.MapClaims{"email": "ana@example.com", "email_verified": "true"}, true, ""},
+		{"sin email_verified y sin exigirlo", jwt.MapClaims{"email": "ana@example.com"}, false, "ana@example.com"},
+		{"no verificado y sin exigirlo", jwt.MapClaims{"email": "ana@example.com", "email_verified": false}, false, ""},
+	}
+	for _, c := range cases {
+		oidcSettings.RequireEmailVerified = c.require
+		if got := verifiedEmail(c.claims); got != c.want {
+			t.Errorf("%s: verifiedEmail = %q, se esperaba %q", c.name, got, c.want)
+		}
+	}
+}
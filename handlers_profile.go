@@ -0,0 +1,339 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"magpanel/database"
+	"magpanel/models"
+	"net/http"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Validez del token que confirma un cambio de email, configurable en [security] de data.conf
+var emailChangeTokenTTL = 24 * time.Hour
+
+// maxPreferencesSize limita el JSON de preferencias que guarda cada usuario
+const maxPreferencesSize = 16 * 1024
+
+// loadProfile arma el perfil del usuario; nunca lee password_hash, salt ni tokens
+func loadProfile(r *http.Request, userID int) (*models.Profile, error) {
+	var p models.Profile
+	var pendingEmail, preferences sql.NullString
+	row, err := dataBase.SelectRow("SELECT id, username, name, email, pending_email, `rank`, preferences, totp_enabled, service_account, created_at FROM users WHERE id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := row.Scan(&p.ID, &p.Username, &p.Name, &p.Email, &pendingEmail, &p.Rank, &preferences, &p.TwoFactorEnabled, &p.ServiceAccount, &p.CreatedAt); err != nil {
+		return nil, err
+	}
+	p.PendingEmail = pendingEmail.String
+	p.Role = roleForRank(p.Rank)
+	p.Permissions = append([]string{}, effectivePermissions(r, &models.User{ID: p.ID, Rank: p.Rank})...)
+	sort.Strings(p.Permissions)
+	p.Preferences = json.RawMessage("{}")
+	if preferences.Valid && preferences.String != "" {
+		p.Preferences = json.RawMessage(preferences.String)
+	}
+	return &p, nil
+}
+
+// getProfile devuelve el perfil del usuario autenticado
+func getProfile(w http.ResponseWriter, r *http.Request) {
+	user, err := getCurrentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	profile, err := loadProfile(r, user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	profile.Impersonation = impersonationFor(r, user)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+// updateProfile actualiza nombre y preferencias del usuario autenticado. Un email nuevo no se aplica
+// de inmediato: queda pendiente hasta que se confirme con el token enviado a esa dirección.
+func updateProfile(w http.ResponseWriter, r *http.Request) {
+	if rejectAPIKey(w, r) {
+		return
+	}
+	user, err := getCurrentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var requestData struct {
+		Name        *string         `json:"name"`
+		Email       *string         `json:"email"`
+		Preferences json.RawMessage `json:"preferences"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	old, err := loadProfile(r, user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sets := []string{}
+	args := []interface{}{}
+
+	if requestData.Name != nil {
+		name := strings.TrimSpace(*requestData.Name)
+		if name == "" {
+			http.Error(w, "El nombre no puede estar vacío", http.StatusBadRequest)
+			return
+		}
+		sets = append(sets, "name = ?")
+		args = append(args, name)
+	}
+
+	if len(requestData.Preferences) > 0 && string(requestData.Preferences) != "null" {
+		if len(requestData.Preferences) > maxPreferencesSize {
+			http.Error(w, fmt.Sprintf("Las preferencias no pueden superar los %d bytes", maxPreferencesSize), http.StatusBadRequest)
+			return
+		}
+		var prefs map[string]interface{}
+		if err := json.Unmarshal(requestData.Preferences, &prefs); err != nil {
+			http.Error(w, "Las preferencias deben ser un objeto JSON", http.StatusBadRequest)
+			return
+		}
+		sets = append(sets, "preferences = ?")
+		args = append(args, string(requestData.Preferences))
+	}
+
+	var verificationToken, newEmail string
+	if requestData.Email != nil && !strings.EqualFold(strings.TrimSpace(*requestData.Email), old.Email) {
+		newEmail = strings.TrimSpace(*requestData.Email)
+		if addr, err := mail.ParseAddress(newEmail); err != nil || addr.Address != newEmail {
+			http.Error(w, "Email inválido", http.StatusBadRequest)
+			return
+		}
+		if old.ServiceAccount {
+			http.Error(w, "Las cuentas de servicio no pueden cambiar su email", http.StatusBadRequest)
+			return
+		}
+		if emailInUse(newEmail, user.ID) {
+			http.Error(w, "El email ya está en uso por otro usuario", http.StatusConflict)
+			return
+		}
+
+		verificationToken, err = generateToken(32)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sets = append(sets, "pending_email = ?", "email_verification_hash = ?", "email_verification_time = ?")
+		args = append(args, newEmail, hashToken(verificationToken), database.FormatTime(time.Now()))
+	}
+
+	if len(sets) == 0 {
+		http.Error(w, "No hay cambios para aplicar", http.StatusBadRequest)
+		return
+	}
+
+	args = append(args, user.ID)
+	if _, err := dataBase.Update(true, "UPDATE users SET "+strings.Join(sets, ", ")+" WHERE id = ?", args...); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if verificationToken != "" {
+		go func(email, token string) {
+			if err := sendEmailChangeEmail(email, token); err != nil {
+				log.Printf("Error al enviar el correo de confirmación de email: %v", err)
+			}
+		}(newEmail, verificationToken)
+	}
+
+	profile, err := loadProfile(r, user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	oldValueBytes, err := json.Marshal(old)
+	if err != nil {
+		// Manejar error de serialización
+		log.Printf("Error al serializar perfil anterior: %v", err)
+	}
+	newValueBytes, err := json.Marshal(profile)
+	if err != nil {
+		// Manejar error de serialización
+		log.Printf("Error al serializar perfil nuevo: %v", err)
+	}
+
+	// Registro del evento de actualización
+	if err := insertLog("update_profile", string(oldValueBytes), string(newValueBytes), r); err != nil {
+		// Manejar el error de inserción del log aquí
+		log.Printf("Error al insertar el registro de actualización de perfil: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+// changeMyPassword cambia la contraseña del usuario autenticado. Exige la contraseña actual y
+// cierra todas las demás sesiones; la sesión desde la que se hizo el cambio sigue abierta.
+func changeMyPassword(w http.ResponseWriter, r *http.Request) {
+	if rejectAPIKey(w, r) {
+		return
+	}
+	user, err := getCurrentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var requestData struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var passwordHash, salt string
+	row, err := dataBase.SelectRow("SELECT password_hash, salt FROM users WHERE id = ?", user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := row.Scan(&passwordHash, &salt); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if ok, _ := checkPassword(passwordHash, salt, requestData.CurrentPassword); !ok {
+		recordFailedLogin(r, user.Username, user.ID, failedLoginBadPassword)
+		http.Error(w, "La contraseña actual es incorrecta", http.StatusForbidden)
+		return
+	}
+	if requestData.NewPassword == requestData.CurrentPassword {
+		http.Error(w, "La contraseña nueva debe ser distinta de la actual", http.StatusBadRequest)
+		return
+	}
+	if err := validatePassword(requestData.NewPassword, user.Username, user.Email); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	newHashedPassword, err := hashPassword(requestData.NewPassword)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := dataBase.Update(true, "UPDATE users SET password_hash = ?, salt = '', recovery_hash = NULL, recovery_hash_time = NULL WHERE id = ?",
+		newHashedPassword, user.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Se cierran las demás sesiones; la actual se conserva para no desloguear a quien hizo el cambio
+	sessionID, _ := requestSessionID(r)
+	if _, err := dataBase.Update(true, "UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND id <> ? AND revoked_at IS NULL",
+		database.FormatTime(time.Now()), user.ID, sessionID); err != nil {
+		log.Printf("Error al revocar las sesiones del usuario %d: %v", user.ID, err)
+	}
+
+	// Registro del evento de cambio de contraseña, sin la contraseña
+	if err := insertLog("change_password", "", fmt.Sprintf(`{"user_id":%d}`, user.ID), r); err != nil {
+		// Manejar el error de inserción del log aquí
+		log.Printf("Error al insertar el registro de cambio de contraseña: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Contraseña actualizada con éxito."})
+}
+
+// confirmEmailChange aplica el email pendiente cuando se presenta el token enviado a esa dirección
+func confirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if requestData.Token == "" {
+		http.Error(w, "Token de confirmación inválido o expirado", http.StatusBadRequest)
+		return
+	}
+
+	var u models.User
+	var pendingEmail, verificationTime sql.NullString
+	hash := hashToken(requestData.Token)
+	row, err := dataBase.SelectRow("SELECT id, username, email, pending_email, email_verification_time FROM users WHERE email_verification_hash = ?", hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := row.Scan(&u.ID, &u.Username, &u.Email, &pendingEmail, &verificationTime); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Token de confirmación inválido o expirado", http.StatusBadRequest)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	issuedAt, err := database.ParseTime(verificationTime.String)
+	if err != nil || time.Since(issuedAt) > emailChangeTokenTTL || !pendingEmail.Valid {
+		http.Error(w, "Token de confirmación inválido o expirado", http.StatusBadRequest)
+		return
+	}
+
+	// Otro usuario pudo tomar el email mientras el cambio estaba pendiente
+	if emailInUse(pendingEmail.String, u.ID) {
+		http.Error(w, "El email ya está en uso por otro usuario", http.StatusConflict)
+		return
+	}
+
+	// El token es de un solo uso: se borra junto con el cambio
+	updated, err := dataBase.Update(true, "UPDATE users SET email = pending_email, pending_email = NULL, email_verification_hash = NULL, email_verification_time = NULL WHERE id = ? AND email_verification_hash = ?",
+		u.ID, hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if updated == 0 {
+		http.Error(w, "Token de confirmación inválido o expirado", http.StatusBadRequest)
+		return
+	}
+
+	// Registro del evento en nombre del usuario dueño de la cuenta
+	newValue := fmt.Sprintf(`{"user_id":%d,"email":%q}`, u.ID, pendingEmail.String)
+	if err := insertLog("confirm_email_change", fmt.Sprintf(`{"user_id":%d,"email":%q}`, u.ID, u.Email), newValue, withCurrentUser(r, &u)); err != nil {
+		// Manejar el error de inserción del log aquí
+		log.Printf("Error al insertar el registro de cambio de email: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Email actualizado con éxito."})
+}
+
+// emailInUse indica si otro usuario ya tiene ese email
+func emailInUse(email string, exceptUserID int) bool {
+	var id int
+	row, err := dataBase.SelectRow("SELECT id FROM users WHERE email = ? AND id <> ?", email, exceptUserID)
+	if err != nil {
+		log.Printf("Error al verificar el email: %v", err)
+		return true
+	}
+	return row.Scan(&id) == nil
+}
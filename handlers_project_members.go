@@ -0,0 +1,317 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"magpanel/models"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Roles de un usuario dentro de un proyecto
+const (
+	projectRoleLead       = "lead"
+	projectRoleTechnician = "technician"
+	projectRoleViewer     = "viewer"
+)
+
+func validProjectRole(role string) bool {
+	switch role {
+	case projectRoleLead, projectRoleTechnician, projectRoleViewer:
+		return true
+	}
+	return false
+}
+
+var projectMemberListSpec = listSpec{
+	from: "FROM project_members m LEFT JOIN users u ON m.user_id = u.id",
+	sortable: map[string]string{
+		"id":         "m.id",
+		"user_id":    "m.user_id",
+		"role":       "m.role",
+		"username":   "u.username",
+		"name":       "u.name",
+		"created_at": "m.created_at",
+	},
+	filters: map[string]listFilter{
+		"role":    {"m.role", filterString},
+		"user_id": {"m.user_id", filterInt},
+	},
+	defaultOrder: "m.id ASC",
+}
+
+// projectIDParam lee el {id} de la URL y verifica que el proyecto exista. Si no, ya escribió el
+// error en la respuesta y devuelve false.
+func projectIDParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	var id int
+	row, err := dataBase.SelectRow("SELECT id FROM projects WHERE id = ?", chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return 0, false
+	}
+	if err := row.Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Proyecto no encontrado", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return 0, false
+	}
+	return id, true
+}
+
+// getProjectMember lee la asignación de un usuario a un proyecto
+func getProjectMember(projectID, userID int) (*models.ProjectMember, error) {
+	var m models.ProjectMember
+	row, err := dataBase.SelectRow("SELECT m.id, m.project_id, m.user_id, COALESCE(u.username, ''), COALESCE(u.name, ''), m.role, m.added_by, m.created_at FROM project_members m LEFT JOIN users u ON m.user_id = u.id WHERE m.project_id = ? AND m.user_id = ?", projectID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := row.Scan(&m.ID, &m.ProjectID, &m.UserID, &m.Username, &m.Name, &m.Role, &m.AddedBy, &m.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func getProjectMembers(w http.ResponseWriter, r *http.Request) {
+	projectID, ok := projectIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	q, err := parseListQuery(r, projectMemberListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.Where("m.project_id = ?", projectID)
+	if err := writeTotalCount(w, q); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query, args := q.selectQuery("SELECT m.id, m.project_id, m.user_id, COALESCE(u.username, ''), COALESCE(u.name, ''), m.role, m.added_by, m.created_at")
+	rows, err := dataBase.Select(query, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	members := []models.ProjectMember{}
+	for rows.Next() {
+		var m models.ProjectMember
+		if err := rows.Scan(&m.ID, &m.ProjectID, &m.UserID, &m.Username, &m.Name, &m.Role, &m.AddedBy, &m.CreatedAt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		members = append(members, m)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+// addProjectMember asigna un usuario al proyecto con un rol
+func addProjectMember(w http.ResponseWriter, r *http.Request) {
+	projectID, ok := projectIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		UserID int    `json:"user_id"`
+		Role   string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validProjectRole(input.Role) {
+		http.Error(w, "role debe ser lead, technician o viewer", http.StatusBadRequest)
+		return
+	}
+
+	user, err := getUserSummary(strconv.Itoa(input.UserID))
+	if err != nil {
+		http.Error(w, "Usuario no encontrado", http.StatusBadRequest)
+		return
+	}
+	if user.Status == userStatusInactive {
+		http.Error(w, "No se puede asignar un usuario desactivado", http.StatusConflict)
+		return
+	}
+
+	currentUser, err := getCurrentUser(r)
+	if err != nil {
+		http.Error(w, "Error al obtener el usuario actual", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := getProjectMember(projectID, user.ID); err == nil {
+		http.Error(w, "El usuario ya es miembro del proyecto", http.StatusConflict)
+		return
+	} else if err != sql.ErrNoRows {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := dataBase.Insert(true, "INSERT INTO project_members (project_id, user_id, role, added_by) VALUES (?, ?, ?, ?)", projectID, user.ID, input.Role, currentUser.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	member, err := getProjectMember(projectID, user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	newValue, _ := json.Marshal(map[string]interface{}{"user_id": member.UserID, "role": member.Role})
+	if err := insertProjectLog(projectID, "add_project_member", "", string(newValue), r); err != nil {
+		log.Printf("Error al insertar el registro de alta de miembro de proyecto: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(member)
+}
+
+// memberParam lee el proyecto y el {userID} de la URL y carga la asignación. Si algo falta, ya
+// escribió el error en la respuesta y devuelve nil.
+func memberParam(w http.ResponseWriter, r *http.Request) *models.ProjectMember {
+	projectID, ok := projectIDParam(w, r)
+	if !ok {
+		return nil
+	}
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		http.Error(w, "Miembro no encontrado", http.StatusNotFound)
+		return nil
+	}
+	member, err := getProjectMember(projectID, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Miembro no encontrado", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return nil
+	}
+	return member
+}
+
+// updateProjectMember cambia el rol de un miembro del proyecto
+func updateProjectMember(w http.ResponseWriter, r *http.Request) {
+	member := memberParam(w, r)
+	if member == nil {
+		return
+	}
+
+	var input struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validProjectRole(input.Role) {
+		http.Error(w, "role debe ser lead, technician o viewer", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := dataBase.Update(true, "UPDATE project_members SET role = ? WHERE id = ?", input.Role, member.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	oldValue, _ := json.Marshal(map[string]interface{}{"user_id": member.UserID, "role": member.Role})
+	newValue, _ := json.Marshal(map[string]interface{}{"user_id": member.UserID, "role": input.Role})
+	if err := insertProjectLog(member.ProjectID, "update_project_member", string(oldValue), string(newValue), r); err != nil {
+		log.Printf("Error al insertar el registro de cambio de rol en proyecto: %v", err)
+	}
+
+	member.Role = input.Role
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(member)
+}
+
+// removeProjectMember quita a un usuario del proyecto
+func removeProjectMember(w http.ResponseWriter, r *http.Request) {
+	member := memberParam(w, r)
+	if member == nil {
+		return
+	}
+
+	if _, err := dataBase.Delete(true, "DELETE FROM project_members WHERE id = ?", member.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	oldValue, _ := json.Marshal(map[string]interface{}{"user_id": member.UserID, "role": member.Role})
+	if err := insertProjectLog(member.ProjectID, "remove_project_member", string(oldValue), "", r); err != nil {
+		log.Printf("Error al insertar el registro de baja de miembro de proyecto: %v", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getUserProjects lista los proyectos a los que está asignado un usuario, con su rol en cada uno.
+// Acepta los mismos filtros que GET /projects y además role.
+func getUserProjects(w http.ResponseWriter, r *http.Request) {
+	user, err := getUserSummary(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+
+	q, err := parseListQuery(r, userProjectListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.Where("pm.user_id = ?", user.ID)
+	if err := restrictToVisibleProjects(r, q, "p.id"); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := applyScheduleFilters(r, q); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := writeTotalCount(w, q); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	projects, err := queryProjects(q, true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if projects == nil {
+		projects = []models.Project{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(projects)
+}
+
+// userProjectListSpec es el listado de proyectos con la asignación del usuario (pm)
+var userProjectListSpec = func() listSpec {
+	spec := listSpec{
+		from:         projectListSpec.from + " JOIN project_members pm ON pm.project_id = p.id",
+		sortable:     map[string]string{"member_role": "pm.role"},
+		filters:      map[string]listFilter{"role": {"pm.role", filterString}},
+		defaultOrder: projectListSpec.defaultOrder,
+	}
+	for k, v := range projectListSpec.sortable {
+		spec.sortable[k] = v
+	}
+	for k, v := range projectListSpec.filters {
+		spec.filters[k] = v
+	}
+	return spec
+}()
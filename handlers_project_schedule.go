@@ -0,0 +1,493 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"magpanel/database"
+	"magpanel/models"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// dateLayout es el formato de las fechas sin hora: fechas de inicio, objetivos y hitos
+const dateLayout = "2006-01-02"
+
+// Un proyecto está atrasado si pasó su fecha objetivo y su estado no es final
+const projectOverdueCondition = "p.target_end_date < ? AND ps.closed = 0"
+
+// today es la fecha de hoy en UTC, como se comparan las fechas en la base
+func today() string {
+	return time.Now().UTC().Format(dateLayout)
+}
+
+// dateOnly recorta una fecha leída de la base a YYYY-MM-DD
+func dateOnly(value *string) *string {
+	if value == nil || len(*value) <= len(dateLayout) {
+		return value
+	}
+	date := (*value)[:len(dateLayout)]
+	return &date
+}
+
+// parseOptionalDate valida una fecha opcional. Una cadena vacía equivale a no tener fecha.
+func parseOptionalDate(field string, value *string) (*string, error) {
+	if value == nil || *value == "" {
+		return nil, nil
+	}
+	if _, err := time.Parse(dateLayout, *value); err != nil {
+		return nil, fmt.Errorf("%s debe tener el formato YYYY-MM-DD", field)
+	}
+	return value, nil
+}
+
+// validateProjectDates valida start_date y target_end_date; el objetivo no puede ser anterior al inicio
+func validateProjectDates(p *models.Project) error {
+	var err error
+	if p.StartDate, err = parseOptionalDate("start_date", p.StartDate); err != nil {
+		return err
+	}
+	if p.TargetEndDate, err = parseOptionalDate("target_end_date", p.TargetEndDate); err != nil {
+		return err
+	}
+	if p.StartDate != nil && p.TargetEndDate != nil && *p.TargetEndDate < *p.StartDate {
+		return fmt.Errorf("target_end_date no puede ser anterior a start_date")
+	}
+	return nil
+}
+
+// projectOverdue indica si el proyecto pasó su fecha objetivo sin llegar a un estado final
+func projectOverdue(targetEndDate *string, closed bool, today string) bool {
+	return targetEndDate != nil && !closed && *targetEndDate < today
+}
+
+// parseDueWithin interpreta un plazo en días: "7", "7d" o "2w"
+func parseDueWithin(value string) (int, error) {
+	multiplier := 1
+	switch {
+	case strings.HasSuffix(value, "d"):
+		value = strings.TrimSuffix(value, "d")
+	case strings.HasSuffix(value, "w"):
+		value, multiplier = strings.TrimSuffix(value, "w"), 7
+	}
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 0 {
+		return 0, fmt.Errorf("due_within debe ser una cantidad de días (ej: 7d o 2w)")
+	}
+	return days * multiplier, nil
+}
+
+// applyScheduleFilters agrega al listado de proyectos los filtros overdue=true|false y
+// due_within=7d (fecha objetivo entre hoy y dentro del plazo, sin contar los estados finales)
+func applyScheduleFilters(r *http.Request, q *listQuery) error {
+	query := r.URL.Query()
+	now := today()
+	if value := query.Get("overdue"); value != "" {
+		overdue, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("overdue debe ser true o false")
+		}
+		if overdue {
+			q.Where(projectOverdueCondition, now)
+		} else {
+			q.Where("(p.target_end_date IS NULL OR NOT ("+projectOverdueCondition+"))", now)
+		}
+	}
+	if value := query.Get("due_within"); value != "" {
+		days, err := parseDueWithin(value)
+		if err != nil {
+			return err
+		}
+		until := time.Now().UTC().AddDate(0, 0, days).Format(dateLayout)
+		q.Where("p.target_end_date >= ? AND p.target_end_date <= ? AND ps.closed = 0", now, until)
+	}
+	return nil
+}
+
+var projectMilestoneListSpec = listSpec{
+	from: "FROM project_milestones m",
+	sortable: map[string]string{
+		"id":           "m.id",
+		"name":         "m.name",
+		"due_date":     "m.due_date",
+		"completed_at": "m.completed_at",
+	},
+	filters:      map[string]listFilter{},
+	defaultOrder: "m.due_date ASC, m.id ASC",
+}
+
+const milestoneColumns = "SELECT m.id, m.project_id, m.name, m.due_date, m.completed_at, m.created_by, m.created_at"
+
+func scanMilestone(scan func(dest ...interface{}) error, today string) (models.ProjectMilestone, error) {
+	var m models.ProjectMilestone
+	if err := scan(&m.ID, &m.ProjectID, &m.Name, &m.DueDate, &m.CompletedAt, &m.CreatedBy, &m.CreatedAt); err != nil {
+		return m, err
+	}
+	m.DueDate = *dateOnly(&m.DueDate)
+	m.Completed = m.CompletedAt != nil
+	m.Overdue = !m.Completed && m.DueDate < today
+	return m, nil
+}
+
+// getProjectMilestones lista los hitos del proyecto por fecha. Acepta ?completed y ?overdue.
+func getProjectMilestones(w http.ResponseWriter, r *http.Request) {
+	projectID, ok := projectIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	q, err := parseListQuery(r, projectMilestoneListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.Where("m.project_id = ?", projectID)
+	now := today()
+	for _, param := range []string{"completed", "overdue"} {
+		value := r.URL.Query().Get(param)
+		if value == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("el filtro %s debe ser true o false", param), http.StatusBadRequest)
+			return
+		}
+		switch {
+		case param == "completed" && b:
+			q.Where("m.completed_at IS NOT NULL")
+		case param == "completed":
+			q.Where("m.completed_at IS NULL")
+		case b:
+			q.Where("m.completed_at IS NULL AND m.due_date < ?", now)
+		default:
+			q.Where("(m.completed_at IS NOT NULL OR m.due_date >= ?)", now)
+		}
+	}
+	if err := writeTotalCount(w, q); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query, args := q.selectQuery(milestoneColumns)
+	rows, err := dataBase.Select(query, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	milestones := []models.ProjectMilestone{}
+	for rows.Next() {
+		m, err := scanMilestone(rows.Scan, now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		milestones = append(milestones, m)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(milestones)
+}
+
+// loadMilestone lee un hito del proyecto
+func loadMilestone(projectID, milestoneID int) (*models.ProjectMilestone, error) {
+	row, err := dataBase.SelectRow(milestoneColumns+" "+projectMilestoneListSpec.from+" WHERE m.project_id = ? AND m.id = ?", projectID, milestoneID)
+	if err != nil {
+		return nil, err
+	}
+	m, err := scanMilestone(row.Scan, today())
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// milestoneInput son los campos editables de un hito
+type milestoneInput struct {
+	Name      string `json:"name"`
+	DueDate   string `json:"due_date"`
+	Completed bool   `json:"completed"`
+}
+
+func (in *milestoneInput) validate() error {
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" {
+		return fmt.Errorf("name es obligatorio")
+	}
+	if _, err := time.Parse(dateLayout, in.DueDate); err != nil {
+		return fmt.Errorf("due_date es obligatorio y debe tener el formato YYYY-MM-DD")
+	}
+	return nil
+}
+
+// createProjectMilestone agrega un hito al proyecto
+func createProjectMilestone(w http.ResponseWriter, r *http.Request) {
+	projectID, ok := projectIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input milestoneInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := input.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	currentUser, err := getCurrentUser(r)
+	if err != nil {
+		http.Error(w, "Error al obtener el usuario actual", http.StatusInternalServerError)
+		return
+	}
+
+	var completedAt interface{}
+	if input.Completed {
+		completedAt = database.FormatTime(time.Now())
+	}
+	id, err := dataBase.Insert(true, "INSERT INTO project_milestones (project_id, name, due_date, created_by, completed_at) VALUES (?, ?, ?, ?, ?)", projectID, input.Name, input.DueDate, currentUser.ID, completedAt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	milestone, err := loadMilestone(projectID, int(id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	newValue, _ := json.Marshal(milestone)
+	if err := insertProjectLog(projectID, "create_milestone", "", string(newValue), r); err != nil {
+		log.Printf("Error al insertar el registro de creación de hito: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(milestone)
+}
+
+// milestoneParam lee el proyecto y el {milestoneID} de la URL y carga el hito. Si algo falta, ya
+// escribió el error en la respuesta y devuelve nil.
+func milestoneParam(w http.ResponseWriter, r *http.Request) *models.ProjectMilestone {
+	projectID, ok := projectIDParam(w, r)
+	if !ok {
+		return nil
+	}
+	milestoneID, err := strconv.Atoi(chi.URLParam(r, "milestoneID"))
+	if err != nil {
+		http.Error(w, "Hito no encontrado", http.StatusNotFound)
+		return nil
+	}
+	milestone, err := loadMilestone(projectID, milestoneID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Hito no encontrado", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return nil
+	}
+	return milestone
+}
+
+// updateProjectMilestone reemplaza nombre, fecha y estado del hito. completed_at se marca al
+// completarlo y se borra al reabrirlo.
+func updateProjectMilestone(w http.ResponseWriter, r *http.Request) {
+	old := milestoneParam(w, r)
+	if old == nil {
+		return
+	}
+
+	var input milestoneInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := input.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	completedAt := old.CompletedAt
+	if input.Completed && !old.Completed {
+		now := database.FormatTime(time.Now())
+		completedAt = &now
+	} else if !input.Completed {
+		completedAt = nil
+	}
+	if _, err := dataBase.Update(true, "UPDATE project_milestones SET name = ?, due_date = ?, completed_at = ? WHERE id = ?", input.Name, input.DueDate, completedAt, old.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	milestone, err := loadMilestone(old.ProjectID, old.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	oldValue, _ := json.Marshal(old)
+	newValue, _ := json.Marshal(milestone)
+	if err := insertProjectLog(old.ProjectID, "update_milestone", string(oldValue), string(newValue), r); err != nil {
+		log.Printf("Error al insertar el registro de actualización de hito: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(milestone)
+}
+
+// deleteProjectMilestone borra un hito
+func deleteProjectMilestone(w http.ResponseWriter, r *http.Request) {
+	old := milestoneParam(w, r)
+	if old == nil {
+		return
+	}
+
+	if _, err := dataBase.Delete(true, "DELETE FROM project_milestones WHERE id = ?", old.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	oldValue, _ := json.Marshal(old)
+	if err := insertProjectLog(old.ProjectID, "delete_milestone", string(oldValue), "", r); err != nil {
+		log.Printf("Error al insertar el registro de eliminación de hito: %v", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getOverdueSummary resume, por cliente y por estado, los proyectos atrasados, los que vencen
+// dentro de due_within (7 días por defecto) y los hitos vencidos sin completar. Los estados
+// finales no cuentan. Filtros opcionales: category_id y client_id.
+func getOverdueSummary(w http.ResponseWriter, r *http.Request) {
+	days := 7
+	if value := r.URL.Query().Get("due_within"); value != "" {
+		var err error
+		if days, err = parseDueWithin(value); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	now := today()
+	until := time.Now().UTC().AddDate(0, 0, days).Format(dateLayout)
+
+	query := "SELECT p.id, p.client_id, cl.name, p.status_id, ps.status_name, ps.`order`, p.target_end_date, " +
+		"(SELECT COUNT(*) FROM project_milestones m WHERE m.project_id = p.id AND m.completed_at IS NULL AND m.due_date < ?) " +
+		"FROM projects p JOIN project_statuses ps ON p.status_id = ps.id JOIN clients cl ON p.client_id = cl.id " +
+		"WHERE ps.closed = 0"
+	args := []interface{}{now}
+	for _, filter := range []struct{ param, column string }{
+		{"category_id", "p.category_id"},
+		{"client_id", "p.client_id"},
+	} {
+		value := r.URL.Query().Get(filter.param)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%s debe ser un número", filter.param), http.StatusBadRequest)
+			return
+		}
+		query += " AND " + filter.column + " = ?"
+		args = append(args, n)
+	}
+	user, err := getCurrentUser(r)
+	if err != nil {
+		http.Error(w, "Error al obtener el usuario actual", http.StatusInternalServerError)
+		return
+	}
+	if !requestHasPermission(r, user, PermProjectsAll) {
+		query += " AND p.id IN (SELECT project_id FROM project_members WHERE user_id = ?)"
+		args = append(args, user.ID)
+	}
+
+	rows, err := dataBase.Select(query, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	summary := models.OverdueSummary{Today: now, DueWithinDays: days, ByClient: []models.OverdueGroup{}, ByStatus: []models.OverdueGroup{}}
+	byClient := map[int]*models.OverdueGroup{}
+	byStatus := map[int]*models.OverdueGroup{}
+	statusOrder := map[int]int{}
+	for rows.Next() {
+		var projectID, clientID, statusID, order, overdueMilestones int
+		var clientName, statusName string
+		var target *string
+		if err := rows.Scan(&projectID, &clientID, &clientName, &statusID, &statusName, &order, &target, &overdueMilestones); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		target = dateOnly(target)
+		overdue := projectOverdue(target, false, now)
+		dueSoon := target != nil && *target >= now && *target <= until
+		if !overdue && !dueSoon && overdueMilestones == 0 {
+			continue
+		}
+
+		client := byClient[clientID]
+		if client == nil {
+			client = &models.OverdueGroup{ID: clientID, Name: clientName}
+			byClient[clientID] = client
+		}
+		status := byStatus[statusID]
+		if status == nil {
+			status = &models.OverdueGroup{ID: statusID, Name: statusName}
+			byStatus[statusID] = status
+			statusOrder[statusID] = order
+		}
+		for _, g := range []*models.OverdueGroup{client, status, &summary.Total} {
+			if overdue {
+				g.OverdueProjects++
+				g.ProjectIDs = append(g.ProjectIDs, projectID)
+			}
+			if dueSoon {
+				g.DueSoonProjects++
+			}
+			g.OverdueMilestones += overdueMilestones
+		}
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// El total no lista ids: ya están en los grupos
+	summary.Total.ProjectIDs = nil
+
+	for _, g := range byClient {
+		summary.ByClient = append(summary.ByClient, *g)
+	}
+	for _, g := range byStatus {
+		summary.ByStatus = append(summary.ByStatus, *g)
+	}
+	// Los clientes con más proyectos atrasados primero; los estados en el orden del flujo
+	sort.Slice(summary.ByClient, func(i, j int) bool {
+		a, b := summary.ByClient[i], summary.ByClient[j]
+		if a.OverdueProjects != b.OverdueProjects {
+			return a.OverdueProjects > b.OverdueProjects
+		}
+		return a.Name < b.Name
+	})
+	sort.Slice(summary.ByStatus, func(i, j int) bool {
+		a, b := summary.ByStatus[i], summary.ByStatus[j]
+		if statusOrder[a.ID] != statusOrder[b.ID] {
+			return statusOrder[a.ID] < statusOrder[b.ID]
+		}
+		return a.ID < b.ID
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
@@ -5,17 +5,45 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"magpanel/database"
 	"magpanel/models"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
 )
 
+var projectStatusListSpec = listSpec{
+	from: "FROM project_statuses p JOIN categories c ON p.category_id = c.id",
+	sortable: map[string]string{
+		"id":            "p.id",
+		"status_name":   "p.status_name",
+		"order":         "p.`order`",
+		"category_id":   "p.category_id",
+		"category_name": "c.name",
+	},
+	filters: map[string]listFilter{
+		"category_id": {"p.category_id", filterInt},
+		"closed":      {"p.closed", filterBool},
+	},
+	defaultOrder: "p.id ASC",
+}
+
 func getProjectStatuses(w http.ResponseWriter, r *http.Request) {
 	var statuses []models.ProjectStatus
 
+	q, err := parseListQuery(r, projectStatusListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := writeTotalCount(w, q); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	// get the p.category_id and Name from the categories table with JOIN
-	rows, err := dataBase.Select("SELECT p.id, p.status_name, p.order, p.category_id, c.name FROM project_statuses p JOIN categories c ON p.category_id = c.id")
+	query, args := q.selectQuery("SELECT p.id, p.status_name, p.`order`, p.category_id, c.name, p.sla_hours, p.closed")
+	rows, err := dataBase.Select(query, args...)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -24,7 +52,7 @@ func getProjectStatuses(w http.ResponseWriter, r *http.Request) {
 
 	for rows.Next() {
 		var s models.ProjectStatus
-		if err := rows.Scan(&s.ID, &s.StatusName, &s.Order, &s.CategoryID, &s.CategoryName); err != nil {
+		if err := rows.Scan(&s.ID, &s.StatusName, &s.Order, &s.CategoryID, &s.CategoryName, &s.SLAHours, &s.Closed); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -41,6 +69,10 @@ func createProjectStatus(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if s.SLAHours != nil && *s.SLAHours < 1 {
+		http.Error(w, "sla_hours debe ser mayor que cero", http.StatusBadRequest)
+		return
+	}
 
 	// Verificar si existe un registro con el mismo order y category_id, si existe y no es el mismo registro que se está actualizando, devolver un error
 	var existing models.ProjectStatus
@@ -57,7 +89,7 @@ func createProjectStatus(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	lastInsertID, err := dataBase.Insert(true, "INSERT INTO project_statuses (status_name, `order`, `category_id`) VALUES (?, ?, ?)", s.StatusName, s.Order, s.CategoryID)
+	lastInsertID, err := dataBase.Insert(true, "INSERT INTO project_statuses (status_name, `order`, `category_id`, sla_hours, closed) VALUES (?, ?, ?, ?, ?)", s.StatusName, s.Order, s.CategoryID, s.SLAHours, s.Closed)
 
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -87,7 +119,7 @@ func getProjectStatusByID(w http.ResponseWriter, r *http.Request) {
 	statusID := chi.URLParam(r, "id")
 
 	var s models.ProjectStatus
-	rows, err := dataBase.SelectRow("SELECT p.id, p.status_name, p.order, p.category_id, c.name FROM project_statuses p JOIN categories c ON p.category_id = c.id WHERE p.id = ?", statusID)
+	rows, err := dataBase.SelectRow("SELECT p.id, p.status_name, p.`order`, p.category_id, c.name, p.sla_hours, p.closed FROM project_statuses p JOIN categories c ON p.category_id = c.id WHERE p.id = ?", statusID)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -97,7 +129,7 @@ func getProjectStatusByID(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	rows.Scan(&s.ID, &s.StatusName, &s.Order, &s.CategoryID, &s.CategoryName)
+	rows.Scan(&s.ID, &s.StatusName, &s.Order, &s.CategoryID, &s.CategoryName, &s.SLAHours, &s.Closed)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(s)
@@ -110,6 +142,10 @@ func updateProjectStatus(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if s.SLAHours != nil && *s.SLAHours < 1 {
+		http.Error(w, "sla_hours debe ser mayor que cero", http.StatusBadRequest)
+		return
+	}
 
 	// Verificar si existe un registro con el mismo order y category_id, si existe y no es el mismo registro que se está actualizando, devolver un error
 	var existing models.ProjectStatus
@@ -127,7 +163,7 @@ func updateProjectStatus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var old models.ProjectStatus
-	rows, err := dataBase.SelectRow("SELECT id, status_name, `order`, category_id FROM project_statuses WHERE id = ?", statusID)
+	rows, err := dataBase.SelectRow("SELECT id, status_name, `order`, category_id, sla_hours, closed FROM project_statuses WHERE id = ?", statusID)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -137,7 +173,7 @@ func updateProjectStatus(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	rows.Scan(&old.ID, &old.StatusName, &old.Order, &old.CategoryID)
+	rows.Scan(&old.ID, &old.StatusName, &old.Order, &old.CategoryID, &old.SLAHours, &old.Closed)
 
 	oldValueBytes, err := json.Marshal(old)
 	if err != nil {
@@ -146,7 +182,7 @@ func updateProjectStatus(w http.ResponseWriter, r *http.Request) {
 	}
 	oldValue := string(oldValueBytes)
 
-	_, err = dataBase.Update(true, "UPDATE project_statuses SET status_name = ?, `order` = ?, category_id = ? WHERE id = ?", s.StatusName, s.Order, s.CategoryID, statusID)
+	_, err = dataBase.Update(true, "UPDATE project_statuses SET status_name = ?, `order` = ?, category_id = ?, sla_hours = ?, closed = ? WHERE id = ?", s.StatusName, s.Order, s.CategoryID, s.SLAHours, s.Closed, statusID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -190,7 +226,14 @@ func deleteProjectStatus(w http.ResponseWriter, r *http.Request) {
 	}
 	oldValue := string(oldValueBytes)
 
-	_, err = dataBase.Delete(true, "DELETE FROM project_statuses WHERE id = ?", statusID)
+	// Las reglas del flujo que usan el estado dejan de tener sentido
+	err = dataBase.WithTx(r.Context(), func(tx *database.Tx) error {
+		if _, err := tx.Delete(false, "DELETE FROM project_status_transitions WHERE from_status_id = ? OR to_status_id = ?", statusID, statusID); err != nil {
+			return err
+		}
+		_, err := tx.Delete(true, "DELETE FROM project_statuses WHERE id = ?", statusID)
+		return err
+	})
 
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
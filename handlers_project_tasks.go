@@ -0,0 +1,673 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"magpanel/database"
+	"magpanel/models"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Estados de una tarea
+const (
+	taskStatusPending    = "pending"
+	taskStatusInProgress = "in_progress"
+	taskStatusDone       = "done"
+	taskStatusCancelled  = "cancelled"
+)
+
+var taskPriorities = map[string]bool{"low": true, "normal": true, "high": true, "urgent": true}
+
+var taskStatuses = map[string]bool{taskStatusPending: true, taskStatusInProgress: true, taskStatusDone: true, taskStatusCancelled: true}
+
+// Columnas de una tarea, con el resumen de su checklist
+const taskColumns = "SELECT t.id, t.project_id, t.title, t.description, t.assignee_id, COALESCE(u.name, ''), t.due_date, t.priority, t.status, t.created_by, t.completed_at, t.created_at, t.updated_at, " +
+	"(SELECT COUNT(*) FROM project_task_items i WHERE i.task_id = t.id), " +
+	"(SELECT COUNT(*) FROM project_task_items i WHERE i.task_id = t.id AND i.done = 1)"
+
+var projectTaskListSpec = listSpec{
+	from: "FROM project_tasks t LEFT JOIN users u ON t.assignee_id = u.id",
+	sortable: map[string]string{
+		"id":          "t.id",
+		"title":       "t.title",
+		"assignee_id": "t.assignee_id",
+		"due_date":    "t.due_date",
+		"priority":    "CASE t.priority WHEN 'urgent' THEN 4 WHEN 'high' THEN 3 WHEN 'normal' THEN 2 ELSE 1 END",
+		"status":      "t.status",
+		"created_at":  "t.created_at",
+		"updated_at":  "t.updated_at",
+	},
+	filters: map[string]listFilter{
+		"status":      {"t.status", filterString},
+		"priority":    {"t.priority", filterString},
+		"assignee_id": {"t.assignee_id", filterInt},
+	},
+	defaultOrder: "t.id ASC",
+}
+
+func scanTask(scan func(dest ...interface{}) error) (models.ProjectTask, error) {
+	var t models.ProjectTask
+	err := scan(&t.ID, &t.ProjectID, &t.Title, &t.Description, &t.AssigneeID, &t.AssigneeName, &t.DueDate, &t.Priority, &t.Status,
+		&t.CreatedBy, &t.CompletedAt, &t.CreatedAt, &t.UpdatedAt, &t.ItemsTotal, &t.ItemsDone)
+	if err != nil {
+		return t, err
+	}
+	t.DueDate = dateOnly(t.DueDate)
+	t.Completion = taskCompletion(t.Status, t.ItemsTotal, t.ItemsDone)
+	return t, nil
+}
+
+// taskCompletion es el avance de una tarea en porcentaje: 100 si está terminada, si no la parte
+// hecha de su checklist
+func taskCompletion(status string, total, done int) float64 {
+	if status == taskStatusDone {
+		return 100
+	}
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(done)/float64(total)*10000) / 100
+}
+
+// loadTask lee una tarea del proyecto con su checklist
+func loadTask(projectID, taskID int) (*models.ProjectTask, error) {
+	row, err := dataBase.SelectRow(taskColumns+" "+projectTaskListSpec.from+" WHERE t.project_id = ? AND t.id = ?", projectID, taskID)
+	if err != nil {
+		return nil, err
+	}
+	t, err := scanTask(row.Scan)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := dataBase.Select("SELECT id, task_id, text, position, done, done_by, done_at, created_at FROM project_task_items WHERE task_id = ? ORDER BY position, id", t.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	t.Items = []models.ProjectTaskItem{}
+	for rows.Next() {
+		var item models.ProjectTaskItem
+		if err := rows.Scan(&item.ID, &item.TaskID, &item.Text, &item.Position, &item.Done, &item.DoneBy, &item.DoneAt, &item.CreatedAt); err != nil {
+			return nil, err
+		}
+		t.Items = append(t.Items, item)
+	}
+	return &t, rows.Err()
+}
+
+// loadTaskSummaries calcula el avance de las tareas de los proyectos dados. Las tareas canceladas
+// no cuentan; los proyectos sin tareas quedan con todo en cero.
+func loadTaskSummaries(projectIDs []int) (map[int]*models.ProjectTaskSummary, error) {
+	summaries := map[int]*models.ProjectTaskSummary{}
+	if len(projectIDs) == 0 {
+		return summaries, nil
+	}
+	placeholders := make([]string, len(projectIDs))
+	args := []interface{}{taskStatusCancelled}
+	for i, id := range projectIDs {
+		placeholders[i] = "?"
+		args = append(args, id)
+		summaries[id] = &models.ProjectTaskSummary{}
+	}
+
+	rows, err := dataBase.Select("SELECT t.project_id, t.status, "+
+		"(SELECT COUNT(*) FROM project_task_items i WHERE i.task_id = t.id), "+
+		"(SELECT COUNT(*) FROM project_task_items i WHERE i.task_id = t.id AND i.done = 1) "+
+		"FROM project_tasks t WHERE t.status <> ? AND t.project_id IN ("+strings.Join(placeholders, ", ")+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var projectID, total, done int
+		var status string
+		if err := rows.Scan(&projectID, &status, &total, &done); err != nil {
+			return nil, err
+		}
+		s := summaries[projectID]
+		s.Total++
+		if status == taskStatusDone {
+			s.Done++
+		}
+		// Mientras se suma, Completion acumula el avance de cada tarea
+		s.Completion += taskCompletion(status, total, done)
+	}
+	for _, s := range summaries {
+		if s.Total > 0 {
+			s.Completion = math.Round(s.Completion/float64(s.Total)*100) / 100
+		}
+	}
+	return summaries, rows.Err()
+}
+
+// taskInput son los campos editables de una tarea
+type taskInput struct {
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	AssigneeID  *int     `json:"assignee_id"`
+	DueDate     *string  `json:"due_date"`
+	Priority    string   `json:"priority"`
+	Status      string   `json:"status"`
+	Items       []string `json:"items"` // Checklist inicial, solo al crear
+}
+
+// validate completa los valores por defecto y revisa los campos. Devuelve el código HTTP y el
+// error si algo no es válido.
+func (in *taskInput) validate(projectID int) (int, error) {
+	in.Title = strings.TrimSpace(in.Title)
+	if in.Title == "" {
+		return http.StatusBadRequest, fmt.Errorf("title es obligatorio")
+	}
+	if in.Priority == "" {
+		in.Priority = "normal"
+	}
+	if !taskPriorities[in.Priority] {
+		return http.StatusBadRequest, fmt.Errorf("priority debe ser low, normal, high o urgent")
+	}
+	if in.Status == "" {
+		in.Status = taskStatusPending
+	}
+	if !taskStatuses[in.Status] {
+		return http.StatusBadRequest, fmt.Errorf("status debe ser pending, in_progress, done o cancelled")
+	}
+	if in.DueDate != nil {
+		if *in.DueDate == "" {
+			in.DueDate = nil
+		} else if _, err := time.Parse(dateLayout, *in.DueDate); err != nil {
+			return http.StatusBadRequest, fmt.Errorf("due_date debe tener el formato YYYY-MM-DD")
+		}
+	}
+	for _, item := range in.Items {
+		if strings.TrimSpace(item) == "" {
+			return http.StatusBadRequest, fmt.Errorf("los ítems de la checklist no pueden estar vacíos")
+		}
+	}
+	if in.AssigneeID != nil {
+		return validateTaskAssignee(projectID, *in.AssigneeID)
+	}
+	return 0, nil
+}
+
+// validateTaskAssignee exige que el responsable sea un usuario activo que pueda ver el proyecto:
+// miembro del proyecto o con projects:all
+func validateTaskAssignee(projectID, userID int) (int, error) {
+	user, err := getUserSummary(strconv.Itoa(userID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return http.StatusBadRequest, fmt.Errorf("El responsable no existe")
+		}
+		return http.StatusInternalServerError, err
+	}
+	if user.Status == userStatusInactive {
+		return http.StatusConflict, fmt.Errorf("No se puede asignar una tarea a un usuario desactivado")
+	}
+	if hasPermission(user.Rank, PermProjectsAll) {
+		return 0, nil
+	}
+	if _, err := getProjectMember(projectID, userID); err != nil {
+		if err == sql.ErrNoRows {
+			return http.StatusBadRequest, fmt.Errorf("El responsable no es miembro del proyecto")
+		}
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+// taskLogValue es la tarea tal como se guarda en los logs, sin la checklist
+func taskLogValue(t *models.ProjectTask) string {
+	logged := *t
+	logged.Items = nil
+	value, err := json.Marshal(logged)
+	if err != nil {
+		log.Printf("Error al serializar la tarea: %v", err)
+	}
+	return string(value)
+}
+
+// getProjectTasks lista las tareas del proyecto con el resumen de su checklist
+func getProjectTasks(w http.ResponseWriter, r *http.Request) {
+	projectID, ok := projectIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	q, err := parseListQuery(r, projectTaskListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.Where("t.project_id = ?", projectID)
+	if err := writeTotalCount(w, q); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query, args := q.selectQuery(taskColumns)
+	rows, err := dataBase.Select(query, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	tasks := []models.ProjectTask{}
+	for rows.Next() {
+		t, err := scanTask(rows.Scan)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tasks = append(tasks, t)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tasks)
+}
+
+// createProjectTask crea una tarea, opcionalmente con su checklist inicial
+func createProjectTask(w http.ResponseWriter, r *http.Request) {
+	projectID, ok := projectIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input taskInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if status, err := input.validate(projectID); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	currentUser, err := getCurrentUser(r)
+	if err != nil {
+		http.Error(w, "Error al obtener el usuario actual", http.StatusInternalServerError)
+		return
+	}
+
+	var taskID int
+	err = dataBase.WithTx(r.Context(), func(tx *database.Tx) error {
+		var completedAt interface{}
+		if input.Status == taskStatusDone {
+			completedAt = database.FormatTime(time.Now())
+		}
+		id, err := tx.Insert(false, "INSERT INTO project_tasks (project_id, title, description, assignee_id, due_date, priority, status, created_by, completed_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			projectID, input.Title, input.Description, input.AssigneeID, input.DueDate, input.Priority, input.Status, currentUser.ID, completedAt)
+		if err != nil {
+			return err
+		}
+		taskID = int(id)
+		for i, text := range input.Items {
+			if _, err := tx.Insert(false, "INSERT INTO project_task_items (task_id, text, position) VALUES (?, ?, ?)", taskID, strings.TrimSpace(text), i+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	task, err := loadTask(projectID, taskID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := insertProjectLog(projectID, "create_task", "", taskLogValue(task), r); err != nil {
+		log.Printf("Error al insertar el registro de creación de tarea: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(task)
+}
+
+// taskParam lee el proyecto y el {taskID} de la URL y carga la tarea. Si algo falta, ya escribió
+// el error en la respuesta y devuelve nil.
+func taskParam(w http.ResponseWriter, r *http.Request) *models.ProjectTask {
+	projectID, ok := projectIDParam(w, r)
+	if !ok {
+		return nil
+	}
+	taskID, err := strconv.Atoi(chi.URLParam(r, "taskID"))
+	if err != nil {
+		http.Error(w, "Tarea no encontrada", http.StatusNotFound)
+		return nil
+	}
+	task, err := loadTask(projectID, taskID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Tarea no encontrada", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return nil
+	}
+	return task
+}
+
+func getProjectTask(w http.ResponseWriter, r *http.Request) {
+	task := taskParam(w, r)
+	if task == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(task)
+}
+
+// updateProjectTask reemplaza los campos de la tarea. La checklist se edita en /items.
+func updateProjectTask(w http.ResponseWriter, r *http.Request) {
+	old := taskParam(w, r)
+	if old == nil {
+		return
+	}
+
+	var input taskInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	input.Items = nil
+	// Reasignar a quien ya era responsable no vuelve a exigir que pueda ver el proyecto
+	assignee := input.AssigneeID
+	if assignee != nil && old.AssigneeID != nil && *assignee == *old.AssigneeID {
+		input.AssigneeID = nil
+	}
+	status, err := input.validate(old.ProjectID)
+	input.AssigneeID = assignee
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	// completed_at se marca al pasar a done y se borra al salir de done
+	completedAt := old.CompletedAt
+	if input.Status == taskStatusDone && old.Status != taskStatusDone {
+		now := database.FormatTime(time.Now())
+		completedAt = &now
+	} else if input.Status != taskStatusDone {
+		completedAt = nil
+	}
+	_, err = dataBase.Update(true, "UPDATE project_tasks SET title = ?, description = ?, assignee_id = ?, due_date = ?, priority = ?, status = ?, completed_at = ? WHERE id = ?",
+		input.Title, input.Description, input.AssigneeID, input.DueDate, input.Priority, input.Status, completedAt, old.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	task, err := loadTask(old.ProjectID, old.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := insertProjectLog(task.ProjectID, "update_task", taskLogValue(old), taskLogValue(task), r); err != nil {
+		log.Printf("Error al insertar el registro de actualización de tarea: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(task)
+}
+
+// deleteProjectTask borra la tarea y su checklist
+func deleteProjectTask(w http.ResponseWriter, r *http.Request) {
+	old := taskParam(w, r)
+	if old == nil {
+		return
+	}
+
+	err := dataBase.WithTx(r.Context(), func(tx *database.Tx) error {
+		if _, err := tx.Delete(false, "DELETE FROM project_task_items WHERE task_id = ?", old.ID); err != nil {
+			return err
+		}
+		_, err := tx.Delete(false, "DELETE FROM project_tasks WHERE id = ?", old.ID)
+		return err
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := insertProjectLog(old.ProjectID, "delete_task", taskLogValue(old), "", r); err != nil {
+		log.Printf("Error al insertar el registro de eliminación de tarea: %v", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// addTaskItem agrega un ítem al final de la checklist
+func addTaskItem(w http.ResponseWriter, r *http.Request) {
+	task := taskParam(w, r)
+	if task == nil {
+		return
+	}
+
+	var input struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	input.Text = strings.TrimSpace(input.Text)
+	if input.Text == "" {
+		http.Error(w, "text es obligatorio", http.StatusBadRequest)
+		return
+	}
+
+	position := 1
+	if n := len(task.Items); n > 0 {
+		position = task.Items[n-1].Position + 1
+	}
+	id, err := dataBase.Insert(true, "INSERT INTO project_task_items (task_id, text, position) VALUES (?, ?, ?)", task.ID, input.Text, position)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	updated, err := loadTask(task.ProjectID, task.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var item models.ProjectTaskItem
+	for _, i := range updated.Items {
+		if i.ID == int(id) {
+			item = i
+		}
+	}
+
+	newValue, _ := json.Marshal(item)
+	if err := insertProjectLog(task.ProjectID, "add_task_item", "", string(newValue), r); err != nil {
+		log.Printf("Error al insertar el registro de alta de ítem de tarea: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(item)
+}
+
+// taskItemParam busca el {itemID} de la URL en la checklist de la tarea
+func taskItemParam(w http.ResponseWriter, r *http.Request, task *models.ProjectTask) *models.ProjectTaskItem {
+	itemID, err := strconv.Atoi(chi.URLParam(r, "itemID"))
+	if err == nil {
+		for i := range task.Items {
+			if task.Items[i].ID == itemID {
+				return &task.Items[i]
+			}
+		}
+	}
+	http.Error(w, "Ítem no encontrado", http.StatusNotFound)
+	return nil
+}
+
+// updateTaskItem cambia el texto de un ítem o lo marca como hecho
+func updateTaskItem(w http.ResponseWriter, r *http.Request) {
+	task := taskParam(w, r)
+	if task == nil {
+		return
+	}
+	old := taskItemParam(w, r, task)
+	if old == nil {
+		return
+	}
+
+	var input struct {
+		Text *string `json:"text"`
+		Done *bool   `json:"done"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	item := *old
+	if input.Text != nil {
+		item.Text = strings.TrimSpace(*input.Text)
+		if item.Text == "" {
+			http.Error(w, "text no puede estar vacío", http.StatusBadRequest)
+			return
+		}
+	}
+
+	query := "UPDATE project_task_items SET text = ?"
+	args := []interface{}{item.Text}
+	if input.Done != nil && *input.Done != old.Done {
+		currentUser, err := getCurrentUser(r)
+		if err != nil {
+			http.Error(w, "Error al obtener el usuario actual", http.StatusInternalServerError)
+			return
+		}
+		item.Done = *input.Done
+		if item.Done {
+			query += ", done = 1, done_by = ?, done_at = ?"
+			args = append(args, currentUser.ID, database.FormatTime(time.Now()))
+		} else {
+			query += ", done = 0, done_by = NULL, done_at = NULL"
+		}
+	}
+	if _, err := dataBase.Update(true, query+" WHERE id = ?", append(args, item.ID)...); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	updated, err := loadTask(task.ProjectID, task.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, i := range updated.Items {
+		if i.ID == item.ID {
+			item = i
+		}
+	}
+
+	oldValue, _ := json.Marshal(old)
+	newValue, _ := json.Marshal(item)
+	if err := insertProjectLog(task.ProjectID, "update_task_item", string(oldValue), string(newValue), r); err != nil {
+		log.Printf("Error al insertar el registro de actualización de ítem de tarea: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(item)
+}
+
+// removeTaskItem quita un ítem de la checklist
+func removeTaskItem(w http.ResponseWriter, r *http.Request) {
+	task := taskParam(w, r)
+	if task == nil {
+		return
+	}
+	old := taskItemParam(w, r, task)
+	if old == nil {
+		return
+	}
+
+	if _, err := dataBase.Delete(true, "DELETE FROM project_task_items WHERE id = ?", old.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	oldValue, _ := json.Marshal(old)
+	if err := insertProjectLog(task.ProjectID, "remove_task_item", string(oldValue), "", r); err != nil {
+		log.Printf("Error al insertar el registro de baja de ítem de tarea: %v", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reorderTaskItems reordena la checklist. item_ids tiene que tener todos los ítems de la tarea,
+// cada uno una vez, en el orden nuevo.
+func reorderTaskItems(w http.ResponseWriter, r *http.Request) {
+	task := taskParam(w, r)
+	if task == nil {
+		return
+	}
+
+	var input struct {
+		ItemIDs []int `json:"item_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pending := map[int]bool{}
+	for _, item := range task.Items {
+		pending[item.ID] = true
+	}
+	for _, id := range input.ItemIDs {
+		if !pending[id] {
+			http.Error(w, "item_ids debe incluir cada ítem de la tarea una sola vez", http.StatusBadRequest)
+			return
+		}
+		delete(pending, id)
+	}
+	if len(pending) > 0 {
+		http.Error(w, "item_ids debe incluir cada ítem de la tarea una sola vez", http.StatusBadRequest)
+		return
+	}
+
+	err := dataBase.WithTx(r.Context(), func(tx *database.Tx) error {
+		for i, id := range input.ItemIDs {
+			if _, err := tx.Update(false, "UPDATE project_task_items SET position = ? WHERE id = ?", i+1, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	oldIDs := make([]int, len(task.Items))
+	for i, item := range task.Items {
+		oldIDs[i] = item.ID
+	}
+	oldValue, _ := json.Marshal(map[string]interface{}{"task_id": task.ID, "item_ids": oldIDs})
+	newValue, _ := json.Marshal(map[string]interface{}{"task_id": task.ID, "item_ids": input.ItemIDs})
+	if err := insertProjectLog(task.ProjectID, "reorder_task_items", string(oldValue), string(newValue), r); err != nil {
+		log.Printf("Error al insertar el registro de orden de la checklist: %v", err)
+	}
+
+	updated, err := loadTask(task.ProjectID, task.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
@@ -5,48 +5,139 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"magpanel/database"
 	"magpanel/models"
 	"net/http"
+	"strconv"
 
 	"github.com/go-chi/chi/v5"
 )
 
+var projectListSpec = listSpec{
+	from: "FROM projects p JOIN categories c ON p.category_id = c.id JOIN project_statuses ps ON p.status_id = ps.id JOIN locations l ON p.location_id = l.id LEFT JOIN users u ON p.author_id = u.id JOIN clients cl ON p.client_id = cl.id",
+	sortable: map[string]string{
+		"id":              "p.id",
+		"code":            "p.code",
+		"name":            "p.name",
+		"category_id":     "p.category_id",
+		"client_id":       "p.client_id",
+		"status_id":       "p.status_id",
+		"created_at":      "p.created_at",
+		"updated_at":      "p.updated_at",
+		"start_date":      "p.start_date",
+		"target_end_date": "p.target_end_date",
+		"client_name":     "cl.name",
+		"category_name":   "c.name",
+		"status_name":     "ps.status_name",
+		"author_name":     "u.name",
+	},
+	filters: map[string]listFilter{
+		"status_id":      {"p.status_id", filterInt},
+		"client_id":      {"p.client_id", filterInt},
+		"category_id":    {"p.category_id", filterInt},
+		"location_id":    {"p.location_id", filterInt},
+		"author_id":      {"p.author_id", filterInt},
+		"code":           {"p.code", filterString},
+		"created_after":  {"p.created_at", filterAfter},
+		"created_before": {"p.created_at", filterBefore},
+		"updated_after":  {"p.updated_at", filterAfter},
+	},
+	defaultOrder: "p.id ASC",
+}
+
+// getProjects lista los proyectos. Con ?mine=true devuelve solo los proyectos a los que está
+// asignado el usuario actual; ?overdue y ?due_within filtran por fecha objetivo.
 func getProjects(w http.ResponseWriter, r *http.Request) {
-	var projects []models.Project
-	// if get GET["limit"] and GET["offset"] values, use them in the query
-	// if GET["limit"] is not provided, get all
-	// if GET["offset"] is not provided, start from 0
-	// if GET["order"] is provided, order by that column
-	query := "SELECT p.id, p.code, p.name, p.description, p.category_id, p.client_id, cl.name, p.status_id, p.location_id, p.author_id, p.created_at, p.updated_at, c.name, ps.status_name, l.name, u.name FROM projects p JOIN categories c ON p.category_id = c.id JOIN project_statuses ps ON p.status_id = ps.id JOIN locations l ON p.location_id = l.id JOIN users u ON p.author_id = u.id JOIN clients cl ON p.client_id = cl.id "
-	if order := r.URL.Query().Get("order"); order != "" {
-		query += "ORDER BY " + order + " "
+	q, err := parseListQuery(r, projectListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	if limit := r.URL.Query().Get("limit"); limit != "" {
-		query += "LIMIT " + limit + " "
+	if err := restrictToVisibleProjects(r, q, "p.id"); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	if offset := r.URL.Query().Get("offset"); offset != "" {
-		query += "OFFSET " + offset
+	if err := applyScheduleFilters(r, q); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if value := r.URL.Query().Get("mine"); value != "" {
+		mine, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "mine debe ser true o false", http.StatusBadRequest)
+			return
+		}
+		if mine {
+			user, err := getCurrentUser(r)
+			if err != nil {
+				http.Error(w, "Error al obtener el usuario actual", http.StatusInternalServerError)
+				return
+			}
+			q.Where("p.id IN (SELECT project_id FROM project_members WHERE user_id = ?)", user.ID)
+		}
+	}
+	if err := writeTotalCount(w, q); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	// get also the categoryName, statusName, locationName and authorName with a JOIN, c.name and client_id and name
-	rows, err := dataBase.Select(query)
-
+	projects, err := queryProjects(q, false)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(projects)
+}
+
+// queryProjects lee una página del listado de proyectos. Con memberRole la consulta debe incluir
+// project_members como pm y se devuelve el rol del usuario en cada proyecto.
+func queryProjects(q *listQuery, memberRole bool) ([]models.Project, error) {
+	// get also the categoryName, statusName, locationName and authorName with a JOIN, c.name and client_id and name
+	columns := "SELECT p.id, p.code, p.name, p.description, p.category_id, p.client_id, cl.name, p.status_id, p.location_id, p.author_id, p.created_at, p.updated_at, c.name, ps.status_name, l.name, COALESCE(u.name, ''), p.start_date, p.target_end_date, ps.closed"
+	if memberRole {
+		columns += ", pm.role"
+	}
+	query, args := q.selectQuery(columns)
+	rows, err := dataBase.Select(query, args...)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
+	var projects []models.Project
+	now := today()
 	for rows.Next() {
 		var p models.Project
-		if err := rows.Scan(&p.ID, &p.Code, &p.Name, &p.Description, &p.CategoryID, &p.ClientID, &p.ClientName, &p.StatusID, &p.LocationID, &p.AuthorID, &p.CreatedAt, &p.UpdatedAt, &p.CategoryName, &p.StatusName, &p.LocationName, &p.AuthorName); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		var closed bool
+		dest := []interface{}{&p.ID, &p.Code, &p.Name, &p.Description, &p.CategoryID, &p.ClientID, &p.ClientName, &p.StatusID, &p.LocationID, &p.AuthorID, &p.CreatedAt, &p.UpdatedAt, &p.CategoryName, &p.StatusName, &p.LocationName, &p.AuthorName, &p.StartDate, &p.TargetEndDate, &closed}
+		if memberRole {
+			dest = append(dest, &p.MemberRole)
 		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		p.StartDate, p.TargetEndDate = dateOnly(p.StartDate), dateOnly(p.TargetEndDate)
+		p.Overdue = projectOverdue(p.TargetEndDate, closed, now)
 		projects = append(projects, p)
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(projects)
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Avance de las tareas de cada proyecto de la página
+	ids := make([]int, len(projects))
+	for i, p := range projects {
+		ids[i] = p.ID
+	}
+	summaries, err := loadTaskSummaries(ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range projects {
+		projects[i].Tasks = summaries[projects[i].ID]
+	}
+	return projects, nil
 }
 
 func createProject(w http.ResponseWriter, r *http.Request) {
@@ -55,6 +146,10 @@ func createProject(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if err := validateProjectDates(&p); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	currentUser, err := getCurrentUser(r)
 
 	if err != nil {
@@ -64,43 +159,41 @@ func createProject(w http.ResponseWriter, r *http.Request) {
 
 	p.AuthorID = currentUser.ID
 
-	// obtain the code from the client_id
-	var clientCode string
-	row, err := dataBase.SelectRow("SELECT code FROM clients WHERE id = ?", p.ClientID)
+	// El código sale de la plantilla de la categoría con los códigos de categoría y cliente
+	template, codeCtx, err := loadCodeContext(p.CategoryID, p.ClientID, projectYear())
 	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "Cliente no encontrado", http.StatusNotFound)
+		if err == errCategoryNotFound || err == errClientNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
 		} else {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 		return
 	}
-	row.Scan(&clientCode)
-
-	lastInsertID, err := dataBase.Insert(true, "INSERT INTO projects (name, description, category_id, status_id, location_id, author_id, client_id) VALUES (?, ?, ?, ?, ?, ?, ?)", p.Name, p.Description, p.CategoryID, p.StatusID, p.LocationID, p.AuthorID, p.ClientID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	p.ID = int(lastInsertID)
 
-	var categoryCode string
-	row, err = dataBase.SelectRow("SELECT code FROM categories WHERE id = ?", p.CategoryID)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "Categoría no encontrada", http.StatusNotFound)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	// El insert y la reserva del número van en la misma transacción para no dejar proyectos sin
+	// código ni números salteados. Si otro proyecto tomó el mismo código entre la reserva y el
+	// insert, el índice único lo rechaza y se vuelve a reservar.
+	for attempt := 1; ; attempt++ {
+		err = dataBase.WithTx(r.Context(), func(tx *database.Tx) error {
+			code, err := allocateProjectCode(tx, template, codeCtx)
+			if err != nil {
+				return err
+			}
+			p.Code = code
+			lastInsertID, err := tx.Insert(true, "INSERT INTO projects (code, name, description, category_id, status_id, location_id, author_id, client_id, start_date, target_end_date) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", p.Code, p.Name, p.Description, p.CategoryID, p.StatusID, p.LocationID, p.AuthorID, p.ClientID, p.StartDate, p.TargetEndDate)
+			if err != nil {
+				return err
+			}
+			p.ID = int(lastInsertID)
+
+			// El estado inicial también queda en el historial
+			_, err = recordStatusChange(tx, p.ID, 0, p.StatusID, p.AuthorID, "")
+			return err
+		})
+		if err == nil || !database.IsDuplicateKey(err) || attempt == maxProjectCodeAttempts {
+			break
 		}
-		return
 	}
-	row.Scan(&categoryCode)
-
-	p.Code = categoryCode + "-" + clientCode + "-" + fmt.Sprintf("%04d", p.ID)
-
-	// save the code
-	_, err = dataBase.Update(true, "UPDATE projects SET code = ? WHERE id = ?", p.Code, p.ID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -114,7 +207,7 @@ func createProject(w http.ResponseWriter, r *http.Request) {
 	newValue := string(newValueBytes)
 
 	// Registro del evento de creación
-	if err := insertLog("create_project", "", newValue, r); err != nil {
+	if err := insertProjectLog(p.ID, "create_project", "", newValue, r); err != nil {
 		// Manejar el error de inserción del log aquí
 		log.Printf("Error al insertar el registro de creación de proyecto: %v", err)
 	}
@@ -124,6 +217,51 @@ func createProject(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(p)
 }
 
+// previewProjectCodeHandler muestra el código que recibiría el próximo proyecto de una categoría y
+// cliente, sin reservarlo. Con ?template= se prueba una plantilla antes de guardarla en la categoría.
+func previewProjectCodeHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	categoryID, err := strconv.Atoi(query.Get("category_id"))
+	if err != nil {
+		http.Error(w, "category_id es obligatorio", http.StatusBadRequest)
+		return
+	}
+	clientID, err := strconv.Atoi(query.Get("client_id"))
+	if err != nil {
+		http.Error(w, "client_id es obligatorio", http.StatusBadRequest)
+		return
+	}
+
+	template, codeCtx, err := loadCodeContext(categoryID, clientID, projectYear())
+	if err != nil {
+		if err == errCategoryNotFound || err == errClientNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if query.Has("template") {
+		if template, err = parseCodeTemplate(query.Get("template")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	code, seq, err := previewProjectCode(template, codeCtx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"template": template.raw,
+		"code":     code,
+		"sequence": seq,
+	})
+}
+
 func getProjectByID(w http.ResponseWriter, r *http.Request) {
 	projectID := chi.URLParam(r, "id")
 
@@ -132,7 +270,7 @@ func getProjectByID(w http.ResponseWriter, r *http.Request) {
 	// Change the query for the project to include the status, author, location and category names
 
 	// also return the category, status, location, author NAME and ID, both of them
-	rows, err := dataBase.SelectRow("SELECT p.id, p.code, p.name, p.description, c.id, c.name, ps.id, ps.status_name, l.id, l.name, l.lat, l.lng, u.id, u.name, p.client_id, cl.name, p.created_at, p.updated_at FROM projects p JOIN categories c ON p.category_id = c.id JOIN project_statuses ps ON p.status_id = ps.id JOIN locations l ON p.location_id = l.id JOIN users u ON p.author_id = u.id JOIN clients cl ON p.client_id = cl.id WHERE p.id = ?", projectID)
+	rows, err := dataBase.SelectRow("SELECT p.id, p.code, p.name, p.description, c.id, c.name, ps.id, ps.status_name, l.id, l.name, l.lat, l.lng, p.author_id, COALESCE(u.name, ''), p.client_id, cl.name, p.created_at, p.updated_at, p.start_date, p.target_end_date, ps.closed FROM projects p JOIN categories c ON p.category_id = c.id JOIN project_statuses ps ON p.status_id = ps.id JOIN locations l ON p.location_id = l.id LEFT JOIN users u ON p.author_id = u.id JOIN clients cl ON p.client_id = cl.id WHERE p.id = ?", projectID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Proyecto no encontrado", http.StatusNotFound)
@@ -141,7 +279,17 @@ func getProjectByID(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	rows.Scan(&p.ID, &p.Code, &p.Name, &p.Description, &p.CategoryID, &p.CategoryName, &p.StatusID, &p.StatusName, &p.LocationID, &p.LocationName, &p.LocationLat, &p.LocationLng, &p.AuthorID, &p.AuthorName, &p.ClientID, &p.ClientName, &p.CreatedAt, &p.UpdatedAt)
+	var closed bool
+	rows.Scan(&p.ID, &p.Code, &p.Name, &p.Description, &p.CategoryID, &p.CategoryName, &p.StatusID, &p.StatusName, &p.LocationID, &p.LocationName, &p.LocationLat, &p.LocationLng, &p.AuthorID, &p.AuthorName, &p.ClientID, &p.ClientName, &p.CreatedAt, &p.UpdatedAt, &p.StartDate, &p.TargetEndDate, &closed)
+	p.StartDate, p.TargetEndDate = dateOnly(p.StartDate), dateOnly(p.TargetEndDate)
+	p.Overdue = projectOverdue(p.TargetEndDate, closed, today())
+
+	summaries, err := loadTaskSummaries([]int{p.ID})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p.Tasks = summaries[p.ID]
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(p)
@@ -154,9 +302,13 @@ func updateProject(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if err := validateProjectDates(&p); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	var old models.Project
-	rows, err := dataBase.SelectRow("SELECT id, name, description, category_id, status_id, location_id, author_id, client_id, created_at, updated_at FROM projects WHERE id = ?", projectID)
+	rows, err := dataBase.SelectRow("SELECT id, name, description, category_id, status_id, location_id, author_id, client_id, start_date, target_end_date, created_at, updated_at FROM projects WHERE id = ?", projectID)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -166,7 +318,14 @@ func updateProject(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	rows.Scan(&old.ID, &old.Name, &old.Description, &old.CategoryID, &old.StatusID, &old.LocationID, &old.AuthorID, &old.ClientID, &old.CreatedAt, &old.UpdatedAt)
+	if err := rows.Scan(&old.ID, &old.Name, &old.Description, &old.CategoryID, &old.StatusID, &old.LocationID, &old.AuthorID, &old.ClientID, &old.StartDate, &old.TargetEndDate, &old.CreatedAt, &old.UpdatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Proyecto no encontrado", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
 
 	oldValueBytes, err := json.Marshal(old)
 	if err != nil {
@@ -175,9 +334,51 @@ func updateProject(w http.ResponseWriter, r *http.Request) {
 	}
 	oldValue := string(oldValueBytes)
 
-	_, err = dataBase.Update(true, "UPDATE projects SET name = ?, description = ?, category_id = ?, status_id = ?, location_id = ?, author_id = ?, client_id = ? WHERE id = ?", p.Name, p.Description, p.CategoryID, p.StatusID, p.LocationID, p.AuthorID, p.ClientID, projectID)
+	// Un cambio de estado o de categoría por PUT respeta el flujo igual que /transition, sin
+	// comentario. Al cambiar de categoría se aplican las reglas de la nueva: si tiene reglas, ninguna
+	// sale de un estado de otra categoría, así que no se puede saltear el flujo pasando por otra.
+	var currentUser *models.User
+	statusChanged := p.StatusID != old.StatusID
+	if statusChanged || p.CategoryID != old.CategoryID {
+		currentUser, err = getCurrentUser(r)
+		if err != nil {
+			http.Error(w, "Error al obtener el usuario actual", http.StatusInternalServerError)
+			return
+		}
+		if err := checkStatusTransition(currentUser, p.CategoryID, old.StatusID, p.StatusID, ""); err != nil {
+			writeStatusTransitionError(w, err)
+			return
+		}
+	}
+
+	err = dataBase.WithTx(r.Context(), func(tx *database.Tx) error {
+		// Igual que en /transition, solo se aplica si nadie cambió el estado desde que se validó
+		updated, err := tx.Update(true, "UPDATE projects SET name = ?, description = ?, category_id = ?, status_id = ?, location_id = ?, author_id = ?, client_id = ?, start_date = ?, target_end_date = ? WHERE id = ? AND status_id = ?", p.Name, p.Description, p.CategoryID, p.StatusID, p.LocationID, p.AuthorID, p.ClientID, p.StartDate, p.TargetEndDate, old.ID, old.StatusID)
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			// MySQL no cuenta las filas que quedan igual: solo es un conflicto si el estado ya no es el leído
+			var current int
+			row, err := tx.SelectRow("SELECT status_id FROM projects WHERE id = ?", old.ID)
+			if err != nil {
+				return err
+			}
+			if err := row.Scan(&current); err != nil && err != sql.ErrNoRows {
+				return err
+			}
+			if current != old.StatusID {
+				return errConcurrentTransition
+			}
+		}
+		if !statusChanged {
+			return nil
+		}
+		_, err = recordStatusChange(tx, old.ID, old.StatusID, p.StatusID, currentUser.ID, "")
+		return err
+	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStatusTransitionError(w, err)
 		return
 	}
 
@@ -189,7 +390,7 @@ func updateProject(w http.ResponseWriter, r *http.Request) {
 	newValue := string(newValueBytes)
 
 	// Registro del evento de actualización
-	if err := insertLog("update_project", oldValue, newValue, r); err != nil {
+	if err := insertProjectLog(old.ID, "update_project", oldValue, newValue, r); err != nil {
 		// Manejar el error de inserción del log aquí
 		log.Printf("Error al insertar el registro de actualización de proyecto: %v", err)
 	}
@@ -220,7 +421,25 @@ func deleteProject(w http.ResponseWriter, r *http.Request) {
 	}
 	oldValue := string(oldValueBytes)
 
-	_, err = dataBase.Delete(true, "DELETE FROM projects WHERE id = ?", projectID)
+	err = dataBase.WithTx(r.Context(), func(tx *database.Tx) error {
+		if _, err := tx.Delete(false, "DELETE FROM project_status_history WHERE project_id = ?", projectID); err != nil {
+			return err
+		}
+		if _, err := tx.Delete(false, "DELETE FROM project_members WHERE project_id = ?", projectID); err != nil {
+			return err
+		}
+		if _, err := tx.Delete(false, "DELETE FROM project_task_items WHERE task_id IN (SELECT id FROM project_tasks WHERE project_id = ?)", projectID); err != nil {
+			return err
+		}
+		if _, err := tx.Delete(false, "DELETE FROM project_tasks WHERE project_id = ?", projectID); err != nil {
+			return err
+		}
+		if _, err := tx.Delete(false, "DELETE FROM project_milestones WHERE project_id = ?", projectID); err != nil {
+			return err
+		}
+		_, err := tx.Delete(true, "DELETE FROM projects WHERE id = ?", projectID)
+		return err
+	})
 
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -228,7 +447,7 @@ func deleteProject(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Registro del evento de eliminación
-	if err := insertLog("delete_project", oldValue, "", r); err != nil {
+	if err := insertProjectLog(old.ID, "delete_project", oldValue, "", r); err != nil {
 		// Manejar el error de inserción del log aquí
 		log.Printf("Error al insertar el registro de eliminación de proyecto: %v", err)
 	}
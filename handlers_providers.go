@@ -11,9 +11,38 @@ import (
 	"github.com/go-chi/chi/v5"
 )
 
+var providerListSpec = listSpec{
+	from: "FROM providers",
+	sortable: map[string]string{
+		"id":          "id",
+		"code":        "code",
+		"name":        "name",
+		"city":        "city",
+		"category_id": "category_id",
+		"company":     "company",
+		"created_at":  "created_at",
+	},
+	filters: map[string]listFilter{
+		"code":        {"code", filterString},
+		"city":        {"city", filterString},
+		"category_id": {"category_id", filterInt},
+	},
+	defaultOrder: "id ASC",
+}
+
 func getProviders(w http.ResponseWriter, r *http.Request) {
+	q, err := parseListQuery(r, providerListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := writeTotalCount(w, q); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	rows, err := dataBase.Select("SELECT id, code, name, address, phone, email, web, city, category_id, company FROM providers")
+	query, args := q.selectQuery("SELECT id, code, name, address, phone, email, web, city, category_id, company")
+	rows, err := dataBase.Select(query, args...)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -5,41 +5,79 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"magpanel/database"
 	"magpanel/models"
 	"net/http"
-	"strings"
 
 	"github.com/go-chi/chi/v5"
 )
 
+var reportListSpec = listSpec{
+	from: "FROM reports r JOIN categories c ON r.category_id = c.id LEFT JOIN users u ON r.author_id = u.id",
+	sortable: map[string]string{
+		"id":          "r.id",
+		"project_id":  "r.project_id",
+		"category_id": "r.category_id",
+		"author_id":   "r.author_id",
+		"created_at":  "r.created_at",
+		"updated_at":  "r.updated_at",
+	},
+	filters: map[string]listFilter{
+		"project_id":     {"r.project_id", filterInt},
+		"category_id":    {"r.category_id", filterInt},
+		"author_id":      {"r.author_id", filterInt},
+		"created_after":  {"r.created_at", filterAfter},
+		"created_before": {"r.created_at", filterBefore},
+	},
+	defaultOrder: "r.id ASC",
+}
+
+// reportDataListSpec es el listado extendido de /reports/all, con los datos del proyecto
+var reportDataListSpec = listSpec{
+	from: "FROM reports r LEFT JOIN projects p ON r.project_id = p.id LEFT JOIN categories c ON r.category_id = c.id LEFT JOIN users u ON r.author_id = u.id",
+	sortable: map[string]string{
+		"id":            "r.id",
+		"project_id":    "r.project_id",
+		"category_id":   "r.category_id",
+		"author_id":     "r.author_id",
+		"created_at":    "r.created_at",
+		"updated_at":    "r.updated_at",
+		"project_name":  "p.name",
+		"project_code":  "p.code",
+		"category_name": "c.name",
+		"author_name":   "u.name",
+	},
+	filters: map[string]listFilter{
+		"project_id":     {"r.project_id", filterInt},
+		"category_id":    {"r.category_id", filterInt},
+		"author_id":      {"r.author_id", filterInt},
+		"client_id":      {"p.client_id", filterInt},
+		"status_id":      {"p.status_id", filterInt},
+		"created_after":  {"r.created_at", filterAfter},
+		"created_before": {"r.created_at", filterBefore},
+	},
+	defaultOrder: "r.id ASC",
+}
+
 func getReports(w http.ResponseWriter, r *http.Request) {
 	var reports []models.Report
 
-	query := "SELECT r.id, r.project_id, r.category_id, r.fields, r.author_id, r.created_at, r.updated_at, c.name, u.name FROM reports r JOIN categories c ON r.category_id = c.id JOIN users u ON r.author_id = u.id "
-	if order := r.URL.Query().Get("order"); order != "" {
-		// order is like "created_at,desc", we need to check if it has a comma
-		if len(order) > 0 {
-			orderParts := strings.Split(order, ",")
-			if len(orderParts) == 2 {
-				// and we need to sanitize the input
-				if orderParts[1] == "asc" || orderParts[1] == "desc" {
-					query += "ORDER BY " + orderParts[0] + " " + orderParts[1] + " "
-				} else {
-					http.Error(w, "Invalid order direction", http.StatusBadRequest)
-					return
-				}
-			}
-		}
-
+	q, err := parseListQuery(r, reportListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	if limit := r.URL.Query().Get("limit"); limit != "" {
-		query += "LIMIT " + limit + " "
+	if err := restrictToVisibleProjects(r, q, "r.project_id"); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	if offset := r.URL.Query().Get("offset"); offset != "" {
-		query += "OFFSET " + offset
+	if err := writeTotalCount(w, q); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	rows, err := dataBase.Select(query)
+	query, args := q.selectQuery("SELECT r.id, r.project_id, r.category_id, r.fields, r.author_id, r.created_at, r.updated_at, c.name, COALESCE(u.name, '')")
+	rows, err := dataBase.Select(query, args...)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -63,33 +101,20 @@ func getReportsByProject(w http.ResponseWriter, r *http.Request) {
 	projectID := chi.URLParam(r, "id")
 
 	var reports []models.Report
-	query := `
-	SELECT r.id, r.project_id, r.category_id, r.fields, r.author_id, r.created_at, r.updated_at, 
-		   c.name, u.name 
-	FROM reports r 
-	JOIN categories c ON r.category_id = c.id 
-	JOIN users u ON r.author_id = u.id 
-	WHERE r.project_id = ? 
-	`
-	if order := r.URL.Query().Get("order"); order != "" {
-		// order is like "created_at,desc", we need to check if it has a comma
-
-		if len(order) > 0 {
-			orderParts := strings.Split(order, ",")
-			if len(orderParts) == 2 {
-				// and we need to sanitize the input
-				if orderParts[1] == "asc" || orderParts[1] == "desc" {
-					query += "ORDER BY " + orderParts[0] + " " + orderParts[1] + " "
-				} else {
-					http.Error(w, "Invalid order direction", http.StatusBadRequest)
-					return
-				}
-			}
-		}
 
+	q, err := parseListQuery(r, reportListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.Where("r.project_id = ?", projectID)
+	if err := writeTotalCount(w, q); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	rows, err := dataBase.Select(query, projectID)
+	query, args := q.selectQuery("SELECT r.id, r.project_id, r.category_id, r.fields, r.author_id, r.created_at, r.updated_at, c.name, COALESCE(u.name, '')")
+	rows, err := dataBase.Select(query, args...)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -125,22 +150,34 @@ func createReport(w http.ResponseWriter, r *http.Request) {
 
 	report.AuthorID = currentUser.ID
 
-	lastInsertID, err := dataBase.Insert(true, "INSERT INTO reports (project_id, category_id, fields, author_id) VALUES (?, ?, ?, ?)", report.ProjectID, report.CategoryID, report.Fields, report.AuthorID)
-	if err != nil {
+	// Solo se cargan reportes en proyectos que el usuario puede ver
+	if visible, err := projectVisible(r, report.ProjectID); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
+	} else if !visible {
+		http.Error(w, "Proyecto no encontrado", http.StatusNotFound)
+		return
 	}
 
-	report.ID = int(lastInsertID)
+	// El reporte y la fecha de actualización del proyecto se escriben juntos
+	err = dataBase.WithTx(r.Context(), func(tx *database.Tx) error {
+		lastInsertID, err := tx.Insert(true, "INSERT INTO reports (project_id, category_id, fields, author_id) VALUES (?, ?, ?, ?)", report.ProjectID, report.CategoryID, report.Fields, report.AuthorID)
+		if err != nil {
+			return err
+		}
+		report.ID = int(lastInsertID)
 
-	if err := insertLog("create_report", "", string(report.Fields), r); err != nil {
-		log.Printf("Error al insertar el registro de creación de reporte: %v", err)
+		// update the updated_at field in the projects table
+		_, err = tx.Update(false, "UPDATE projects SET updated_at = NOW() WHERE id = ?", report.ProjectID)
+		return err
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	// trigger a function for update the updated_at field in the projects table
-	_, err = dataBase.Update(false, "UPDATE projects SET updated_at = NOW() WHERE id = ?", report.ProjectID)
-	if err != nil {
-		log.Printf("Error al actualizar la fecha de actualización del proyecto: %v", err)
+	if err := insertProjectLog(report.ProjectID, "create_report", "", string(report.Fields), r); err != nil {
+		log.Printf("Error al insertar el registro de creación de reporte: %v", err)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -150,37 +187,23 @@ func createReport(w http.ResponseWriter, r *http.Request) {
 func getReportsData(w http.ResponseWriter, r *http.Request) {
 	var reports []models.Report
 
-	query := `SELECT r.id, r.project_id, r.category_id, r.fields, r.author_id, r.created_at, r.updated_at, 
-		p.name AS project_name, p.code AS project_code, c.name AS category_name, u.name AS author_name
-		FROM reports r
-		LEFT JOIN projects p ON r.project_id = p.id
-		LEFT JOIN categories c ON r.category_id = c.id
-		LEFT JOIN users u ON r.author_id = u.id`
-
-	if order := r.URL.Query().Get("order"); order != "" {
-		// order is like "created_at,desc", we need to check if it has a comma
-		if len(order) > 0 {
-			orderParts := strings.Split(order, ",")
-			if len(orderParts) == 2 {
-				// and we need to sanitize the input
-				if orderParts[1] == "asc" || orderParts[1] == "desc" {
-					query += " ORDER BY " + orderParts[0] + " " + orderParts[1] + " "
-				} else {
-					http.Error(w, "Invalid order direction", http.StatusBadRequest)
-					return
-				}
-			}
-		}
-
+	q, err := parseListQuery(r, reportDataListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	if limit := r.URL.Query().Get("limit"); limit != "" {
-		query += " LIMIT " + limit + " "
+	if err := restrictToVisibleProjects(r, q, "r.project_id"); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	if offset := r.URL.Query().Get("offset"); offset != "" {
-		query += " OFFSET " + offset
+	if err := writeTotalCount(w, q); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	rows, err := dataBase.Select(query)
+	query, args := q.selectQuery(`SELECT r.id, r.project_id, r.category_id, r.fields, r.author_id, r.created_at, r.updated_at,
+		p.name AS project_name, p.code AS project_code, c.name AS category_name, COALESCE(u.name, '') AS author_name`)
+	rows, err := dataBase.Select(query, args...)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -200,21 +223,39 @@ func getReportsData(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(reports)
 }
 
+// reportParam lee el reporte de la URL. En /projects/{id}/reports/{reportID} el reporte es
+// {reportID} y tiene que pertenecer al proyecto {id}; en /reports/{id} projectID queda vacío.
+func reportParam(r *http.Request) (reportID, projectID string) {
+	if reportID = chi.URLParam(r, "reportID"); reportID != "" {
+		return reportID, chi.URLParam(r, "id")
+	}
+	return chi.URLParam(r, "id"), ""
+}
+
+// reportCondition es el WHERE que busca el reporte, limitado al proyecto si la ruta lo indica
+func reportCondition(column, reportID, projectID string) (string, []interface{}) {
+	if projectID != "" {
+		return column + "id = ? AND " + column + "project_id = ?", []interface{}{reportID, projectID}
+	}
+	return column + "id = ?", []interface{}{reportID}
+}
+
 func getReportByID(w http.ResponseWriter, r *http.Request) {
-	reportID := chi.URLParam(r, "id")
+	reportID, projectID := reportParam(r)
 
 	var report models.Report
 
 	query := `
         SELECT r.id, r.project_id, r.category_id, r.fields, r.author_id, r.created_at, r.updated_at,
-		p.name AS project_name, p.code AS project_code, c.name AS category_name, u.name AS author_name
+		p.name AS project_name, p.code AS project_code, c.name AS category_name, COALESCE(u.name, '') AS author_name
         FROM reports r
         LEFT JOIN projects p ON r.project_id = p.id
         LEFT JOIN categories c ON r.category_id = c.id
         LEFT JOIN users u ON r.author_id = u.id
-        WHERE r.id = ?`
+        WHERE `
+	condition, args := reportCondition("r.", reportID, projectID)
 
-	row, err := dataBase.SelectRow(query, reportID)
+	row, err := dataBase.SelectRow(query+condition, args...)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -239,30 +280,44 @@ func getReportByID(w http.ResponseWriter, r *http.Request) {
 }
 
 func updateReport(w http.ResponseWriter, r *http.Request) {
-	reportID := chi.URLParam(r, "id")
+	reportID, projectID := reportParam(r)
 	var report models.Report
 	if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	oldReport, err := getReportByIDInternal(reportID)
+	oldReport, err := getReportByIDInternal(reportID, projectID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Reporte no encontrado", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
 		return
 	}
+	if report.ProjectID != oldReport.ProjectID {
+		// Tampoco se puede mover el reporte a un proyecto que el usuario no ve
+		if visible, err := projectVisible(r, report.ProjectID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else if !visible {
+			http.Error(w, "Proyecto no encontrado", http.StatusNotFound)
+			return
+		}
+	}
 	oldValueBytes, err := json.Marshal(oldReport)
 	if err != nil {
 		log.Printf("Error al serializar reporte antiguo: %v", err)
 	}
 
-	_, err = dataBase.Update(true, "UPDATE reports SET project_id = ?, category_id = ?, fields = ?, author_id = ? WHERE id = ?", report.ProjectID, report.CategoryID, report.Fields, report.AuthorID, reportID)
+	_, err = dataBase.Update(true, "UPDATE reports SET project_id = ?, category_id = ?, fields = ?, author_id = ? WHERE id = ?", report.ProjectID, report.CategoryID, report.Fields, report.AuthorID, oldReport.ID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if err := insertLog("update_report", string(oldValueBytes), string(report.Fields), r); err != nil {
+	if err := insertProjectLog(oldReport.ProjectID, "update_report", string(oldValueBytes), string(report.Fields), r); err != nil {
 		log.Printf("Error al insertar el registro de actualización de reporte: %v", err)
 	}
 
@@ -270,9 +325,11 @@ func updateReport(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(fmt.Sprintf("Reporte con ID %s actualizado correctamente", reportID))
 }
 
-func getReportByIDInternal(reportID string) (*models.Report, error) {
+// getReportByIDInternal lee un reporte; si projectID no está vacío, solo dentro de ese proyecto
+func getReportByIDInternal(reportID, projectID string) (*models.Report, error) {
 	var report models.Report
-	row, err := dataBase.SelectRow("SELECT id, project_id, category_id, fields, author_id, created_at, updated_at FROM reports WHERE id = ?", reportID)
+	condition, args := reportCondition("", reportID, projectID)
+	row, err := dataBase.SelectRow("SELECT id, project_id, category_id, fields, author_id, created_at, updated_at FROM reports WHERE "+condition, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -284,11 +341,15 @@ func getReportByIDInternal(reportID string) (*models.Report, error) {
 }
 
 func deleteReport(w http.ResponseWriter, r *http.Request) {
-	reportID := chi.URLParam(r, "id")
+	reportID, projectID := reportParam(r)
 
-	oldReport, err := getReportByIDInternal(reportID)
+	oldReport, err := getReportByIDInternal(reportID, projectID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Reporte no encontrado", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
 		return
 	}
 	oldValueBytes, err := json.Marshal(oldReport)
@@ -296,13 +357,13 @@ func deleteReport(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Error al serializar reporte antiguo: %v", err)
 	}
 
-	_, err = dataBase.Delete(true, "DELETE FROM reports WHERE id = ?", reportID)
+	_, err = dataBase.Delete(true, "DELETE FROM reports WHERE id = ?", oldReport.ID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if err := insertLog("delete_report", string(oldValueBytes), "", r); err != nil {
+	if err := insertProjectLog(oldReport.ProjectID, "delete_report", string(oldValueBytes), "", r); err != nil {
 		log.Printf("Error al insertar el registro de eliminación de reporte: %v", err)
 	}
 
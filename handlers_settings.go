@@ -13,10 +13,33 @@ import (
 	"github.com/mailgun/mailgun-go"
 )
 
+var settingListSpec = listSpec{
+	from: "FROM settings",
+	sortable: map[string]string{
+		"id":  "id",
+		"key": "`key`",
+	},
+	filters: map[string]listFilter{
+		"key": {"`key`", filterString},
+	},
+	defaultOrder: "id ASC",
+}
+
 func getSettings(w http.ResponseWriter, r *http.Request) {
 	var settings []models.Setting
 
-	rows, err := dataBase.Select("SELECT id, `key`, `value`, description FROM settings")
+	q, err := parseListQuery(r, settingListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := writeTotalCount(w, q); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query, args := q.selectQuery("SELECT id, `key`, `value`, description")
+	rows, err := dataBase.Select(query, args...)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -245,24 +268,40 @@ func createFeedback(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(f)
 }
 
+var logListSpec = listSpec{
+	from: "FROM logs LEFT JOIN users ON logs.user_id = users.id",
+	sortable: map[string]string{
+		"id":         "logs.id",
+		"type":       "logs.type",
+		"user_id":    "logs.user_id",
+		"created_at": "logs.created_at",
+	},
+	filters: map[string]listFilter{
+		"type":                 {"logs.type", filterString},
+		"user_id":              {"logs.user_id", filterInt},
+		"impersonated_user_id": {"logs.impersonated_user_id", filterInt},
+		"project_id":           {"logs.project_id", filterInt},
+		"created_after":        {"logs.created_at", filterAfter},
+		"created_before":       {"logs.created_at", filterBefore},
+	},
+	defaultOrder: "logs.created_at DESC",
+}
+
 func getLogs(w http.ResponseWriter, r *http.Request) {
 	var logs []models.Log
 
-	query := "SELECT logs.id, logs.type, logs.old_value, logs.new_value, logs.user_id, logs.created_at, users.username FROM logs JOIN users ON logs.user_id = users.id "
-	if order := r.URL.Query().Get("order"); order != "" {
-		query += "ORDER BY " + order
-	} else {
-		query += "ORDER BY logs.created_at DESC "
-
-	}
-	if limit := r.URL.Query().Get("limit"); limit != "" {
-		query += "LIMIT " + limit + " "
+	q, err := parseListQuery(r, logListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	if offset := r.URL.Query().Get("offset"); offset != "" {
-		query += "OFFSET " + offset
+	if err := writeTotalCount(w, q); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	rows, err := dataBase.Select(query)
+	query, args := q.selectQuery("SELECT logs.id, logs.type, logs.old_value, logs.new_value, logs.user_id, logs.impersonated_user_id, logs.project_id, logs.created_at, COALESCE(users.username, '')")
+	rows, err := dataBase.Select(query, args...)
 
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -272,7 +311,7 @@ func getLogs(w http.ResponseWriter, r *http.Request) {
 
 	for rows.Next() {
 		var l models.Log
-		if err := rows.Scan(&l.ID, &l.Type, &l.OldValue, &l.NewValue, &l.UserID, &l.CreatedAt, &l.Username); err != nil {
+		if err := rows.Scan(&l.ID, &l.Type, &l.OldValue, &l.NewValue, &l.UserID, &l.ImpersonatedUserID, &l.ProjectID, &l.CreatedAt, &l.Username); err != nil {
 
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
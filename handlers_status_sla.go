@@ -0,0 +1,368 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"magpanel/database"
+	"magpanel/models"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// statusStay es un período continuo de un proyecto en un estado, armado a partir de dos entradas
+// consecutivas del historial. Si el proyecto sigue en el estado, left es el momento del cálculo.
+type statusStay struct {
+	projectID int
+	statusID  int
+	entered   time.Time
+	left      time.Time
+	open      bool
+}
+
+func (s statusStay) hours() float64 {
+	return roundHours(s.left.Sub(s.entered))
+}
+
+// roundHours expresa una duración en horas con dos decimales
+func roundHours(d time.Duration) float64 {
+	return math.Round(d.Hours()*100) / 100
+}
+
+// statusInfo son los datos de un estado que necesitan las métricas
+type statusInfo struct {
+	name         string
+	order        int
+	categoryID   int
+	categoryName string
+	slaHours     *int
+}
+
+// breached indica si una estadía de las horas dadas supera el SLA del estado
+func (s statusInfo) breached(hours float64) bool {
+	return s.slaHours != nil && hours > float64(*s.slaHours)
+}
+
+// loadStatusInfo lee todos los estados con su categoría y su SLA
+func loadStatusInfo() (map[int]statusInfo, error) {
+	rows, err := dataBase.Select("SELECT p.id, p.status_name, p.`order`, p.category_id, c.name, p.sla_hours FROM project_statuses p JOIN categories c ON p.category_id = c.id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	statuses := map[int]statusInfo{}
+	for rows.Next() {
+		var id int
+		var s statusInfo
+		if err := rows.Scan(&id, &s.name, &s.order, &s.categoryID, &s.categoryName, &s.slaHours); err != nil {
+			return nil, err
+		}
+		statuses[id] = s
+	}
+	return statuses, rows.Err()
+}
+
+// loadStatusStays arma las estadías a partir del historial de estados que cumple la condición
+// dada. La estadía de cada entrada termina con la siguiente entrada del mismo proyecto; la última
+// sigue abierta hasta now.
+func loadStatusStays(now time.Time, condition string, args ...interface{}) ([]statusStay, error) {
+	query := "SELECT project_id, to_status_id, created_at FROM project_status_history"
+	if condition != "" {
+		query += " WHERE " + condition
+	}
+	rows, err := dataBase.Select(query+" ORDER BY project_id, created_at, id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stays []statusStay
+	for rows.Next() {
+		var s statusStay
+		var createdAt string
+		if err := rows.Scan(&s.projectID, &s.statusID, &createdAt); err != nil {
+			return nil, err
+		}
+		if s.entered, err = database.ParseTime(createdAt); err != nil {
+			return nil, err
+		}
+		if n := len(stays); n > 0 && stays[n-1].projectID == s.projectID {
+			stays[n-1].left = s.entered
+			stays[n-1].open = false
+		}
+		s.left, s.open = now, true
+		stays = append(stays, s)
+	}
+	return stays, rows.Err()
+}
+
+// getProjectStatusDurations devuelve cuánto tiempo pasó el proyecto en cada estado, en el orden
+// en que entró a cada uno por primera vez
+func getProjectStatusDurations(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "id")
+
+	var id, currentStatusID int
+	row, err := dataBase.SelectRow("SELECT id, status_id FROM projects WHERE id = ?", projectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := row.Scan(&id, &currentStatusID); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Proyecto no encontrado", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	statuses, err := loadStatusInfo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	stays, err := loadStatusStays(time.Now().UTC(), "project_id = ?", id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	durations := []models.ProjectStatusDuration{}
+	index := map[int]int{}
+	for _, stay := range stays {
+		i, ok := index[stay.statusID]
+		if !ok {
+			status := statuses[stay.statusID]
+			i = len(durations)
+			index[stay.statusID] = i
+			durations = append(durations, models.ProjectStatusDuration{
+				StatusID:   stay.statusID,
+				StatusName: status.name,
+				SLAHours:   status.slaHours,
+			})
+		}
+		d := &durations[i]
+		hours := stay.hours()
+		d.Visits++
+		d.Hours = math.Round((d.Hours+hours)*100) / 100
+		if hours > d.MaxHours {
+			d.MaxHours = hours
+		}
+		if stay.open && stay.statusID == currentStatusID {
+			d.Current = true
+		}
+		if statuses[stay.statusID].breached(hours) {
+			d.Breached = true
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(durations)
+}
+
+// getSLABreaches lista los proyectos que llevan en su estado actual más horas que el SLA del
+// estado, de mayor a menor atraso. Filtros opcionales: category_id, status_id y client_id. Solo
+// incluye los proyectos que el usuario puede ver.
+func getSLABreaches(w http.ResponseWriter, r *http.Request) {
+	query := "SELECT p.id, p.code, p.name, p.category_id, c.name, p.status_id, s.status_name, s.sla_hours, " +
+		"COALESCE((SELECT MAX(h.created_at) FROM project_status_history h WHERE h.project_id = p.id), p.created_at) " +
+		"FROM projects p JOIN project_statuses s ON p.status_id = s.id JOIN categories c ON p.category_id = c.id " +
+		"WHERE s.sla_hours IS NOT NULL"
+	var args []interface{}
+	for _, filter := range []struct{ param, column string }{
+		{"category_id", "p.category_id"},
+		{"status_id", "p.status_id"},
+		{"client_id", "p.client_id"},
+	} {
+		value := r.URL.Query().Get(filter.param)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%s debe ser un número", filter.param), http.StatusBadRequest)
+			return
+		}
+		query += " AND " + filter.column + " = ?"
+		args = append(args, n)
+	}
+
+	user, err := getCurrentUser(r)
+	if err != nil {
+		http.Error(w, "Error al obtener el usuario actual", http.StatusInternalServerError)
+		return
+	}
+	if !requestHasPermission(r, user, PermProjectsAll) {
+		query += " AND p.id IN (SELECT project_id FROM project_members WHERE user_id = ?)"
+		args = append(args, user.ID)
+	}
+
+	rows, err := dataBase.Select(query, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	now := time.Now().UTC()
+	breaches := []models.ProjectSLABreach{}
+	for rows.Next() {
+		var b models.ProjectSLABreach
+		if err := rows.Scan(&b.ProjectID, &b.Code, &b.Name, &b.CategoryID, &b.CategoryName, &b.StatusID, &b.StatusName, &b.SLAHours, &b.EnteredAt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		entered, err := database.ParseTime(b.EnteredAt)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		b.EnteredAt = database.FormatTime(entered)
+		b.Hours = roundHours(now.Sub(entered))
+		if b.Hours <= float64(b.SLAHours) {
+			continue
+		}
+		b.OverdueHours = math.Round((b.Hours-float64(b.SLAHours))*100) / 100
+		breaches = append(breaches, b)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sort.SliceStable(breaches, func(i, j int) bool { return breaches[i].OverdueHours > breaches[j].OverdueHours })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(breaches)
+}
+
+// getStatusMetrics resume, por categoría y estado, cuánto tiempo pasan los proyectos en cada
+// estado. Cuenta las estadías que empezaron entre from (inclusive) y to (exclusive); las que
+// siguen abiertas se miden hasta ahora. Filtro opcional: category_id.
+func getStatusMetrics(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().UTC()
+	params := r.URL.Query()
+
+	var from, to time.Time
+	var err error
+	if value := params.Get("from"); value != "" {
+		if from, err = parseFilterDate(value); err != nil {
+			http.Error(w, "from debe ser una fecha", http.StatusBadRequest)
+			return
+		}
+	}
+	to = now
+	if value := params.Get("to"); value != "" {
+		if to, err = parseFilterDate(value); err != nil {
+			http.Error(w, "to debe ser una fecha", http.StatusBadRequest)
+			return
+		}
+	}
+	if !from.IsZero() && !to.After(from) {
+		http.Error(w, "to debe ser posterior a from", http.StatusBadRequest)
+		return
+	}
+	var categoryID int
+	if value := params.Get("category_id"); value != "" {
+		if categoryID, err = strconv.Atoi(value); err != nil {
+			http.Error(w, "category_id debe ser un número", http.StatusBadRequest)
+			return
+		}
+	}
+
+	statuses, err := loadStatusInfo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// El límite superior se aplica después: la entrada que cierra una estadía puede ser posterior a to
+	var conditions []string
+	var args []interface{}
+	if !from.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, database.FormatTime(from))
+	}
+	// Sin projects:all solo cuentan los proyectos de los que el usuario es miembro
+	user, err := getCurrentUser(r)
+	if err != nil {
+		http.Error(w, "Error al obtener el usuario actual", http.StatusInternalServerError)
+		return
+	}
+	if !requestHasPermission(r, user, PermProjectsAll) {
+		conditions = append(conditions, "project_id IN (SELECT project_id FROM project_members WHERE user_id = ?)")
+		args = append(args, user.ID)
+	}
+	stays, err := loadStatusStays(now, strings.Join(conditions, " AND "), args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	hoursByStatus := map[int][]float64{}
+	metricsByStatus := map[int]*models.ProjectStatusMetric{}
+	for _, stay := range stays {
+		status, ok := statuses[stay.statusID]
+		if !ok || !stay.entered.Before(to) || (categoryID != 0 && status.categoryID != categoryID) {
+			continue
+		}
+		m := metricsByStatus[stay.statusID]
+		if m == nil {
+			m = &models.ProjectStatusMetric{
+				CategoryID:   status.categoryID,
+				CategoryName: status.categoryName,
+				StatusID:     stay.statusID,
+				StatusName:   status.name,
+				SLAHours:     status.slaHours,
+			}
+			metricsByStatus[stay.statusID] = m
+		}
+		hours := stay.hours()
+		hoursByStatus[stay.statusID] = append(hoursByStatus[stay.statusID], hours)
+		m.Stays++
+		if stay.open {
+			m.OpenStays++
+		}
+		if hours > m.MaxHours {
+			m.MaxHours = hours
+		}
+		if status.breached(hours) {
+			m.Breaches++
+		}
+	}
+
+	metrics := []models.ProjectStatusMetric{}
+	for statusID, m := range metricsByStatus {
+		hours := hoursByStatus[statusID]
+		sort.Float64s(hours)
+		total := 0.0
+		for _, h := range hours {
+			total += h
+		}
+		m.AverageHours = math.Round(total/float64(len(hours))*100) / 100
+		if n := len(hours); n%2 == 1 {
+			m.MedianHours = hours[n/2]
+		} else {
+			m.MedianHours = math.Round((hours[n/2-1]+hours[n/2])/2*100) / 100
+		}
+		metrics = append(metrics, *m)
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].CategoryID != metrics[j].CategoryID {
+			return metrics[i].CategoryID < metrics[j].CategoryID
+		}
+		if a, b := statuses[metrics[i].StatusID].order, statuses[metrics[j].StatusID].order; a != b {
+			return a < b
+		}
+		return metrics[i].StatusID < metrics[j].StatusID
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metrics)
+}
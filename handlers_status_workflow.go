@@ -0,0 +1,502 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"magpanel/database"
+	"magpanel/models"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// statusTransitionError es un cambio de estado rechazado, con el código HTTP que le corresponde
+type statusTransitionError struct {
+	status  int
+	message string
+}
+
+func (e *statusTransitionError) Error() string { return e.message }
+
+// errConcurrentTransition indica que el estado del proyecto cambió mientras se procesaba el pedido
+var errConcurrentTransition = &statusTransitionError{http.StatusConflict, "El estado del proyecto cambió mientras se procesaba el pedido, vuelve a intentarlo"}
+
+var statusTransitionListSpec = listSpec{
+	from: "FROM project_status_transitions t JOIN project_statuses f ON t.from_status_id = f.id JOIN project_statuses s ON t.to_status_id = s.id",
+	sortable: map[string]string{
+		"id":             "t.id",
+		"category_id":    "t.category_id",
+		"from_status_id": "t.from_status_id",
+		"to_status_id":   "t.to_status_id",
+	},
+	filters: map[string]listFilter{
+		"category_id":    {"t.category_id", filterInt},
+		"from_status_id": {"t.from_status_id", filterInt},
+		"to_status_id":   {"t.to_status_id", filterInt},
+	},
+	defaultOrder: "t.category_id ASC, f.`order` ASC, s.`order` ASC",
+}
+
+// getStatusTransitions lista las reglas del flujo de estados
+func getStatusTransitions(w http.ResponseWriter, r *http.Request) {
+	q, err := parseListQuery(r, statusTransitionListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := writeTotalCount(w, q); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query, args := q.selectQuery("SELECT t.id, t.category_id, t.from_status_id, f.status_name, t.to_status_id, s.status_name, t.roles, t.require_comment")
+	rows, err := dataBase.Select(query, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	transitions := []models.ProjectStatusTransition{}
+	for rows.Next() {
+		var t models.ProjectStatusTransition
+		var roles string
+		if err := rows.Scan(&t.ID, &t.CategoryID, &t.FromStatusID, &t.FromStatusName, &t.ToStatusID, &t.ToStatusName, &roles, &t.RequireComment); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		t.Roles = splitRoles(roles)
+		transitions = append(transitions, t)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(transitions)
+}
+
+// createStatusTransition agrega una regla. Los dos estados tienen que ser de la misma categoría;
+// desde que una categoría tiene alguna regla, solo se permiten los cambios de estado definidos.
+func createStatusTransition(w http.ResponseWriter, r *http.Request) {
+	var t models.ProjectStatusTransition
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if t.FromStatusID == t.ToStatusID {
+		http.Error(w, "Los estados de origen y destino deben ser distintos", http.StatusBadRequest)
+		return
+	}
+	roles, err := normalizeRoles(t.Roles)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fromCategory, err := statusCategory(t.FromStatusID)
+	if err != nil {
+		http.Error(w, "Estado de origen no encontrado", http.StatusBadRequest)
+		return
+	}
+	toCategory, err := statusCategory(t.ToStatusID)
+	if err != nil {
+		http.Error(w, "Estado de destino no encontrado", http.StatusBadRequest)
+		return
+	}
+	if fromCategory != toCategory {
+		http.Error(w, "Los dos estados deben pertenecer a la misma categoría", http.StatusBadRequest)
+		return
+	}
+	t.CategoryID = fromCategory
+
+	var existing int
+	row, err := dataBase.SelectRow("SELECT id FROM project_status_transitions WHERE from_status_id = ? AND to_status_id = ?", t.FromStatusID, t.ToStatusID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := row.Scan(&existing); err != sql.ErrNoRows {
+		http.Error(w, "Ya existe una regla para ese cambio de estado", http.StatusConflict)
+		return
+	}
+
+	lastInsertID, err := dataBase.Insert(true, "INSERT INTO project_status_transitions (category_id, from_status_id, to_status_id, roles, require_comment) VALUES (?, ?, ?, ?, ?)",
+		t.CategoryID, t.FromStatusID, t.ToStatusID, strings.Join(roles, ","), t.RequireComment)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	t.ID = int(lastInsertID)
+	t.Roles = roles
+
+	newValueBytes, err := json.Marshal(t)
+	if err != nil {
+		// Manejar error de serialización
+		log.Printf("Error al serializar la regla de estado: %v", err)
+	}
+	// Registro del evento de creación
+	if err := insertLog("create_status_transition", "", string(newValueBytes), r); err != nil {
+		// Manejar el error de inserción del log aquí
+		log.Printf("Error al insertar el registro de creación de regla de estado: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(t)
+}
+
+// updateStatusTransition cambia los roles y la exigencia de comentario de una regla
+func updateStatusTransition(w http.ResponseWriter, r *http.Request) {
+	transitionID := chi.URLParam(r, "id")
+
+	var input struct {
+		Roles          []string `json:"roles"`
+		RequireComment bool     `json:"require_comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	roles, err := normalizeRoles(input.Roles)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	old, err := getStatusTransition(transitionID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Regla de estado no encontrada", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	_, err = dataBase.Update(true, "UPDATE project_status_transitions SET roles = ?, require_comment = ? WHERE id = ?", strings.Join(roles, ","), input.RequireComment, old.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	t := *old
+	t.Roles = roles
+	t.RequireComment = input.RequireComment
+
+	oldValueBytes, _ := json.Marshal(old)
+	newValueBytes, _ := json.Marshal(t)
+	// Registro del evento de actualización
+	if err := insertLog("update_status_transition", string(oldValueBytes), string(newValueBytes), r); err != nil {
+		log.Printf("Error al insertar el registro de actualización de regla de estado: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+// deleteStatusTransition borra una regla del flujo
+func deleteStatusTransition(w http.ResponseWriter, r *http.Request) {
+	old, err := getStatusTransition(chi.URLParam(r, "id"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Regla de estado no encontrada", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if _, err := dataBase.Delete(true, "DELETE FROM project_status_transitions WHERE id = ?", old.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	oldValueBytes, _ := json.Marshal(old)
+	// Registro del evento de eliminación
+	if err := insertLog("delete_status_transition", string(oldValueBytes), "", r); err != nil {
+		log.Printf("Error al insertar el registro de eliminación de regla de estado: %v", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func getStatusTransition(transitionID string) (*models.ProjectStatusTransition, error) {
+	var t models.ProjectStatusTransition
+	var roles string
+	row, err := dataBase.SelectRow("SELECT id, category_id, from_status_id, to_status_id, roles, require_comment FROM project_status_transitions WHERE id = ?", transitionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := row.Scan(&t.ID, &t.CategoryID, &t.FromStatusID, &t.ToStatusID, &roles, &t.RequireComment); err != nil {
+		return nil, err
+	}
+	t.Roles = splitRoles(roles)
+	return &t, nil
+}
+
+// statusCategory devuelve la categoría a la que pertenece un estado
+func statusCategory(statusID int) (int, error) {
+	var categoryID int
+	row, err := dataBase.SelectRow("SELECT category_id FROM project_statuses WHERE id = ?", statusID)
+	if err != nil {
+		return 0, err
+	}
+	err = row.Scan(&categoryID)
+	return categoryID, err
+}
+
+// normalizeRoles valida los nombres de rol de una regla y los deja sin repetir
+func normalizeRoles(roles []string) ([]string, error) {
+	normalized := []string{}
+	seen := map[string]bool{}
+	for _, role := range roles {
+		role = strings.ToLower(strings.TrimSpace(role))
+		if _, ok := rankForRole(role); !ok {
+			return nil, fmt.Errorf("rol desconocido: %q", role)
+		}
+		if !seen[role] {
+			seen[role] = true
+			normalized = append(normalized, role)
+		}
+	}
+	return normalized, nil
+}
+
+func splitRoles(roles string) []string {
+	if roles == "" {
+		return []string{}
+	}
+	return strings.Split(roles, ",")
+}
+
+// checkStatusTransition valida que el usuario pueda pasar un proyecto de la categoría del estado
+// fromStatusID a toStatusID. Las categorías sin reglas permiten cualquier cambio entre sus estados.
+func checkStatusTransition(user *models.User, categoryID, fromStatusID, toStatusID int, comment string) error {
+	toCategory, err := statusCategory(toStatusID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return &statusTransitionError{http.StatusBadRequest, "Estado de destino no encontrado"}
+		}
+		return err
+	}
+	if toCategory != categoryID {
+		return &statusTransitionError{http.StatusBadRequest, "El estado de destino no pertenece a la categoría del proyecto"}
+	}
+	if fromStatusID == toStatusID {
+		return &statusTransitionError{http.StatusBadRequest, "El proyecto ya está en ese estado"}
+	}
+
+	var rules int
+	row, err := dataBase.SelectRow("SELECT COUNT(*) FROM project_status_transitions WHERE category_id = ?", categoryID)
+	if err != nil {
+		return err
+	}
+	if err := row.Scan(&rules); err != nil {
+		return err
+	}
+	if rules == 0 {
+		return nil
+	}
+
+	var roles string
+	var requireComment bool
+	row, err = dataBase.SelectRow("SELECT roles, require_comment FROM project_status_transitions WHERE from_status_id = ? AND to_status_id = ?", fromStatusID, toStatusID)
+	if err != nil {
+		return err
+	}
+	if err := row.Scan(&roles, &requireComment); err != nil {
+		if err == sql.ErrNoRows {
+			return &statusTransitionError{http.StatusConflict, "El flujo de la categoría no permite ese cambio de estado"}
+		}
+		return err
+	}
+	if allowed := splitRoles(roles); len(allowed) > 0 && !containsPermission(allowed, roleForRank(user.Rank)) {
+		return &statusTransitionError{http.StatusForbidden, "Tu rol no puede realizar ese cambio de estado"}
+	}
+	if requireComment && strings.TrimSpace(comment) == "" {
+		return &statusTransitionError{http.StatusBadRequest, "Ese cambio de estado requiere un comentario"}
+	}
+	return nil
+}
+
+// recordStatusChange guarda una entrada del historial de estados dentro de la transacción.
+// fromStatusID 0 indica el estado inicial del proyecto.
+func recordStatusChange(tx *database.Tx, projectID, fromStatusID, toStatusID, userID int, comment string) (*models.ProjectStatusChange, error) {
+	change := &models.ProjectStatusChange{
+		ProjectID:  projectID,
+		ToStatusID: toStatusID,
+		UserID:     userID,
+		Comment:    comment,
+		CreatedAt:  database.FormatTime(time.Now()),
+	}
+	var from interface{}
+	if fromStatusID != 0 {
+		change.FromStatusID = &fromStatusID
+		from = fromStatusID
+	}
+	id, err := tx.Insert(false, "INSERT INTO project_status_history (project_id, from_status_id, to_status_id, user_id, comment, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		projectID, from, toStatusID, userID, comment, change.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	change.ID = int(id)
+	return change, nil
+}
+
+// transitionProject cambia el estado de un proyecto según el flujo de su categoría y lo registra
+// en el historial con el comentario
+func transitionProject(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "id")
+
+	var input struct {
+		StatusID int    `json:"status_id"`
+		Comment  string `json:"comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	input.Comment = strings.TrimSpace(input.Comment)
+
+	user, err := getCurrentUser(r)
+	if err != nil {
+		http.Error(w, "Error al obtener el usuario actual", http.StatusInternalServerError)
+		return
+	}
+
+	var id, categoryID, fromStatusID int
+	row, err := dataBase.SelectRow("SELECT id, category_id, status_id FROM projects WHERE id = ?", projectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := row.Scan(&id, &categoryID, &fromStatusID); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Proyecto no encontrado", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := checkStatusTransition(user, categoryID, fromStatusID, input.StatusID, input.Comment); err != nil {
+		writeStatusTransitionError(w, err)
+		return
+	}
+
+	var change *models.ProjectStatusChange
+	err = dataBase.WithTx(r.Context(), func(tx *database.Tx) error {
+		// Solo se aplica si nadie cambió el estado desde que se validó la regla
+		updated, err := tx.Update(false, "UPDATE projects SET status_id = ?, updated_at = ? WHERE id = ? AND status_id = ?",
+			input.StatusID, database.FormatTime(time.Now()), id, fromStatusID)
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return errConcurrentTransition
+		}
+		change, err = recordStatusChange(tx, id, fromStatusID, input.StatusID, user.ID, input.Comment)
+		return err
+	})
+	if err != nil {
+		writeStatusTransitionError(w, err)
+		return
+	}
+
+	newValueBytes, _ := json.Marshal(change)
+	// Registro del cambio de estado
+	if err := insertProjectLog(id, "transition_project", fmt.Sprintf(`{"project_id":%d,"status_id":%d}`, id, fromStatusID), string(newValueBytes), r); err != nil {
+		log.Printf("Error al insertar el registro de cambio de estado: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(change)
+}
+
+func writeStatusTransitionError(w http.ResponseWriter, err error) {
+	if transitionErr, ok := err.(*statusTransitionError); ok {
+		http.Error(w, transitionErr.message, transitionErr.status)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+var statusHistoryListSpec = listSpec{
+	from: "FROM project_status_history h LEFT JOIN project_statuses f ON h.from_status_id = f.id LEFT JOIN project_statuses s ON h.to_status_id = s.id LEFT JOIN users u ON h.user_id = u.id",
+	sortable: map[string]string{
+		"id":         "h.id",
+		"created_at": "h.created_at",
+	},
+	filters: map[string]listFilter{
+		"user_id":        {"h.user_id", filterInt},
+		"to_status_id":   {"h.to_status_id", filterInt},
+		"created_after":  {"h.created_at", filterAfter},
+		"created_before": {"h.created_at", filterBefore},
+	},
+	defaultOrder: "h.id ASC",
+}
+
+// getProjectStatusHistory devuelve los cambios de estado de un proyecto, del más antiguo al más nuevo
+func getProjectStatusHistory(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "id")
+
+	var id int
+	row, err := dataBase.SelectRow("SELECT id FROM projects WHERE id = ?", projectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := row.Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Proyecto no encontrado", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	q, err := parseListQuery(r, statusHistoryListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.Where("h.project_id = ?", id)
+	if err := writeTotalCount(w, q); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query, args := q.selectQuery("SELECT h.id, h.project_id, h.from_status_id, COALESCE(f.status_name, ''), h.to_status_id, COALESCE(s.status_name, ''), h.user_id, COALESCE(u.username, ''), h.comment, h.created_at")
+	rows, err := dataBase.Select(query, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	history := []models.ProjectStatusChange{}
+	for rows.Next() {
+		var c models.ProjectStatusChange
+		var from sql.NullInt64
+		var createdAt string
+		if err := rows.Scan(&c.ID, &c.ProjectID, &from, &c.FromStatusName, &c.ToStatusID, &c.ToStatusName, &c.UserID, &c.Username, &c.Comment, &createdAt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if from.Valid {
+			fromID := int(from.Int64)
+			c.FromStatusID = &fromID
+		}
+		c.CreatedAt = createdAt
+		if t, err := database.ParseTime(createdAt); err == nil {
+			c.CreatedAt = database.FormatTime(t)
+		}
+		history = append(history, c)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
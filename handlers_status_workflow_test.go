@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// workflowFixture tiene un proyecto en la categoría ruled, cuyo flujo solo deja a un
+// administrador pasar de started a finished, y otra categoría free sin reglas
+type workflowFixture struct {
+	manager, admin    string // access tokens
+	project           int
+	ruled, free       int // categorías
+	started, finished int // estados de ruled
+	open              int // estado de free
+}
+
+func newWorkflowFixture(t *testing.T) *workflowFixture {
+	t.Helper()
+	newTestDatabase(t)
+	managerID := createTestUser(t, "manager", 2)
+	adminID := createTestUser(t, "admin", 3)
+
+	insert := func(query string, args ...interface{}) int {
+		id, err := dataBase.Insert(false, query, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return int(id)
+	}
+	category := func(name string) int {
+		return insert("INSERT INTO categories (`type`, name, fields, filters) VALUES ('project', ?, '[]', '[]')", name)
+	}
+	status := func(name string, categoryID int) int {
+		return insert("INSERT INTO project_statuses (status_name, category_id) VALUES (?, ?)", name, categoryID)
+	}
+
+	f := &workflowFixture{manager: testToken(t, managerID), admin: testToken(t, adminID)}
+	f.ruled, f.free = category("Con flujo"), category("Libre")
+	f.started, f.finished = status("Iniciado", f.ruled), status("Terminado", f.ruled)
+	f.open = status("Abierto", f.free)
+	insert("INSERT INTO project_status_transitions (category_id, from_status_id, to_status_id, roles, require_comment) VALUES (?, ?, ?, 'admin', 0)",
+		f.ruled, f.started, f.finished)
+	f.project = insert("INSERT INTO projects (name, description, category_id, client_id, status_id, location_id, author_id) VALUES ('Obra', '', ?, 0, ?, 0, ?)",
+		f.ruled, f.started, managerID)
+	return f
+}
+
+func (f *workflowFixture) put(t *testing.T, handler http.Handler, token string, categoryID, statusID int) int {
+	t.Helper()
+	body := fmt.Sprintf(`{"name": "Obra", "description": "", "category_id": %d, "status_id": %d}`, categoryID, statusID)
+	return doRequest(t, handler, "PUT", fmt.Sprintf("/projects/%d", f.project), token, body).Code
+}
+
+func (f *workflowFixture) state(t *testing.T) (categoryID, statusID int) {
+	t.Helper()
+	row, _ := dataBase.SelectRow("SELECT category_id, status_id FROM projects WHERE id = ?", f.project)
+	if err := row.Scan(&categoryID, &statusID); err != nil {
+		t.Fatal(err)
+	}
+	return categoryID, statusID
+}
+
+func TestUpdateProjectFollowsStatusWorkflow(t *testing.T) {
+	f := newWorkflowFixture(t)
+	router := initRoutes()
+
+	if code := f.put(t, router, f.manager, f.ruled, f.started); code != http.StatusOK {
+		t.Errorf("PUT sin cambiar el estado = %d, se esperaba 200", code)
+	}
+	if code := f.put(t, router, f.manager, f.ruled, f.finished); code != http.StatusForbidden {
+		t.Errorf("PUT con un cambio de estado reservado a admin = %d, se esperaba 403", code)
+	}
+	if code := f.put(t, router, f.manager, f.free, f.started); code != http.StatusBadRequest {
+		t.Errorf("PUT con un estado de otra categoría = %d, se esperaba 400", code)
+	}
+	if categoryID, statusID := f.state(t); categoryID != f.ruled || statusID != f.started {
+		t.Fatalf("el proyecto quedó en la categoría %d con el estado %d", categoryID, statusID)
+	}
+	if code := f.put(t, router, f.admin, f.ruled, f.finished); code != http.StatusOK {
+		t.Errorf("PUT de admin con el cambio permitido = %d, se esperaba 200", code)
+	}
+}
+
+func TestUpdateProjectCannotSkipWorkflowThroughAnotherCategory(t *testing.T) {
+	f := newWorkflowFixture(t)
+	router := initRoutes()
+
+	// Pasar a una categoría sin reglas está permitido...
+	if code := f.put(t, router, f.manager, f.free, f.open); code != http.StatusOK {
+		t.Fatalf("PUT a la categoría sin reglas = %d, se esperaba 200", code)
+	}
+	// ...pero volver entra a un flujo con reglas desde un estado ajeno
+	if code := f.put(t, router, f.manager, f.ruled, f.finished); code != http.StatusConflict {
+		t.Errorf("PUT de vuelta a la categoría con reglas = %d, se esperaba 409", code)
+	}
+	if categoryID, statusID := f.state(t); categoryID != f.free || statusID != f.open {
+		t.Errorf("el proyecto quedó en la categoría %d con el estado %d", categoryID, statusID)
+	}
+}
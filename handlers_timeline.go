@@ -0,0 +1,227 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"magpanel/models"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Tipos de log que aparecen en la línea de tiempo y el evento que les corresponde. Las altas de
+// proyectos y reportes y los cambios de estado salen de sus propias tablas.
+var timelineLogEvents = []struct{ logType, event string }{
+	{"update_project", "project_updated"},
+	{"update_report", "report_updated"},
+	{"delete_report", "report_deleted"},
+	{"upload_attachment", "attachment_uploaded"},
+	{"remove_attachment", "attachment_removed"},
+	{"add_project_member", "member_added"},
+	{"update_project_member", "member_updated"},
+	{"remove_project_member", "member_removed"},
+	{"create_task", "task_created"},
+	{"update_task", "task_updated"},
+	{"delete_task", "task_deleted"},
+	{"create_milestone", "milestone_created"},
+	{"update_milestone", "milestone_updated"},
+	{"delete_milestone", "milestone_deleted"},
+}
+
+// Campos del proyecto que se comparan en project_updated. status_id queda afuera porque sus
+// cambios ya aparecen como status_changed.
+var timelineProjectFields = []string{"name", "description", "category_id", "client_id", "location_id", "author_id", "start_date", "target_end_date"}
+
+// Campos de un hito que se comparan en milestone_updated
+var timelineMilestoneFields = []string{"name", "due_date", "completed"}
+
+// Campos de una tarea que se comparan en task_updated. La checklist no entra en la línea de tiempo.
+var timelineTaskFields = []string{"title", "description", "assignee_id", "due_date", "priority", "status"}
+
+// timelineListSpec arma el listado de eventos de un proyecto. La línea de tiempo es la unión del
+// historial de estados, los reportes y los logs del proyecto; el id va en cada parte de la unión
+// para que cada tabla use su índice por proyecto.
+func timelineListSpec(projectID int) listSpec {
+	var logCases string
+	var logTypes []string
+	for _, e := range timelineLogEvents {
+		logCases += fmt.Sprintf(" WHEN '%s' THEN '%s'", e.logType, e.event)
+		logTypes = append(logTypes, "'"+e.logType+"'")
+	}
+
+	events := fmt.Sprintf("SELECT CASE WHEN h.from_status_id IS NULL THEN 'project_created' ELSE 'status_changed' END AS event_type, "+
+		"h.id AS source_id, h.user_id, h.created_at, h.from_status_id AS from_status_id, h.to_status_id AS to_status_id, h.comment AS old_value, '' AS new_value "+
+		"FROM project_status_history h WHERE h.project_id = %d "+
+		"UNION ALL SELECT 'report_created', r.id, r.author_id, r.created_at, NULL, NULL, '', r.fields "+
+		"FROM reports r WHERE r.project_id = %d "+
+		"UNION ALL SELECT CASE l.type%s END, l.id, l.user_id, l.created_at, NULL, NULL, l.old_value, l.new_value "+
+		"FROM logs l WHERE l.project_id = %d AND l.type IN (%s)",
+		projectID, projectID, logCases, projectID, strings.Join(logTypes, ", "))
+
+	return listSpec{
+		from: "FROM (" + events + ") e LEFT JOIN users u ON e.user_id = u.id " +
+			"LEFT JOIN project_statuses f ON e.from_status_id = f.id LEFT JOIN project_statuses s ON e.to_status_id = s.id",
+		sortable: map[string]string{
+			"created_at": "e.created_at",
+			"type":       "e.event_type",
+			"user_id":    "e.user_id",
+		},
+		filters: map[string]listFilter{
+			"type":           {"e.event_type", filterString},
+			"user_id":        {"e.user_id", filterInt},
+			"created_after":  {"e.created_at", filterAfter},
+			"created_before": {"e.created_at", filterBefore},
+		},
+		defaultOrder: "e.created_at DESC, e.source_id DESC",
+	}
+}
+
+// getProjectTimeline devuelve lo que pasó en un proyecto, del evento más nuevo al más viejo:
+// alta, cambios de estado, ediciones, reportes y adjuntos, con quién lo hizo
+func getProjectTimeline(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "id")
+
+	var id int
+	row, err := dataBase.SelectRow("SELECT id FROM projects WHERE id = ?", projectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := row.Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Proyecto no encontrado", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	q, err := parseListQuery(r, timelineListSpec(id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := writeTotalCount(w, q); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query, args := q.selectQuery("SELECT e.event_type, e.source_id, e.user_id, COALESCE(u.name, ''), COALESCE(u.username, ''), e.created_at, " +
+		"e.from_status_id, COALESCE(f.status_name, ''), e.to_status_id, COALESCE(s.status_name, ''), e.old_value, e.new_value")
+	rows, err := dataBase.Select(query, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	events := []models.TimelineEvent{}
+	for rows.Next() {
+		var e models.TimelineEvent
+		var fromStatusID, toStatusID sql.NullInt64
+		var fromStatusName, toStatusName, oldValue, newValue string
+		if err := rows.Scan(&e.Type, &e.SourceID, &e.UserID, &e.UserName, &e.Username, &e.CreatedAt,
+			&fromStatusID, &fromStatusName, &toStatusID, &toStatusName, &oldValue, &newValue); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		switch e.Type {
+		case "project_created", "status_changed":
+			change := models.TimelineStatusChange{
+				ToStatusID:   int(toStatusID.Int64),
+				ToStatusName: toStatusName,
+				Comment:      oldValue,
+			}
+			if fromStatusID.Valid {
+				from := int(fromStatusID.Int64)
+				change.FromStatusID = &from
+				change.FromStatusName = fromStatusName
+			}
+			e.Data = change
+		case "project_updated":
+			e.Data = models.TimelineProjectUpdate{Changes: fieldChanges(oldValue, newValue, timelineProjectFields)}
+		case "report_created":
+			e.Data = models.TimelineReport{ReportID: e.SourceID, Fields: rawJSON(newValue)}
+		case "report_updated", "report_deleted":
+			// El log guarda el reporte completo antes del cambio y, en ediciones, los campos nuevos
+			var old struct {
+				ID     int             `json:"id"`
+				Fields json.RawMessage `json:"fields"`
+			}
+			json.Unmarshal([]byte(oldValue), &old)
+			e.Data = models.TimelineReport{ReportID: old.ID, Fields: rawJSON(newValue), OldFields: rawJSON(string(old.Fields))}
+		case "attachment_uploaded", "attachment_removed":
+			var attachment models.TimelineAttachment
+			json.Unmarshal([]byte(oldValue), &attachment)
+			json.Unmarshal([]byte(newValue), &attachment)
+			e.Data = attachment
+		case "member_added", "member_updated", "member_removed":
+			var before, after models.TimelineMember
+			json.Unmarshal([]byte(oldValue), &before)
+			json.Unmarshal([]byte(newValue), &after)
+			// Los logs guardan user_id y role; el rol anterior sale de old_value
+			member := models.TimelineMember{UserID: after.UserID, Role: after.Role, OldRole: before.Role}
+			if member.UserID == 0 {
+				member.UserID = before.UserID
+			}
+			e.Data = member
+		case "task_created", "task_updated", "task_deleted":
+			var task struct {
+				ID    int    `json:"id"`
+				Title string `json:"title"`
+			}
+			json.Unmarshal([]byte(oldValue), &task)
+			json.Unmarshal([]byte(newValue), &task)
+			data := models.TimelineTask{TaskID: task.ID, Title: task.Title}
+			if e.Type == "task_updated" {
+				data.Changes = fieldChanges(oldValue, newValue, timelineTaskFields)
+			}
+			e.Data = data
+		case "milestone_created", "milestone_updated", "milestone_deleted":
+			var milestone struct {
+				ID      int    `json:"id"`
+				Name    string `json:"name"`
+				DueDate string `json:"due_date"`
+			}
+			json.Unmarshal([]byte(oldValue), &milestone)
+			json.Unmarshal([]byte(newValue), &milestone)
+			data := models.TimelineMilestone{MilestoneID: milestone.ID, Name: milestone.Name, DueDate: milestone.DueDate}
+			if e.Type == "milestone_updated" {
+				data.Changes = fieldChanges(oldValue, newValue, timelineMilestoneFields)
+			}
+			e.Data = data
+		}
+		events = append(events, e)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+// fieldChanges compara los campos dados de un registro antes y después de una edición, tal como
+// quedaron en el log
+func fieldChanges(oldValue, newValue string, fields []string) []models.TimelineFieldChange {
+	changes := []models.TimelineFieldChange{}
+	var before, after map[string]interface{}
+	if json.Unmarshal([]byte(oldValue), &before) != nil || json.Unmarshal([]byte(newValue), &after) != nil {
+		return changes
+	}
+	for _, field := range fields {
+		if !reflect.DeepEqual(before[field], after[field]) {
+			changes = append(changes, models.TimelineFieldChange{Field: field, Old: before[field], New: after[field]})
+		}
+	}
+	return changes
+}
+
+// rawJSON devuelve el valor como JSON crudo, o nil si está vacío o no es JSON válido
+func rawJSON(value string) json.RawMessage {
+	if value == "" || !json.Valid([]byte(value)) {
+		return nil
+	}
+	return json.RawMessage(value)
+}
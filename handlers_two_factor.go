@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"magpanel/database"
+	"magpanel/models"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// startTwoFactorLogin responde al login con un challenge en lugar de los tokens
+func startTwoFactorLogin(w http.ResponseWriter, r *http.Request, u *models.User, enabled bool) {
+	response, err := twoFactorLoginChallenge(r.Context(), u, enabled)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// twoFactorLoginChallenge emite el challenge del segundo paso del login. Si el usuario todavía no
+// configuró 2FA pero su rol lo exige, incluye además el secreto pendiente para enrolarse.
+func twoFactorLoginChallenge(ctx context.Context, u *models.User, enabled bool) (map[string]interface{}, error) {
+	response := map[string]interface{}{
+		"expires_in": int(twoFactorChallengeTTL.Seconds()),
+	}
+
+	purpose := challengeVerify
+	if enabled {
+		response["two_factor_required"] = true
+	} else {
+		purpose = challengeEnroll
+		secret, err := pendingTOTPSecret(ctx, u.ID)
+		if err != nil {
+			return nil, err
+		}
+		response["two_factor_setup_required"] = true
+		response["secret"] = secret
+		response["otpauth_uri"] = totpURI(secret, u.Username)
+	}
+
+	challenge, err := createTwoFactorChallenge(u.ID, purpose)
+	if err != nil {
+		return nil, err
+	}
+	response["challenge_token"] = challenge
+	return response, nil
+}
+
+// completeTwoFactorLogin es el segundo paso del login: canjea el challenge más un código TOTP
+// (o un código de recuperación) por los tokens de sesión
+func completeTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if requestData.Code == "" && requestData.RecoveryCode == "" {
+		http.Error(w, "Se requiere code o recovery_code", http.StatusBadRequest)
+		return
+	}
+
+	userID, purpose, err := takeTwoFactorChallenge(requestData.ChallengeToken)
+	if err != nil {
+		if err == errInvalidChallenge {
+			http.Error(w, "No autorizado. "+err.Error(), http.StatusUnauthorized)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	var u models.User
+	var lockedUntil sql.NullString
+	row, err := dataBase.SelectRow("SELECT id, name, username, `rank`, status, locked_until FROM users WHERE id = ?", userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := row.Scan(&u.ID, &u.Name, &u.Username, &u.Rank, &u.Status, &lockedUntil); err != nil {
+		http.Error(w, "No autorizado. El usuario ya no existe", http.StatusUnauthorized)
+		return
+	}
+	if u.Status == userStatusInactive {
+		deleteTwoFactorChallenge(requestData.ChallengeToken)
+		http.Error(w, "La cuenta está desactivada", http.StatusForbidden)
+		return
+	}
+	r = withCurrentUser(r, &u)
+
+	// Los códigos fallidos también cuentan para el bloqueo de la cuenta
+	if until := accountLockedUntil(lockedUntil); !until.IsZero() {
+		deleteTwoFactorChallenge(requestData.ChallengeToken)
+		recordFailedLogin(r, u.Username, u.ID, failedLoginLocked)
+		http.Error(w, "Cuenta bloqueada por intentos fallidos hasta "+database.FormatTime(until)+" (UTC)", http.StatusLocked)
+		return
+	}
+
+	var ok bool
+	logType := "login_2fa"
+	if requestData.RecoveryCode != "" && purpose == challengeVerify {
+		ok, err = useRecoveryCode(u.ID, requestData.RecoveryCode)
+		logType = "login_2fa_recovery_code"
+	} else {
+		ok, err = verifyUserTOTP(u.ID, requestData.Code)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		recordFailedLogin(r, u.Username, u.ID, failedLoginBad2FA)
+		http.Error(w, "Código inválido", http.StatusUnauthorized)
+		return
+	}
+	deleteTwoFactorChallenge(requestData.ChallengeToken)
+
+	var recoveryCodes []string
+	if purpose == challengeEnroll {
+		recoveryCodes, err = enableTwoFactor(r.Context(), u.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logType = "enable_2fa"
+	}
+
+	// Registro del evento (los códigos nunca se guardan en el log)
+	if err := insertLog(logType, "", fmt.Sprintf(`{"user_id":%d}`, u.ID), r); err != nil {
+		// Manejar el error de inserción del log aquí
+		log.Printf("Error al insertar el registro de 2FA: %v", err)
+	}
+
+	if err := resetFailedLogins(u.ID); err != nil {
+		log.Printf("Error al reiniciar los intentos fallidos del usuario %d: %v", u.ID, err)
+	}
+
+	tokens, err := createSession(r, u.ID, u.Name)
+	if err != nil {
+		http.Error(w, "Error al generar el Access Token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		*tokenResponse
+		RecoveryCodes []string `json:"recovery_codes,omitempty"`
+	}{tokens, recoveryCodes})
+}
+
+// getTwoFactorStatus indica si el usuario autenticado tiene 2FA y cuántos códigos de recuperación le quedan
+func getTwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	user, err := getCurrentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var enabled bool
+	var remaining int
+	row, err := dataBase.SelectRow("SELECT totp_enabled, (SELECT COUNT(*) FROM two_factor_recovery_codes c WHERE c.user_id = users.id AND c.used_at IS NULL) FROM users WHERE id = ?", user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := row.Scan(&enabled, &remaining); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":                  enabled,
+		"required":                 twoFactorRequiredForRank(user.Rank),
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// enrollTwoFactor genera un secreto pendiente; se activa recién al confirmarlo con un código
+func enrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if rejectAPIKey(w, r) {
+		return
+	}
+	user, err := getCurrentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var enabled bool
+	row, err := dataBase.SelectRow("SELECT totp_enabled FROM users WHERE id = ?", user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	row.Scan(&enabled)
+	if enabled {
+		http.Error(w, "El 2FA ya está activado", http.StatusConflict)
+		return
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		http.Error(w, "Error al generar el secreto 2FA", http.StatusInternalServerError)
+		return
+	}
+	if _, err := dataBase.Update(true, "UPDATE users SET totp_secret = ?, totp_secret_created_at = ?, totp_last_step = 0 WHERE id = ?", secret, database.FormatTime(time.Now()), user.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":      secret,
+		"otpauth_uri": totpURI(secret, user.Username),
+	})
+}
+
+// confirmTwoFactor activa el secreto pendiente si el código es correcto y devuelve los códigos de recuperación
+func confirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if rejectAPIKey(w, r) {
+		return
+	}
+	var requestData struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	user, err := getCurrentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	ok, err := verifyUserTOTP(user.ID, requestData.Code)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Código inválido", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := enableTwoFactor(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Registro del evento de activación
+	if err := insertLog("enable_2fa", "", fmt.Sprintf(`{"user_id":%d}`, user.ID), r); err != nil {
+		// Manejar el error de inserción del log aquí
+		log.Printf("Error al insertar el registro de activación de 2FA: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+// disableTwoFactor desactiva el 2FA propio, salvo que el rol lo exija
+func disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if rejectAPIKey(w, r) {
+		return
+	}
+	var requestData struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	user, err := getCurrentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if twoFactorRequiredForRank(user.Rank) {
+		http.Error(w, "El 2FA es obligatorio para el rol "+roleForRank(user.Rank), http.StatusForbidden)
+		return
+	}
+
+	var ok bool
+	if requestData.RecoveryCode != "" {
+		ok, err = useRecoveryCode(user.ID, requestData.RecoveryCode)
+	} else {
+		ok, err = verifyUserTOTP(user.ID, requestData.Code)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Código inválido", http.StatusBadRequest)
+		return
+	}
+
+	if err := clearTwoFactor(r.Context(), user.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Registro del evento de desactivación
+	if err := insertLog("disable_2fa", fmt.Sprintf(`{"user_id":%d}`, user.ID), "", r); err != nil {
+		// Manejar el error de inserción del log aquí
+		log.Printf("Error al insertar el registro de desactivación de 2FA: %v", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// regenerateRecoveryCodes invalida los códigos de recuperación anteriores y genera nuevos
+func regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	if rejectAPIKey(w, r) {
+		return
+	}
+	var requestData struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	user, err := getCurrentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var enabled bool
+	row, err := dataBase.SelectRow("SELECT totp_enabled FROM users WHERE id = ?", user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	row.Scan(&enabled)
+	if !enabled {
+		http.Error(w, "El 2FA no está activado", http.StatusConflict)
+		return
+	}
+
+	ok, err := verifyUserTOTP(user.ID, requestData.Code)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Código inválido", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := enableTwoFactor(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Registro del evento de regeneración
+	if err := insertLog("regenerate_2fa_recovery_codes", "", fmt.Sprintf(`{"user_id":%d}`, user.ID), r); err != nil {
+		// Manejar el error de inserción del log aquí
+		log.Printf("Error al insertar el registro de regeneración de códigos: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+// resetUserTwoFactor permite a un administrador quitar el 2FA de un usuario que perdió su dispositivo.
+// Si su rol lo exige, en el próximo login se le pedirá configurarlo de nuevo.
+func resetUserTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "id")
+
+	var username string
+	var enabled bool
+	row, err := dataBase.SelectRow("SELECT username, totp_enabled FROM users WHERE id = ?", userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := row.Scan(&username, &enabled); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := clearTwoFactor(r.Context(), userID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Registro del evento de reseteo
+	oldValue := fmt.Sprintf(`{"user_id":%s,"username":%q,"enabled":%t}`, userID, username, enabled)
+	if err := insertLog("reset_2fa", oldValue, "", r); err != nil {
+		// Manejar el error de inserción del log aquí
+		log.Printf("Error al insertar el registro de reseteo de 2FA: %v", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -5,17 +5,51 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"magpanel/database"
 	"magpanel/models"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 )
 
+var userListSpec = listSpec{
+	from: "FROM users",
+	sortable: map[string]string{
+		"id":         "`id`",
+		"username":   "`username`",
+		"rank":       "`rank`",
+		"email":      "`email`",
+		"name":       "`name`",
+		"created_at": "`created_at`",
+	},
+	filters: map[string]listFilter{
+		"rank":            {"`rank`", filterInt},
+		"service_account": {"`service_account`", filterBool},
+		"status":          {"`status`", filterString},
+		"email":           {"`email`", filterString},
+		"created_after":   {"`created_at`", filterAfter},
+		"created_before":  {"`created_at`", filterBefore},
+	},
+	defaultOrder: "`id` ASC",
+}
+
 func getUsers(w http.ResponseWriter, r *http.Request) {
 	var users []models.User
 
-	rows, err := dataBase.Select("SELECT `id`, `username`, `rank`, `email`, `name`, `recovery_hash`, `created_at`, `updated_at` FROM users")
+	q, err := parseListQuery(r, userListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := writeTotalCount(w, q); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query, args := q.selectQuery("SELECT `id`, `username`, `rank`, `email`, `name`, `recovery_hash`, `service_account`, `status`, `created_at`, `updated_at`")
+	rows, err := dataBase.Select(query, args...)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -27,7 +61,7 @@ func getUsers(w http.ResponseWriter, r *http.Request) {
 		var createdAt, updatedAt []byte // Usar []byte para leer los valores de fecha y hora
 		var recoveryHash sql.NullString // Usar sql.NullString para manejar valores NULL
 
-		if err := rows.Scan(&u.ID, &u.Username, &u.Rank, &u.Email, &u.Name, &recoveryHash, &createdAt, &updatedAt); err != nil {
+		if err := rows.Scan(&u.ID, &u.Username, &u.Rank, &u.Email, &u.Name, &recoveryHash, &u.ServiceAccount, &u.Status, &createdAt, &updatedAt); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -67,14 +101,14 @@ func updateUser(w http.ResponseWriter, r *http.Request) {
 
 		return
 	}
-	rows.Scan(&old.ID, &old.Username, &old.Rank, &old.Email, &old.Name, &old.PasswordHash)
-
-	oldValueBytes, err := json.Marshal(old)
-	if err != nil {
-		// Manejar error de serialización
-		log.Printf("Error al serializar antiguo usuario: %v", err)
+	if err := rows.Scan(&old.ID, &old.Username, &old.Rank, &old.Email, &old.Name, &old.PasswordHash); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
 	}
-	oldValue := string(oldValueBytes)
 
 	// Verificar si el nombre de usuario ya existe para otro ID
 	// if usernameExistsForOtherID(u.Username, userID) {
@@ -87,26 +121,67 @@ func updateUser(w http.ResponseWriter, r *http.Request) {
 	var args []interface{}
 
 	if u.PasswordHash != "" {
-		// Generar nueva sal y hashear la nueva contraseña
-		newSalt := generateSalt()
-		newHashedPassword := hashPassword(u.PasswordHash, newSalt)
-		fmt.Println("Nueva contraseña:", u.PasswordHash)
-		fmt.Println("Nueva contraseña hasheada:", newHashedPassword)
-		fmt.Println("Nueva sal:", newSalt)
-
-		query = "UPDATE users SET `username` = ?, `rank` = ?, `email` = ?, `name` = ?, `password_hash` = ?, `salt` = ? WHERE `id` = ?"
-		args = append(args, u.Username, u.Rank, u.Email, u.Name, newHashedPassword, newSalt, userID)
+		if err := validatePassword(u.PasswordHash, u.Username, u.Email); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		newHashedPassword, err := hashPassword(u.PasswordHash)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		query = "UPDATE users SET `username` = ?, `rank` = ?, `email` = ?, `name` = ?, `password_hash` = ?, `salt` = '' WHERE `id` = ?"
+		args = append(args, u.Username, u.Rank, u.Email, u.Name, newHashedPassword, userID)
 	} else {
 		query = "UPDATE users SET `username` = ?, `rank` = ?, `email` = ?, `name` = ? WHERE `id` = ?"
 		args = append(args, u.Username, u.Rank, u.Email, u.Name, userID)
 	}
 
-	_, err = dataBase.Update(true, query, args...)
-	if err != nil {
+	// Si baja el rol, sus API keys pierden en la misma transacción los scopes que ya no le corresponden
+	err = dataBase.WithTx(r.Context(), func(tx *database.Tx) error {
+		if roleForRank(old.Rank) == RoleAdmin && roleForRank(u.Rank) != RoleAdmin {
+			if last, err := isLastActiveAdmin(tx, old.ID); err != nil {
+				return err
+			} else if last {
+				return errLastAdminDemote
+			}
+		}
+		if _, err := tx.Update(true, query, args...); err != nil {
+			return err
+		}
+		if u.Rank < old.Rank {
+			return trimAPIKeyScopes(tx, old.ID, permissionsForRank(u.Rank))
+		}
+		return nil
+	})
+	if err == errLastAdminDemote {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// Si cambió la contraseña o bajó el rol se cierran todas las sesiones abiertas del usuario
+	if u.PasswordHash != "" || u.Rank < old.Rank {
+		if _, err := revokeUserSessions(userID); err != nil {
+			log.Printf("Error al revocar las sesiones del usuario %s: %v", userID, err)
+		}
+	}
+
+	// El log nunca guarda contraseñas ni hashes
+	old.PasswordHash = ""
+	u.PasswordHash = ""
+
+	oldValueBytes, err := json.Marshal(old)
+	if err != nil {
+		// Manejar error de serialización
+		log.Printf("Error al serializar antiguo usuario: %v", err)
+	}
+	oldValue := string(oldValueBytes)
+
 	newValueBytes, err := json.Marshal(u)
 	if err != nil {
 		// Manejar error de serialización
@@ -136,20 +211,35 @@ func createUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generar sal y hashear la contraseña
-	salt := generateSalt()
-	hashedPassword := hashPassword(u.PasswordHash, salt)
-	// log.Println("Hash almacenado:", hashedPassword)
-	// log.Println("Salt almacenado:", salt)
-	// log.Println("Contraseña cruda almacenado:", u.PasswordHash)
+	// Las cuentas de servicio no tienen contraseña, el resto debe cumplir la política
+	if !u.ServiceAccount {
+		if err := validatePassword(u.PasswordHash, u.Username, u.Email); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Hashear la contraseña. Las cuentas de servicio no tienen contraseña,
+	// solo se usan a través de API keys
+	var hashedPassword string
+	if !u.ServiceAccount {
+		var err error
+		hashedPassword, err = hashPassword(u.PasswordHash)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 
-	lastInsertID, err := dataBase.Insert(true, "INSERT INTO users (`username`, `rank`, `email`, `name`, `password_hash`, `salt`) VALUES (?, ?, ?, ?, ?, ?)", u.Username, u.Rank, u.Email, u.Name, hashedPassword, salt)
+	lastInsertID, err := dataBase.Insert(true, "INSERT INTO users (`username`, `rank`, `email`, `name`, `password_hash`, `service_account`) VALUES (?, ?, ?, ?, ?, ?)", u.Username, u.Rank, u.Email, u.Name, hashedPassword, u.ServiceAccount)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	u.ID = int(lastInsertID)
+	u.Status = userStatusActive
+	u.PasswordHash = "" // Ni la contraseña ni su hash van al log ni a la respuesta
 
 	newValueBytes, err := json.Marshal(u)
 	if err != nil {
@@ -166,7 +256,6 @@ func createUser(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	u.PasswordHash = "" // No devolver la contraseña hasheada
 	json.NewEncoder(w).Encode(u)
 }
 
@@ -175,10 +264,14 @@ func getUserByID(w http.ResponseWriter, r *http.Request) {
 
 	var u models.User
 	var createdAt, updatedAt []byte // Usar []byte para leer los valores de fecha y hora
-	var recoveryHash sql.NullString // Usar sql.NullString para manejar valores NULL
 
-	rows, err := dataBase.SelectRow("SELECT `id`, `username`, `rank`, `email`, `name`, `password_hash`, `recovery_hash`, `created_at`, `updated_at` FROM users WHERE id = ?", userID)
+	// password_hash y recovery_hash nunca salen de la base
+	rows, err := dataBase.SelectRow("SELECT `id`, `username`, `rank`, `email`, `name`, `service_account`, `status`, `created_at`, `updated_at` FROM users WHERE id = ?", userID)
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := rows.Scan(&u.ID, &u.Username, &u.Rank, &u.Email, &u.Name, &u.ServiceAccount, &u.Status, &createdAt, &updatedAt); err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Usuario no encontrado", http.StatusNotFound)
 		} else {
@@ -186,7 +279,6 @@ func getUserByID(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	rows.Scan(&u.ID, &u.Username, &u.Rank, &u.Email, &u.Name, &u.PasswordHash, &recoveryHash, &createdAt, &updatedAt)
 
 	// Convertir createdAt y updatedAt a time.Time
 	u.CreatedAt, err = time.Parse("2006-01-02 15:04:05", string(createdAt))
@@ -202,11 +294,152 @@ func getUserByID(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(u)
 }
 
-func deleteUser(w http.ResponseWriter, r *http.Request) {
-	userID := chi.URLParam(r, "id")
+var (
+	errTransferTarget  = fmt.Errorf("El usuario destino de la transferencia no existe o no está activo")
+	errLastAdmin       = fmt.Errorf("No se puede desactivar al último administrador activo")
+	errLastAdminDemote = fmt.Errorf("No se puede quitar el rol de administrador al último administrador activo")
+)
 
-	var old models.User
-	rows, err := dataBase.SelectRow("SELECT `id`, `username`, `rank`, `email`, `name`, `password_hash` FROM users WHERE id = ?", userID)
+// isLastActiveAdmin indica si no hay otro administrador activo además de userID
+func isLastActiveAdmin(tx *database.Tx, userID int) (bool, error) {
+	var admins int
+	row, err := tx.SelectRow("SELECT COUNT(*) FROM users WHERE `rank` >= 3 AND status = ? AND service_account = ? AND id <> ?", userStatusActive, false, userID)
+	if err != nil {
+		return false, err
+	}
+	if err := row.Scan(&admins); err != nil {
+		return false, err
+	}
+	return admins == 0, nil
+}
+
+// deactivateUser da de baja a un usuario sin borrarlo: deja de poder iniciar sesión, se cierran
+// sus sesiones y se revocan sus API keys. Sus proyectos y reportes conservan el autor, salvo que
+// se pida transferirlos con ?transfer_to={id}.
+func deactivateUser(w http.ResponseWriter, r *http.Request) {
+	if rejectAPIKey(w, r) {
+		return
+	}
+	currentUser, err := getCurrentUser(r)
+	if err != nil {
+		http.Error(w, "Error al obtener el usuario actual", http.StatusInternalServerError)
+		return
+	}
+
+	old, err := getUserSummary(chi.URLParam(r, "id"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if old.ID == currentUser.ID {
+		http.Error(w, "No puedes desactivar tu propio usuario", http.StatusConflict)
+		return
+	}
+	if old.Status == userStatusInactive {
+		http.Error(w, "El usuario ya está desactivado", http.StatusConflict)
+		return
+	}
+
+	transferTo := 0
+	if value := r.URL.Query().Get("transfer_to"); value != "" {
+		transferTo, err = strconv.Atoi(value)
+		if err != nil || transferTo <= 0 {
+			http.Error(w, "transfer_to debe ser el ID de un usuario", http.StatusBadRequest)
+			return
+		}
+		if transferTo == old.ID {
+			http.Error(w, "No se puede transferir al mismo usuario que se desactiva", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var transferred map[string]int64
+	now := database.FormatTime(time.Now())
+	err = dataBase.WithTx(r.Context(), func(tx *database.Tx) error {
+		// Siempre tiene que quedar al menos un administrador activo
+		if roleForRank(old.Rank) == RoleAdmin {
+			if last, err := isLastActiveAdmin(tx, old.ID); err != nil {
+				return err
+			} else if last {
+				return errLastAdmin
+			}
+		}
+
+		updated, err := tx.Update(false, "UPDATE users SET status = ?, deactivated_at = ?, deactivated_by = ?, recovery_hash = NULL, recovery_hash_time = NULL WHERE id = ? AND status <> ?",
+			userStatusInactive, now, currentUser.ID, old.ID, userStatusInactive)
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return sql.ErrNoRows
+		}
+
+		// Nada de lo emitido antes de la baja sigue sirviendo para autenticarse
+		if _, err := tx.Update(false, "UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", now, old.ID); err != nil {
+			return err
+		}
+		if _, err := tx.Update(false, "UPDATE api_keys SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", now, old.ID); err != nil {
+			return err
+		}
+		if _, err := tx.Delete(false, "DELETE FROM two_factor_challenges WHERE user_id = ?", old.ID); err != nil {
+			return err
+		}
+		if _, err := tx.Update(false, "UPDATE user_invitations SET revoked_at = ? WHERE user_id = ? AND accepted_at IS NULL AND revoked_at IS NULL", now, old.ID); err != nil {
+			return err
+		}
+
+		if transferTo != 0 {
+			transferred, err = transferUserContent(tx, old.ID, transferTo)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			http.Error(w, "El usuario ya está desactivado", http.StatusConflict)
+		case errLastAdmin:
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errTransferTarget:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	oldValueBytes, err := json.Marshal(old)
+	if err != nil {
+		// Manejar error de serialización
+		log.Printf("Error al serializar antiguo usuario: %v", err)
+	}
+	newValue := map[string]interface{}{"user_id": old.ID, "status": userStatusInactive}
+	if transferTo != 0 {
+		newValue["transfer_to"] = transferTo
+		newValue["transferred"] = transferred
+	}
+	newValueBytes, _ := json.Marshal(newValue)
+
+	// Registro del evento de desactivación
+	if err := insertLog("deactivate_user", string(oldValueBytes), string(newValueBytes), r); err != nil {
+		// Manejar el error de inserción del log aquí
+		log.Printf("Error al insertar el registro de desactivación de usuario: %v", err)
+	}
+	w.WriteHeader(http.StatusNoContent) // 204 No Content como respuesta exitosa sin cuerpo
+}
+
+// reactivateUser vuelve a habilitar a un usuario desactivado. Sus sesiones y API keys siguen
+// revocadas: tiene que iniciar sesión de nuevo.
+func reactivateUser(w http.ResponseWriter, r *http.Request) {
+	if rejectAPIKey(w, r) {
+		return
+	}
+
+	old, err := getUserSummary(chi.URLParam(r, "id"))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Usuario no encontrado", http.StatusNotFound)
@@ -215,25 +448,150 @@ func deleteUser(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	rows.Scan(&old.ID, &old.Username, &old.Rank, &old.Email, &old.Name, &old.PasswordHash)
+	if old.Status != userStatusInactive {
+		http.Error(w, "El usuario no está desactivado", http.StatusConflict)
+		return
+	}
 
-	_, err = dataBase.Delete(true, "DELETE FROM users WHERE id = ?", userID)
+	updated, err := dataBase.Update(true, "UPDATE users SET status = ?, deactivated_at = NULL, deactivated_by = NULL WHERE id = ? AND status = ?",
+		userStatusActive, old.ID, userStatusInactive)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if updated == 0 {
+		http.Error(w, "El usuario no está desactivado", http.StatusConflict)
+		return
+	}
 
 	oldValueBytes, err := json.Marshal(old)
 	if err != nil {
 		// Manejar error de serialización
 		log.Printf("Error al serializar antiguo usuario: %v", err)
 	}
-	oldValue := string(oldValueBytes)
 
-	// Registro del evento de eliminación
-	if err := insertLog("delete_user", oldValue, "", r); err != nil {
+	// Registro del evento de reactivación
+	if err := insertLog("reactivate_user", string(oldValueBytes), fmt.Sprintf(`{"user_id":%d,"status":%q}`, old.ID, userStatusActive), r); err != nil {
 		// Manejar el error de inserción del log aquí
-		log.Printf("Error al insertar el registro de eliminación de usuario: %v", err)
+		log.Printf("Error al insertar el registro de reactivación de usuario: %v", err)
 	}
-	w.WriteHeader(http.StatusNoContent) // 204 No Content como respuesta exitosa sin cuerpo
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// transferUser pasa todos los proyectos y reportes de un usuario (activo o no) a otro usuario activo
+func transferUser(w http.ResponseWriter, r *http.Request) {
+	if rejectAPIKey(w, r) {
+		return
+	}
+
+	var input struct {
+		ToUserID int `json:"to_user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if input.ToUserID <= 0 {
+		http.Error(w, "to_user_id es obligatorio", http.StatusBadRequest)
+		return
+	}
+
+	from, err := getUserSummary(chi.URLParam(r, "id"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if input.ToUserID == from.ID {
+		http.Error(w, "El usuario destino debe ser otro usuario", http.StatusBadRequest)
+		return
+	}
+
+	var transferred map[string]int64
+	err = dataBase.WithTx(r.Context(), func(tx *database.Tx) error {
+		transferred, err = transferUserContent(tx, from.ID, input.ToUserID)
+		return err
+	})
+	if err != nil {
+		if err == errTransferTarget {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	newValueBytes, _ := json.Marshal(map[string]interface{}{
+		"from_user_id": from.ID,
+		"to_user_id":   input.ToUserID,
+		"transferred":  transferred,
+	})
+	// Registro del evento de transferencia
+	if err := insertLog("transfer_user_content", "", string(newValueBytes), r); err != nil {
+		// Manejar el error de inserción del log aquí
+		log.Printf("Error al insertar el registro de transferencia: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(transferred)
+}
+
+// getUserSummary lee los datos básicos del usuario que se registran en los logs, sin secretos
+func getUserSummary(userID string) (*models.User, error) {
+	var u models.User
+	row, err := dataBase.SelectRow("SELECT `id`, `username`, `rank`, `email`, `name`, `service_account`, `status` FROM users WHERE id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := row.Scan(&u.ID, &u.Username, &u.Rank, &u.Email, &u.Name, &u.ServiceAccount, &u.Status); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// transferUserContent reasigna el autor de los proyectos y reportes de fromUserID a toUserID,
+// que tiene que ser un usuario activo, y le pasa sus asignaciones a proyectos (si toUserID ya
+// estaba en el proyecto, conserva su rol) y las tareas de las que es responsable. Devuelve
+// cuántos registros se movieron de cada tipo.
+func transferUserContent(tx *database.Tx, fromUserID, toUserID int) (map[string]int64, error) {
+	var status string
+	row, err := tx.SelectRow("SELECT status FROM users WHERE id = ?", toUserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := row.Scan(&status); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errTransferTarget
+		}
+		return nil, err
+	}
+	if status != userStatusActive {
+		return nil, errTransferTarget
+	}
+
+	projects, err := tx.Update(false, "UPDATE projects SET author_id = ? WHERE author_id = ?", toUserID, fromUserID)
+	if err != nil {
+		return nil, err
+	}
+	reports, err := tx.Update(false, "UPDATE reports SET author_id = ? WHERE author_id = ?", toUserID, fromUserID)
+	if err != nil {
+		return nil, err
+	}
+	// La subconsulta va envuelta para que MySQL acepte leer la misma tabla que actualiza
+	memberships, err := tx.Update(false, "UPDATE project_members SET user_id = ? WHERE user_id = ? AND project_id NOT IN (SELECT project_id FROM (SELECT project_id FROM project_members WHERE user_id = ?) t)", toUserID, fromUserID, toUserID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Delete(false, "DELETE FROM project_members WHERE user_id = ?", fromUserID); err != nil {
+		return nil, err
+	}
+	tasks, err := tx.Update(false, "UPDATE project_tasks SET assignee_id = ? WHERE assignee_id = ?", toUserID, fromUserID)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]int64{"projects": projects, "reports": reports, "memberships": memberships, "tasks": tasks}, nil
 }
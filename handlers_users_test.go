@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func putUser(t *testing.T, handler http.Handler, token string, id int, username string, rank int) int {
+	t.Helper()
+	body := fmt.Sprintf(`{"username": %q, "rank": %d, "email": "%s@example.com", "name": %q}`, username, rank, username, username)
+	return doRequest(t, handler, "PUT", fmt.Sprintf("/users/%d", id), token, body).Code
+}
+
+func TestUpdateUserDemotion(t *testing.T) {
+	newTestDatabase(t)
+	adminID := createTestUser(t, "admin", 3)
+	demotedID := createTestUser(t, "admin2", 3)
+	admin, demoted := testToken(t, adminID), testToken(t, demotedID)
+	scopes, _ := json.Marshal([]string{PermReportsWrite, PermUsersAdmin})
+	keyID, err := dataBase.Insert(false, "INSERT INTO api_keys (name, prefix, key_hash, user_id, scopes, expires_at, created_by) VALUES ('ci', 'mp_', 'hash', ?, ?, '2099-01-01 00:00:00', ?)",
+		demotedID, string(scopes), demotedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := initRoutes()
+
+	if code := putUser(t, router, admin, demotedID, "admin2", 1); code != http.StatusOK {
+		t.Fatalf("PUT /users/%d bajando el rol = %d, se esperaba 200", demotedID, code)
+	}
+	if rank := userRank(t, demotedID); rank != 1 {
+		t.Errorf("rank = %d, se esperaba 1", rank)
+	}
+	// Las sesiones abiertas con el rol anterior se cierran
+	if w := doRequest(t, router, "GET", "/permissions", demoted, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /permissions con la sesión anterior = %d, se esperaba 401", w.Code)
+	}
+	// Y las API keys pierden los scopes que el rol nuevo no tiene
+	var stored string
+	row, _ := dataBase.SelectRow("SELECT scopes FROM api_keys WHERE id = ?", keyID)
+	if err := row.Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	json.Unmarshal([]byte(stored), &got)
+	if !reflect.DeepEqual(got, []string{PermReportsWrite}) {
+		t.Errorf("scopes de la API key = %v, se esperaba solo %s", got, PermReportsWrite)
+	}
+
+	// Ya no queda otro administrador activo
+	if code := putUser(t, router, admin, adminID, "admin", 2); code != http.StatusConflict {
+		t.Errorf("PUT /users/%d quitando el rol al último administrador = %d, se esperaba 409", adminID, code)
+	}
+	if rank := userRank(t, adminID); rank != 3 {
+		t.Errorf("el último administrador quedó con rank %d", rank)
+	}
+	if w := doRequest(t, router, "GET", "/permissions", admin, ""); w.Code != http.StatusOK {
+		t.Errorf("GET /permissions del administrador = %d, se esperaba 200", w.Code)
+	}
+}
+
+func TestUpdateUserNotFound(t *testing.T) {
+	newTestDatabase(t)
+	admin := testToken(t, createTestUser(t, "admin", 3))
+	if code := putUser(t, initRoutes(), admin, 999, "nadie", 1); code != http.StatusNotFound {
+		t.Errorf("PUT /users/999 = %d, se esperaba 404", code)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io"
+	"magpanel/database"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+// testPassword es la contraseña de los usuarios que crea createTestUser
+const testPassword = "Una-Clave-Segura-9"
+
+// newTestDatabase reemplaza dataBase por una base SQLite temporal con todas las migraciones
+// aplicadas; es un archivo y no ":memory:" para que haya varias conexiones, como en producción.
+// También deja una clave JWT, quita los límites de tasa y baja el costo de argon2id; todo se
+// restaura al terminar el test.
+func newTestDatabase(t *testing.T) {
+	t.Helper()
+	db, err := database.NewSQLiteDatabase(filepath.Join(t.TempDir(), "magpanel.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	oldDB, oldSecret, oldKeyring := dataBase, legacySecret, keyring
+	oldIP, oldUsername := ipLimiter, usernameLimiter
+	oldTime, oldMemory, oldThreads := argon2Time, argon2Memory, argon2Threads
+	t.Cleanup(func() {
+		db.Close()
+		dataBase, legacySecret, keyring = oldDB, oldSecret, oldKeyring
+		ipLimiter, usernameLimiter = oldIP, oldUsername
+		argon2Time, argon2Memory, argon2Threads = oldTime, oldMemory, oldThreads
+	})
+
+	dataBase = db
+	legacySecret = []byte("clave-de-test")
+	keyring = &jwtKeyring{}
+	ipLimiter = newKeyedLimiter(rate.Inf, 0)
+	usernameLimiter = newKeyedLimiter(rate.Inf, 0)
+	argon2Time, argon2Memory, argon2Threads = 1, 1024, 1
+}
+
+// createTestUser crea un usuario activo con testPassword y devuelve su id
+func createTestUser(t *testing.T, username string, rank int) int {
+	t.Helper()
+	hash, err := hashPassword(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := dataBase.Insert(false, "INSERT INTO users (username, `rank`, email, name, password_hash) VALUES (?, ?, ?, ?, ?)",
+		username, rank, username+"@example.com", username, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int(id)
+}
+
+// testToken abre una sesión para el usuario y devuelve su access token
+func testToken(t *testing.T, userID int) string {
+	t.Helper()
+	tokens, err := createSession(httptest.NewRequest("POST", "/login", nil), userID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens.AccessToken
+}
+
+// doRequest envía el request al router y devuelve la respuesta. token puede ser vacío.
+func doRequest(t *testing.T, handler http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"magpanel/database"
+	"math/big"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Algoritmos con los que se pueden firmar los access tokens
+const (
+	jwtAlgHS256 = "HS256"
+	jwtAlgRS256 = "RS256"
+	jwtAlgEdDSA = "EdDSA"
+)
+
+// legacyKeyID identifica a JWT_KEY de data.conf dentro del keyring. Los tokens sin `kid`
+// se emitieron antes del keyring y se validan con esa clave.
+const legacyKeyID = "legacy"
+
+// Cada cuánto se relee el keyring de la base, para que una rotación hecha desde otra
+// instancia o desde la línea de comandos se aplique sin reiniciar
+const (
+	keyringReloadInterval     = time.Minute
+	keyringMissReloadInterval = 10 * time.Second
+)
+
+// jwtKey es una clave del keyring. Solo la activa firma; las retiradas siguen validando
+// tokens hasta verifyUntil.
+type jwtKey struct {
+	kid         string
+	alg         string
+	signKey     interface{} // []byte, *rsa.PrivateKey o ed25519.PrivateKey
+	verifyKey   interface{} // []byte, *rsa.PublicKey o ed25519.PublicKey
+	active      bool
+	verifyUntil time.Time // Tiempo cero: sin vencimiento
+}
+
+func (k *jwtKey) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.alg)
+}
+
+func (k *jwtKey) expired() bool {
+	return !k.verifyUntil.IsZero() && time.Now().After(k.verifyUntil)
+}
+
+type jwtKeyring struct {
+	mu       sync.RWMutex
+	keys     map[string]*jwtKey
+	current  *jwtKey
+	loadedAt time.Time
+}
+
+var keyring = &jwtKeyring{}
+
+var errNoSigningKey = errors.New("no hay una clave de firma configurada")
+
+// legacySecret es JWT_KEY de data.conf; firma mientras no se haya rotado ninguna clave
+var legacySecret []byte
+
+// reload lee las claves de jwt_keys. Si la tabla está vacía el keyring contiene solo JWT_KEY.
+func (kr *jwtKeyring) reload() error {
+	rows, err := dataBase.Select("SELECT kid, algorithm, secret, active, verify_until FROM jwt_keys")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	keys := map[string]*jwtKey{}
+	var current *jwtKey
+	for rows.Next() {
+		var kid, alg string
+		var secret, verifyUntil sql.NullString
+		var active bool
+		if err := rows.Scan(&kid, &alg, &secret, &active, &verifyUntil); err != nil {
+			return err
+		}
+		key, err := decodeJWTKey(kid, alg, secret)
+		if err != nil {
+			log.Printf("Clave JWT %s ignorada: %v", kid, err)
+			continue
+		}
+		key.active = active
+		if verifyUntil.Valid {
+			if until, err := database.ParseTime(verifyUntil.String); err == nil {
+				key.verifyUntil = until
+			}
+		}
+		keys[kid] = key
+		if active {
+			current = key
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Sin rotaciones todavía, JWT_KEY sigue siendo la clave de firma
+	if _, ok := keys[legacyKeyID]; !ok && current == nil && len(legacySecret) > 0 {
+		current = &jwtKey{kid: legacyKeyID, alg: jwtAlgHS256, signKey: legacySecret, verifyKey: legacySecret, active: true}
+		keys[legacyKeyID] = current
+	}
+
+	kr.mu.Lock()
+	kr.keys = keys
+	kr.current = current
+	kr.loadedAt = time.Now()
+	kr.mu.Unlock()
+	return nil
+}
+
+// reloadIfOlder relee el keyring si la última lectura es más vieja que maxAge
+func (kr *jwtKeyring) reloadIfOlder(maxAge time.Duration) {
+	kr.mu.RLock()
+	stale := time.Since(kr.loadedAt) > maxAge
+	kr.mu.RUnlock()
+	if stale {
+		if err := kr.reload(); err != nil {
+			log.Printf("Error al leer las claves JWT: %v", err)
+		}
+	}
+}
+
+// signingKey devuelve la clave activa
+func (kr *jwtKeyring) signingKey() (*jwtKey, error) {
+	kr.reloadIfOlder(keyringReloadInterval)
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	if kr.current == nil {
+		return nil, errNoSigningKey
+	}
+	return kr.current, nil
+}
+
+// lookup busca una clave por kid. Si no la conoce relee la base (con un mínimo entre lecturas),
+// por si fue creada por otra instancia.
+func (kr *jwtKeyring) lookup(kid string) *jwtKey {
+	kr.reloadIfOlder(keyringReloadInterval)
+	kr.mu.RLock()
+	key := kr.keys[kid]
+	kr.mu.RUnlock()
+	if key == nil {
+		kr.reloadIfOlder(keyringMissReloadInterval)
+		kr.mu.RLock()
+		key = kr.keys[kid]
+		kr.mu.RUnlock()
+	}
+	return key
+}
+
+// verificationKey es el jwt.Keyfunc de los access tokens: elige la clave por `kid` y exige
+// que el algoritmo del token sea el de la clave, para que no se pueda cambiar el algoritmo.
+func (kr *jwtKeyring) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = legacyKeyID
+	}
+	key := kr.lookup(kid)
+	if key == nil {
+		return nil, fmt.Errorf("clave de firma desconocida")
+	}
+	if key.expired() {
+		return nil, fmt.Errorf("clave de firma retirada")
+	}
+	if token.Method.Alg() != key.alg {
+		return nil, fmt.Errorf("algoritmo de firma inesperado")
+	}
+	return key.verifyKey, nil
+}
+
+// signAccessToken firma los claims con la clave activa e incluye su `kid` en el header
+func signAccessToken(claims jwt.Claims) (string, error) {
+	key, err := keyring.signingKey()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.signKey)
+}
+
+// decodeJWTKey arma una clave a partir de lo guardado en jwt_keys. La clave pública de los
+// algoritmos asimétricos se deriva de la privada; public_key se guarda para consultarla desde afuera.
+func decodeJWTKey(kid, alg string, secret sql.NullString) (*jwtKey, error) {
+	key := &jwtKey{kid: kid, alg: alg}
+	switch alg {
+	case jwtAlgHS256:
+		if kid == legacyKeyID && !secret.Valid {
+			// La clave legada no se copia a la base, se sigue leyendo de data.conf
+			if len(legacySecret) == 0 {
+				return nil, fmt.Errorf("JWT_KEY no está configurado")
+			}
+			key.signKey, key.verifyKey = legacySecret, legacySecret
+			return key, nil
+		}
+		raw, err := base64.StdEncoding.DecodeString(secret.String)
+		if err != nil || len(raw) == 0 {
+			return nil, fmt.Errorf("secreto inválido")
+		}
+		key.signKey, key.verifyKey = raw, raw
+	case jwtAlgRS256, jwtAlgEdDSA:
+		block, _ := pem.Decode([]byte(secret.String))
+		if block == nil {
+			return nil, fmt.Errorf("clave privada inválida")
+		}
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch private := private.(type) {
+		case *rsa.PrivateKey:
+			if alg != jwtAlgRS256 {
+				return nil, fmt.Errorf("la clave no corresponde al algoritmo %s", alg)
+			}
+			key.signKey, key.verifyKey = private, &private.PublicKey
+		case ed25519.PrivateKey:
+			if alg != jwtAlgEdDSA {
+				return nil, fmt.Errorf("la clave no corresponde al algoritmo %s", alg)
+			}
+			key.signKey, key.verifyKey = private, private.Public().(ed25519.PublicKey)
+		default:
+			return nil, fmt.Errorf("tipo de clave no soportado")
+		}
+	default:
+		return nil, fmt.Errorf("algoritmo no soportado: %s", alg)
+	}
+	return key, nil
+}
+
+// generateJWTKey crea el material de una clave nueva: el secreto (base64 o PEM PKCS#8) y,
+// para los algoritmos asimétricos, la clave pública en PEM
+func generateJWTKey(alg string) (secret string, publicKey sql.NullString, err error) {
+	var private interface{}
+	var public interface{}
+	switch alg {
+	case jwtAlgHS256:
+		raw := make([]byte, 32)
+		if _, err := rand.Read(raw); err != nil {
+			return "", publicKey, err
+		}
+		return base64.StdEncoding.EncodeToString(raw), publicKey, nil
+	case jwtAlgRS256:
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return "", publicKey, err
+		}
+		private, public = rsaKey, &rsaKey.PublicKey
+	case jwtAlgEdDSA:
+		edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return "", publicKey, err
+		}
+		private, public = edPrivate, edPublic
+	default:
+		return "", publicKey, fmt.Errorf("algoritmo no soportado: %s (HS256, RS256 o EdDSA)", alg)
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return "", publicKey, err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return "", publicKey, err
+	}
+	secret = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}))
+	publicKey = sql.NullString{String: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})), Valid: true}
+	return secret, publicKey, nil
+}
+
+// rotateJWTKey crea una clave activa nueva. La anterior deja de firmar pero sigue validando
+// tokens durante grace. En la primera rotación JWT_KEY entra al keyring como clave retirada.
+func rotateJWTKey(ctx context.Context, alg string, grace time.Duration) (string, error) {
+	secret, publicKey, err := generateJWTKey(alg)
+	if err != nil {
+		return "", err
+	}
+	kid, err := generateToken(8)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	err = dataBase.WithTx(ctx, func(tx *database.Tx) error {
+		var count int
+		row, err := tx.SelectRow("SELECT COUNT(*) FROM jwt_keys")
+		if err != nil {
+			return err
+		}
+		if err := row.Scan(&count); err != nil {
+			return err
+		}
+
+		if count == 0 && len(legacySecret) > 0 {
+			_, err = tx.Insert(false, "INSERT INTO jwt_keys (kid, algorithm, active, retired_at, verify_until) VALUES (?, ?, 0, ?, ?)",
+				legacyKeyID, jwtAlgHS256, database.FormatTime(now), database.FormatTime(now.Add(grace)))
+		} else {
+			_, err = tx.Update(false, "UPDATE jwt_keys SET active = 0, retired_at = ?, verify_until = ? WHERE active = 1",
+				database.FormatTime(now), database.FormatTime(now.Add(grace)))
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Insert(false, "INSERT INTO jwt_keys (kid, algorithm, secret, public_key, active) VALUES (?, ?, ?, ?, 1)",
+			kid, alg, secret, publicKey)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return kid, nil
+}
+
+// runKeys maneja los subcomandos `magpanel keys list|rotate|prune`
+func runKeys(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("uso: magpanel keys list|rotate [-alg HS256|RS256|EdDSA] [-grace duración]|prune")
+	}
+
+	switch args[0] {
+	case "list":
+		rows, err := dataBase.Select("SELECT kid, algorithm, active, created_at, retired_at, verify_until FROM jwt_keys ORDER BY created_at, kid")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		found := false
+		for rows.Next() {
+			var kid, alg, createdAt string
+			var active bool
+			var retiredAt, verifyUntil sql.NullString
+			if err := rows.Scan(&kid, &alg, &active, &createdAt, &retiredAt, &verifyUntil); err != nil {
+				return err
+			}
+			found = true
+			if active {
+				fmt.Printf("[activa]   %s %s (creada %s)\n", kid, alg, createdAt)
+			} else {
+				fmt.Printf("[retirada] %s %s (retirada %s, valida tokens hasta %s)\n", kid, alg, retiredAt.String, verifyUntil.String)
+			}
+		}
+		if !found {
+			fmt.Println("No hay claves rotadas, se firma con JWT_KEY de data.conf")
+		}
+		return rows.Err()
+
+	case "rotate":
+		fs := flag.NewFlagSet("keys rotate", flag.ContinueOnError)
+		alg := fs.String("alg", jwtAlgHS256, "Algoritmo de la clave nueva: HS256, RS256 o EdDSA")
+		// Las instancias en marcha pueden seguir firmando con la clave anterior hasta releer el keyring
+		minGrace := accessTokenTTL + keyringReloadInterval
+		grace := fs.Duration("grace", minGrace, "Tiempo durante el que la clave anterior sigue validando tokens")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *grace < minGrace {
+			fmt.Printf("Aviso: la gracia es menor que ACCESS_TOKEN_TTL más %s (%s), algunos access tokens vigentes se rechazarán antes de vencer\n", keyringReloadInterval, minGrace)
+		}
+		kid, err := rotateJWTKey(context.Background(), *alg, *grace)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Clave %s (%s) activa; la anterior valida tokens durante %s\n", kid, *alg, *grace)
+		return nil
+
+	case "prune":
+		deleted, err := dataBase.Delete(true, "DELETE FROM jwt_keys WHERE active = 0 AND verify_until < ?", database.FormatTime(time.Now()))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Claves eliminadas: %d\n", deleted)
+		return nil
+	}
+
+	return fmt.Errorf("subcomando de keys desconocido: %s", args[0])
+}
+
+// jwk es una clave pública en formato JSON Web Key (RFC 7517)
+type jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// getJWKS publica las claves públicas vigentes para que otros servicios validen los access
+// tokens. Las claves HS256 son secretas y no se publican.
+func getJWKS(w http.ResponseWriter, r *http.Request) {
+	keyring.reloadIfOlder(keyringReloadInterval)
+
+	keys := []jwk{}
+	keyring.mu.RLock()
+	for _, key := range keyring.keys {
+		if key.expired() {
+			continue
+		}
+		switch public := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, jwk{Kty: "RSA", Use: "sig", Alg: key.alg, Kid: key.kid,
+				N: base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())})
+		case ed25519.PublicKey:
+			keys = append(keys, jwk{Kty: "OKP", Use: "sig", Alg: key.alg, Kid: key.kid, Crv: "Ed25519",
+				X: base64.RawURLEncoding.EncodeToString(public)})
+		}
+	}
+	keyring.mu.RUnlock()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Kid < keys[j].Kid })
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+// signingMethodEdDSA agrega Ed25519 (RFC 8037) a golang-jwt, que en v3 no lo trae
+type signingMethodEdDSA struct{}
+
+func (m *signingMethodEdDSA) Alg() string {
+	return jwtAlgEdDSA
+}
+
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(private, []byte(signingString))), nil
+}
+
+func (m *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(public, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+func init() {
+	jwt.RegisterSigningMethod(jwtAlgEdDSA, func() jwt.SigningMethod {
+		return &signingMethodEdDSA{}
+	})
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// sessionClaims abre una sesión para el usuario y devuelve los claims de un access token de esa
+// sesión, para firmarlos con otras claves
+func sessionClaims(t *testing.T, userID int) jwt.MapClaims {
+	t.Helper()
+	claims, err := parseAccessToken(testToken(t, userID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jwt.MapClaims{"user_id": userID, "sid": claims.SessionID, "exp": time.Now().Add(accessTokenTTL).Unix()}
+}
+
+// rotateTestKey rota la clave de firma y relee el keyring, como lo haría una instancia al
+// vencer keyringReloadInterval
+func rotateTestKey(t *testing.T, alg string, grace time.Duration) string {
+	t.Helper()
+	kid, err := rotateJWTKey(context.Background(), alg, grace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.reload(); err != nil {
+		t.Fatal(err)
+	}
+	return kid
+}
+
+func TestTokenWithoutKidUsesLegacySecret(t *testing.T) {
+	newTestDatabase(t)
+	claims := sessionClaims(t, createTestUser(t, "ana", 1))
+
+	// Los tokens emitidos antes del keyring no traen `kid`
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(legacySecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseAccessToken(token); err != nil {
+		t.Errorf("token sin kid firmado con JWT_KEY: %v", err)
+	}
+
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("otra-clave"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseAccessToken(forged); err == nil {
+		t.Error("se aceptó un token sin kid firmado con otra clave")
+	}
+}
+
+func TestRetiredKeyStopsVerifying(t *testing.T) {
+	newTestDatabase(t)
+	userID := createTestUser(t, "ana", 1)
+	before := testToken(t, userID)
+
+	rotateTestKey(t, jwtAlgEdDSA, time.Hour)
+	after := testToken(t, userID)
+	if _, err := parseAccessToken(before); err != nil {
+		t.Fatalf("el token firmado con la clave retirada no sirve durante la gracia: %v", err)
+	}
+
+	// Vencida la gracia la clave retirada ya no valida, la activa sí
+	if _, err := dataBase.Update(false, "UPDATE jwt_keys SET verify_until = ? WHERE active = 0", "2000-01-01 00:00:00"); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseAccessToken(before); err == nil {
+		t.Error("se aceptó un token firmado con una clave retirada después de verify_until")
+	}
+	if _, err := parseAccessToken(after); err != nil {
+		t.Errorf("el token de la clave activa dejó de servir: %v", err)
+	}
+}
+
+func TestVerificationKeyRejectsAlgorithmConfusion(t *testing.T) {
+	newTestDatabase(t)
+	claims := sessionClaims(t, createTestUser(t, "ana", 1))
+	kid := rotateTestKey(t, jwtAlgRS256, time.Hour)
+
+	var publicPEM string
+	row, _ := dataBase.SelectRow("SELECT public_key FROM jwt_keys WHERE kid = ?", kid)
+	if err := row.Scan(&publicPEM); err != nil {
+		t.Fatal(err)
+	}
+
+	// HS256 con la clave pública RS256 como secreto HMAC, que cualquiera puede leer del JWKS
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = kid
+	forged, err := token.SignedString([]byte(publicPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseAccessToken(forged); err == nil {
+		t.Error("se aceptó un token HS256 firmado con la clave pública RS256")
+	}
+
+	// El kid de JWT_KEY tampoco acepta otro algoritmo
+	token = jwt.NewWithClaims(jwt.SigningMethodHS512, claims)
+	token.Header["kid"] = legacyKeyID
+	forged, err = token.SignedString(legacySecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseAccessToken(forged); err == nil {
+		t.Error("se aceptó un token HS512 con el kid de JWT_KEY")
+	}
+
+	if _, err := keyring.verificationKey(&jwt.Token{Header: map[string]interface{}{"kid": kid}, Method: jwt.SigningMethodRS256}); err != nil {
+		t.Errorf("verificationKey rechazó el algoritmo de la clave: %v", err)
+	}
+}
+
+func TestJWKSNeverPublishesSecrets(t *testing.T) {
+	newTestDatabase(t)
+	router := initRoutes()
+	jwks := func() (string, []map[string]interface{}) {
+		t.Helper()
+		w := doRequest(t, router, "GET", "/.well-known/jwks.json", "", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /.well-known/jwks.json = %d", w.Code)
+		}
+		var response struct {
+			Keys []map[string]interface{} `json:"keys"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		return w.Body.String(), response.Keys
+	}
+
+	// Solo con JWT_KEY no hay nada que publicar
+	if _, keys := jwks(); len(keys) != 0 {
+		t.Errorf("JWKS con solo JWT_KEY publicó %v", keys)
+	}
+
+	hsKid := rotateTestKey(t, jwtAlgHS256, time.Hour)
+	rsKid := rotateTestKey(t, jwtAlgRS256, time.Hour)
+	edKid := rotateTestKey(t, jwtAlgEdDSA, time.Hour)
+	var hsSecret string
+	row, _ := dataBase.SelectRow("SELECT secret FROM jwt_keys WHERE kid = ?", hsKid)
+	if err := row.Scan(&hsSecret); err != nil {
+		t.Fatal(err)
+	}
+
+	body, keys := jwks()
+	published := map[string]string{}
+	for _, key := range keys {
+		kid, _ := key["kid"].(string)
+		kty, _ := key["kty"].(string)
+		published[kid] = kty
+		if _, ok := key["k"]; ok || kty == "oct" {
+			t.Errorf("JWKS publicó una clave simétrica: %v", key)
+		}
+	}
+	if len(published) != 2 || published[rsKid] != "RSA" || published[edKid] != "OKP" {
+		t.Errorf("JWKS publicó %v, se esperaban solo %s (RSA) y %s (OKP)", published, rsKid, edKid)
+	}
+	for _, secret := range []string{hsSecret, string(legacySecret), base64.RawURLEncoding.EncodeToString(legacySecret)} {
+		if strings.Contains(body, secret) {
+			t.Errorf("el JWKS contiene un secreto HS256")
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"magpanel/database"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxListLimit = 1000
+
+type filterKind int
+
+const (
+	filterInt    filterKind = iota // ?status_id=3
+	filterString                   // ?type=create_project
+	filterAfter                    // ?created_after=2024-01-31 (>=)
+	filterBefore                   // ?created_before=2024-01-31 (<)
+	filterBool                     // ?blocked=true
+)
+
+// listFilter asocia un parámetro de la query string con una columna SQL
+type listFilter struct {
+	column string
+	kind   filterKind
+}
+
+// listSpec describe cómo se lista un recurso: de dónde sale, por qué columnas
+// se puede ordenar y qué filtros acepta. Nada fuera de estos mapas llega al SQL.
+type listSpec struct {
+	from         string                // FROM ... JOIN ..., sin WHERE
+	sortable     map[string]string     // nombre público -> columna SQL
+	filters      map[string]listFilter // parámetro -> filtro
+	defaultOrder string                // columna SQL con dirección, ej: "p.id DESC"
+}
+
+// listQuery es el resultado de validar los parámetros de un listado
+type listQuery struct {
+	spec   listSpec
+	where  []string
+	args   []interface{}
+	order  string
+	limit  int // 0 = sin límite
+	offset int
+}
+
+// parseListQuery valida order, limit, offset y los filtros del request contra la spec.
+// order acepta "campo", "campo,asc|desc", "campo asc|desc" o "-campo".
+func parseListQuery(r *http.Request, spec listSpec) (*listQuery, error) {
+	query := r.URL.Query()
+	q := &listQuery{spec: spec, order: spec.defaultOrder}
+
+	if order := strings.TrimSpace(query.Get("order")); order != "" {
+		field, direction, err := parseOrder(order)
+		if err != nil {
+			return nil, err
+		}
+		column, ok := spec.sortable[field]
+		if !ok {
+			return nil, fmt.Errorf("no se puede ordenar por %q", field)
+		}
+		q.order = column + " " + direction
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxListLimit {
+			return nil, fmt.Errorf("limit debe ser un número entre 1 y %d", maxListLimit)
+		}
+		q.limit = n
+	}
+	if offset := query.Get("offset"); offset != "" {
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("offset debe ser un número mayor o igual a 0")
+		}
+		q.offset = n
+	}
+
+	for param, filter := range spec.filters {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		switch filter.kind {
+		case filterInt:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("el filtro %s debe ser numérico", param)
+			}
+			q.Where(filter.column+" = ?", n)
+		case filterString:
+			q.Where(filter.column+" = ?", value)
+		case filterBool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("el filtro %s debe ser true o false", param)
+			}
+			if b {
+				q.Where(filter.column + " = 1")
+			} else {
+				q.Where(filter.column + " = 0")
+			}
+		case filterAfter, filterBefore:
+			t, err := parseFilterDate(value)
+			if err != nil {
+				return nil, fmt.Errorf("el filtro %s debe ser una fecha (YYYY-MM-DD o RFC3339)", param)
+			}
+			op := " >= ?"
+			if filter.kind == filterBefore {
+				op = " < ?"
+			}
+			q.Where(filter.column+op, database.FormatTime(t))
+		}
+	}
+
+	return q, nil
+}
+
+func parseOrder(order string) (string, string, error) {
+	direction := "ASC"
+	field := order
+	if strings.HasPrefix(order, "-") {
+		field, direction = order[1:], "DESC"
+	} else if parts := strings.FieldsFunc(order, func(r rune) bool { return r == ',' || r == ' ' }); len(parts) == 2 {
+		field = parts[0]
+		switch strings.ToLower(parts[1]) {
+		case "asc":
+		case "desc":
+			direction = "DESC"
+		default:
+			return "", "", fmt.Errorf("dirección de orden inválida: %s", parts[1])
+		}
+	} else if len(parts) > 2 {
+		return "", "", fmt.Errorf("orden inválido: %s", order)
+	}
+
+	// se acepta el alias de tabla que mandaban los clientes viejos (p.created_at)
+	if i := strings.LastIndex(field, "."); i >= 0 {
+		field = field[i+1:]
+	}
+	return field, direction, nil
+}
+
+func parseFilterDate(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02 15:04:05", value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// Where agrega una condición fija (ej: el project_id de la URL) a la consulta
+func (q *listQuery) Where(condition string, args ...interface{}) {
+	q.where = append(q.where, condition)
+	q.args = append(q.args, args...)
+}
+
+func (q *listQuery) whereClause() string {
+	if len(q.where) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(q.where, " AND ")
+}
+
+// selectQuery arma la consulta completa a partir de las columnas del SELECT
+func (q *listQuery) selectQuery(selectColumns string) (string, []interface{}) {
+	query := selectColumns + " " + q.spec.from + q.whereClause()
+	if q.order != "" {
+		query += " ORDER BY " + q.order
+	}
+
+	args := append([]interface{}{}, q.args...)
+	if q.limit > 0 || q.offset > 0 {
+		limit := q.limit
+		if limit == 0 {
+			limit = maxListLimit
+		}
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, q.offset)
+	}
+	return query, args
+}
+
+// count devuelve el total de filas que cumplen los filtros, sin limit ni offset
+func (q *listQuery) count() (int, error) {
+	row, err := dataBase.SelectRow("SELECT COUNT(*) "+q.spec.from+q.whereClause(), q.args...)
+	if err != nil {
+		return 0, err
+	}
+	var total int
+	err = row.Scan(&total)
+	return total, err
+}
+
+// writeTotalCount calcula el total del listado y lo devuelve en el encabezado X-Total-Count
+func writeTotalCount(w http.ResponseWriter, q *listQuery) error {
+	total, err := q.count()
+	if err != nil {
+		return err
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	return nil
+}
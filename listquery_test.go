@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+var testListSpec = listSpec{
+	from: "FROM projects p",
+	sortable: map[string]string{
+		"id":         "p.id",
+		"created_at": "p.created_at",
+	},
+	filters: map[string]listFilter{
+		"status_id":     {"p.status_id", filterInt},
+		"type":          {"p.type", filterString},
+		"blocked":       {"p.blocked", filterBool},
+		"created_after": {"p.created_at", filterAfter},
+	},
+	defaultOrder: "p.id DESC",
+}
+
+func TestParseListQuery(t *testing.T) {
+	cases := []struct {
+		query string
+		want  string
+		args  []interface{}
+	}{
+		{"", "SELECT p.id FROM projects p ORDER BY p.id DESC", []interface{}{}},
+		{"order=created_at", "SELECT p.id FROM projects p ORDER BY p.created_at ASC", []interface{}{}},
+		{"order=-created_at", "SELECT p.id FROM projects p ORDER BY p.created_at DESC", []interface{}{}},
+		{"order=p.created_at,desc", "SELECT p.id FROM projects p ORDER BY p.created_at DESC", []interface{}{}},
+		{"order=id%20asc&limit=10&offset=20", "SELECT p.id FROM projects p ORDER BY p.id ASC LIMIT ? OFFSET ?", []interface{}{10, 20}},
+		{"offset=5", "SELECT p.id FROM projects p ORDER BY p.id DESC LIMIT ? OFFSET ?", []interface{}{maxListLimit, 5}},
+		{"status_id=3", "SELECT p.id FROM projects p WHERE p.status_id = ? ORDER BY p.id DESC", []interface{}{3}},
+		{"blocked=false", "SELECT p.id FROM projects p WHERE p.blocked = 0 ORDER BY p.id DESC", []interface{}{}},
+		{"created_after=2024-01-31", "SELECT p.id FROM projects p WHERE p.created_at >= ? ORDER BY p.id DESC", []interface{}{"2024-01-31 00:00:00"}},
+		{"created_after=2024-01-31T10:00:00-03:00", "SELECT p.id FROM projects p WHERE p.created_at >= ? ORDER BY p.id DESC", []interface{}{"2024-01-31 13:00:00"}},
+		// los parámetros que no están en la spec se ignoran
+		{"name=x'%20OR%201=1", "SELECT p.id FROM projects p ORDER BY p.id DESC", []interface{}{}},
+	}
+	for _, c := range cases {
+		q, err := parseListQuery(httptest.NewRequest("GET", "/projects?"+c.query, nil), testListSpec)
+		if err != nil {
+			t.Errorf("%s: %v", c.query, err)
+			continue
+		}
+		query, args := q.selectQuery("SELECT p.id")
+		if query != c.want || !reflect.DeepEqual(args, c.args) {
+			t.Errorf("%s: %s %v, se esperaba %s %v", c.query, query, args, c.want, c.args)
+		}
+	}
+}
+
+func TestParseListQueryRejects(t *testing.T) {
+	for _, query := range []string{
+		"order=name",
+		"order=id%20DROP%20TABLE",
+		"order=id,sideways",
+		"order=id,asc,desc",
+		"limit=0",
+		"limit=1001",
+		"limit=abc",
+		"offset=-1",
+		"status_id=abc",
+		"blocked=maybe",
+		"created_after=31/01/2024",
+	} {
+		if _, err := parseListQuery(httptest.NewRequest("GET", "/projects?"+query, nil), testListSpec); err == nil {
+			t.Errorf("%s: se esperaba un error", query)
+		}
+	}
+}
+
+func TestListQueryWhereKeepsArgumentOrder(t *testing.T) {
+	q, err := parseListQuery(httptest.NewRequest("GET", "/projects?type=obra", nil), testListSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Where("p.client_id = ?", 7)
+	query, args := q.selectQuery("SELECT p.id")
+	want := "SELECT p.id FROM projects p WHERE p.type = ? AND p.client_id = ? ORDER BY p.id DESC"
+	if query != want || !reflect.DeepEqual(args, []interface{}{"obra", 7}) {
+		t.Errorf("%s %v", query, args)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"magpanel/database"
+	"net/http"
+	"time"
+)
+
+// Bloqueo progresivo de cuentas, configurable en la sección [security] de data.conf.
+// Después de loginMaxAttempts fallos seguidos la cuenta se bloquea loginLockout; cada bloqueo
+// siguiente dura el doble, hasta loginMaxLockout. Un login exitoso reinicia los contadores, y
+// los fallos se dejan de contar si pasa loginLockout sin ninguno nuevo.
+var (
+	loginMaxAttempts = 5
+	loginLockout     = 15 * time.Minute
+	loginMaxLockout  = 24 * time.Hour
+)
+
+// Motivos que se guardan en failed_logins
+const (
+	failedLoginUnknownUser = "unknown_user"
+	failedLoginBadPassword = "bad_password"
+	failedLoginBad2FA      = "bad_2fa_code"
+	failedLoginLocked      = "locked"
+)
+
+// accountLockedUntil devuelve hasta cuándo está bloqueada la cuenta, o el tiempo cero si no lo está
+func accountLockedUntil(lockedUntil sql.NullString) time.Time {
+	if !lockedUntil.Valid {
+		return time.Time{}
+	}
+	until, err := database.ParseTime(lockedUntil.String)
+	if err != nil || !time.Now().Before(until) {
+		return time.Time{}
+	}
+	return until
+}
+
+// lockoutDuration calcula la duración del bloqueo número n (1, 2, 3...)
+func lockoutDuration(n int) time.Duration {
+	d := loginLockout
+	for i := 1; i < n && d < loginMaxLockout; i++ {
+		d *= 2
+	}
+	if d > loginMaxLockout {
+		d = loginMaxLockout
+	}
+	return d
+}
+
+// recordFailedLogin guarda el intento en failed_logins y, si corresponde a un usuario existente,
+// suma el fallo a su contador y lo bloquea al llegar a loginMaxAttempts
+func recordFailedLogin(r *http.Request, username string, userID int, reason string) {
+	userAgent := r.UserAgent()
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	var nullableUserID interface{}
+	if userID != 0 {
+		nullableUserID = userID
+	}
+	if _, err := dataBase.Insert(false, "INSERT INTO failed_logins (username, user_id, ip, user_agent, reason, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		username, nullableUserID, clientIP(r), userAgent, reason, database.FormatTime(time.Now())); err != nil {
+		log.Printf("Error al registrar el login fallido de %s: %v", username, err)
+	}
+
+	// Los intentos sobre una cuenta ya bloqueada no alargan el bloqueo
+	if userID == 0 || reason == failedLoginLocked {
+		return
+	}
+
+	err := dataBase.WithTx(context.Background(), func(tx *database.Tx) error {
+		var failed, lockouts int
+		var lastFailed sql.NullString
+		row, err := tx.SelectRow("SELECT failed_login_count, lockout_count, last_failed_login_at FROM users WHERE id = ? FOR UPDATE", userID)
+		if err != nil {
+			return err
+		}
+		if err := row.Scan(&failed, &lockouts, &lastFailed); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if failedLoginsExpired(lastFailed, now) {
+			failed = 0
+		}
+		failed++
+		if failed < loginMaxAttempts {
+			_, err = tx.Update(false, "UPDATE users SET failed_login_count = ?, last_failed_login_at = ? WHERE id = ?",
+				failed, database.FormatTime(now), userID)
+			return err
+		}
+
+		lockouts++
+		until := now.Add(lockoutDuration(lockouts))
+		log.Printf("Usuario %s bloqueado hasta %s por %d intentos fallidos", username, database.FormatTime(until), failed)
+		_, err = tx.Update(false, "UPDATE users SET failed_login_count = 0, lockout_count = ?, locked_until = ?, last_failed_login_at = ? WHERE id = ?",
+			lockouts, database.FormatTime(until), database.FormatTime(now), userID)
+		return err
+	})
+	if err != nil {
+		log.Printf("Error al actualizar el contador de intentos de %s: %v", username, err)
+	}
+}
+
+// failedLoginsExpired indica si el último fallo es más viejo que loginLockout, y los fallos
+// acumulados hasta entonces ya no cuentan para el próximo bloqueo
+func failedLoginsExpired(lastFailed sql.NullString, now time.Time) bool {
+	if !lastFailed.Valid {
+		return true
+	}
+	last, err := database.ParseTime(lastFailed.String)
+	return err != nil || now.Sub(last) > loginLockout
+}
+
+// unknownUserLockedUntil imita el bloqueo para un nombre de usuario que no existe, repasando sus
+// intentos fallidos con las mismas reglas que una cuenta real. Así la respuesta 423 no revela
+// qué usuarios existen.
+func unknownUserLockedUntil(username string) time.Time {
+	rows, err := dataBase.Select("SELECT created_at FROM failed_logins WHERE username = ? AND user_id IS NULL AND reason = ? AND created_at > ? ORDER BY id",
+		username, failedLoginUnknownUser, database.FormatTime(time.Now().Add(-loginMaxLockout)))
+	if err != nil {
+		log.Printf("Error al leer los intentos fallidos de %s: %v", username, err)
+		return time.Time{}
+	}
+	defer rows.Close()
+
+	var failed, lockouts int
+	var until, last time.Time
+	for rows.Next() {
+		var createdAt string
+		if err := rows.Scan(&createdAt); err != nil {
+			log.Printf("Error al leer los intentos fallidos de %s: %v", username, err)
+			return time.Time{}
+		}
+		at, err := database.ParseTime(createdAt)
+		if err != nil {
+			continue
+		}
+		if at.Sub(last) > loginLockout {
+			failed = 0
+		}
+		last = at
+		// Los intentos durante el bloqueo se guardan como "locked" y no llegan acá
+		if failed++; failed >= loginMaxAttempts {
+			lockouts++
+			until = at.Add(lockoutDuration(lockouts))
+			failed = 0
+		}
+	}
+	if !time.Now().Before(until) {
+		return time.Time{}
+	}
+	return until
+}
+
+// resetFailedLogins reinicia los contadores después de un login completo
+func resetFailedLogins(userID interface{}) error {
+	_, err := dataBase.Update(false, "UPDATE users SET failed_login_count = 0, lockout_count = 0, locked_until = NULL WHERE id = ? AND (failed_login_count > 0 OR lockout_count > 0 OR locked_until IS NOT NULL)", userID)
+	return err
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"magpanel/database"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLockoutDuration(t *testing.T) {
+	cases := []struct {
+		n    int
+		want time.Duration
+	}{
+		{1, 15 * time.Minute},
+		{2, 30 * time.Minute},
+		{3, time.Hour},
+		{7, 16 * time.Hour},
+		{8, 24 * time.Hour},
+		{50, 24 * time.Hour},
+	}
+	for _, c := range cases {
+		if got := lockoutDuration(c.n); got != c.want {
+			t.Errorf("lockoutDuration(%d) = %s, se esperaba %s", c.n, got, c.want)
+		}
+	}
+}
+
+func TestCheckPasswordWithoutHash(t *testing.T) {
+	if ok, _ := checkPassword("", "", testPassword); ok {
+		t.Error("checkPassword aceptó una contraseña sin hash")
+	}
+	// El hash de referencia usa el costo actual, así tarda lo mismo que uno real
+	params, _, _, err := decodePHC(dummyPasswordHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.time != argon2Time || params.memory != argon2Memory || params.threads != argon2Threads {
+		t.Errorf("el hash de referencia usa %+v y no los parámetros actuales", params)
+	}
+}
+
+// loginAttempts hace n logins con la contraseña indicada y devuelve el código y el cuerpo de cada respuesta
+func loginAttempts(t *testing.T, handler http.Handler, username, password string, n int) []string {
+	t.Helper()
+	var responses []string
+	for i := 0; i < n; i++ {
+		w := doRequest(t, handler, "POST", "/login", "", fmt.Sprintf(`{"username": %q, "password": %q}`, username, password))
+		body := strings.TrimSpace(w.Body.String())
+		// La hora del bloqueo depende de cuándo se hizo cada intento
+		if i := strings.Index(body, " hasta "); i >= 0 {
+			body = body[:i]
+		}
+		responses = append(responses, fmt.Sprintf("%d %s", w.Code, body))
+	}
+	return responses
+}
+
+func TestUnknownUsersLockLikeRealAccounts(t *testing.T) {
+	newTestDatabase(t)
+	createTestUser(t, "ana", 1)
+	router := initRoutes()
+
+	existing := loginAttempts(t, router, "ana", "incorrecta", loginMaxAttempts)
+	unknown := loginAttempts(t, router, "nadie", "incorrecta", loginMaxAttempts)
+	// Con la cuenta bloqueada ni la contraseña correcta sirve
+	existing = append(existing, loginAttempts(t, router, "ana", testPassword, 1)...)
+	unknown = append(unknown, loginAttempts(t, router, "nadie", testPassword, 1)...)
+
+	for i := range existing {
+		if existing[i] != unknown[i] {
+			t.Errorf("intento %d: el usuario existente recibió %q y el inexistente %q", i+1, existing[i], unknown[i])
+		}
+	}
+	if !strings.HasPrefix(unknown[loginMaxAttempts], "423 ") {
+		t.Errorf("el usuario inexistente no quedó bloqueado: %q", unknown[loginMaxAttempts])
+	}
+}
+
+func TestUnknownUserLockedUntil(t *testing.T) {
+	newTestDatabase(t)
+	fail := func(at time.Time, n int) {
+		for i := 0; i < n; i++ {
+			if _, err := dataBase.Insert(false, "INSERT INTO failed_logins (username, ip, user_agent, reason, created_at) VALUES ('nadie', '', '', ?, ?)",
+				failedLoginUnknownUser, database.FormatTime(at)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	now := time.Now()
+	fail(now.Add(-20*time.Minute), loginMaxAttempts-1)
+	if until := unknownUserLockedUntil("nadie"); !until.IsZero() {
+		t.Fatalf("bloqueado hasta %s antes de llegar a %d intentos", until, loginMaxAttempts)
+	}
+
+	// El primer bloqueo (15 minutos) ya venció
+	fail(now.Add(-20*time.Minute), 1)
+	if until := unknownUserLockedUntil("nadie"); !until.IsZero() {
+		t.Fatalf("el primer bloqueo no venció: %s", until)
+	}
+
+	// El segundo dura el doble desde el último intento
+	fail(now.Add(-time.Minute), loginMaxAttempts)
+	until := unknownUserLockedUntil("nadie")
+	want := now.Add(-time.Minute + lockoutDuration(2))
+	if d := until.Sub(want); d < -time.Second || d > time.Second {
+		t.Errorf("bloqueado hasta %s, se esperaba %s", until, want)
+	}
+
+	if until := unknownUserLockedUntil("otro"); !until.IsZero() {
+		t.Errorf("otro nombre de usuario quedó bloqueado hasta %s", until)
+	}
+}
+
+func TestUnknownUserFailuresExpire(t *testing.T) {
+	newTestDatabase(t)
+	fail := func(at time.Time) {
+		if _, err := dataBase.Insert(false, "INSERT INTO failed_logins (username, ip, user_agent, reason, created_at) VALUES ('nadie', '', '', ?, ?)",
+			failedLoginUnknownUser, database.FormatTime(at)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// El último fallo llega después de loginLockout sin otros: los anteriores ya no cuentan
+	now := time.Now()
+	for i := 0; i < loginMaxAttempts-1; i++ {
+		fail(now.Add(-loginLockout - 2*time.Minute))
+	}
+	fail(now.Add(-time.Minute))
+	if until := unknownUserLockedUntil("nadie"); !until.IsZero() {
+		t.Errorf("bloqueado hasta %s con fallos fuera de la ventana", until)
+	}
+}
+
+func TestFailedLoginsExpire(t *testing.T) {
+	newTestDatabase(t)
+	id := createTestUser(t, "ana", 1)
+	r := httptest.NewRequest("POST", "/login", nil)
+	state := func() (failed int, locked bool) {
+		t.Helper()
+		var lockedUntil sql.NullString
+		row, _ := dataBase.SelectRow("SELECT failed_login_count, locked_until FROM users WHERE id = ?", id)
+		if err := row.Scan(&failed, &lockedUntil); err != nil {
+			t.Fatal(err)
+		}
+		return failed, !accountLockedUntil(lockedUntil).IsZero()
+	}
+	setLastFailure := func(at time.Time) {
+		t.Helper()
+		if _, err := dataBase.Update(false, "UPDATE users SET last_failed_login_at = ? WHERE id = ?", database.FormatTime(at), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < loginMaxAttempts-1; i++ {
+		recordFailedLogin(r, "ana", id, failedLoginBadPassword)
+	}
+	if failed, locked := state(); failed != loginMaxAttempts-1 || locked {
+		t.Fatalf("después de %d fallos: contador %d, bloqueada %v", loginMaxAttempts-1, failed, locked)
+	}
+
+	// Un fallo después de loginLockout sin otros vuelve a contar desde uno
+	setLastFailure(time.Now().Add(-loginLockout - time.Minute))
+	recordFailedLogin(r, "ana", id, failedLoginBadPassword)
+	if failed, locked := state(); failed != 1 || locked {
+		t.Errorf("fallo después de la ventana: contador %d, bloqueada %v; se esperaba 1 sin bloqueo", failed, locked)
+	}
+
+	// Dentro de la ventana siguen sumando hasta el bloqueo
+	for i := 1; i < loginMaxAttempts; i++ {
+		setLastFailure(time.Now().Add(-loginLockout + time.Minute))
+		recordFailedLogin(r, "ana", id, failedLoginBadPassword)
+	}
+	if _, locked := state(); !locked {
+		t.Error("la cuenta no se bloqueó con los fallos dentro de la ventana")
+	}
+}
+
+func TestServiceAccountCannotLogIn(t *testing.T) {
+	newTestDatabase(t)
+	id := createTestUser(t, "integracion", 1)
+	if _, err := dataBase.Update(false, "UPDATE users SET service_account = 1 WHERE id = ?", id); err != nil {
+		t.Fatal(err)
+	}
+	router := initRoutes()
+
+	service := loginAttempts(t, router, "integracion", testPassword, 1)
+	unknown := loginAttempts(t, router, "nadie", testPassword, 1)
+	if service[0] != unknown[0] {
+		t.Errorf("la cuenta de servicio recibió %q y un usuario inexistente %q", service[0], unknown[0])
+	}
+}
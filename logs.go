@@ -6,6 +6,12 @@ import (
 )
 
 func insertLog(logType, oldValue, newValue string, r *http.Request) error {
+	return insertProjectLog(0, logType, oldValue, newValue, r)
+}
+
+// insertProjectLog registra un evento asociado a un proyecto para que aparezca en su línea de
+// tiempo; con projectID 0 el registro no se asocia a ninguno
+func insertProjectLog(projectID int, logType, oldValue, newValue string, r *http.Request) error {
 	user, err := getCurrentUser(r) // Asumo que getCurrentUser devuelve (*User, error)
 	if err != nil {
 		// Maneja el error de no poder obtener el usuario, puede que no esté autorizado o el token no sea válido
@@ -17,8 +23,19 @@ func insertLog(logType, oldValue, newValue string, r *http.Request) error {
 		return fmt.Errorf("usuario no encontrado o no autorizado")
 	}
 
+	var project interface{}
+	if projectID != 0 {
+		project = projectID
+	}
+
+	// Durante una suplantación la acción se registra a nombre del administrador que la hizo
+	if user.Impersonator != nil {
+		_, err = dataBase.Insert(true, "INSERT INTO logs (`type`, `old_value`, `new_value`, `user_id`, `impersonated_user_id`, `project_id`) VALUES (?, ?, ?, ?, ?, ?)", logType, oldValue, newValue, user.Impersonator.ID, user.ID, project)
+		return err
+	}
+
 	// Preparar la sentencia SQL para insertar el registro
-	_, err = dataBase.Insert(true, "INSERT INTO logs (`type`, `old_value`, `new_value`, `user_id`) VALUES (?, ?, ?, ?)", logType, oldValue, newValue, user.ID)
+	_, err = dataBase.Insert(true, "INSERT INTO logs (`type`, `old_value`, `new_value`, `user_id`, `project_id`) VALUES (?, ?, ?, ?, ?)", logType, oldValue, newValue, user.ID, project)
 	return err
 
 }
@@ -10,7 +10,6 @@ import (
 
 	"gopkg.in/ini.v1"
 
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 )
@@ -19,16 +18,53 @@ var totalRequests int
 var uptime time.Time
 var dataBase *database.DatabaseStruct
 var minioClient *minio.Client
-var jwtKey []byte
 var bucketName string
 
 func main() {
-	initConfig()
-	defer dataBase.Close()
 	var port string
 	flag.StringVar(&port, "port", "3001", "Define el puerto en el que el servidor debería escuchar")
 	flag.Parse()
 
+	initConfig()
+	defer dataBase.Close()
+
+	// Subcomandos de línea de comandos
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if flag.Arg(0) == "keys" {
+		if err := runKeys(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if flag.Arg(0) == "passwords" {
+		if err := runPasswords(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if flag.Arg(0) == "users" {
+		if err := runUsers(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if flag.Arg(0) == "projects" {
+		if err := runProjects(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// No servimos con un esquema desactualizado
+	if err := checkSchema(); err != nil {
+		log.Fatal(err)
+	}
+
 	r := initRoutes()
 	uptime = time.Now()
 
@@ -48,32 +84,75 @@ func initConfig() {
 
 	// Leer las propiedades de la sección "database"
 	dataSection := cfg.Section("keys")
-	jwtKey = []byte(dataSection.Key("JWT_KEY").String())
+	legacySecret = []byte(dataSection.Key("JWT_KEY").String())
+	accessTokenTTL = dataSection.Key("ACCESS_TOKEN_TTL").MustDuration(accessTokenTTL)
+	refreshTokenTTL = dataSection.Key("REFRESH_TOKEN_TTL").MustDuration(refreshTokenTTL)
+	securitySection := cfg.Section("security")
+	loginMaxAttempts = securitySection.Key("LOGIN_MAX_ATTEMPTS").MustInt(loginMaxAttempts)
+	loginLockout = securitySection.Key("LOGIN_LOCKOUT").MustDuration(loginLockout)
+	loginMaxLockout = securitySection.Key("LOGIN_MAX_LOCKOUT").MustDuration(loginMaxLockout)
+	trustProxyHeaders = securitySection.Key("TRUST_PROXY_HEADERS").MustBool(false)
+	recoveryTokenTTL = securitySection.Key("RECOVERY_TOKEN_TTL").MustDuration(recoveryTokenTTL)
+	invitationTTL = securitySection.Key("INVITATION_TTL").MustDuration(invitationTTL)
+	impersonationTTL = securitySection.Key("IMPERSONATION_TTL").MustDuration(impersonationTTL)
+	emailChangeTokenTTL = securitySection.Key("EMAIL_CHANGE_TOKEN_TTL").MustDuration(emailChangeTokenTTL)
+	passwordMinLength = securitySection.Key("PASSWORD_MIN_LENGTH").MustInt(passwordMinLength)
+	argon2Time = uint32(securitySection.Key("ARGON2_TIME").MustUint(uint(argon2Time)))
+	argon2Memory = uint32(securitySection.Key("ARGON2_MEMORY").MustUint(uint(argon2Memory)))
+	argon2Threads = uint8(securitySection.Key("ARGON2_THREADS").MustUint(uint(argon2Threads)))
+	if argon2Time == 0 || argon2Memory < 8*uint32(argon2Threads) || argon2Threads == 0 {
+		log.Fatal("Parámetros de argon2 inválidos en la sección [security]")
+	}
+	oidcSection := cfg.Section("oidc")
+	oidcSettings.Enabled = oidcSection.Key("ENABLED").MustBool(false)
+	oidcSettings.Issuer = oidcSection.Key("ISSUER").String()
+	oidcSettings.ClientID = oidcSection.Key("CLIENT_ID").String()
+	oidcSettings.ClientSecret = oidcSection.Key("CLIENT_SECRET").String()
+	oidcSettings.RedirectURL = oidcSection.Key("REDIRECT_URL").String()
+	oidcSettings.FrontendURL = oidcSection.Key("FRONTEND_URL").String()
+	if scopes := oidcSection.Key("SCOPES").Strings(" "); len(scopes) > 0 {
+		oidcSettings.Scopes = scopes
+	}
+	oidcSettings.Provision = oidcSection.Key("PROVISION").MustBool(false)
+	oidcSettings.DefaultRank = oidcSection.Key("DEFAULT_RANK").MustInt(0)
+	oidcSettings.GroupsClaim = oidcSection.Key("GROUPS_CLAIM").MustString(oidcSettings.GroupsClaim)
+	oidcSettings.RequireEmailVerified = oidcSection.Key("REQUIRE_EMAIL_VERIFIED").MustBool(true)
+	if oidcSettings.GroupRoles, err = parseGroupRoles(oidcSection.Key("GROUP_ROLES").String()); err != nil {
+		log.Fatal(err)
+	}
+	if oidcSettings.Enabled && (oidcSettings.Issuer == "" || oidcSettings.ClientID == "" || oidcSettings.RedirectURL == "") {
+		log.Fatal("La sección [oidc] requiere ISSUER, CLIENT_ID y REDIRECT_URL")
+	}
 	dbSection := cfg.Section("database")
-	username := dbSection.Key("DB_USER").String()
-	password := dbSection.Key("DB_PASS").String()
-	host := dbSection.Key("DB_HOST").String()
-	databaseName := dbSection.Key("DB_NAME").String()
+	dbConfig := database.Config{
+		Driver: dbSection.Key("DB_DRIVER").MustString(database.DriverMySQL),
+		User:   dbSection.Key("DB_USER").String(),
+		Pass:   dbSection.Key("DB_PASS").String(),
+		Host:   dbSection.Key("DB_HOST").String(),
+		Name:   dbSection.Key("DB_NAME").String(),
+		Path:   dbSection.Key("DB_PATH").String(),
+	}
 	endpoint := dataSection.Key("ENDPOINT").String()
 	accessKeyID := dataSection.Key("ACCESS_KEY_ID").String()
 	secretAccessKey := dataSection.Key("SECRET_ACCESS_KEY").String()
 	bucketName = dataSection.Key("BUCKET_NAME").String()
 
-	// Inicializa un cliente de DigitalOcean Spaces
-	minioClient, err = minio.New(endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(accessKeyID, secretAccessKey, ""),
-		Secure: true,
-	})
-	if err != nil {
-		log.Fatalln(err)
-	}
-	minioClient.SetAppInfo("magpanel", "1.0.0")
-	if err != nil {
-		log.Fatalln(err)
+	// Inicializa un cliente de DigitalOcean Spaces (opcional en desarrollo local)
+	if endpoint != "" {
+		minioClient, err = minio.New(endpoint, &minio.Options{
+			Creds:  credentials.NewStaticV4(accessKeyID, secretAccessKey, ""),
+			Secure: true,
+		})
+		if err != nil {
+			log.Fatalln(err)
+		}
+		minioClient.SetAppInfo("magpanel", "1.0.0")
+	} else {
+		log.Println("ENDPOINT no configurado, los adjuntos quedan deshabilitados")
 	}
 
 	// Inicializar la base de datos
-	dataBase, err = database.NewDatabase(username, password, databaseName, host)
+	dataBase, err = database.Open(dbConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
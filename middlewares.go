@@ -1,18 +1,47 @@
 package main
 
 import (
+	"context"
 	"magpanel/models"
 	"net/http"
 	"strings"
+	"time"
 
-	"github.com/golang-jwt/jwt"
 	"golang.org/x/time/rate"
 )
 
-var limiter = rate.NewLimiter(1, 3) // Permite 1 solicitud por segundo con un burst de 3.
+// Límites por clave para las rutas públicas de autenticación
+var (
+	ipLimiter       = newKeyedLimiter(1, 5)                          // 1 solicitud por segundo por IP, burst de 5
+	usernameLimiter = newKeyedLimiter(rate.Every(10*time.Second), 5) // 1 intento cada 10 segundos por usuario, burst de 5
+)
+
+type contextKey string
+
+// currentUserKey guarda en el contexto del request el usuario autenticado por AuthMiddleware
+const currentUserKey contextKey = "currentUser"
+
+// withCurrentUser deja el usuario en el contexto en rutas públicas (login), para que insertLog
+// pueda registrar el evento a su nombre
+func withCurrentUser(r *http.Request, user *models.User) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), currentUserKey, user))
+}
 
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Las integraciones se autentican con una API key en lugar de un JWT
+		if key := apiKeyFromRequest(r); key != "" {
+			user, scopes, err := authenticateAPIKey(key)
+			if err != nil {
+				http.Error(w, "No autorizado. "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+			ctx := context.WithValue(r.Context(), currentUserKey, user)
+			ctx = context.WithValue(ctx, apiKeyScopesKey, scopes)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
 		// Obtenemos el token de autorización del encabezado
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
@@ -20,12 +49,6 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		// Verificamos si el token es "token-secreto" y lo salteamos
-		if authHeader == "token-secreto" {
-			next.ServeHTTP(w, r)
-			return
-		}
-
 		// El token debe estar en el formato "Bearer {token}"
 		tokenParts := strings.Split(authHeader, " ")
 		if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
@@ -33,29 +56,29 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		// Parseamos y validamos el token
+		// Parseamos y validamos el token, incluyendo que su sesión no haya sido revocada
 		tokenString := tokenParts[1]
-		claims := &models.Claims{}
-
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
-		})
-
+		claims, err := parseAccessToken(tokenString)
 		if err != nil {
-			if err == jwt.ErrSignatureInvalid {
-				http.Error(w, "No autorizado. Token de autenticación inválido.", http.StatusUnauthorized)
-				return
-			}
 			http.Error(w, "No autorizado. Token de autenticación inválido.", http.StatusUnauthorized)
 			return
 		}
-		if !token.Valid {
-			http.Error(w, "No autorizado. Token de autenticación inválido.", http.StatusUnauthorized)
+
+		// Cargamos el usuario del token y lo dejamos en el contexto para los handlers
+		user, err := loadTokenUser(claims)
+		if err != nil {
+			http.Error(w, "No autorizado. "+err.Error(), http.StatusUnauthorized)
 			return
 		}
 
 		// Si el token es válido, pasamos al siguiente middleware o controlador
-		next.ServeHTTP(w, r)
+		ctx := context.WithValue(r.Context(), currentUserKey, user)
+		ctx = context.WithValue(ctx, sessionIDKey, claims.SessionID)
+		if user.Impersonator != nil {
+			auditImpersonatedRequest(next, w, r.WithContext(ctx))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
@@ -76,7 +99,8 @@ func CORSMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*") // Ajusta esto según tus necesidades
 		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Total-Count")
 
 		if r.Method == "OPTIONS" {
 			return // Para preflight request
@@ -88,7 +112,7 @@ func CORSMiddleware(next http.Handler) http.Handler {
 
 func RateLimit(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !limiter.Allow() {
+		if !ipLimiter.Allow(clientIP(r)) {
 			http.Error(w, "Demasiadas solicitudes, intenta de nuevo más tarde.", http.StatusTooManyRequests)
 			return
 		}
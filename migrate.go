@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// runMigrate maneja los subcomandos `magpanel migrate up|down [n]|status`
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("uso: magpanel migrate up|down [n]|status")
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := dataBase.MigrateUp()
+		for _, m := range applied {
+			fmt.Printf("Aplicada %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("La base de datos ya está actualizada")
+		}
+		return nil
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("cantidad de migraciones inválida: %s", args[1])
+			}
+			steps = n
+		}
+		reverted, err := dataBase.MigrateDown(steps)
+		for _, m := range reverted {
+			fmt.Printf("Revertida %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(reverted) == 0 {
+			fmt.Println("No hay migraciones aplicadas para revertir")
+		}
+		return nil
+
+	case "status":
+		status, err := dataBase.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			if s.Applied {
+				fmt.Printf("[x] %04d_%s (aplicada %s)\n", s.Version, s.Name, s.AppliedAt)
+			} else {
+				fmt.Printf("[ ] %04d_%s\n", s.Version, s.Name)
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("subcomando de migrate desconocido: %s", args[0])
+}
+
+// checkSchema impide levantar el servidor si hay migraciones pendientes
+func checkSchema() error {
+	pending, err := dataBase.PendingMigrations()
+	if err != nil {
+		return fmt.Errorf("no se pudo verificar el esquema de la base de datos: %v", err)
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("la base de datos tiene %d migraciones pendientes (la primera es %04d_%s), ejecuta `magpanel migrate up`", len(pending), pending[0].Version, pending[0].Name)
+	}
+	return nil
+}
@@ -15,9 +15,93 @@ type User struct {
 	Email        string         `json:"email"`
 	Name         string         `json:"name,omitempty"`          // `omitempty` para que los valores nulos no aparezcan en el JSON
 	PasswordHash string         `json:"password_hash,omitempty"` // No se incluirá en las respuestas JSON
-	RecoveryHash sql.NullString `json:"recovery_hash,omitempty"` // Maneja NULL
-	CreatedAt    time.Time      `json:"created_at,omitempty"`
-	UpdatedAt    time.Time      `json:"updated_at,omitempty"`
+	RecoveryHash sql.NullString `json:"-"`                       // Secreto, nunca se serializa
+	// Las cuentas de servicio solo se autentican con API keys, nunca con contraseña
+	ServiceAccount bool   `json:"service_account,omitempty"`
+	Status         string `json:"status,omitempty"` // active, pending (invitado que todavía no aceptó) o inactive (desactivado)
+	// Durante una suplantación, el administrador que realmente actúa; nil en una sesión normal
+	Impersonator *User     `json:"-"`
+	CreatedAt    time.Time `json:"created_at,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at,omitempty"`
+}
+
+// Profile es la vista del usuario autenticado sobre sí mismo, sin secretos
+type Profile struct {
+	ID               int             `json:"id"`
+	Username         string          `json:"username"`
+	Name             string          `json:"name"`
+	Email            string          `json:"email"`
+	PendingEmail     string          `json:"pending_email,omitempty"` // Email nuevo a la espera de confirmación
+	Rank             int             `json:"rank"`
+	Role             string          `json:"role"`
+	Permissions      []string        `json:"permissions"`
+	Preferences      json.RawMessage `json:"preferences"`
+	TwoFactorEnabled bool            `json:"two_factor_enabled"`
+	ServiceAccount   bool            `json:"service_account,omitempty"`
+	CreatedAt        string          `json:"created_at"`
+	// Presente solo cuando un administrador está viendo la API como este usuario
+	Impersonation *Impersonation `json:"impersonation,omitempty"`
+}
+
+// Impersonation describe la suplantación en curso de la sesión actual
+type Impersonation struct {
+	ImpersonatorID       int    `json:"impersonator_id"`
+	ImpersonatorUsername string `json:"impersonator_username"`
+	ExpiresAt            string `json:"expires_at"`
+}
+
+// APIKey es una credencial de máquina ligada a un usuario o cuenta de servicio.
+// Solo se guarda el hash; la clave completa se devuelve una única vez al crearla.
+type APIKey struct {
+	ID         int      `json:"id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Key        string   `json:"key,omitempty"` // Solo en la respuesta de creación
+	UserID     int      `json:"user_id"`
+	Username   string   `json:"username,omitempty"`
+	Scopes     []string `json:"scopes"`
+	ExpiresAt  string   `json:"expires_at"`
+	LastUsedAt string   `json:"last_used_at,omitempty"`
+	RevokedAt  string   `json:"revoked_at,omitempty"`
+	CreatedBy  int      `json:"created_by"`
+	CreatedAt  string   `json:"created_at,omitempty"`
+}
+
+// Invitation es una invitación para que un usuario nuevo elija su contraseña
+type Invitation struct {
+	ID         int    `json:"id"`
+	UserID     int    `json:"user_id"`
+	Username   string `json:"username"`
+	Email      string `json:"email"`
+	Status     string `json:"status"` // pending, accepted, revoked o expired
+	InvitedBy  int    `json:"invited_by"`
+	SendCount  int    `json:"send_count"`
+	LastSentAt string `json:"last_sent_at"`
+	ExpiresAt  string `json:"expires_at"`
+	AcceptedAt string `json:"accepted_at,omitempty"`
+	RevokedAt  string `json:"revoked_at,omitempty"`
+	CreatedAt  string `json:"created_at,omitempty"`
+	EmailSent  *bool  `json:"email_sent,omitempty"` // Solo al crear o reenviar
+}
+
+// FailedLogin es un intento de login fallido, para auditoría
+type FailedLogin struct {
+	ID        int    `json:"id"`
+	Username  string `json:"username"`
+	UserID    int    `json:"user_id,omitempty"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent,omitempty"`
+	Reason    string `json:"reason"` // unknown_user, bad_password, bad_2fa_code, locked
+	CreatedAt string `json:"created_at"`
+}
+
+// UserLock es el estado de bloqueo por intentos fallidos de un usuario
+type UserLock struct {
+	UserID           int    `json:"user_id"`
+	Username         string `json:"username"`
+	FailedLoginCount int    `json:"failed_login_count"`
+	LockoutCount     int    `json:"lockout_count"`
+	LockedUntil      string `json:"locked_until"`
 }
 
 type Client struct {
@@ -101,13 +185,16 @@ type ProviderContact struct {
 }
 
 type Log struct {
-	ID        int    `json:"id"`
-	Type      string `json:"type"`
-	OldValue  string `json:"old_value"`
-	NewValue  string `json:"new_value"`
-	UserID    int    `json:"user_id"`
-	Username  string `json:"username,omitempty"`
-	CreatedAt string `json:"created_at,omitempty"`
+	ID       int    `json:"id"`
+	Type     string `json:"type"`
+	OldValue string `json:"old_value"`
+	NewValue string `json:"new_value"`
+	UserID   int    `json:"user_id"` // Quien realizó la acción; en una suplantación, el administrador
+	Username string `json:"username,omitempty"`
+	// Usuario suplantado mientras se realizó la acción, si hubo suplantación
+	ImpersonatedUserID *int   `json:"impersonated_user_id,omitempty"`
+	ProjectID          *int   `json:"project_id,omitempty"` // Proyecto al que se refiere el evento, si hay uno
+	CreatedAt          string `json:"created_at,omitempty"`
 }
 type Field struct {
 	Name     string `json:"name"`
@@ -137,6 +224,8 @@ type Category struct {
 	FieldsJSON  string   `json:"-"` // Usado para escanear desde la base de datos
 	Filters     []Filter `json:"filters,omitempty"`
 	FiltersJSON string   `json:"-"` // Usado para escanear desde la base de datos
+	// Plantilla de los códigos de proyecto; vacía usa la plantilla por defecto. En PUT, nil la deja como está.
+	CodeTemplate *string `json:"code_template,omitempty"`
 }
 type Location struct {
 	ID      int     `json:"id"`
@@ -153,6 +242,248 @@ type ProjectStatus struct {
 	CategoryID   int    `json:"category_id,omitempty"`
 	CategoryName string `json:"category_name,omitempty"`
 	Order        int    `json:"order"`
+	SLAHours     *int   `json:"sla_hours"` // Horas máximas que un proyecto debería pasar en el estado; nil sin objetivo
+	Closed       bool   `json:"closed"`    // Estado final: los proyectos en él no cuentan como atrasados
+}
+
+// ProjectStatusTransition es una regla del flujo de estados de una categoría: permite pasar de
+// FromStatusID a ToStatusID
+type ProjectStatusTransition struct {
+	ID             int      `json:"id"`
+	CategoryID     int      `json:"category_id"`
+	FromStatusID   int      `json:"from_status_id"`
+	FromStatusName string   `json:"from_status_name,omitempty"`
+	ToStatusID     int      `json:"to_status_id"`
+	ToStatusName   string   `json:"to_status_name,omitempty"`
+	Roles          []string `json:"roles"` // Roles que pueden usarla; vacío para cualquiera
+	RequireComment bool     `json:"require_comment"`
+}
+
+// ProjectMember es un usuario asignado a un proyecto con su rol en él
+type ProjectMember struct {
+	ID        int    `json:"id"`
+	ProjectID int    `json:"project_id"`
+	UserID    int    `json:"user_id"`
+	Username  string `json:"username,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Role      string `json:"role"` // lead, technician o viewer
+	AddedBy   int    `json:"added_by"`
+	CreatedAt string `json:"created_at"`
+}
+
+// ProjectTask es una tarea de un proyecto con su checklist. ItemsTotal, ItemsDone y Completion
+// resumen la checklist; Items solo viene al pedir una tarea.
+type ProjectTask struct {
+	ID           int               `json:"id"`
+	ProjectID    int               `json:"project_id"`
+	Title        string            `json:"title"`
+	Description  string            `json:"description"`
+	AssigneeID   *int              `json:"assignee_id"`
+	AssigneeName string            `json:"assignee_name,omitempty"`
+	DueDate      *string           `json:"due_date"` // YYYY-MM-DD
+	Priority     string            `json:"priority"` // low, normal, high o urgent
+	Status       string            `json:"status"`   // pending, in_progress, done o cancelled
+	CreatedBy    int               `json:"created_by"`
+	CompletedAt  *string           `json:"completed_at"`
+	ItemsTotal   int               `json:"items_total"`
+	ItemsDone    int               `json:"items_done"`
+	Completion   float64           `json:"completion"` // Porcentaje, 100 si la tarea está terminada
+	Items        []ProjectTaskItem `json:"items,omitempty"`
+	CreatedAt    string            `json:"created_at"`
+	UpdatedAt    string            `json:"updated_at"`
+}
+
+// ProjectMilestone es un hito con nombre y fecha de un proyecto
+type ProjectMilestone struct {
+	ID          int     `json:"id"`
+	ProjectID   int     `json:"project_id"`
+	Name        string  `json:"name"`
+	DueDate     string  `json:"due_date"` // YYYY-MM-DD
+	Completed   bool    `json:"completed"`
+	CompletedAt *string `json:"completed_at"`
+	Overdue     bool    `json:"overdue"` // Pasó la fecha sin completarse
+	CreatedBy   int     `json:"created_by"`
+	CreatedAt   string  `json:"created_at"`
+}
+
+// OverdueSummary agrupa los proyectos atrasados o por vencer por cliente y por estado
+type OverdueSummary struct {
+	Today         string         `json:"today"`
+	DueWithinDays int            `json:"due_within_days"`
+	Total         OverdueGroup   `json:"total"`
+	ByClient      []OverdueGroup `json:"by_client"`
+	ByStatus      []OverdueGroup `json:"by_status"`
+}
+
+// OverdueGroup son los contadores de un cliente o un estado en OverdueSummary
+type OverdueGroup struct {
+	ID                int    `json:"id,omitempty"`
+	Name              string `json:"name,omitempty"`
+	OverdueProjects   int    `json:"overdue_projects"`
+	DueSoonProjects   int    `json:"due_soon_projects"`     // Vencen entre hoy y due_within_days
+	OverdueMilestones int    `json:"overdue_milestones"`    // Hitos vencidos sin completar
+	ProjectIDs        []int  `json:"project_ids,omitempty"` // Proyectos atrasados del grupo
+}
+
+// ProjectTaskItem es un ítem de la checklist de una tarea
+type ProjectTaskItem struct {
+	ID        int     `json:"id"`
+	TaskID    int     `json:"task_id"`
+	Text      string  `json:"text"`
+	Position  int     `json:"position"`
+	Done      bool    `json:"done"`
+	DoneBy    *int    `json:"done_by"`
+	DoneAt    *string `json:"done_at"`
+	CreatedAt string  `json:"created_at"`
+}
+
+// ProjectTaskSummary es el avance de las tareas de un proyecto, sin contar las canceladas.
+// Completion es el promedio del avance de cada tarea.
+type ProjectTaskSummary struct {
+	Total      int     `json:"total"`
+	Done       int     `json:"done"`
+	Completion float64 `json:"completion"`
+}
+
+// TimelineEvent es una entrada de la línea de tiempo de un proyecto. Data depende de Type:
+//
+//	project_created                    TimelineStatusChange (estado inicial)
+//	status_changed                     TimelineStatusChange
+//	project_updated                    TimelineProjectUpdate
+//	report_created, report_updated,
+//	report_deleted                     TimelineReport
+//	attachment_uploaded,
+//	attachment_removed                 TimelineAttachment
+//	member_added, member_updated,
+//	member_removed                     TimelineMember
+//	task_created, task_updated,
+//	task_deleted                       TimelineTask
+//	milestone_created,
+//	milestone_updated,
+//	milestone_deleted                  TimelineMilestone
+type TimelineEvent struct {
+	Type      string      `json:"type"`
+	SourceID  int         `json:"source_id"` // Fila de origen: historial de estados, reporte o log según Type
+	UserID    int         `json:"user_id"`
+	UserName  string      `json:"user_name,omitempty"`
+	Username  string      `json:"username,omitempty"`
+	CreatedAt string      `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// TimelineStatusChange describe un cambio de estado en la línea de tiempo
+type TimelineStatusChange struct {
+	FromStatusID   *int   `json:"from_status_id"` // nil en el estado inicial
+	FromStatusName string `json:"from_status_name,omitempty"`
+	ToStatusID     int    `json:"to_status_id"`
+	ToStatusName   string `json:"to_status_name,omitempty"`
+	Comment        string `json:"comment,omitempty"`
+}
+
+// TimelineFieldChange es un campo del proyecto, de una tarea o de un hito que cambió en una edición
+type TimelineFieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// TimelineProjectUpdate son los campos que cambió una edición del proyecto
+type TimelineProjectUpdate struct {
+	Changes []TimelineFieldChange `json:"changes"`
+}
+
+// TimelineReport describe un reporte creado, editado o borrado
+type TimelineReport struct {
+	ReportID  int             `json:"report_id"`
+	Fields    json.RawMessage `json:"fields,omitempty"`
+	OldFields json.RawMessage `json:"old_fields,omitempty"` // Solo en ediciones y borrados
+}
+
+// TimelineAttachment describe un adjunto subido o eliminado
+type TimelineAttachment struct {
+	URL    string `json:"url,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	FileID string `json:"file_id,omitempty"` // Solo en eliminaciones
+}
+
+// TimelineMember describe un alta, cambio de rol o baja de un miembro del proyecto
+type TimelineMember struct {
+	UserID  int    `json:"user_id"`
+	Role    string `json:"role,omitempty"`
+	OldRole string `json:"old_role,omitempty"` // Solo en cambios de rol y bajas
+}
+
+// TimelineTask describe una tarea creada, editada o borrada
+type TimelineTask struct {
+	TaskID  int                   `json:"task_id"`
+	Title   string                `json:"title"`
+	Changes []TimelineFieldChange `json:"changes,omitempty"` // Solo en ediciones
+}
+
+// TimelineMilestone describe un hito creado, editado o borrado
+type TimelineMilestone struct {
+	MilestoneID int                   `json:"milestone_id"`
+	Name        string                `json:"name"`
+	DueDate     string                `json:"due_date"`
+	Changes     []TimelineFieldChange `json:"changes,omitempty"` // Solo en ediciones
+}
+
+// ProjectStatusDuration es el tiempo que un proyecto pasó en uno de sus estados, sumando todas
+// las veces que estuvo en él
+type ProjectStatusDuration struct {
+	StatusID   int     `json:"status_id"`
+	StatusName string  `json:"status_name"`
+	SLAHours   *int    `json:"sla_hours"`
+	Visits     int     `json:"visits"`
+	Hours      float64 `json:"hours"`
+	MaxHours   float64 `json:"max_hours"` // La estadía más larga
+	Current    bool    `json:"current"`
+	Breached   bool    `json:"breached"` // Alguna estadía superó sla_hours
+}
+
+// ProjectSLABreach es un proyecto que lleva en su estado actual más horas que el SLA del estado
+type ProjectSLABreach struct {
+	ProjectID    int     `json:"project_id"`
+	Code         string  `json:"code"`
+	Name         string  `json:"name"`
+	CategoryID   int     `json:"category_id"`
+	CategoryName string  `json:"category_name"`
+	StatusID     int     `json:"status_id"`
+	StatusName   string  `json:"status_name"`
+	SLAHours     int     `json:"sla_hours"`
+	EnteredAt    string  `json:"entered_at"`
+	Hours        float64 `json:"hours"`
+	OverdueHours float64 `json:"overdue_hours"`
+}
+
+// ProjectStatusMetric resume el tiempo que pasan los proyectos en un estado
+type ProjectStatusMetric struct {
+	CategoryID   int     `json:"category_id"`
+	CategoryName string  `json:"category_name"`
+	StatusID     int     `json:"status_id"`
+	StatusName   string  `json:"status_name"`
+	SLAHours     *int    `json:"sla_hours"`
+	Stays        int     `json:"stays"`
+	OpenStays    int     `json:"open_stays"` // Proyectos que siguen en el estado
+	AverageHours float64 `json:"average_hours"`
+	MedianHours  float64 `json:"median_hours"`
+	MaxHours     float64 `json:"max_hours"`
+	Breaches     int     `json:"breaches"`
+}
+
+// ProjectStatusChange es una entrada del historial de estados de un proyecto
+type ProjectStatusChange struct {
+	ID             int    `json:"id"`
+	ProjectID      int    `json:"project_id"`
+	FromStatusID   *int   `json:"from_status_id"` // nil en el estado inicial
+	FromStatusName string `json:"from_status_name,omitempty"`
+	ToStatusID     int    `json:"to_status_id"`
+	ToStatusName   string `json:"to_status_name,omitempty"`
+	UserID         int    `json:"user_id"`
+	Username       string `json:"username,omitempty"`
+	Comment        string `json:"comment"`
+	CreatedAt      string `json:"created_at"`
 }
 
 type Report struct {
@@ -170,24 +501,29 @@ type Report struct {
 }
 
 type Project struct {
-	ID           int    `json:"id"`
-	Name         string `json:"name"`
-	Code         string `json:"code,omitempty"`
-	Description  string `json:"description,omitempty"`
-	CategoryID   int    `json:"category_id,omitempty"`
-	StatusID     int    `json:"status_id"`
-	LocationID   int    `json:"location_id,omitempty"`
-	AuthorID     int    `json:"author_id"`
-	ClientID     int    `json:"client_id"`
-	ClientName   string `json:"client_name,omitempty"`
-	CategoryName string `json:"category_name,omitempty"`
-	StatusName   string `json:"status_name,omitempty"`
-	LocationName string `json:"location_name,omitempty"`
-	LocationLat  string `json:"location_lat,omitempty"`
-	LocationLng  string `json:"location_lng,omitempty"`
-	AuthorName   string `json:"author_name,omitempty"`
-	CreatedAt    string `json:"created_at,omitempty"` // Asume que este campo es manejado automáticamente por la base de datos
-	UpdatedAt    string `json:"updated_at,omitempty"` // Asume que este campo es manejado automáticamente por la base de datos
+	ID            int                 `json:"id"`
+	Name          string              `json:"name"`
+	Code          string              `json:"code,omitempty"`
+	Description   string              `json:"description,omitempty"`
+	CategoryID    int                 `json:"category_id,omitempty"`
+	StatusID      int                 `json:"status_id"`
+	LocationID    int                 `json:"location_id,omitempty"`
+	AuthorID      int                 `json:"author_id"`
+	ClientID      int                 `json:"client_id"`
+	ClientName    string              `json:"client_name,omitempty"`
+	CategoryName  string              `json:"category_name,omitempty"`
+	StatusName    string              `json:"status_name,omitempty"`
+	LocationName  string              `json:"location_name,omitempty"`
+	LocationLat   string              `json:"location_lat,omitempty"`
+	LocationLng   string              `json:"location_lng,omitempty"`
+	AuthorName    string              `json:"author_name,omitempty"`
+	MemberRole    string              `json:"member_role,omitempty"` // Rol del usuario en el proyecto, en GET /users/{id}/projects
+	StartDate     *string             `json:"start_date"`            // YYYY-MM-DD
+	TargetEndDate *string             `json:"target_end_date"`       // YYYY-MM-DD
+	Overdue       bool                `json:"overdue"`               // Pasó target_end_date y el estado no es final
+	Tasks         *ProjectTaskSummary `json:"tasks,omitempty"`
+	CreatedAt     string              `json:"created_at,omitempty"` // Asume que este campo es manejado automáticamente por la base de datos
+	UpdatedAt     string              `json:"updated_at,omitempty"` // Asume que este campo es manejado automáticamente por la base de datos
 }
 type Setting struct {
 	ID          int    `json:"id"`
@@ -203,6 +539,9 @@ type SettingVal struct {
 
 // Estructura para almacenar las reclamaciones (claims) del token JWT
 type Claims struct {
-	UserID uint `json:"user_id"`
+	UserID    uint   `json:"user_id"`
+	SessionID string `json:"sid,omitempty"` // Sesión del refresh token, permite revocar el access token
+	// Con suplantación, el administrador que realmente usa el token; UserID es el usuario suplantado
+	ImpersonatorID uint `json:"imp,omitempty"`
 	jwt.StandardClaims
 }
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"magpanel/database"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// oidcConfig es la sección [oidc] de data.conf
+type oidcConfig struct {
+	Enabled      bool
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string // URL pública de GET /oidc/callback registrada en el proveedor
+	Scopes       []string
+	// Si está configurado, el callback redirige a esta URL con los tokens en el fragmento (#access_token=...)
+	// en lugar de responder JSON
+	FrontendURL string
+	// Alta automática de usuarios que no existen en MagPanel
+	Provision   bool
+	DefaultRank int
+	// Claim con los grupos del usuario y a qué rol corresponde cada grupo
+	GroupsClaim string
+	GroupRoles  map[string]string
+	// Si está activo, un email sin el claim email_verified no sirve para vincular cuentas
+	RequireEmailVerified bool
+}
+
+var oidcSettings = oidcConfig{
+	Scopes:               []string{"openid", "email", "profile"},
+	GroupsClaim:          "groups",
+	GroupRoles:           map[string]string{},
+	RequireEmailVerified: true,
+}
+
+// Validez del state entre GET /oidc/login y el callback
+const oidcStateTTL = 10 * time.Minute
+
+// Cada cuánto se vuelven a pedir el documento de discovery y las claves del proveedor
+const (
+	oidcDiscoveryTTL    = time.Hour
+	oidcJWKSMissRefresh = time.Minute
+)
+
+var errInvalidOIDCState = errors.New("state de OIDC inválido o expirado")
+
+var oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// parseGroupRoles lee GROUP_ROLES con el formato `grupo=rol,grupo=rol`
+func parseGroupRoles(value string) (map[string]string, error) {
+	groupRoles := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		group, role, found := strings.Cut(pair, "=")
+		group, role = strings.TrimSpace(group), strings.TrimSpace(role)
+		if !found || group == "" {
+			return nil, fmt.Errorf("GROUP_ROLES inválido: %q", pair)
+		}
+		if _, ok := rankForRole(role); !ok {
+			return nil, fmt.Errorf("GROUP_ROLES: rol desconocido %q", role)
+		}
+		groupRoles[group] = role
+	}
+	return groupRoles, nil
+}
+
+// oidcDiscovery es el subconjunto de /.well-known/openid-configuration que se usa
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcProvider guarda en memoria el discovery y las claves públicas del proveedor
+type oidcProvider struct {
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	discoveredAt  time.Time
+	keys          map[string]interface{}
+	keysFetchedAt time.Time
+}
+
+var oidcIdP = &oidcProvider{}
+
+func fetchJSON(rawURL string, v interface{}) error {
+	resp, err := oidcHTTPClient.Get(rawURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s respondió %d", rawURL, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// config devuelve el discovery del proveedor, pidiéndolo de nuevo si está vencido
+func (p *oidcProvider) config() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil && time.Since(p.discoveredAt) < oidcDiscoveryTTL {
+		return p.discovery, nil
+	}
+
+	var d oidcDiscovery
+	if err := fetchJSON(strings.TrimSuffix(oidcSettings.Issuer, "/")+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, fmt.Errorf("discovery de OIDC: %v", err)
+	}
+	if d.Issuer != oidcSettings.Issuer {
+		return nil, fmt.Errorf("discovery de OIDC: el issuer %q no coincide con el configurado", d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery de OIDC incompleto")
+	}
+	p.discovery = &d
+	p.discoveredAt = time.Now()
+	return p.discovery, nil
+}
+
+// key devuelve la clave pública del proveedor con ese kid. Si no la conoce vuelve a pedir el JWKS,
+// como máximo una vez por minuto, por si el proveedor rotó sus claves.
+func (p *oidcProvider) key(kid string) (interface{}, error) {
+	d, err := p.config()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok && time.Since(p.keysFetchedAt) < oidcDiscoveryTTL {
+		return key, nil
+	}
+	if time.Since(p.keysFetchedAt) < oidcJWKSMissRefresh {
+		if key, ok := p.keys[kid]; ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("clave %q desconocida del proveedor OIDC", kid)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := fetchJSON(d.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("JWKS de OIDC: %v", err)
+	}
+	keys := map[string]interface{}{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if public, err := k.publicKey(); err == nil {
+			keys[k.Kid] = public
+		}
+	}
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("clave %q desconocida del proveedor OIDC", kid)
+}
+
+// publicKey convierte un JWK en la clave pública que espera golang-jwt
+func (k jwk) publicKey() (interface{}, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("curva no soportada: %s", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		x, err := decode(k.X)
+		if err != nil || k.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("clave OKP inválida")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("tipo de clave no soportado: %s", k.Kty)
+}
+
+// oidcIdentity son los datos del usuario que se toman del id_token
+type oidcIdentity struct {
+	Subject           string
+	Email             string
+	Name              string
+	PreferredUsername string
+	Groups            []string
+}
+
+// verifyIDToken valida firma, issuer, audiencia, vencimiento y nonce del id_token
+func verifyIDToken(idToken, nonce string) (*oidcIdentity, error) {
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA, *signingMethodEdDSA:
+		default:
+			return nil, fmt.Errorf("algoritmo de firma no permitido: %s", token.Method.Alg())
+		}
+		kid, _ := token.Header["kid"].(string)
+		return oidcIdP.key(kid)
+	})
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("id_token inválido: %v", err)
+	}
+
+	now := time.Now().Unix()
+	if !claims.VerifyIssuer(oidcSettings.Issuer, true) {
+		return nil, fmt.Errorf("id_token de otro issuer")
+	}
+	if !claims.VerifyAudience(oidcSettings.ClientID, true) {
+		return nil, fmt.Errorf("id_token para otra audiencia")
+	}
+	if !claims.VerifyExpiresAt(now, true) {
+		return nil, fmt.Errorf("id_token expirado")
+	}
+	if claimNonce, _ := claims["nonce"].(string); claimNonce != nonce {
+		return nil, fmt.Errorf("nonce del id_token inválido")
+	}
+
+	identity := &oidcIdentity{}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email = verifiedEmail(claims)
+	identity.Name, _ = claims["name"].(string)
+	identity.PreferredUsername, _ = claims["preferred_username"].(string)
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("id_token sin sub")
+	}
+	switch groups := claims[oidcSettings.GroupsClaim].(type) {
+	case string:
+		identity.Groups = []string{groups}
+	case []interface{}:
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				identity.Groups = append(identity.Groups, s)
+			}
+		}
+	}
+	return identity, nil
+}
+
+// verifiedEmail devuelve el email del id_token solo si el proveedor lo marca como verificado,
+// porque con él se vinculan cuentas existentes. Con REQUIRE_EMAIL_VERIFIED = false también se
+// acepta cuando el proveedor no envía email_verified, pero nunca si lo marca como no verificado
+func verifiedEmail(claims jwt.MapClaims) string {
+	email, _ := claims["email"].(string)
+	verified, ok := claims["email_verified"].(bool)
+	if verified || (!ok && !oidcSettings.RequireEmailVerified) {
+		return email
+	}
+	return ""
+}
+
+// rankForGroups devuelve el rank más alto que corresponde a los grupos del usuario, o false
+// si ninguno de sus grupos está mapeado
+func rankForGroups(groups []string) (int, bool) {
+	best, found := 0, false
+	for _, group := range groups {
+		role, ok := oidcSettings.GroupRoles[group]
+		if !ok {
+			continue
+		}
+		if rank, _ := rankForRole(role); !found || rank > best {
+			best, found = rank, true
+		}
+	}
+	return best, found
+}
+
+// pkceChallenge calcula el code_challenge S256 de un code_verifier (RFC 7636)
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// createOIDCState guarda el state, el nonce y el code_verifier de un login en curso
+func createOIDCState() (state, nonce, verifier string, err error) {
+	if state, err = generateToken(32); err != nil {
+		return
+	}
+	if nonce, err = generateToken(32); err != nil {
+		return
+	}
+	if verifier, err = generateToken(48); err != nil {
+		return
+	}
+	_, err = dataBase.Insert(false, "INSERT INTO oidc_states (state_hash, nonce, code_verifier, expires_at) VALUES (?, ?, ?, ?)",
+		hashToken(state), nonce, verifier, database.FormatTime(time.Now().Add(oidcStateTTL)))
+	return
+}
+
+// takeOIDCState consume un state: sirve una sola vez
+func takeOIDCState(state string) (nonce, verifier string, err error) {
+	var expiresAt string
+	hash := hashToken(state)
+	row, err := dataBase.SelectRow("SELECT nonce, code_verifier, expires_at FROM oidc_states WHERE state_hash = ?", hash)
+	if err != nil {
+		return "", "", err
+	}
+	if err := row.Scan(&nonce, &verifier, &expiresAt); err != nil {
+		if err == sql.ErrNoRows {
+			return "", "", errInvalidOIDCState
+		}
+		return "", "", err
+	}
+	deleted, err := dataBase.Delete(false, "DELETE FROM oidc_states WHERE state_hash = ?", hash)
+	if err != nil {
+		return "", "", err
+	}
+	expires, err := database.ParseTime(expiresAt)
+	if deleted == 0 || err != nil || time.Now().After(expires) {
+		return "", "", errInvalidOIDCState
+	}
+	// De paso se limpian los logins abandonados
+	dataBase.Delete(false, "DELETE FROM oidc_states WHERE expires_at < ?", database.FormatTime(time.Now()))
+	return nonce, verifier, nil
+}
+
+// exchangeOIDCCode canjea el código de autorización por el id_token en el token endpoint
+func exchangeOIDCCode(code, verifier string) (string, error) {
+	d, err := oidcIdP.config()
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {oidcSettings.RedirectURL},
+		"client_id":     {oidcSettings.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequest(http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if oidcSettings.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(oidcSettings.ClientID), url.QueryEscape(oidcSettings.ClientSecret))
+	}
+
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var tokenResp struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return "", fmt.Errorf("respuesta inválida del token endpoint: %v", err)
+	}
+	if tokenResp.Error != "" {
+		return "", fmt.Errorf("el proveedor rechazó el código: %s %s", tokenResp.Error, tokenResp.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK || tokenResp.IDToken == "" {
+		return "", fmt.Errorf("el token endpoint respondió %d sin id_token", resp.StatusCode)
+	}
+	return tokenResp.IDToken, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Política de contraseñas, configurable en la sección [security] de data.conf
+var (
+	passwordMinLength = 10
+	passwordMaxLength = 128 // argon2 procesa la contraseña completa, se limita para evitar abusos
+)
+
+// Contraseñas que se rechazan aunque cumplan el resto de la política. Se comparan en minúsculas
+// y sin los símbolos del principio y el final, así "Password2024!" equivale a "password2024".
+var commonPasswords = map[string]bool{
+	"1q2w3e4r5t":       true,
+	"1q2w3e4r5t6y":     true,
+	"1qaz2wsx3edc":     true,
+	"a1b2c3d4e5":       true,
+	"abc1234567":       true,
+	"abcd123456":       true,
+	"admin12345":       true,
+	"admin123456":      true,
+	"administrador1":   true,
+	"administrador123": true,
+	"argentina123":     true,
+	"bienvenido1":      true,
+	"bienvenido123":    true,
+	"contrasena1":      true,
+	"contrasena123":    true,
+	"contraseña1":      true,
+	"contraseña123":    true,
+	"iloveyou123":      true,
+	"magpanel1":        true,
+	"magpanel123":      true,
+	"magpanel2024":     true,
+	"magpanel2025":     true,
+	"magservicios1":    true,
+	"magservicios123":  true,
+	"password1":        true,
+	"password12":       true,
+	"password123":      true,
+	"password1234":     true,
+	"password2024":     true,
+	"password2025":     true,
+	"qwerty123":        true,
+	"qwerty12345":      true,
+	"qwerty123456":     true,
+	"qwertyuiop1":      true,
+	"qwertyuiop123":    true,
+	"welcome123":       true,
+}
+
+// validatePassword aplica la política de contraseñas. username y email se usan para rechazar
+// contraseñas que los contengan.
+func validatePassword(password, username, email string) error {
+	// Las comunes se revisan primero, así se rechazan aunque PASSWORD_MIN_LENGTH sea más corto
+	trimmed := strings.TrimFunc(strings.ToLower(password), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	})
+	if commonPasswords[trimmed] {
+		return fmt.Errorf("la contraseña es demasiado común")
+	}
+
+	length := len([]rune(password))
+	if length < passwordMinLength {
+		return fmt.Errorf("la contraseña debe tener al menos %d caracteres", passwordMinLength)
+	}
+	if length > passwordMaxLength {
+		return fmt.Errorf("la contraseña no puede superar los %d caracteres", passwordMaxLength)
+	}
+
+	var hasLetter, hasDigit bool
+	for _, c := range password {
+		switch {
+		case unicode.IsLetter(c):
+			hasLetter = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		}
+	}
+	if !hasLetter || !hasDigit {
+		return fmt.Errorf("la contraseña debe combinar letras y números")
+	}
+
+	lower := strings.ToLower(password)
+	if username != "" && len(username) >= 3 && strings.Contains(lower, strings.ToLower(username)) {
+		return fmt.Errorf("la contraseña no puede contener el nombre de usuario")
+	}
+	if local, _, found := strings.Cut(strings.ToLower(email), "@"); found && len(local) >= 3 && strings.Contains(lower, local) {
+		return fmt.Errorf("la contraseña no puede contener el email")
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"unicode"
+)
+
+func TestValidatePassword(t *testing.T) {
+	cases := []struct {
+		password string
+		valid    bool
+	}{
+		{"Una-Clave-Segura-9", true},
+		{"corta1", false},
+		{"sololetrasaqui", false},
+		{"1234567890123", false},
+		{"Password2024!", false},
+		{"Magpanel123", false},
+		{"**Qwerty12345**", false},
+		{"Password2024!x", true},
+		{"ana-2024-secreta", false}, // contiene el nombre de usuario
+		{"x-ana.perez-9xx", false},  // contiene el email
+	}
+	for _, c := range cases {
+		err := validatePassword(c.password, "ana", "ana.perez@example.com")
+		if (err == nil) != c.valid {
+			t.Errorf("validatePassword(%q) = %v, se esperaba válida=%v", c.password, err, c.valid)
+		}
+	}
+}
+
+func TestCommonPasswordsCombineLettersAndDigits(t *testing.T) {
+	// Una entrada sin letras o sin números ya la rechaza otra regla y no aporta nada a la lista
+	for password := range commonPasswords {
+		var letter, digit bool
+		for _, c := range password {
+			letter = letter || unicode.IsLetter(c)
+			digit = digit || unicode.IsDigit(c)
+		}
+		if !letter || !digit {
+			t.Errorf("%q no combina letras y números", password)
+		}
+	}
+}
+
+func TestCommonPasswordsWithShortMinLength(t *testing.T) {
+	defer func(n int) { passwordMinLength = n }(passwordMinLength)
+	passwordMinLength = 8
+
+	if err := validatePassword("Password1", "", ""); err == nil || err.Error() != "la contraseña es demasiado común" {
+		t.Errorf("Password1 con mínimo 8: %v, se esperaba que fuera común", err)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Costo de argon2id para los hashes nuevos, configurable en la sección [security] de data.conf.
+// Los hashes guardan sus propios parámetros, así que cambiar estos valores no invalida los
+// existentes: se vuelven a hashear en el siguiente login exitoso.
+var (
+	argon2Time    uint32 = 3
+	argon2Memory  uint32 = 64 * 1024 // KiB
+	argon2Threads uint8  = 4
+)
+
+const (
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+// Parámetros fijos del formato anterior (hash y sal en dos columnas hexadecimales)
+const (
+	legacyArgon2Time    = 1
+	legacyArgon2Memory  = 64 * 1024
+	legacyArgon2Threads = 4
+)
+
+// argon2Params son los parámetros embebidos en un hash PHC
+type argon2Params struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+}
+
+// hashPassword devuelve un hash argon2id en formato PHC:
+// $argon2id$v=19$m=65536,t=3,p=4$<sal>$<hash> (sal y hash en base64 sin padding)
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	params := argon2Params{time: argon2Time, memory: argon2Memory, threads: argon2Threads}
+	key := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, argon2KeyLen)
+	return encodePHC(params, salt, key), nil
+}
+
+func encodePHC(params argon2Params, salt, key []byte) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, params.memory, params.time, params.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+// decodePHC separa un hash PHC en parámetros, sal y hash
+func decodePHC(encoded string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return params, nil, nil, fmt.Errorf("formato de hash desconocido")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("versión de argon2 no soportada")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return params, nil, nil, fmt.Errorf("parámetros de argon2 inválidos")
+	}
+	if params.time == 0 || params.memory == 0 || params.threads == 0 {
+		return params, nil, nil, fmt.Errorf("parámetros de argon2 inválidos")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("sal inválida")
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, fmt.Errorf("hash inválido")
+	}
+	return params, salt, key, nil
+}
+
+// legacyToPHC convierte un hash del formato anterior (hash y sal hexadecimales) a PHC,
+// sin necesidad de conocer la contraseña
+func legacyToPHC(hashHex, saltHex string) (string, error) {
+	salt, err := hex.DecodeString(saltHex)
+	if err != nil || len(salt) == 0 {
+		return "", fmt.Errorf("sal inválida")
+	}
+	key, err := hex.DecodeString(hashHex)
+	if err != nil || len(key) == 0 {
+		return "", fmt.Errorf("hash inválido")
+	}
+	return encodePHC(argon2Params{time: legacyArgon2Time, memory: legacyArgon2Memory, threads: legacyArgon2Threads}, salt, key), nil
+}
+
+// checkPassword compara la contraseña con el hash guardado. legacySalt solo se usa con hashes del
+// formato anterior. needsRehash indica que el hash no usa los parámetros actuales y conviene
+// reemplazarlo ahora que se conoce la contraseña.
+func checkPassword(encoded, legacySalt, password string) (ok bool, needsRehash bool) {
+	// Sin hash (usuario inexistente o sin contraseña local) igual se paga el costo de argon2id,
+	// para que el tiempo de respuesta no revele qué usuarios existen
+	if encoded == "" {
+		checkPassword(dummyPasswordHash(), "", password)
+		return false, false
+	}
+	if !strings.HasPrefix(encoded, "$") {
+		converted, err := legacyToPHC(encoded, legacySalt)
+		if err != nil {
+			return false, false
+		}
+		encoded = converted
+		needsRehash = true
+	}
+
+	params, salt, key, err := decodePHC(encoded)
+	if err != nil {
+		log.Printf("Hash de contraseña ilegible: %v", err)
+		return false, false
+	}
+	candidate := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, candidate) != 1 {
+		return false, false
+	}
+	if params.time != argon2Time || params.memory != argon2Memory || params.threads != argon2Threads || len(key) != argon2KeyLen {
+		needsRehash = true
+	}
+	return true, needsRehash
+}
+
+var (
+	dummyHash     string
+	dummyHashOnce sync.Once
+)
+
+// dummyPasswordHash es un hash de una contraseña al azar con los parámetros actuales, contra el
+// que se verifica cuando no hay un hash real
+func dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		password, err := generateToken(16)
+		if err == nil {
+			dummyHash, err = hashPassword(password)
+		}
+		if err != nil {
+			log.Fatalf("Error al generar el hash de referencia: %v", err)
+		}
+	})
+	return dummyHash
+}
+
+// rehashPassword reemplaza el hash de un usuario por uno con los parámetros actuales. Solo
+// actualiza si el hash no cambió mientras tanto, para no pisar un cambio de contraseña concurrente.
+func rehashPassword(userID int, oldHash, password string) {
+	newHash, err := hashPassword(password)
+	if err != nil {
+		log.Printf("Error al rehashear la contraseña del usuario %d: %v", userID, err)
+		return
+	}
+	if _, err := dataBase.Update(true, "UPDATE users SET password_hash = ?, salt = '' WHERE id = ? AND password_hash = ?", newHash, userID, oldHash); err != nil {
+		log.Printf("Error al rehashear la contraseña del usuario %d: %v", userID, err)
+	}
+}
+
+// runPasswords maneja el subcomando `magpanel passwords upgrade`, que convierte al formato PHC
+// los hashes guardados con el formato anterior. No cambia el costo: eso ocurre en el siguiente login.
+func runPasswords(args []string) error {
+	if len(args) == 0 || args[0] != "upgrade" {
+		return fmt.Errorf("uso: magpanel passwords upgrade")
+	}
+
+	type legacyRow struct {
+		id         int
+		hash, salt string
+	}
+	var legacy []legacyRow
+	rows, err := dataBase.Select("SELECT id, password_hash, salt FROM users WHERE password_hash <> '' AND password_hash NOT LIKE '$%'")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var l legacyRow
+		if err := rows.Scan(&l.id, &l.hash, &l.salt); err != nil {
+			rows.Close()
+			return err
+		}
+		legacy = append(legacy, l)
+	}
+	rows.Close()
+
+	converted := 0
+	for _, l := range legacy {
+		encoded, err := legacyToPHC(l.hash, l.salt)
+		if err != nil {
+			fmt.Printf("Usuario %d: %v, se omite\n", l.id, err)
+			continue
+		}
+		if _, err := dataBase.Update(true, "UPDATE users SET password_hash = ?, salt = '' WHERE id = ? AND password_hash = ?", encoded, l.id, l.hash); err != nil {
+			return err
+		}
+		converted++
+	}
+	fmt.Printf("Hashes convertidos: %d de %d\n", converted, len(legacy))
+	return nil
+}
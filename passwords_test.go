@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// legacyHash arma un hash del formato anterior: hash y sal en hexadecimal, con los parámetros fijos
+func legacyHash(password string) (hashHex, saltHex string) {
+	salt := []byte("sal-de-16-bytes!")
+	key := argon2.IDKey([]byte(password), salt, legacyArgon2Time, legacyArgon2Memory, legacyArgon2Threads, argon2KeyLen)
+	return hex.EncodeToString(key), hex.EncodeToString(salt)
+}
+
+func TestCheckLegacyPassword(t *testing.T) {
+	hash, salt := legacyHash(testPassword)
+
+	ok, needsRehash := checkPassword(hash, salt, testPassword)
+	if !ok || !needsRehash {
+		t.Errorf("checkPassword con un hash anterior = %v, %v; se esperaba true, true", ok, needsRehash)
+	}
+	if ok, _ := checkPassword(hash, salt, "incorrecta"); ok {
+		t.Error("checkPassword aceptó una contraseña incorrecta con un hash anterior")
+	}
+	if ok, _ := checkPassword(hash, "", testPassword); ok {
+		t.Error("checkPassword aceptó un hash anterior sin sal")
+	}
+
+	// La conversión a PHC sin la contraseña sigue verificando igual
+	encoded, err := legacyToPHC(hash, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$", argon2.Version, legacyArgon2Memory, legacyArgon2Time, legacyArgon2Threads)
+	if !strings.HasPrefix(encoded, want) {
+		t.Errorf("legacyToPHC = %s, se esperaba el prefijo %s", encoded, want)
+	}
+	if ok, needsRehash := checkPassword(encoded, "", testPassword); !ok || !needsRehash {
+		t.Errorf("checkPassword con el hash convertido = %v, %v; se esperaba true, true", ok, needsRehash)
+	}
+}
+
+func TestPHCRoundTrip(t *testing.T) {
+	params := argon2Params{time: 2, memory: 2048, threads: 3}
+	salt, key := []byte("0123456789abcdef"), []byte("una clave derivada de 32 bytes!!")
+	encoded := encodePHC(params, salt, key)
+	if want := fmt.Sprintf("$argon2id$v=%d$m=2048,t=2,p=3$", argon2.Version); !strings.HasPrefix(encoded, want) {
+		t.Fatalf("encodePHC = %s, se esperaba el prefijo %s", encoded, want)
+	}
+
+	gotParams, gotSalt, gotKey, err := decodePHC(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotParams != params || !reflect.DeepEqual(gotSalt, salt) || !reflect.DeepEqual(gotKey, key) {
+		t.Errorf("decodePHC = %+v %q %q", gotParams, gotSalt, gotKey)
+	}
+
+	for _, bad := range []string{
+		"",
+		"$argon2i$v=19$m=2048,t=2,p=3$c2Fs$aGFzaA",
+		"$argon2id$v=16$m=2048,t=2,p=3$c2Fs$aGFzaA",
+		"$argon2id$v=19$m=2048,t=0,p=3$c2Fs$aGFzaA",
+		"$argon2id$v=19$m=2048,t=2,p=3$c2Fs$",
+		"$argon2id$v=19$m=2048,t=2,p=3$c2Fs",
+	} {
+		if _, _, _, err := decodePHC(bad); err == nil {
+			t.Errorf("decodePHC(%q) no devolvió error", bad)
+		}
+	}
+}
+
+func TestNeedsRehashAfterCostChange(t *testing.T) {
+	newTestDatabase(t)
+	hash, err := hashPassword(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, needsRehash := checkPassword(hash, "", testPassword); !ok || needsRehash {
+		t.Fatalf("checkPassword con los parámetros actuales = %v, %v; se esperaba true, false", ok, needsRehash)
+	}
+
+	// Subir el costo no invalida el hash, pero pide reemplazarlo
+	argon2Time++
+	if ok, needsRehash := checkPassword(hash, "", testPassword); !ok || !needsRehash {
+		t.Errorf("checkPassword después de cambiar el costo = %v, %v; se esperaba true, true", ok, needsRehash)
+	}
+	if ok, _ := checkPassword(hash, "", "incorrecta"); ok {
+		t.Error("checkPassword aceptó una contraseña incorrecta")
+	}
+}
+
+func TestLoginUpgradesLegacyHash(t *testing.T) {
+	newTestDatabase(t)
+	id := createTestUser(t, "ana", 1)
+	hash, salt := legacyHash(testPassword)
+	if _, err := dataBase.Update(false, "UPDATE users SET password_hash = ?, salt = ? WHERE id = ?", hash, salt, id); err != nil {
+		t.Fatal(err)
+	}
+
+	w := doRequest(t, initRoutes(), "POST", "/login", "", fmt.Sprintf(`{"username": "ana", "password": %q}`, testPassword))
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /login con un hash anterior = %d %s", w.Code, w.Body)
+	}
+
+	var stored, storedSalt string
+	row, _ := dataBase.SelectRow("SELECT password_hash, salt FROM users WHERE id = ?", id)
+	if err := row.Scan(&stored, &storedSalt); err != nil {
+		t.Fatal(err)
+	}
+	params, _, _, err := decodePHC(stored)
+	if err != nil || storedSalt != "" {
+		t.Fatalf("después del login quedó el hash %q con sal %q (%v)", stored, storedSalt, err)
+	}
+	if params.time != argon2Time || params.memory != argon2Memory || params.threads != argon2Threads {
+		t.Errorf("el hash nuevo usa %+v y no los parámetros actuales", params)
+	}
+	if ok, needsRehash := checkPassword(stored, "", testPassword); !ok || needsRehash {
+		t.Errorf("checkPassword con el hash nuevo = %v, %v", ok, needsRehash)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"magpanel/models"
+	"net/http"
+	"sort"
+)
+
+// Roles derivados del campo `rank` de users
+const (
+	RoleViewer     = "viewer"     // rank 0: solo lectura
+	RoleTechnician = "technician" // rank 1: carga reportes y adjuntos
+	RoleManager    = "manager"    // rank 2: gestiona proyectos y el directorio
+	RoleAdmin      = "admin"      // rank 3 o más: administración completa
+)
+
+// Permisos con nombre que se exigen en las rutas
+const (
+	PermProjectsRead     = "projects:read"
+	PermProjectsWrite    = "projects:write"
+	PermProjectsAll      = "projects:all" // ver todos los proyectos y no solo aquellos de los que es miembro
+	PermReportsRead      = "reports:read"
+	PermReportsWrite     = "reports:write"
+	PermTasksWrite       = "tasks:write"     // tareas y checklists de los proyectos
+	PermDirectoryRead    = "directory:read"  // clientes, proveedores, contactos y ubicaciones
+	PermDirectoryWrite   = "directory:write" // clientes, proveedores, contactos y ubicaciones
+	PermCatalogRead      = "catalog:read"    // categorías y estados de proyecto
+	PermCatalogWrite     = "catalog:write"   // categorías y estados de proyecto
+	PermAttachmentsWrite = "attachments:write"
+	PermUsersRead        = "users:read"
+	PermUsersAdmin       = "users:admin"
+	PermSettingsAdmin    = "settings:admin"
+	PermLogsRead         = "logs:read"
+	PermFeedbackWrite    = "feedback:write"
+)
+
+var viewerPermissions = []string{
+	PermProjectsRead, PermReportsRead, PermDirectoryRead, PermCatalogRead, PermFeedbackWrite,
+}
+
+var technicianPermissions = append(append([]string{}, viewerPermissions...),
+	PermReportsWrite, PermAttachmentsWrite, PermTasksWrite,
+)
+
+var managerPermissions = append(append([]string{}, technicianPermissions...),
+	PermProjectsWrite, PermProjectsAll, PermDirectoryWrite, PermUsersRead, PermLogsRead,
+)
+
+var adminPermissions = append(append([]string{}, managerPermissions...),
+	PermCatalogWrite, PermUsersAdmin, PermSettingsAdmin,
+)
+
+var rolePermissions = map[string][]string{
+	RoleViewer:     viewerPermissions,
+	RoleTechnician: technicianPermissions,
+	RoleManager:    managerPermissions,
+	RoleAdmin:      adminPermissions,
+}
+
+// roleForRank traduce el rank numérico de la base al rol correspondiente
+func roleForRank(rank int) string {
+	switch {
+	case rank >= 3:
+		return RoleAdmin
+	case rank == 2:
+		return RoleManager
+	case rank == 1:
+		return RoleTechnician
+	}
+	return RoleViewer
+}
+
+// rankForRole es la inversa de roleForRank: el rank que se guarda para cada rol
+func rankForRole(role string) (int, bool) {
+	switch role {
+	case RoleAdmin:
+		return 3, true
+	case RoleManager:
+		return 2, true
+	case RoleTechnician:
+		return 1, true
+	case RoleViewer:
+		return 0, true
+	}
+	return 0, false
+}
+
+// permissionsForRank devuelve los permisos efectivos de un rank
+func permissionsForRank(rank int) []string {
+	return rolePermissions[roleForRank(rank)]
+}
+
+func hasPermission(rank int, permission string) bool {
+	return containsPermission(permissionsForRank(rank), permission)
+}
+
+func containsPermission(permissions []string, permission string) bool {
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// isKnownPermission indica si el permiso existe en algún rol (se usa para validar scopes)
+func isKnownPermission(permission string) bool {
+	return containsPermission(adminPermissions, permission)
+}
+
+// effectivePermissions devuelve los permisos del usuario para este request: los de su rol,
+// recortados a los scopes de la API key si el request se autenticó con una
+func effectivePermissions(r *http.Request, user *models.User) []string {
+	permissions := permissionsForRank(user.Rank)
+	scopes, ok := requestAPIKeyScopes(r)
+	if !ok {
+		return permissions
+	}
+	var effective []string
+	for _, p := range permissions {
+		if containsPermission(scopes, p) {
+			effective = append(effective, p)
+		}
+	}
+	return effective
+}
+
+// requestHasPermission es como hasPermission pero teniendo en cuenta los scopes de la API key
+func requestHasPermission(r *http.Request, user *models.User, permission string) bool {
+	return containsPermission(effectivePermissions(r, user), permission)
+}
+
+// RequirePermission exige que el usuario autenticado tenga todos los permisos indicados.
+// Debe usarse después de AuthMiddleware.
+func RequirePermission(permissions ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, err := getCurrentUser(r)
+			if err != nil {
+				http.Error(w, "No autorizado. "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+			for _, permission := range permissions {
+				if !requestHasPermission(r, user, permission) {
+					http.Error(w, "Acceso denegado. Se requiere el permiso "+permission+".", http.StatusForbidden)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireByMethod exige `read` para GET y `write` para el resto de los métodos de un grupo de rutas
+func RequireByMethod(read, write string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		readHandler := RequirePermission(read)(next)
+		writeHandler := RequirePermission(write)(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				readHandler.ServeHTTP(w, r)
+				return
+			}
+			writeHandler.ServeHTTP(w, r)
+		})
+	}
+}
+
+// getMyPermissions devuelve el rol y los permisos efectivos del usuario autenticado
+func getMyPermissions(w http.ResponseWriter, r *http.Request) {
+	user, err := getCurrentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	permissions := append([]string{}, effectivePermissions(r, user)...)
+	sort.Strings(permissions)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user_id":     user.ID,
+		"rank":        user.Rank,
+		"role":        roleForRank(user.Rank),
+		"permissions": permissions,
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestRoutesRequireRole(t *testing.T) {
+	newTestDatabase(t)
+	router := initRoutes()
+	tokens := map[int]string{}
+	for rank, role := range []string{RoleViewer, RoleTechnician, RoleManager, RoleAdmin} {
+		tokens[rank] = testToken(t, createTestUser(t, role, rank))
+	}
+
+	// minRank es el rank más bajo que pasa el control de permisos de la ruta
+	cases := []struct {
+		method, path, body string
+		minRank            int
+	}{
+		{"GET", "/projects", "", 0},
+		{"GET", "/categories", "", 0},
+		{"POST", "/reports", "{}", 1},
+		{"POST", "/projects", "{}", 2},
+		{"POST", "/clients", "{}", 2},
+		{"GET", "/users", "", 2},
+		{"GET", "/logs", "", 2},
+
+		// Usuarios
+		{"POST", "/users", "{}", 3},
+		{"PUT", "/users/999", `{"username": "nadie", "rank": 3}`, 3},
+		{"DELETE", "/users/999", "", 3},
+		{"POST", "/users/999/reactivate", "", 3},
+		{"GET", "/users/locks", "", 3},
+		{"GET", "/users/invitations", "", 3},
+		{"POST", "/users/invite", "{}", 3},
+
+		// Ajustes, incluso leerlos
+		{"GET", "/settings", "", 3},
+		{"POST", "/settings", "{}", 3},
+		{"PUT", "/settings/999", "{}", 3},
+		{"DELETE", "/settings/999", "", 3},
+
+		// Escrituras del catálogo
+		{"POST", "/categories", "{}", 3},
+		{"PUT", "/categories/999", "{}", 3},
+		{"DELETE", "/categories/999", "", 3},
+		{"POST", "/project-statuses", "{}", 3},
+		{"DELETE", "/project-statuses/999", "", 3},
+		{"POST", "/project-status-transitions", "{}", 3},
+	}
+	for _, c := range cases {
+		for rank := 0; rank <= 3; rank++ {
+			code := doRequest(t, router, c.method, c.path, tokens[rank], c.body).Code
+			if rank < c.minRank && code != http.StatusForbidden {
+				t.Errorf("%s %s con %s = %d, se esperaba 403", c.method, c.path, roleForRank(rank), code)
+			}
+			if rank >= c.minRank && (code == http.StatusForbidden || code == http.StatusUnauthorized) {
+				t.Errorf("%s %s con %s = %d, se esperaba que pase el control de permisos", c.method, c.path, roleForRank(rank), code)
+			}
+		}
+	}
+}
+
+func TestPermissionsEndpointReflectsRank(t *testing.T) {
+	newTestDatabase(t)
+	router := initRoutes()
+
+	for rank := 0; rank <= 4; rank++ {
+		id := createTestUser(t, "usuario"+string(rune('0'+rank)), rank)
+		w := doRequest(t, router, "GET", "/permissions", testToken(t, id), "")
+		var response struct {
+			UserID      int      `json:"user_id"`
+			Rank        int      `json:"rank"`
+			Role        string   `json:"role"`
+			Permissions []string `json:"permissions"`
+		}
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &response) != nil {
+			t.Fatalf("GET /permissions con rank %d = %d %s", rank, w.Code, w.Body)
+		}
+
+		want := append([]string{}, permissionsForRank(rank)...)
+		sort.Strings(want)
+		if response.UserID != id || response.Rank != rank || response.Role != roleForRank(rank) || !reflect.DeepEqual(response.Permissions, want) {
+			t.Errorf("GET /permissions con rank %d = %+v, se esperaba el rol %s con %v", rank, response, roleForRank(rank), want)
+		}
+	}
+
+	// Cada rol tiene todo lo del anterior y algo más
+	previous := []string{}
+	for _, role := range []string{RoleViewer, RoleTechnician, RoleManager, RoleAdmin} {
+		permissions := rolePermissions[role]
+		for _, p := range previous {
+			if !containsPermission(permissions, p) {
+				t.Errorf("el rol %s no tiene el permiso %s del rol anterior", role, p)
+			}
+		}
+		if len(permissions) <= len(previous) {
+			t.Errorf("el rol %s no agrega permisos", role)
+		}
+		previous = permissions
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"magpanel/database"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Plantilla que usan las categorías sin code_template propio
+const defaultCodeTemplate = "{category}-{client}-{seq}"
+
+const (
+	defaultCodePadding = 4
+	maxCodePadding     = 12
+	maxCodeTemplateLen = 255
+	// Veces que createProject vuelve a reservar un código que otro proyecto ocupó al mismo tiempo
+	maxProjectCodeAttempts = 3
+)
+
+// codeToken reconoce {nombre} y {nombre:modificador:...} dentro de una plantilla
+var codeToken = regexp.MustCompile(`\{([a-z]+)((?::[a-z0-9]+)*)\}`)
+
+// codeTemplate es una plantilla de código de proyecto ya validada. Tokens:
+//
+//	{category}  código de la categoría
+//	{client}    código del cliente
+//	{year}/{yy} año de alta del proyecto, con 4 o 2 dígitos
+//	{seq}       número correlativo; admite modificadores que definen dónde se reinicia
+//	            (category, client, year; sin ninguno es por categoría) y un relleno con ceros:
+//	            {seq:client:year:5}
+type codeTemplate struct {
+	raw                          string
+	byCategory, byClient, byYear bool
+	padding                      int
+}
+
+// codeContext son los datos del proyecto con los que se arma el código
+type codeContext struct {
+	CategoryID   int
+	CategoryCode string
+	ClientID     int
+	ClientCode   string
+	Year         int
+}
+
+// parseCodeTemplate valida una plantilla; vacía equivale a la plantilla por defecto
+func parseCodeTemplate(raw string) (*codeTemplate, error) {
+	if raw == "" {
+		raw = defaultCodeTemplate
+	}
+	if len(raw) > maxCodeTemplateLen {
+		return nil, fmt.Errorf("la plantilla de código no puede superar los %d caracteres", maxCodeTemplateLen)
+	}
+
+	t := &codeTemplate{raw: raw, padding: defaultCodePadding}
+	sequences := 0
+	for _, match := range codeToken.FindAllStringSubmatch(raw, -1) {
+		var modifiers []string
+		if match[2] != "" {
+			modifiers = strings.Split(match[2][1:], ":")
+		}
+		switch match[1] {
+		case "category", "client", "year", "yy":
+			if len(modifiers) > 0 {
+				return nil, fmt.Errorf("el token {%s} no admite modificadores", match[1])
+			}
+		case "seq":
+			sequences++
+			for _, m := range modifiers {
+				switch m {
+				case "category":
+					t.byCategory = true
+				case "client":
+					t.byClient = true
+				case "year":
+					t.byYear = true
+				default:
+					padding, err := strconv.Atoi(m)
+					if err != nil || padding < 1 || padding > maxCodePadding {
+						return nil, fmt.Errorf("modificador de {seq} inválido: %q", m)
+					}
+					t.padding = padding
+				}
+			}
+			if !t.byCategory && !t.byClient && !t.byYear {
+				t.byCategory = true
+			}
+		default:
+			return nil, fmt.Errorf("token desconocido en la plantilla de código: {%s}", match[1])
+		}
+	}
+	if sequences != 1 {
+		return nil, fmt.Errorf("la plantilla de código debe tener exactamente un token {seq}")
+	}
+	return t, nil
+}
+
+// scope identifica el contador que usa el proyecto: los proyectos con el mismo scope comparten numeración
+func (t *codeTemplate) scope(c codeContext) string {
+	var parts []string
+	if t.byCategory {
+		parts = append(parts, fmt.Sprintf("category=%d", c.CategoryID))
+	}
+	if t.byClient {
+		parts = append(parts, fmt.Sprintf("client=%d", c.ClientID))
+	}
+	if t.byYear {
+		parts = append(parts, fmt.Sprintf("year=%d", c.Year))
+	}
+	return strings.Join(parts, ";")
+}
+
+// render arma el código con el número de secuencia dado
+func (t *codeTemplate) render(c codeContext, seq int) string {
+	return codeToken.ReplaceAllStringFunc(t.raw, func(token string) string {
+		name := codeToken.FindStringSubmatch(token)[1]
+		switch name {
+		case "category":
+			return c.CategoryCode
+		case "client":
+			return c.ClientCode
+		case "year":
+			return fmt.Sprintf("%04d", c.Year)
+		case "yy":
+			return fmt.Sprintf("%02d", c.Year%100)
+		}
+		return fmt.Sprintf("%0*d", t.padding, seq)
+	})
+}
+
+var (
+	errCategoryNotFound = fmt.Errorf("Categoría no encontrada")
+	errClientNotFound   = fmt.Errorf("Cliente no encontrado")
+)
+
+// loadCodeContext lee la plantilla de la categoría y los códigos de categoría y cliente.
+// Devuelve errCategoryNotFound o errClientNotFound si falta alguno de los dos.
+func loadCodeContext(categoryID, clientID, year int) (*codeTemplate, codeContext, error) {
+	c := codeContext{CategoryID: categoryID, ClientID: clientID, Year: year}
+
+	var categoryCode, template sql.NullString
+	row, err := dataBase.SelectRow("SELECT code, code_template FROM categories WHERE id = ?", categoryID)
+	if err != nil {
+		return nil, c, err
+	}
+	if err := row.Scan(&categoryCode, &template); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, c, errCategoryNotFound
+		}
+		return nil, c, err
+	}
+	c.CategoryCode = categoryCode.String
+
+	row, err = dataBase.SelectRow("SELECT code FROM clients WHERE id = ?", clientID)
+	if err != nil {
+		return nil, c, err
+	}
+	if err := row.Scan(&c.ClientCode); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, c, errClientNotFound
+		}
+		return nil, c, err
+	}
+
+	t, err := parseCodeTemplate(template.String)
+	if err != nil {
+		return nil, c, fmt.Errorf("la categoría %d tiene una plantilla de código inválida: %v", categoryID, err)
+	}
+	return t, c, nil
+}
+
+// allocateProjectCode reserva el siguiente número del scope dentro de la transacción y devuelve
+// el código. El UPDATE bloquea la fila del contador hasta el commit, así que dos altas
+// simultáneas nunca reciben el mismo número. Si el código ya lo usa otro proyecto (por ejemplo
+// uno numerado con otra plantilla) se pasa al siguiente.
+func allocateProjectCode(tx *database.Tx, t *codeTemplate, c codeContext) (string, error) {
+	scope := t.scope(c)
+	if _, err := tx.Insert(false, "INSERT IGNORE INTO project_code_sequences (scope, last_value) VALUES (?, 0)", scope); err != nil {
+		return "", err
+	}
+	for {
+		if _, err := tx.Update(false, "UPDATE project_code_sequences SET last_value = last_value + 1 WHERE scope = ?", scope); err != nil {
+			return "", err
+		}
+		var seq int
+		row, err := tx.SelectRow("SELECT last_value FROM project_code_sequences WHERE scope = ?", scope)
+		if err != nil {
+			return "", err
+		}
+		if err := row.Scan(&seq); err != nil {
+			return "", err
+		}
+
+		code := t.render(c, seq)
+		taken, err := projectCodeTaken(tx.SelectRow, code)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return code, nil
+		}
+	}
+}
+
+// previewProjectCode calcula el código que recibiría el próximo proyecto sin consumir el número
+func previewProjectCode(t *codeTemplate, c codeContext) (string, int, error) {
+	var last int
+	row, err := dataBase.SelectRow("SELECT last_value FROM project_code_sequences WHERE scope = ?", t.scope(c))
+	if err != nil {
+		return "", 0, err
+	}
+	if err := row.Scan(&last); err != nil && err != sql.ErrNoRows {
+		return "", 0, err
+	}
+	for seq := last + 1; ; seq++ {
+		code := t.render(c, seq)
+		taken, err := projectCodeTaken(dataBase.SelectRow, code)
+		if err != nil {
+			return "", 0, err
+		}
+		if !taken {
+			return code, seq, nil
+		}
+	}
+}
+
+// projectCodeTaken indica si algún proyecto ya tiene ese código
+func projectCodeTaken(selectRow func(string, ...interface{}) (*sql.Row, error), code string) (bool, error) {
+	var id int
+	row, err := selectRow("SELECT id FROM projects WHERE code = ?", code)
+	if err != nil {
+		return false, err
+	}
+	if err := row.Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// runProjects atiende `magpanel projects renumber`: vuelve a numerar todos los proyectos, en
+// orden de alta, con la plantilla actual de su categoría y contadores desde cero
+func runProjects(args []string) error {
+	if len(args) == 0 || args[0] != "renumber" {
+		return fmt.Errorf("uso: magpanel projects renumber [-dry-run]")
+	}
+	fs := flag.NewFlagSet("projects renumber", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "Muestra los códigos nuevos sin guardarlos")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	type projectRow struct {
+		id, categoryID, clientID int
+		code, createdAt          string
+	}
+	var projects []projectRow
+	rows, err := dataBase.Select("SELECT id, category_id, client_id, code, created_at FROM projects ORDER BY created_at, id")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var p projectRow
+		if err := rows.Scan(&p.id, &p.categoryID, &p.clientID, &p.code, &p.createdAt); err != nil {
+			rows.Close()
+			return err
+		}
+		projects = append(projects, p)
+	}
+	rows.Close()
+
+	// Las plantillas y códigos se leen antes de abrir la transacción
+	type plan struct {
+		projectRow
+		template *codeTemplate
+		context  codeContext
+	}
+	var plans []plan
+	for _, p := range projects {
+		created, err := database.ParseTime(p.createdAt)
+		if err != nil {
+			return fmt.Errorf("proyecto %d: fecha de alta inválida: %v", p.id, err)
+		}
+		t, c, err := loadCodeContext(p.categoryID, p.clientID, created.Year())
+		if err != nil {
+			return fmt.Errorf("proyecto %d: %v", p.id, err)
+		}
+		plans = append(plans, plan{p, t, c})
+	}
+
+	// Se numera desde cero en una sola transacción; con -dry-run se descarta al final
+	errDryRun := fmt.Errorf("dry run")
+	changed := 0
+	err = dataBase.WithTx(context.Background(), func(tx *database.Tx) error {
+		if _, err := tx.Delete(false, "DELETE FROM project_code_sequences"); err != nil {
+			return err
+		}
+		if _, err := tx.Update(false, "UPDATE projects SET code = ''"); err != nil {
+			return err
+		}
+		for _, p := range plans {
+			code, err := allocateProjectCode(tx, p.template, p.context)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Update(false, "UPDATE projects SET code = ? WHERE id = ?", code, p.id); err != nil {
+				return err
+			}
+			if code != p.code {
+				changed++
+				fmt.Printf("Proyecto %d: %s -> %s\n", p.id, p.code, code)
+			}
+		}
+		if *dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && err != errDryRun {
+		return err
+	}
+
+	if *dryRun {
+		fmt.Printf("Se cambiarían %d de %d códigos (sin guardar)\n", changed, len(plans))
+	} else {
+		fmt.Printf("Códigos cambiados: %d de %d\n", changed, len(plans))
+	}
+	return nil
+}
+
+// projectYear es el año que usan {year} y {yy} para un proyecto nuevo
+func projectYear() int {
+	return time.Now().UTC().Year()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Visibilidad por proyecto: quien no tiene projects:all solo ve los proyectos de los que es
+// miembro, y con ellos sus reportes, adjuntos y demás datos. Lo que no puede ver responde 404,
+// igual que si no existiera, para no revelar qué proyectos hay.
+
+// restrictToVisibleProjects limita el listado a los proyectos visibles para el usuario del
+// request. column es la columna con el id del proyecto (p.id, r.project_id, ...).
+func restrictToVisibleProjects(r *http.Request, q *listQuery, column string) error {
+	user, err := getCurrentUser(r)
+	if err != nil {
+		return err
+	}
+	if !requestHasPermission(r, user, PermProjectsAll) {
+		q.Where(column+" IN (SELECT project_id FROM project_members WHERE user_id = ?)", user.ID)
+	}
+	return nil
+}
+
+// projectVisible indica si el proyecto existe y el usuario del request lo puede ver
+func projectVisible(r *http.Request, projectID interface{}) (bool, error) {
+	user, err := getCurrentUser(r)
+	if err != nil {
+		return false, err
+	}
+
+	query := "SELECT p.id FROM projects p WHERE p.id = ?"
+	args := []interface{}{projectID}
+	if !requestHasPermission(r, user, PermProjectsAll) {
+		query = "SELECT p.id FROM projects p JOIN project_members pm ON pm.project_id = p.id AND pm.user_id = ? WHERE p.id = ?"
+		args = []interface{}{user.ID, projectID}
+	}
+
+	var id int
+	row, err := dataBase.SelectRow(query, args...)
+	if err != nil {
+		return false, err
+	}
+	if err := row.Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// RequireProjectVisible responde 404 si el proyecto {id} de la URL no existe o el usuario no lo
+// puede ver. Se usa en las rutas /projects/{id}.
+func RequireProjectVisible(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		visible, err := projectVisible(r, chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !visible {
+			http.Error(w, "Proyecto no encontrado", http.StatusNotFound)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireReportVisible responde 404 si el reporte {id} de la URL no existe o pertenece a un
+// proyecto que el usuario no puede ver. Se usa en las rutas /reports/{id}.
+func RequireReportVisible(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var projectID int
+		row, err := dataBase.SelectRow("SELECT project_id FROM reports WHERE id = ?", chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		visible := true
+		if err := row.Scan(&projectID); err != nil {
+			if err != sql.ErrNoRows {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			visible = false
+		}
+		if visible {
+			if visible, err = projectVisible(r, projectID); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if !visible {
+			http.Error(w, "Reporte no encontrado", http.StatusNotFound)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// visibilityFixture tiene dos proyectos con un reporte cada uno; el técnico solo es miembro del
+// primero y el manager ve todos por projects:all
+type visibilityFixture struct {
+	technician, manager      string // access tokens
+	ownProject, otherProject int
+	ownReport, otherReport   int
+}
+
+func newVisibilityFixture(t *testing.T) *visibilityFixture {
+	t.Helper()
+	newTestDatabase(t)
+	technicianID := createTestUser(t, "tecnico", 1)
+	managerID := createTestUser(t, "manager", 2)
+
+	insert := func(query string, args ...interface{}) int {
+		id, err := dataBase.Insert(false, query, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return int(id)
+	}
+	category := insert("INSERT INTO categories (`type`, name, fields, filters) VALUES ('report', 'Visita', '[]', '[]')")
+	project := func(name string) int {
+		return insert("INSERT INTO projects (name, description, category_id, client_id, status_id, location_id, author_id) VALUES (?, '', ?, 0, 0, 0, ?)",
+			name, category, managerID)
+	}
+	report := func(projectID int) int {
+		// fields se guarda como lo hace createReport, con el JSON crudo en bytes
+		return insert("INSERT INTO reports (project_id, category_id, fields, author_id) VALUES (?, ?, ?, ?)", projectID, category, json.RawMessage("{}"), managerID)
+	}
+
+	f := &visibilityFixture{
+		technician:   testToken(t, technicianID),
+		manager:      testToken(t, managerID),
+		ownProject:   project("Propio"),
+		otherProject: project("Ajeno"),
+	}
+	f.ownReport = report(f.ownProject)
+	f.otherReport = report(f.otherProject)
+	insert("INSERT INTO project_members (project_id, user_id, role, added_by) VALUES (?, ?, 'member', ?)", f.ownProject, technicianID, managerID)
+	return f
+}
+
+func (f *visibilityFixture) reportExists(t *testing.T, id int) bool {
+	t.Helper()
+	var n int
+	row, _ := dataBase.SelectRow("SELECT COUNT(*) FROM reports WHERE id = ?", id)
+	if err := row.Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n == 1
+}
+
+func TestReportRoutesRespectProjectVisibility(t *testing.T) {
+	f := newVisibilityFixture(t)
+	router := initRoutes()
+
+	cases := []struct {
+		name   string
+		token  string
+		method string
+		path   string
+		want   int
+	}{
+		{"reporte propio", f.technician, "GET", fmt.Sprintf("/reports/%d", f.ownReport), http.StatusOK},
+		{"reporte ajeno", f.technician, "GET", fmt.Sprintf("/reports/%d", f.otherReport), http.StatusNotFound},
+		{"reporte propio en su proyecto", f.technician, "GET", fmt.Sprintf("/projects/%d/reports/%d", f.ownProject, f.ownReport), http.StatusOK},
+		{"reporte ajeno en su proyecto", f.technician, "GET", fmt.Sprintf("/projects/%d/reports/%d", f.ownProject, f.otherReport), http.StatusNotFound},
+		{"reporte ajeno en el proyecto ajeno", f.technician, "GET", fmt.Sprintf("/projects/%d/reports/%d", f.otherProject, f.otherReport), http.StatusNotFound},
+		{"reportes del proyecto ajeno", f.technician, "GET", fmt.Sprintf("/projects/%d/reports", f.otherProject), http.StatusNotFound},
+		{"manager con reporte de otro proyecto", f.manager, "GET", fmt.Sprintf("/projects/%d/reports/%d", f.ownProject, f.otherReport), http.StatusNotFound},
+		{"manager con reporte ajeno", f.manager, "GET", fmt.Sprintf("/projects/%d/reports/%d", f.otherProject, f.otherReport), http.StatusOK},
+	}
+	for _, c := range cases {
+		if w := doRequest(t, router, c.method, c.path, c.token, ""); w.Code != c.want {
+			t.Errorf("%s: %s %s = %d, se esperaba %d", c.name, c.method, c.path, w.Code, c.want)
+		}
+	}
+}
+
+func TestReportWritesThroughOwnProjectCannotReachOtherProjects(t *testing.T) {
+	f := newVisibilityFixture(t)
+	router := initRoutes()
+
+	// Con el id de un proyecto propio en la URL no se puede editar ni borrar un reporte ajeno
+	path := fmt.Sprintf("/projects/%d/reports/%d", f.ownProject, f.otherReport)
+	body := fmt.Sprintf(`{"project_id": %d, "category_id": 1, "fields": {}, "author_id": 1}`, f.ownProject)
+	if w := doRequest(t, router, "PUT", path, f.technician, body); w.Code != http.StatusNotFound {
+		t.Errorf("PUT %s = %d, se esperaba 404", path, w.Code)
+	}
+	var projectID int
+	row, _ := dataBase.SelectRow("SELECT project_id FROM reports WHERE id = ?", f.otherReport)
+	if err := row.Scan(&projectID); err != nil || projectID != f.otherProject {
+		t.Errorf("el reporte ajeno cambió de proyecto a %d (%v)", projectID, err)
+	}
+	if w := doRequest(t, router, "DELETE", path, f.technician, ""); w.Code != http.StatusNotFound {
+		t.Errorf("DELETE %s = %d, se esperaba 404", path, w.Code)
+	}
+	if !f.reportExists(t, f.otherReport) {
+		t.Error("se borró el reporte ajeno")
+	}
+
+	// Tampoco se puede mover un reporte propio a un proyecto ajeno
+	path = fmt.Sprintf("/reports/%d", f.ownReport)
+	body = fmt.Sprintf(`{"project_id": %d, "category_id": 1, "fields": {}, "author_id": 1}`, f.otherProject)
+	if w := doRequest(t, router, "PUT", path, f.technician, body); w.Code != http.StatusNotFound {
+		t.Errorf("PUT %s hacia un proyecto ajeno = %d, se esperaba 404", path, w.Code)
+	}
+
+	path = fmt.Sprintf("/projects/%d/reports/%d", f.ownProject, f.ownReport)
+	if w := doRequest(t, router, "DELETE", path, f.technician, ""); w.Code != http.StatusNoContent {
+		t.Errorf("DELETE %s = %d, se esperaba 204", path, w.Code)
+	}
+	if f.reportExists(t, f.ownReport) {
+		t.Error("el reporte propio no se borró")
+	}
+}
+
+func TestReportListsOnlyVisibleProjects(t *testing.T) {
+	f := newVisibilityFixture(t)
+	router := initRoutes()
+
+	listed := func(token string) []int {
+		w := doRequest(t, router, "GET", "/reports", token, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /reports = %d: %s", w.Code, w.Body)
+		}
+		var reports []struct {
+			ID int `json:"id"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &reports); err != nil {
+			t.Fatal(err)
+		}
+		ids := []int{}
+		for _, r := range reports {
+			ids = append(ids, r.ID)
+		}
+		return ids
+	}
+	if ids := listed(f.technician); len(ids) != 1 || ids[0] != f.ownReport {
+		t.Errorf("el técnico ve los reportes %v, se esperaba solo %d", ids, f.ownReport)
+	}
+	if ids := listed(f.manager); len(ids) != 2 {
+		t.Errorf("el manager ve los reportes %v, se esperaban los dos", ids)
+	}
+}
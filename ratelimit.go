@@ -0,0 +1,54 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// keyedLimiter mantiene un token bucket independiente por clave (IP, usuario), así un
+// cliente ruidoso no deja sin servicio al resto
+type keyedLimiter struct {
+	mu        sync.Mutex
+	limit     rate.Limit
+	burst     int
+	limiters  map[string]*limiterEntry
+	lastSweep time.Time
+}
+
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Las claves que no se usan hace más de limiterIdleTTL se descartan
+const limiterIdleTTL = 10 * time.Minute
+
+func newKeyedLimiter(limit rate.Limit, burst int) *keyedLimiter {
+	return &keyedLimiter{limit: limit, burst: burst, limiters: map[string]*limiterEntry{}, lastSweep: time.Now()}
+}
+
+// Allow consume un token del bucket de la clave
+func (k *keyedLimiter) Allow(key string) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(k.lastSweep) > time.Minute {
+		for key, entry := range k.limiters {
+			if now.Sub(entry.lastSeen) > limiterIdleTTL {
+				delete(k.limiters, key)
+			}
+		}
+		k.lastSweep = now
+	}
+
+	entry, ok := k.limiters[key]
+	if !ok {
+		entry = &limiterEntry{limiter: rate.NewLimiter(k.limit, k.burst)}
+		k.limiters[key] = entry
+	}
+	entry.lastSeen = now
+	return entry.limiter.Allow()
+}
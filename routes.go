@@ -33,34 +33,92 @@ func initRoutes() *chi.Mux {
 		})
 	})
 
-	r.Get("/version", getVersion) // GET /version - Devuelve la versión de la API
+	r.Get("/version", getVersion)            // GET /version - Devuelve la versión de la API
+	r.Get("/.well-known/jwks.json", getJWKS) // GET /.well-known/jwks.json - Claves públicas para validar los access tokens
 
-	// Aplica el middleware de tasa de límite solo al endpoint de login
+	// Aplica el middleware de tasa de límite (por IP) a los endpoints públicos de autenticación
 	r.Group(func(r chi.Router) {
 		r.Use(RateLimit) // Este middleware se aplicará solo a las rutas dentro de este grupo
 		r.Post("/login", loginUser)
+		r.Post("/login/2fa", completeTwoFactorLogin)         // POST /login/2fa - Segundo paso del login con código TOTP o de recuperación
+		r.Post("/request-recovery", requestPasswordRecovery) // POST /request-recovery - Solicitar recuperación de contraseña
+		r.Post("/change-password", changePassword)           // POST /change-password - Cambio de contraseña para un usuario
+		r.Get("/oidc/login", oidcLogin)                      // GET /oidc/login - Iniciar el login con el proveedor OIDC
+		r.Get("/oidc/callback", oidcCallback)                // GET /oidc/callback - Retorno del proveedor OIDC
+		r.Post("/invitations/accept", acceptInvitation)      // POST /invitations/accept - El invitado elige su contraseña
+		r.Post("/confirm-email", confirmEmailChange)         // POST /confirm-email - Confirmar un cambio de email con el token recibido
 	})
 
-	r.Post("/request-recovery", requestPasswordRecovery) // POST /request-recovery - Solicitar recuperación de contraseña
-	r.Post("/change-password", changePassword)           // POST /change-password - Cambio de contraseña para un usuario
+	r.Post("/token/refresh", refreshToken) // POST /token/refresh - Renovar el access token con el refresh token
 
 	r.Group(func(r chi.Router) {
 		r.Use(AuthMiddleware)
-		r.Post("/attachments", HandleUpload(minioClient, bucketName))
-		r.Post("/attachment-remove", HandleRemove(minioClient, bucketName))
+
+		r.Post("/logout", logout)        // POST /logout - Cerrar la sesión actual
+		r.Post("/logout-all", logoutAll) // POST /logout-all - Cerrar todas las sesiones del usuario
+
+		// Perfil del usuario autenticado
+		r.Route("/profile", func(r chi.Router) {
+			r.Get("/", getProfile)                // GET /profile - Perfil del usuario autenticado
+			r.Put("/", updateProfile)             // PUT /profile - Actualizar nombre, email y preferencias
+			r.Post("/password", changeMyPassword) // POST /profile/password - Cambiar la contraseña propia
+		})
+
+		// Segundo factor (TOTP) del usuario autenticado
+		r.Route("/2fa", func(r chi.Router) {
+			r.Get("/", getTwoFactorStatus)                     // GET /2fa - Estado del 2FA
+			r.Post("/enroll", enrollTwoFactor)                 // POST /2fa/enroll - Generar secreto y URI otpauth
+			r.Post("/confirm", confirmTwoFactor)               // POST /2fa/confirm - Activar con el primer código
+			r.Post("/disable", disableTwoFactor)               // POST /2fa/disable - Desactivar
+			r.Post("/recovery-codes", regenerateRecoveryCodes) // POST /2fa/recovery-codes - Regenerar códigos de recuperación
+		})
+
+		// Permisos efectivos del usuario autenticado
+		r.Get("/permissions", getMyPermissions)
+
+		r.Group(func(r chi.Router) {
+			r.Use(RequirePermission(PermAttachmentsWrite))
+			r.Post("/attachments", HandleUpload(minioClient, bucketName))
+			r.Post("/attachment-remove", HandleRemove(minioClient, bucketName))
+		})
+
+		// API keys para integraciones: cada usuario gestiona las suyas, users:admin las de todos
+		r.Route("/api-keys", func(r chi.Router) {
+			r.Get("/", getAPIKeys)          // GET /api-keys - Listar API keys
+			r.Post("/", createAPIKey)       // POST /api-keys - Crear una API key (la clave se muestra una sola vez)
+			r.Delete("/{id}", revokeAPIKey) // DELETE /api-keys/{id} - Revocar una API key
+		})
 
 		// Definir las rutas para usuarios
 		r.Route("/users", func(r chi.Router) {
-			r.Get("/", getUsers)          // GET /users - Obtener todos los usuarios
-			r.Get("/{id}", getUserByID)   // GET /users/{id} - Obtener un usuario por su ID
-			r.Post("/", createUser)       // POST /users - Crear un nuevo usuario
-			r.Put("/{id}", updateUser)    // PUT /users/{id} - Actualizar un usuario existente
-			r.Delete("/{id}", deleteUser) // DELETE /users/{id} - Eliminar un usuario
+			r.Use(RequireByMethod(PermUsersRead, PermUsersAdmin))
+			r.Get("/", getUsers)                         // GET /users - Obtener todos los usuarios
+			r.Get("/{id}", getUserByID)                  // GET /users/{id} - Obtener un usuario por su ID
+			r.Post("/", createUser)                      // POST /users - Crear un nuevo usuario
+			r.Post("/invite", inviteUser)                // POST /users/invite - Invitar a un usuario por email
+			r.Put("/{id}", updateUser)                   // PUT /users/{id} - Actualizar un usuario existente
+			r.Delete("/{id}", deactivateUser)            // DELETE /users/{id} - Desactivar un usuario (?transfer_to={id} le pasa sus proyectos y reportes)
+			r.Post("/{id}/reactivate", reactivateUser)   // POST /users/{id}/reactivate - Reactivar un usuario desactivado
+			r.Post("/{id}/transfer", transferUser)       // POST /users/{id}/transfer - Transferir sus proyectos y reportes a otro usuario
+			r.Post("/{id}/impersonate", impersonateUser) // POST /users/{id}/impersonate - Ver la API como otro usuario (solo administradores)
+			r.Delete("/{id}/2fa", resetUserTwoFactor)    // DELETE /users/{id}/2fa - Resetear el 2FA de un usuario
+			r.Get("/{id}/projects", getUserProjects)     // GET /users/{id}/projects - Proyectos asignados y su rol en cada uno
+
+			// Bloqueos por intentos fallidos, solo administradores
+			r.With(RequirePermission(PermUsersAdmin)).Get("/locks", getUserLocks)            // GET /users/locks - Cuentas bloqueadas
+			r.With(RequirePermission(PermUsersAdmin)).Get("/failed-logins", getFailedLogins) // GET /users/failed-logins - Intentos de login fallidos
+			r.Delete("/{id}/lock", clearUserLock)                                            // DELETE /users/{id}/lock - Desbloquear una cuenta
+
+			// Invitaciones, solo administradores
+			r.With(RequirePermission(PermUsersAdmin)).Get("/invitations", getInvitations) // GET /users/invitations - Listar invitaciones
+			r.Post("/invitations/{id}/resend", resendInvitation)                          // POST /users/invitations/{id}/resend - Reenviar con un link nuevo
+			r.Delete("/invitations/{id}", revokeInvitation)                               // DELETE /users/invitations/{id} - Revocar una invitación pendiente
 
 			// Rutas adicionales para operaciones específicas de usuarios
 		})
 		// Rutas para "clients"
 		r.Route("/clients", func(r chi.Router) {
+			r.Use(RequireByMethod(PermDirectoryRead, PermDirectoryWrite))
 			r.Get("/", getClients)
 			r.Post("/", createClient)
 			r.Route("/{id}", func(r chi.Router) {
@@ -74,6 +132,7 @@ func initRoutes() *chi.Mux {
 		})
 
 		r.Route("/contacts", func(r chi.Router) {
+			r.Use(RequireByMethod(PermDirectoryRead, PermDirectoryWrite))
 			r.Get("/", getContacts)
 			r.Post("/", createContact)
 			r.Route("/{id}", func(r chi.Router) {
@@ -85,27 +144,68 @@ func initRoutes() *chi.Mux {
 
 		// Rutas para "projects"
 		r.Route("/projects", func(r chi.Router) {
-			r.Get("/", getProjects)
-			r.Post("/", createProject)
+			r.Group(func(r chi.Router) {
+				r.Use(RequireByMethod(PermProjectsRead, PermProjectsWrite))
+				r.Get("/", getProjects)
+				r.Post("/", createProject)
+				r.Get("/code-preview", previewProjectCodeHandler) // GET /projects/code-preview - Código que recibiría el próximo proyecto
+				r.Get("/sla-breaches", getSLABreaches)            // GET /projects/sla-breaches - Proyectos que superan el SLA de su estado
+				r.Get("/status-metrics", getStatusMetrics)        // GET /projects/status-metrics - Tiempo medio y mediano por estado
+				r.Get("/overdue-summary", getOverdueSummary)      // GET /projects/overdue-summary - Atrasados y por vencer por cliente y estado
+			})
 			r.Route("/{id}", func(r chi.Router) {
+				r.Use(RequireProjectVisible)
 				// rutas para reportes de proyectos
-				r.Get("/reports", getReportsByProject)
-				r.Post("/reports", createReport)
-				r.Route("/reports/{reportID}", func(r chi.Router) {
-					r.Get("/", getReportByID)
-					r.Put("/", updateReport)
-					r.Delete("/", deleteReport)
+				r.Group(func(r chi.Router) {
+					r.Use(RequireByMethod(PermReportsRead, PermReportsWrite))
+					r.Get("/reports", getReportsByProject)
+					r.Post("/reports", createReport)
+					r.Route("/reports/{reportID}", func(r chi.Router) {
+						r.Get("/", getReportByID)
+						r.Put("/", updateReport)
+						r.Delete("/", deleteReport)
+					})
+				})
+				r.Group(func(r chi.Router) {
+					r.Use(RequireByMethod(PermProjectsRead, PermProjectsWrite))
+					r.Get("/", getProjectByID)
+					r.Put("/", updateProject)
+					r.Delete("/", deleteProject)
+					r.Post("/transition", transitionProject)                      // POST /projects/{id}/transition - Cambiar de estado según el flujo
+					r.Get("/status-history", getProjectStatusHistory)             // GET /projects/{id}/status-history - Historial de estados
+					r.Get("/status-durations", getProjectStatusDurations)         // GET /projects/{id}/status-durations - Tiempo en cada estado
+					r.Get("/timeline", getProjectTimeline)                        // GET /projects/{id}/timeline - Eventos del proyecto en orden cronológico
+					r.Get("/members", getProjectMembers)                          // GET /projects/{id}/members - Equipo del proyecto
+					r.Post("/members", addProjectMember)                          // POST /projects/{id}/members - Asignar un usuario con un rol
+					r.Put("/members/{userID}", updateProjectMember)               // PUT /projects/{id}/members/{userID} - Cambiar el rol
+					r.Delete("/members/{userID}", removeProjectMember)            // DELETE /projects/{id}/members/{userID} - Quitar del proyecto
+					r.Get("/milestones", getProjectMilestones)                    // GET /projects/{id}/milestones - Hitos del proyecto
+					r.Post("/milestones", createProjectMilestone)                 // POST /projects/{id}/milestones - Agregar un hito
+					r.Put("/milestones/{milestoneID}", updateProjectMilestone)    // PUT /projects/{id}/milestones/{milestoneID} - Editar o completar un hito
+					r.Delete("/milestones/{milestoneID}", deleteProjectMilestone) // DELETE /projects/{id}/milestones/{milestoneID} - Borrar un hito
+				})
+				// tareas y checklists
+				r.Group(func(r chi.Router) {
+					r.Use(RequireByMethod(PermProjectsRead, PermTasksWrite))
+					r.Get("/tasks", getProjectTasks)                           // GET /projects/{id}/tasks - Tareas del proyecto
+					r.Post("/tasks", createProjectTask)                        // POST /projects/{id}/tasks - Crear una tarea con su checklist
+					r.Get("/tasks/{taskID}", getProjectTask)                   // GET /projects/{id}/tasks/{taskID} - Tarea con su checklist
+					r.Put("/tasks/{taskID}", updateProjectTask)                // PUT /projects/{id}/tasks/{taskID} - Editar la tarea
+					r.Delete("/tasks/{taskID}", deleteProjectTask)             // DELETE /projects/{id}/tasks/{taskID} - Borrar la tarea
+					r.Post("/tasks/{taskID}/items", addTaskItem)               // POST /projects/{id}/tasks/{taskID}/items - Agregar un ítem
+					r.Put("/tasks/{taskID}/items/order", reorderTaskItems)     // PUT /projects/{id}/tasks/{taskID}/items/order - Reordenar la checklist
+					r.Put("/tasks/{taskID}/items/{itemID}", updateTaskItem)    // PUT /projects/{id}/tasks/{taskID}/items/{itemID} - Editar o marcar un ítem
+					r.Delete("/tasks/{taskID}/items/{itemID}", removeTaskItem) // DELETE /projects/{id}/tasks/{taskID}/items/{itemID} - Quitar un ítem
 				})
-				r.Get("/", getProjectByID)
-				r.Put("/", updateProject)
-				r.Delete("/", deleteProject)
 			})
 		})
 		r.Route("/reports", func(r chi.Router) {
+			r.Use(RequireByMethod(PermReportsRead, PermReportsWrite))
 			r.Get("/", getReports)
 			r.Get("/all", getReportsData)
 			r.Post("/", createReport)
 			r.Route("/{id}", func(r chi.Router) {
+				r.Use(RequireReportVisible)
 				r.Get("/", getReportByID)
 				r.Put("/", updateReport)
 				r.Delete("/", deleteReport)
@@ -113,6 +213,7 @@ func initRoutes() *chi.Mux {
 		})
 
 		r.Route("/providers", func(r chi.Router) {
+			r.Use(RequireByMethod(PermDirectoryRead, PermDirectoryWrite))
 			r.Get("/", getProviders)
 			r.Post("/", createProvider)
 			r.Route("/{id}", func(r chi.Router) {
@@ -122,8 +223,20 @@ func initRoutes() *chi.Mux {
 			})
 		})
 
+		// Reglas del flujo de estados de cada categoría
+		r.Route("/project-status-transitions", func(r chi.Router) {
+			r.Use(RequireByMethod(PermCatalogRead, PermCatalogWrite))
+			r.Get("/", getStatusTransitions)
+			r.Post("/", createStatusTransition)
+			r.Route("/{id}", func(r chi.Router) {
+				r.Put("/", updateStatusTransition)
+				r.Delete("/", deleteStatusTransition)
+			})
+		})
+
 		// Rutas para "project-statuses"
 		r.Route("/project-statuses", func(r chi.Router) {
+			r.Use(RequireByMethod(PermCatalogRead, PermCatalogWrite))
 			r.Get("/", getProjectStatuses)
 			r.Post("/", createProjectStatus)
 			r.Route("/{id}", func(r chi.Router) {
@@ -135,6 +248,7 @@ func initRoutes() *chi.Mux {
 
 		// Rutas para "locations"
 		r.Route("/locations", func(r chi.Router) {
+			r.Use(RequireByMethod(PermDirectoryRead, PermDirectoryWrite))
 			r.Get("/", getLocations)
 			r.Post("/", createLocation)
 			r.Route("/{id}", func(r chi.Router) {
@@ -143,11 +257,12 @@ func initRoutes() *chi.Mux {
 				r.Delete("/", deleteLocation)
 			})
 		})
-		r.Get("/logs", getLogs)
-		r.Post("/feedback", createFeedback)
+		r.With(RequirePermission(PermLogsRead)).Get("/logs", getLogs)
+		r.With(RequirePermission(PermFeedbackWrite)).Post("/feedback", createFeedback)
 
-		// Rutas para "settings"
+		// Rutas para "settings", incluyen la API key de Mailgun así que incluso leerlas es de administrador
 		r.Route("/settings", func(r chi.Router) {
+			r.Use(RequirePermission(PermSettingsAdmin))
 			r.Get("/", getSettings)
 			r.Post("/", createSetting)
 			r.Route("/{id}", func(r chi.Router) {
@@ -157,6 +272,7 @@ func initRoutes() *chi.Mux {
 		})
 
 		r.Route("/categories", func(r chi.Router) {
+			r.Use(RequireByMethod(PermCatalogRead, PermCatalogWrite))
 			r.Get("/", getCategories)   // Obtener todas las categorías
 			r.Post("/", createCategory) // Crear una nueva categoría
 			r.Route("/{id}", func(r chi.Router) {
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"magpanel/database"
+	"magpanel/models"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Duración de los tokens, configurable en la sección [keys] de data.conf
+var (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// sessionIDKey guarda en el contexto la sesión a la que pertenece el access token del request
+const sessionIDKey contextKey = "sessionID"
+
+var (
+	errInvalidRefreshToken = errors.New("refresh token inválido o expirado")
+	errRefreshTokenReused  = errors.New("refresh token reutilizado, la sesión fue revocada")
+)
+
+// tokenResponse es la respuesta de login y de /token/refresh
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"` // Las sesiones de suplantación no se renuevan
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"` // segundos de validez del access token
+}
+
+// generateToken devuelve n bytes aleatorios codificados en hexadecimal
+func generateToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// hashToken usa SHA-256: los tokens ya son aleatorios, no hace falta un hash lento como el de contraseñas
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createSession abre una sesión nueva para el usuario y devuelve el par de tokens
+func createSession(r *http.Request, userID int, userName string) (*tokenResponse, error) {
+	sessionID, err := generateToken(16)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := generateToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	userAgent := r.UserAgent()
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+
+	_, err = dataBase.Insert(true, "INSERT INTO sessions (id, user_id, refresh_hash, user_agent, ip, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		sessionID, userID, hashToken(refreshToken), userAgent, clientIP(r), database.FormatTime(time.Now().Add(refreshTokenTTL)))
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := generateAccessToken(userID, userName, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return &tokenResponse{AccessToken: accessToken, RefreshToken: refreshToken, TokenType: "Bearer", ExpiresIn: int(accessTokenTTL.Seconds())}, nil
+}
+
+// rotateRefreshToken canjea un refresh token por un par nuevo. El token usado queda invalidado;
+// si alguien vuelve a presentar un token ya rotado se asume que fue robado y se revoca la sesión.
+func rotateRefreshToken(ctx context.Context, refreshToken string) (*tokenResponse, int, error) {
+	var userID int
+	var userName, sessionID string
+	var newRefreshToken string
+	reused := false
+
+	hash := hashToken(refreshToken)
+	err := dataBase.WithTx(ctx, func(tx *database.Tx) error {
+		var currentHash, expiresAt, status string
+		var revokedAt sql.NullString
+		row, err := tx.SelectRow("SELECT s.id, s.user_id, s.refresh_hash, s.expires_at, s.revoked_at, u.name, u.status FROM sessions s JOIN users u ON s.user_id = u.id "+
+			"WHERE s.refresh_hash = ? OR s.previous_refresh_hash = ? FOR UPDATE", hash, hash)
+		if err != nil {
+			return err
+		}
+		if err := row.Scan(&sessionID, &userID, &currentHash, &expiresAt, &revokedAt, &userName, &status); err != nil {
+			if err == sql.ErrNoRows {
+				return errInvalidRefreshToken
+			}
+			return err
+		}
+		if revokedAt.Valid || status == userStatusInactive {
+			return errInvalidRefreshToken
+		}
+		if currentHash != hash {
+			reused = true
+			_, err := tx.Update(false, "UPDATE sessions SET revoked_at = ? WHERE id = ?", database.FormatTime(time.Now()), sessionID)
+			if err != nil {
+				return err
+			}
+			return nil
+		}
+		expires, err := database.ParseTime(expiresAt)
+		if err != nil || time.Now().After(expires) {
+			return errInvalidRefreshToken
+		}
+
+		newRefreshToken, err = generateToken(32)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Update(false, "UPDATE sessions SET previous_refresh_hash = refresh_hash, refresh_hash = ?, last_used_at = ? WHERE id = ?",
+			hashToken(newRefreshToken), database.FormatTime(time.Now()), sessionID)
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if reused {
+		log.Printf("Refresh token reutilizado en la sesión %s del usuario %d, sesión revocada", sessionID, userID)
+		return nil, userID, errRefreshTokenReused
+	}
+
+	accessToken, err := generateAccessToken(userID, userName, sessionID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &tokenResponse{AccessToken: accessToken, RefreshToken: newRefreshToken, TokenType: "Bearer", ExpiresIn: int(accessTokenTTL.Seconds())}, userID, nil
+}
+
+// revokeSession invalida una sesión; sus access tokens dejan de aceptarse de inmediato
+func revokeSession(sessionID string) error {
+	_, err := dataBase.Update(true, "UPDATE sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", database.FormatTime(time.Now()), sessionID)
+	return err
+}
+
+// revokeUserSessions invalida todas las sesiones abiertas de un usuario
+func revokeUserSessions(userID interface{}) (int64, error) {
+	return dataBase.Update(true, "UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", database.FormatTime(time.Now()), userID)
+}
+
+// checkSession verifica que la sesión del access token siga abierta y que sea del mismo tipo
+// (normal o de suplantación por impersonatorID) que el token
+func checkSession(sessionID string, userID, impersonatorID int) error {
+	if sessionID == "" {
+		return fmt.Errorf("token sin sesión, volvé a iniciar sesión")
+	}
+	var expiresAt string
+	var revokedAt sql.NullString
+	var impersonator sql.NullInt64
+	row, err := dataBase.SelectRow("SELECT expires_at, revoked_at, impersonator_id FROM sessions WHERE id = ? AND user_id = ?", sessionID, userID)
+	if err != nil {
+		return err
+	}
+	if err := row.Scan(&expiresAt, &revokedAt, &impersonator); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("sesión inexistente")
+		}
+		return err
+	}
+	if revokedAt.Valid {
+		return fmt.Errorf("sesión cerrada")
+	}
+	if int(impersonator.Int64) != impersonatorID {
+		return fmt.Errorf("sesión inexistente")
+	}
+	if expires, err := database.ParseTime(expiresAt); err != nil || time.Now().After(expires) {
+		return fmt.Errorf("sesión expirada")
+	}
+	return nil
+}
+
+// parseAccessToken valida firma, vencimiento y sesión de un access token
+func parseAccessToken(tokenString string) (*models.Claims, error) {
+	claims := &models.Claims{}
+	// La clave se elige por el `kid` del header entre las claves vigentes del keyring
+	token, err := jwt.ParseWithClaims(tokenString, claims, keyring.verificationKey)
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("token inválido o expirado")
+	}
+	if err := checkSession(claims.SessionID, int(claims.UserID), int(claims.ImpersonatorID)); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// requestSessionID devuelve la sesión del access token con el que se autenticó el request
+func requestSessionID(r *http.Request) (string, bool) {
+	sessionID, ok := r.Context().Value(sessionIDKey).(string)
+	return sessionID, ok
+}
+
+// trustProxyHeaders indica si la API corre detrás de un proxy que completa X-Forwarded-For
+var trustProxyHeaders bool
+
+// clientIP devuelve la IP remota del request, sin el puerto
+func clientIP(r *http.Request) string {
+	if trustProxyHeaders {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testSession abre una sesión para el usuario y devuelve los dos tokens
+func testSession(t *testing.T, userID int) *tokenResponse {
+	t.Helper()
+	tokens, err := createSession(httptest.NewRequest("POST", "/login", nil), userID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+// refresh canjea el refresh token en POST /token/refresh y devuelve el código y los tokens nuevos
+func refresh(t *testing.T, handler http.Handler, refreshToken string) (int, *tokenResponse) {
+	t.Helper()
+	w := doRequest(t, handler, "POST", "/token/refresh", "", fmt.Sprintf(`{"refresh_token": %q}`, refreshToken))
+	var tokens tokenResponse
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &tokens); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code, &tokens
+}
+
+func authorized(t *testing.T, handler http.Handler, accessToken string) bool {
+	t.Helper()
+	return doRequest(t, handler, "GET", "/permissions", accessToken, "").Code == http.StatusOK
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	newTestDatabase(t)
+	userID := createTestUser(t, "ana", 1)
+	router := initRoutes()
+	first := testSession(t, userID)
+
+	code, second := refresh(t, router, first.RefreshToken)
+	if code != http.StatusOK || second.RefreshToken == "" || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("POST /token/refresh = %d, refresh token nuevo %q", code, second.RefreshToken)
+	}
+	if !authorized(t, router, second.AccessToken) {
+		t.Fatal("el access token renovado no sirve")
+	}
+
+	// Reusar el refresh token ya canjeado revoca la sesión entera
+	if code, _ := refresh(t, router, first.RefreshToken); code != http.StatusUnauthorized {
+		t.Fatalf("reusar el refresh token = %d, se esperaba 401", code)
+	}
+	if authorized(t, router, first.AccessToken) || authorized(t, router, second.AccessToken) {
+		t.Error("los access tokens de la sesión revocada siguen sirviendo")
+	}
+	if code, _ := refresh(t, router, second.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("el último refresh token de la sesión revocada = %d, se esperaba 401", code)
+	}
+}
+
+func TestRefreshTokenReuseKeepsOtherSessions(t *testing.T) {
+	newTestDatabase(t)
+	userID := createTestUser(t, "ana", 1)
+	router := initRoutes()
+	stolen, other := testSession(t, userID), testSession(t, userID)
+
+	if code, _ := refresh(t, router, stolen.RefreshToken); code != http.StatusOK {
+		t.Fatalf("POST /token/refresh = %d", code)
+	}
+	if code, _ := refresh(t, router, stolen.RefreshToken); code != http.StatusUnauthorized {
+		t.Fatalf("reusar el refresh token = %d, se esperaba 401", code)
+	}
+	if !authorized(t, router, other.AccessToken) {
+		t.Error("la reutilización cerró también otra sesión del usuario")
+	}
+	if code, _ := refresh(t, router, "no-existe"); code != http.StatusUnauthorized {
+		t.Errorf("refresh token desconocido = %d, se esperaba 401", code)
+	}
+}
+
+func TestLogoutRevokesAccessToken(t *testing.T) {
+	newTestDatabase(t)
+	userID := createTestUser(t, "ana", 1)
+	router := initRoutes()
+	current, other := testSession(t, userID), testSession(t, userID)
+
+	if w := doRequest(t, router, "POST", "/logout", current.AccessToken, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("POST /logout = %d", w.Code)
+	}
+	// El access token todavía no venció, pero su sesión (sid) está cerrada
+	if authorized(t, router, current.AccessToken) {
+		t.Error("el access token sigue sirviendo después del logout")
+	}
+	if code, _ := refresh(t, router, current.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("refresh de la sesión cerrada = %d, se esperaba 401", code)
+	}
+	if !authorized(t, router, other.AccessToken) {
+		t.Error("el logout cerró también otra sesión del usuario")
+	}
+}
+
+func TestLogoutAllRevokesEverySession(t *testing.T) {
+	newTestDatabase(t)
+	userID := createTestUser(t, "ana", 1)
+	otherUserID := createTestUser(t, "beto", 1)
+	router := initRoutes()
+	first, second := testSession(t, userID), testSession(t, userID)
+	unrelated := testSession(t, otherUserID)
+
+	if w := doRequest(t, router, "POST", "/logout-all", first.AccessToken, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("POST /logout-all = %d", w.Code)
+	}
+	for i, tokens := range []*tokenResponse{first, second} {
+		if authorized(t, router, tokens.AccessToken) {
+			t.Errorf("sesión %d: el access token sigue sirviendo después de logout-all", i+1)
+		}
+		if code, _ := refresh(t, router, tokens.RefreshToken); code != http.StatusUnauthorized {
+			t.Errorf("sesión %d: refresh después de logout-all = %d, se esperaba 401", i+1, code)
+		}
+	}
+	if !authorized(t, router, unrelated.AccessToken) {
+		t.Error("logout-all cerró la sesión de otro usuario")
+	}
+}
+
+func TestAccessTokenWithoutSessionIsRejected(t *testing.T) {
+	newTestDatabase(t)
+	userID := createTestUser(t, "ana", 1)
+	token, err := generateAccessToken(userID, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authorized(t, initRoutes(), token) {
+		t.Error("se aceptó un access token sin sid")
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"magpanel/database"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP según RFC 6238 con los parámetros que soportan todas las apps (SHA1, 6 dígitos, 30 segundos)
+const (
+	totpIssuer = "MagPanel"
+	totpPeriod = 30
+	totpDigits = 6
+	totpSkew   = 1 // pasos de tolerancia hacia atrás y hacia adelante por desfase de reloj
+
+	twoFactorChallengeTTL  = 5 * time.Minute
+	twoFactorMaxAttempts   = 5
+	twoFactorRecoveryCodes = 10
+	// Tiempo durante el cual el login reutiliza el secreto pendiente de confirmar en vez de generar otro
+	twoFactorPendingSecretTTL = 24 * time.Hour
+)
+
+// Propósitos del challenge que se emite en el login cuando falta el segundo factor
+const (
+	challengeVerify = "verify" // el usuario ya tiene 2FA, debe ingresar el código
+	challengeEnroll = "enroll" // su rol exige 2FA y todavía no lo configuró
+)
+
+var errInvalidChallenge = errors.New("challenge inválido o expirado, volvé a iniciar sesión")
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateTOTPSecret devuelve un secreto nuevo de 160 bits en base32
+func generateTOTPSecret() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(buf), nil
+}
+
+// totpURI arma el otpauth:// que las apps leen desde un código QR
+func totpURI(secret, account string) string {
+	label := url.PathEscape(totpIssuer + ":" + account)
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", totpIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprintf("%d", totpDigits))
+	query.Set("period", fmt.Sprintf("%d", totpPeriod))
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// matchTOTP busca el paso de tiempo en el que el código es válido. Solo se aceptan pasos
+// posteriores a lastStep para que un mismo código no pueda usarse dos veces.
+func matchTOTP(secret, code string, lastStep int64) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := time.Now().Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// pendingTOTPSecret devuelve el secreto pendiente de confirmar del usuario para enrolarse en el
+// login. Mientras no venza twoFactorPendingSecretTTL se reutiliza el mismo, así repetir el login no
+// invalida el QR que el usuario ya escaneó; si no hay uno vigente se genera otro.
+func pendingTOTPSecret(ctx context.Context, userID int) (string, error) {
+	var secret string
+	err := dataBase.WithTx(ctx, func(tx *database.Tx) error {
+		var current, createdAt sql.NullString
+		row, err := tx.SelectRow("SELECT totp_secret, totp_secret_created_at FROM users WHERE id = ? AND totp_enabled = 0 FOR UPDATE", userID)
+		if err != nil {
+			return err
+		}
+		if err := row.Scan(&current, &createdAt); err != nil {
+			return err
+		}
+		if current.String != "" && createdAt.Valid {
+			if created, err := database.ParseTime(createdAt.String); err == nil && time.Since(created) < twoFactorPendingSecretTTL {
+				secret = current.String
+				return nil
+			}
+		}
+
+		if secret, err = generateTOTPSecret(); err != nil {
+			return fmt.Errorf("Error al generar el secreto 2FA")
+		}
+		_, err = tx.Update(false, "UPDATE users SET totp_secret = ?, totp_secret_created_at = ?, totp_last_step = 0 WHERE id = ?",
+			secret, database.FormatTime(time.Now()), userID)
+		return err
+	})
+	return secret, err
+}
+
+// verifyUserTOTP valida un código contra el secreto del usuario (activo o pendiente de confirmar)
+func verifyUserTOTP(userID int, code string) (bool, error) {
+	var secret sql.NullString
+	var lastStep int64
+	row, err := dataBase.SelectRow("SELECT totp_secret, totp_last_step FROM users WHERE id = ?", userID)
+	if err != nil {
+		return false, err
+	}
+	if err := row.Scan(&secret, &lastStep); err != nil {
+		return false, err
+	}
+	if !secret.Valid || secret.String == "" {
+		return false, nil
+	}
+
+	step, ok := matchTOTP(secret.String, code, lastStep)
+	if !ok {
+		return false, nil
+	}
+	// Solo gana quien actualiza primero el último paso usado
+	updated, err := dataBase.Update(false, "UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?", step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	return updated == 1, nil
+}
+
+// useRecoveryCode marca como usado un código de recuperación válido del usuario
+func useRecoveryCode(userID int, code string) (bool, error) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	updated, err := dataBase.Update(false, "UPDATE two_factor_recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		database.FormatTime(time.Now()), userID, hashToken(code))
+	if err != nil {
+		return false, err
+	}
+	return updated == 1, nil
+}
+
+// replaceRecoveryCodes borra los códigos anteriores del usuario y genera nuevos, que se muestran una sola vez
+func replaceRecoveryCodes(tx *database.Tx, userID int) ([]string, error) {
+	if _, err := tx.Delete(false, "DELETE FROM two_factor_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, twoFactorRecoveryCodes)
+	for i := 0; i < twoFactorRecoveryCodes; i++ {
+		random, err := generateToken(5)
+		if err != nil {
+			return nil, err
+		}
+		code := random[:5] + "-" + random[5:]
+		if _, err := tx.Insert(false, "INSERT INTO two_factor_recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, hashToken(code)); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// enableTwoFactor activa el secreto pendiente del usuario y devuelve sus códigos de recuperación
+func enableTwoFactor(ctx context.Context, userID int) ([]string, error) {
+	var codes []string
+	err := dataBase.WithTx(ctx, func(tx *database.Tx) error {
+		if _, err := tx.Update(false, "UPDATE users SET totp_enabled = 1 WHERE id = ?", userID); err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	return codes, err
+}
+
+// clearTwoFactor desactiva el 2FA del usuario y borra su secreto y sus códigos de recuperación
+func clearTwoFactor(ctx context.Context, userID interface{}) error {
+	return dataBase.WithTx(ctx, func(tx *database.Tx) error {
+		if _, err := tx.Update(false, "UPDATE users SET totp_secret = NULL, totp_enabled = 0, totp_last_step = 0 WHERE id = ?", userID); err != nil {
+			return err
+		}
+		_, err := tx.Delete(false, "DELETE FROM two_factor_recovery_codes WHERE user_id = ?", userID)
+		return err
+	})
+}
+
+// twoFactorRequiredForRank indica si el rol del rank figura en el ajuste two_factor_required_roles
+func twoFactorRequiredForRank(rank int) bool {
+	var value string
+	row, err := dataBase.SelectRow("SELECT `value` FROM settings WHERE `key` = 'two_factor_required_roles'")
+	if err != nil {
+		return false
+	}
+	row.Scan(&value)
+
+	role := roleForRank(rank)
+	for _, required := range strings.Split(value, ",") {
+		if strings.TrimSpace(required) == role {
+			return true
+		}
+	}
+	return false
+}
+
+// createTwoFactorChallenge emite el token temporal que reemplaza a la contraseña en el segundo paso del login
+func createTwoFactorChallenge(userID int, purpose string) (string, error) {
+	token, err := generateToken(32)
+	if err != nil {
+		return "", err
+	}
+	_, err = dataBase.Insert(false, "INSERT INTO two_factor_challenges (token_hash, user_id, purpose, expires_at) VALUES (?, ?, ?, ?)",
+		hashToken(token), userID, purpose, database.FormatTime(time.Now().Add(twoFactorChallengeTTL)))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// takeTwoFactorChallenge valida el challenge y cuenta un intento; después de
+// twoFactorMaxAttempts intentos el challenge deja de servir. El intento se cuenta en el mismo
+// UPDATE que verifica el límite, así los requests en paralelo no pueden superarlo.
+func takeTwoFactorChallenge(token string) (int, string, error) {
+	var userID int
+	var purpose string
+	hash := hashToken(token)
+
+	updated, err := dataBase.Update(false, "UPDATE two_factor_challenges SET attempts = attempts + 1 WHERE token_hash = ? AND attempts < ? AND expires_at > ?",
+		hash, twoFactorMaxAttempts, database.FormatTime(time.Now()))
+	if err != nil {
+		return 0, "", err
+	}
+	if updated == 0 {
+		deleteTwoFactorChallenge(token)
+		return 0, "", errInvalidChallenge
+	}
+
+	row, err := dataBase.SelectRow("SELECT user_id, purpose FROM two_factor_challenges WHERE token_hash = ?", hash)
+	if err != nil {
+		return 0, "", err
+	}
+	if err := row.Scan(&userID, &purpose); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, "", errInvalidChallenge
+		}
+		return 0, "", err
+	}
+	return userID, purpose, nil
+}
+
+func deleteTwoFactorChallenge(token string) error {
+	_, err := dataBase.Delete(false, "DELETE FROM two_factor_challenges WHERE token_hash = ?", hashToken(token))
+	return err
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"magpanel/database"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// Vector de la RFC 6238 para SHA1 en T = 59, recortado a 6 dígitos
+	code, err := totpCode("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", 59/totpPeriod)
+	if err != nil || code != "287082" {
+		t.Errorf("totpCode = %s, %v, se esperaba 287082", code, err)
+	}
+}
+
+func TestMatchTOTPRejectsUsedSteps(t *testing.T) {
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := time.Now().Unix() / totpPeriod
+	code, _ := totpCode(secret, current)
+
+	step, ok := matchTOTP(secret, code[:3]+" "+code[3:], 0)
+	if !ok || step != current {
+		t.Fatalf("matchTOTP con el código actual = %d, %v", step, ok)
+	}
+	if _, ok := matchTOTP(secret, code, current); ok {
+		t.Error("matchTOTP aceptó un código de un paso ya usado")
+	}
+	old, _ := totpCode(secret, current-totpSkew-1)
+	if _, ok := matchTOTP(secret, old, 0); ok {
+		t.Error("matchTOTP aceptó un código fuera de la tolerancia")
+	}
+}
+
+func TestTwoFactorChallengeAttemptsAreCapped(t *testing.T) {
+	newTestDatabase(t)
+	userID := createTestUser(t, "ana", 1)
+	token, err := createTwoFactorChallenge(userID, challengeVerify)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Los intentos en paralelo no pueden superar el límite
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted := 0
+	for i := 0; i < 4*twoFactorMaxAttempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id, purpose, err := takeTwoFactorChallenge(token)
+			if err == nil {
+				if id != userID || purpose != challengeVerify {
+					t.Errorf("takeTwoFactorChallenge = %d, %s", id, purpose)
+				}
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			} else if err != errInvalidChallenge {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if accepted != twoFactorMaxAttempts {
+		t.Errorf("se aceptaron %d intentos, el límite es %d", accepted, twoFactorMaxAttempts)
+	}
+
+	var n int
+	row, _ := dataBase.SelectRow("SELECT COUNT(*) FROM two_factor_challenges")
+	if err := row.Scan(&n); err != nil || n != 0 {
+		t.Errorf("el challenge agotado no se borró (%d, %v)", n, err)
+	}
+}
+
+func TestTwoFactorChallengeExpires(t *testing.T) {
+	newTestDatabase(t)
+	userID := createTestUser(t, "ana", 1)
+	token, err := createTwoFactorChallenge(userID, challengeVerify)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dataBase.Update(false, "UPDATE two_factor_challenges SET expires_at = ?", "2000-01-01 00:00:00"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := takeTwoFactorChallenge(token); err != errInvalidChallenge {
+		t.Errorf("takeTwoFactorChallenge con un challenge vencido = %v", err)
+	}
+	if _, _, err := takeTwoFactorChallenge("no-existe"); err != errInvalidChallenge {
+		t.Errorf("takeTwoFactorChallenge con un token desconocido = %v", err)
+	}
+}
+
+// startTestTwoFactorLogin activa 2FA para el usuario, hace el primer paso del login y devuelve
+// el secreto y el challenge
+func startTestTwoFactorLogin(t *testing.T, handler http.Handler, username string) (secret, challenge string) {
+	t.Helper()
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dataBase.Update(false, "UPDATE users SET totp_secret = ?, totp_enabled = 1 WHERE username = ?", secret, username); err != nil {
+		t.Fatal(err)
+	}
+
+	w := doRequest(t, handler, "POST", "/login", "", fmt.Sprintf(`{"username": %q, "password": %q}`, username, testPassword))
+	var response struct {
+		Required  bool   `json:"two_factor_required"`
+		Challenge string `json:"challenge_token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || !response.Required || response.Challenge == "" {
+		t.Fatalf("POST /login con 2FA = %d %s", w.Code, w.Body)
+	}
+	return secret, response.Challenge
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	newTestDatabase(t)
+	createTestUser(t, "ana", 1)
+	router := initRoutes()
+	secret, challenge := startTestTwoFactorLogin(t, router, "ana")
+
+	code, _ := totpCode(secret, time.Now().Unix()/totpPeriod)
+	w := doRequest(t, router, "POST", "/login/2fa", "", fmt.Sprintf(`{"challenge_token": %q, "code": %q}`, challenge, code))
+	var tokens tokenResponse
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &tokens) != nil || tokens.AccessToken == "" {
+		t.Fatalf("POST /login/2fa = %d %s", w.Code, w.Body)
+	}
+
+	// El challenge se consume con el login
+	w = doRequest(t, router, "POST", "/login/2fa", "", fmt.Sprintf(`{"challenge_token": %q, "code": %q}`, challenge, code))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("reusar el challenge = %d, se esperaba 401", w.Code)
+	}
+}
+
+func TestTwoFactorLoginStopsAfterMaxAttempts(t *testing.T) {
+	newTestDatabase(t)
+	createTestUser(t, "ana", 1)
+	router := initRoutes()
+	secret, challenge := startTestTwoFactorLogin(t, router, "ana")
+
+	code, _ := totpCode(secret, time.Now().Unix()/totpPeriod)
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	for i := 0; i < twoFactorMaxAttempts; i++ {
+		w := doRequest(t, router, "POST", "/login/2fa", "", fmt.Sprintf(`{"challenge_token": %q, "code": %q}`, challenge, wrong))
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("intento %d = %d, se esperaba 401", i+1, w.Code)
+		}
+	}
+
+	// Agotado el challenge, ni siquiera el código correcto sirve
+	w := doRequest(t, router, "POST", "/login/2fa", "", fmt.Sprintf(`{"challenge_token": %q, "code": %q}`, challenge, code))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("código correcto con el challenge agotado = %d %s, se esperaba 401", w.Code, w.Body)
+	}
+}
+
+func TestTwoFactorEnrollLoginKeepsPendingSecret(t *testing.T) {
+	newTestDatabase(t)
+	id := createTestUser(t, "ana", 1)
+	if _, err := dataBase.Update(false, "UPDATE settings SET `value` = ? WHERE `key` = 'two_factor_required_roles'", RoleTechnician); err != nil {
+		t.Fatal(err)
+	}
+	router := initRoutes()
+	login := func() string {
+		t.Helper()
+		w := doRequest(t, router, "POST", "/login", "", fmt.Sprintf(`{"username": "ana", "password": %q}`, testPassword))
+		var response struct {
+			SetupRequired bool   `json:"two_factor_setup_required"`
+			Secret        string `json:"secret"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || !response.SetupRequired || response.Secret == "" {
+			t.Fatalf("POST /login sin 2FA configurado = %d %s", w.Code, w.Body)
+		}
+		return response.Secret
+	}
+
+	// Repetir el login no cambia el secreto que el usuario pudo haber escaneado
+	secret := login()
+	if again := login(); again != secret {
+		t.Errorf("el segundo login generó otro secreto: %s, antes %s", again, secret)
+	}
+
+	// Vencido el secreto pendiente se genera uno nuevo
+	old := database.FormatTime(time.Now().Add(-twoFactorPendingSecretTTL - time.Minute))
+	if _, err := dataBase.Update(false, "UPDATE users SET totp_secret_created_at = ? WHERE id = ?", old, id); err != nil {
+		t.Fatal(err)
+	}
+	if again := login(); again == secret {
+		t.Error("el login reutilizó un secreto pendiente vencido")
+	}
+}
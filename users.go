@@ -1,36 +1,28 @@
 package main
 
 import (
-	"crypto/rand"
-	"crypto/subtle"
+	"bufio"
 	"database/sql"
-	"encoding/hex"
+	"flag"
 	"fmt"
+	"html"
 	"log"
 	"magpanel/models"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt"
 	"github.com/mailgun/mailgun-go"
-	"golang.org/x/crypto/argon2"
 )
 
-func sendRecoveryEmail(email, token string) error {
-	// Obtener la configuración de Mailgun desde la base de datos
-	domain, apiKey, err := getMailgunConfig()
-	if err != nil {
-		return err
-	}
-
-	// Configuración de Mailgun
-	mg := mailgun.NewMailgun(domain, apiKey)
+// Logo que encabeza los correos enviados por la API
+const emailLogoURL = "https://mag-servicios.com/wp-content/uploads/2022/12/01-4.png"
 
+func sendRecoveryEmail(email, token string) error {
 	// Construir el mensaje de correo electrónico en formato HTML
-	sender := "no-reply@mag-servicios.com" // Considera también almacenar esto en la tabla de configuraciones
 	subject := "Recuperación de contraseña"
-	logoURL := "https://mag-servicios.com/wp-content/uploads/2022/12/01-4.png"
 	body := fmt.Sprintf(`
 	<html>
 	<body>
@@ -42,12 +34,63 @@ func sendRecoveryEmail(email, token string) error {
 		</div>
 	</body>
 	</html>
-	`, logoURL, token, token)
+	`, emailLogoURL, token, token)
+
+	return sendHTMLEmail(email, subject, body)
+}
+
+// sendEmailChangeEmail envía a la dirección nueva el token que confirma el cambio de email
+func sendEmailChangeEmail(email, token string) error {
+	subject := "Confirmación de cambio de email"
+	body := fmt.Sprintf(`
+	<html>
+	<body>
+		<div style="text-align: center;">
+			<img src="%s" alt="Logo MAG Servicios" style="max-width: 200px; margin-bottom: 20px;">
+			<p>Recibimos un pedido para usar esta dirección en tu cuenta de MAG Servicios.</p>
+			<p>Tu token de confirmación es: <strong>%s</strong></p>
+			<a href="https://gestion.mag-servicios.com/confirm-email/%s/" style="display: inline-block; background-color: #007BFF; color: white; padding: 10px 20px; text-decoration: none; border-radius: 5px; font-weight: bold;">Confirmar Email</a>
+			<p>Si no fuiste vos, ignorá este mensaje: el email de tu cuenta no cambiará.</p>
+		</div>
+	</body>
+	</html>
+	`, emailLogoURL, token, token)
+
+	return sendHTMLEmail(email, subject, body)
+}
+
+// sendInvitationEmail envía el link con el que un usuario invitado elige su contraseña
+func sendInvitationEmail(email, name, inviterName, token string) error {
+	subject := "Invitación a MagPanel"
+	body := fmt.Sprintf(`
+	<html>
+	<body>
+		<div style="text-align: center;">
+			<img src="%s" alt="Logo MAG Servicios" style="max-width: 200px; margin-bottom: 20px;">
+			<p>Hola %s, %s te invitó a usar MagPanel.</p>
+			<p>Entrá al siguiente link para elegir tu contraseña y activar tu cuenta:</p>
+			<a href="https://gestion.mag-servicios.com/invitation/%s/" style="display: inline-block; background-color: #007BFF; color: white; padding: 10px 20px; text-decoration: none; border-radius: 5px; font-weight: bold;">Activar Cuenta</a>
+			<p>El link vence en %s y se puede usar una sola vez.</p>
+		</div>
+	</body>
+	</html>
+	`, emailLogoURL, html.EscapeString(name), html.EscapeString(inviterName), token, invitationTTL)
+
+	return sendHTMLEmail(email, subject, body)
+}
 
-	recipient := email
+// sendHTMLEmail envía un correo en formato HTML con la configuración de Mailgun guardada en la base
+func sendHTMLEmail(recipient, subject, body string) error {
+	// Obtener la configuración de Mailgun desde la base de datos
+	domain, apiKey, err := getMailgunConfig()
+	if err != nil {
+		return err
+	}
+
+	// Configuración de Mailgun
+	mg := mailgun.NewMailgun(domain, apiKey)
+	sender := "no-reply@mag-servicios.com" // Considera también almacenar esto en la tabla de configuraciones
 
-	// Asegúrate de utilizar la función adecuada para enviar mensajes en formato HTML.
-	// Si estás utilizando Mailgun, `NewMessage` debería ser reemplazado por `NewMessage` con el parámetro adecuado para indicar que el contenido es HTML
 	message := mg.NewMessage(sender, subject, "", recipient) // El cuerpo vacío se reemplaza por el parámetro de HTML a continuación
 	message.SetHtml(body)
 
@@ -102,125 +145,46 @@ func userExists(userID int) bool {
 	return userID != 0
 }
 
-func generateAccessToken(userID int, userName string) (string, error) {
+func generateAccessToken(userID int, userName, sessionID string) (string, error) {
 
-	expirationTime := time.Now().Add(24 * time.Hour * 180) // El token expira en 90 días
+	expirationTime := time.Now().Add(accessTokenTTL) // Vida corta, se renueva con el refresh token
 
-	// Crear un nuevo token que será del tipo JWT
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	// Se firma con la clave activa del keyring, que agrega su `kid` al header
+	return signAccessToken(jwt.MapClaims{
 		"user_id":   userID,
 		"user_name": userName,
+		"sid":       sessionID,
 		"exp":       expirationTime.Unix(),
 	})
-
-	// Firmar el token con tu clave secreta
-	tokenString, err := token.SignedString(jwtKey)
-	if err != nil {
-		return "", err
-	}
-
-	return tokenString, nil
-}
-
-func generateSalt() string {
-	salt := make([]byte, 16)
-	_, err := rand.Read(salt)
-	if err != nil {
-		// Manejar error adecuadamente
-	}
-	return hex.EncodeToString(salt)
 }
 
 func getUserFromToken(tokenString string) (*models.User, error) {
-	// Parsea el token
-	token, err := jwt.ParseWithClaims(tokenString, &models.Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil // `jwtKey` es tu clave secreta para validar el token
-	})
-
+	// Parsea el token y verifica que su sesión siga abierta
+	claims, err := parseAccessToken(tokenString)
 	if err != nil {
 		return nil, err // Maneja el error de parseo
 	}
 
-	// Comprueba la validez del token
-	if claims, ok := token.Claims.(*models.Claims); ok && token.Valid {
-		// Aquí `Claims` es la estructura que usaste para guardar la información en el token
-		userID := claims.UserID
-
-		// Usar el `userID` para buscar al usuario en tu base de datos
-		var user models.User
-		rows, err := dataBase.SelectRow("SELECT id, username, email FROM users WHERE id = ?", userID)
-		if err != nil {
-			return nil, err // Maneja el error de la base de datos
-		}
-		rows.Scan(&user.ID, &user.Username, &user.Email)
-
-		return &user, nil
-	} else {
-		return nil, fmt.Errorf("token inválido o expirado")
-	}
-}
-
-func hashPassword(password, salt string) string {
-	saltBytes, _ := hex.DecodeString(salt)
-	hash := argon2.IDKey([]byte(password), saltBytes, 1, 64*1024, 4, 32)
-	return hex.EncodeToString(hash)
-}
-func comparePasswords(hashedPassword, password, salt string) bool {
-	// Decodificar la sal desde hexadecimal a bytes
-	saltBytes, err := hex.DecodeString(salt)
+	// Usar el `userID` para buscar al usuario en tu base de datos
+	var user models.User
+	rows, err := dataBase.SelectRow("SELECT id, username, email FROM users WHERE id = ?", claims.UserID)
 	if err != nil {
-		log.Println("Error al decodificar la sal:", err)
-		return false
+		return nil, err // Maneja el error de la base de datos
 	}
+	rows.Scan(&user.ID, &user.Username, &user.Email)
 
-	// Decodificar el hash de la contraseña desde hexadecimal a bytes
-	hashedPasswordBytes, err := hex.DecodeString(hashedPassword)
-	if err != nil {
-		log.Println("Error al decodificar el hash de la contraseña:", err)
-		return false
-	}
-
-	// Calcular el hash de la contraseña proporcionada
-	hash := argon2.IDKey([]byte(password), saltBytes, 1, 64*1024, 4, 32)
-
-	log.Println("Contraseña proporcionada:", password)
-	log.Println("Hash de la contraseña proporcionada:", hex.EncodeToString(hash))
-	log.Println("Hash almacenado:", hashedPassword)
-	log.Println("Sal utilizada:", salt)
-
-	// Comparar los hashes
-	return subtle.ConstantTimeCompare(hashedPasswordBytes, hash) == 1
-}
-
-func verifyPassword(password, hashedPassword, salt string) bool {
-	// Generar el hash de la contraseña proporcionada con la sal almacenada
-	newHash := hashPassword(password, salt)
-	// Comparar los hashes
-	return hashedPassword == newHash
+	return &user, nil
 }
 
 func checkAccessToken(accessToken string) (int, error) {
-	// Parsear el token
-	token, err := jwt.Parse(accessToken, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
+	// Parsear el token y verificar que su sesión siga abierta
+	claims, err := parseAccessToken(accessToken)
 	if err != nil {
 		return 0, err
 	}
 
-	// Verificar si el token es válido
-	if !token.Valid {
-		return 0, fmt.Errorf("Token inválido")
-	}
-
-	// Obtener las reclamaciones (claims) del token
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return 0, fmt.Errorf("Error al obtener las reclamaciones del token")
-	}
-
 	// Obtener el ID de usuario desde las reclamaciones
-	userID := int(claims["user_id"].(float64))
+	userID := int(claims.UserID)
 
 	// Verificar si el usuario aún existe en la base de datos
 	exists := userExists(userID)
@@ -233,38 +197,95 @@ func checkAccessToken(accessToken string) (int, error) {
 }
 
 func getCurrentUser(r *http.Request) (*models.User, error) {
-	authToken := r.Header.Get("Authorization")
-
-	// Caso especial para "token-secreto"
-	if authToken == "token-secreto" {
-		// Asigna un usuario administrador predeterminado o realiza alguna otra acción específica
-		// Este es solo un ejemplo, ajusta según tu lógica de negocio
-		return &models.User{ID: 1, Username: "admin", Email: "admin@example.com"}, nil
+	// Si AuthMiddleware ya cargó el usuario, lo reutilizamos
+	if user, ok := r.Context().Value(currentUserKey).(*models.User); ok {
+		return user, nil
 	}
 
-	// Para los casos que no son el "token-secreto", asumimos que es un JWT
+	authToken := r.Header.Get("Authorization")
+
+	// Fuera de AuthMiddleware solo se aceptan JWT
 	// Quitamos el prefijo "Bearer " si está presente
 	tokenString := strings.TrimPrefix(authToken, "Bearer ")
 
-	// Parsea y valida el JWT para obtener el usuario
-	token, err := jwt.ParseWithClaims(tokenString, &models.Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil // `jwtKey` es tu clave secreta para validar el token
-	})
-
-	if err != nil || !token.Valid {
-		return nil, fmt.Errorf("token inválido o expirado")
+	// Parsea y valida el JWT (y su sesión) para obtener el usuario
+	claims, err := parseAccessToken(tokenString)
+	if err != nil {
+		return nil, err
 	}
+	return loadTokenUser(claims)
+}
 
-	if claims, ok := token.Claims.(*models.Claims); ok {
-		// Usar el `userID` para buscar al usuario en tu base de datos
-		var user models.User
-		rows, err := dataBase.SelectRow("SELECT id, username, email FROM users WHERE id = ?", claims.UserID)
+// loadTokenUser busca en la base al usuario de un access token ya validado
+func loadTokenUser(claims *models.Claims) (*models.User, error) {
+	var user models.User
+	rows, err := dataBase.SelectRow("SELECT id, username, email, `rank`, status FROM users WHERE id = ?", claims.UserID)
+	if err != nil {
+		return nil, err // Maneja el error de la base de datos
+	}
+	if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Rank, &user.Status); err != nil {
+		return nil, fmt.Errorf("el usuario asociado al token ya no existe")
+	}
+	if user.Status == userStatusInactive {
+		return nil, fmt.Errorf("el usuario asociado al token está desactivado")
+	}
+	if claims.ImpersonatorID != 0 {
+		impersonator, err := loadImpersonator(int(claims.ImpersonatorID))
 		if err != nil {
-			return nil, err // Maneja el error de la base de datos
+			return nil, err
 		}
-		rows.Scan(&user.ID, &user.Username, &user.Email)
-		return &user, nil
+		user.Impersonator = impersonator
 	}
+	return &user, nil
+}
 
-	return nil, fmt.Errorf("no se pudo procesar el token")
+// runUsers maneja el subcomando `magpanel users create-admin`, que crea un administrador sin pasar
+// por la API; sirve para el primer usuario de una base recién migrada. La contraseña se lee de la
+// entrada estándar para que no quede en el historial del shell.
+func runUsers(args []string) error {
+	if len(args) == 0 || args[0] != "create-admin" {
+		return fmt.Errorf("uso: magpanel users create-admin -username admin -email admin@example.com [-name Nombre]")
+	}
+	fs := flag.NewFlagSet("users create-admin", flag.ContinueOnError)
+	username := fs.String("username", "", "Nombre de usuario del administrador")
+	email := fs.String("email", "", "Email del administrador")
+	name := fs.String("name", "", "Nombre visible (por defecto el nombre de usuario)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *username == "" || *email == "" {
+		return fmt.Errorf("-username y -email son obligatorios")
+	}
+	if *name == "" {
+		*name = *username
+	}
+	if err := checkSchema(); err != nil {
+		return err
+	}
+	if usernameExists(*username) {
+		return fmt.Errorf("el nombre de usuario %s ya existe", *username)
+	}
+
+	fmt.Fprint(os.Stderr, "Contraseña: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		return fmt.Errorf("no se pudo leer la contraseña: %v", err)
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if err := validatePassword(password, *username, *email); err != nil {
+		return err
+	}
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	rank, _ := rankForRole(RoleAdmin)
+	id, err := dataBase.Insert(true, "INSERT INTO users (`username`, `rank`, `email`, `name`, `password_hash`) VALUES (?, ?, ?, ?, ?)",
+		*username, rank, *email, *name, hashedPassword)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Administrador %s creado con ID %d\n", *username, id)
+	return nil
 }